@@ -0,0 +1,64 @@
+// Command reaggregate rebuilds the usage rollups of a billing period from the
+// raw usage events counted in it, e.g. after fixing events by hand:
+//
+//	reaggregate -period 2026-10-01 [-customer <uuid>]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/app/service"
+	"github.com/novaru/billing-service/internal/config"
+	"github.com/novaru/billing-service/internal/database"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+func main() {
+	period := flag.String("period", "", "start date of the billing period to rebuild (YYYY-MM-DD)")
+	customer := flag.String("customer", "", "only rebuild this customer's rollups (default: every customer)")
+	flag.Parse()
+
+	periodStart, err := time.Parse(time.DateOnly, *period)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reaggregate: -period must be a date like 2026-10-01")
+		flag.Usage()
+		os.Exit(2)
+	}
+	customerID := uuid.Nil
+	if *customer != "" {
+		if customerID, err = uuid.Parse(*customer); err != nil {
+			fmt.Fprintln(os.Stderr, "reaggregate: -customer must be a UUID")
+			os.Exit(2)
+		}
+	}
+
+	cfg := config.Load()
+	if err := logger.Initialize(cfg.Env); err != nil {
+		panic(err)
+	}
+
+	db, err := database.New(cfg.DatabaseURL)
+	if err != nil {
+		logger.Fatal("Failed to connect to database", zap.Error(err))
+	}
+	defer db.Close()
+
+	aggregator := service.NewUsageAggregator(repository.NewUnitOfWork(db.Pool), 0, 0)
+	written, err := aggregator.Rebuild(context.Background(), periodStart, customerID)
+	if err != nil {
+		logger.Fatal("Failed to rebuild usage aggregates", zap.Error(err))
+	}
+
+	logger.Info("Rebuilt usage aggregates",
+		zap.String("period_start", *period),
+		zap.String("customer_id", *customer),
+		zap.Int("aggregates", written))
+}
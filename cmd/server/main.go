@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -17,9 +18,11 @@ import (
 	"github.com/novaru/billing-service/internal/app/service"
 	"github.com/novaru/billing-service/internal/config"
 	"github.com/novaru/billing-service/internal/database"
+	"github.com/novaru/billing-service/internal/payment"
 	"github.com/novaru/billing-service/internal/router"
 	E "github.com/novaru/billing-service/internal/shared/errors"
 	"github.com/novaru/billing-service/internal/shared/response"
+	"github.com/novaru/billing-service/internal/storage"
 	"github.com/novaru/billing-service/pkg/logger"
 )
 
@@ -38,24 +41,87 @@ func main() {
 	}
 	defer db.Close()
 
-	q := generated.New(db.Pool)
+	// Initialize file storage
+	files, err := storage.NewLocalStorage(cfg.StorageDir)
+	if err != nil {
+		logger.Fatal("Failed to initialize file storage", zap.Error(err))
+	}
+
+	// Initialize payment gateway
+	var gateway payment.PaymentGateway
+	var fake *payment.FakeGateway
+	switch cfg.PaymentGateway {
+	case payment.GatewayFake:
+		if cfg.FakeGatewaySecret == "" {
+			logger.Fatal("FAKE_GATEWAY_SECRET is required by the fake payment gateway")
+		}
+		fake = payment.NewFakeGateway(cfg.FakeGatewaySecret, 10*time.Second)
+		fake.SetOutcome(payment.Outcome(cfg.FakeGatewayOutcome))
+		gateway = fake
+	default:
+		logger.Fatal("Unsupported payment gateway", zap.String("gateway", cfg.PaymentGateway))
+	}
+
+	verifiers := map[string]payment.WebhookVerifier{}
+	if cfg.StripeWebhookSecret != "" {
+		verifiers[payment.GatewayStripe] = payment.NewStripeWebhook(cfg.StripeWebhookSecret, payment.DefaultStripeTolerance)
+	}
+	if cfg.XenditCallbackToken != "" {
+		verifiers[payment.GatewayXendit] = payment.NewXenditWebhook(cfg.XenditCallbackToken)
+	}
+	if cfg.MidtransServerKey != "" {
+		verifiers[payment.GatewayMidtrans] = payment.NewMidtransWebhook(cfg.MidtransServerKey)
+	}
+	// The fake settles payments on request, so it is never trusted in production
+	devGateway := fake != nil && cfg.Env != "production"
+	if devGateway {
+		verifiers[payment.GatewayFake] = fake
+	}
 
 	// Initialize repositories
-	userRepo := repository.NewUserRepository(q)
-	planRepo := repository.NewPlanRepository(q)
+	repos := repository.NewRepositories(generated.New(db.Pool))
+	uow := repository.NewUnitOfWork(db.Pool)
 
 	// Initialize services
-	userService := service.NewUserService(cfg, userRepo)
-	planService := service.NewPlanService(planRepo)
+	userService := service.NewUserService(cfg, repos.Users, uow)
+	planService := service.NewPlanService(repos.Plans)
+	lastUsedTracker := service.NewLastUsedTracker(repos.ApiKeys, 30*time.Second)
+	apiKeyService := service.NewApiKeyService(repos.ApiKeys, repos.Customers, lastUsedTracker)
+	customerService := service.NewCustomerService(repos.Customers)
+	invoiceBuilder := service.NewInvoiceBuilder(7*24*time.Hour, service.InvoiceNumbering{
+		DefaultPrefix:    cfg.InvoiceNumberPrefix,
+		CurrencyPrefixes: cfg.InvoiceNumberPrefixes,
+	})
+	subscriptionService := service.NewSubscriptionService(repos, uow, invoiceBuilder)
+	invoiceService := service.NewInvoiceService(repos, uow, invoiceBuilder, files, gateway)
+	checkoutService := service.NewCheckoutService(repos, uow, invoiceBuilder, gateway)
+	webhookService := service.NewWebhookService(repos, uow, invoiceBuilder, verifiers)
+	refundService := service.NewRefundService(uow, gateway)
+	quotaTracker := service.NewQuotaTracker(repos, 10*time.Second, 10*time.Minute)
+	usageService := service.NewUsageService(repos, quotaTracker)
+	idempotencyStore := service.NewIdempotencyStore(repos.Idempotency, 24*time.Hour, time.Minute)
+	renewer := service.NewSubscriptionRenewer(uow, invoiceBuilder, time.Minute, 100)
+	usageAggregator := service.NewUsageAggregator(uow, 30*time.Second, 1000)
 
 	// Initialize handlers
 	handlers := handler.New(
 		userService,
 		planService,
+		apiKeyService,
+		subscriptionService,
+		customerService,
+		invoiceService,
+		webhookService,
+		checkoutService,
+		refundService,
+		usageService,
 	)
+	if devGateway {
+		handlers.FakeGateway = handler.NewFakeGatewayHandler(fake, webhookService)
+	}
 
 	// Setup router
-	r := router.New(cfg, handlers).Setup()
+	r := router.New(cfg, handlers, apiKeyService, idempotencyStore).Setup()
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		if err := db.Health(r.Context()); err != nil {
 			response.WriteError(w, E.NewInternalError("database connection error", err))
@@ -74,6 +140,15 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Go(func() { lastUsedTracker.Run(workerCtx) })
+	workers.Go(func() { renewer.Run(workerCtx) })
+	workers.Go(func() { usageAggregator.Run(workerCtx) })
+	workers.Go(func() { quotaTracker.Run(workerCtx) })
+	workers.Go(func() { idempotencyStore.Run(workerCtx, time.Hour) })
+
 	// Graceful shutdown
 	go func() {
 		logger.Info("Server starting on " + cfg.Port)
@@ -97,5 +172,13 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
+	// Stop workers and persist whatever they still buffer
+	stopWorkers()
+	workers.Wait()
+
+	if err := lastUsedTracker.Flush(ctx); err != nil {
+		logger.Error("Failed to flush API key usage", zap.Error(err))
+	}
+
 	logger.Info("Server exited gracefully")
 }
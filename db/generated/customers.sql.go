@@ -12,6 +12,33 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const consumeCustomerCredit = `-- name: ConsumeCustomerCredit :one
+WITH c AS (
+  SELECT id, LEAST(GREATEST(COALESCE(credit_balance_cents, 0), 0), $1::bigint) AS applied
+  FROM customers
+  WHERE id = $2
+  FOR UPDATE
+)
+UPDATE customers
+SET credit_balance_cents = COALESCE(customers.credit_balance_cents, 0) - c.applied,
+    updated_at = now()
+FROM c
+WHERE customers.id = c.id
+RETURNING c.applied
+`
+
+type ConsumeCustomerCreditParams struct {
+	MaxAmount int64     `json:"max_amount"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func (q *Queries) ConsumeCustomerCredit(ctx context.Context, arg ConsumeCustomerCreditParams) (int64, error) {
+	row := q.db.QueryRow(ctx, consumeCustomerCredit, arg.MaxAmount, arg.ID)
+	var applied int64
+	err := row.Scan(&applied)
+	return applied, err
+}
+
 const createCustomer = `-- name: CreateCustomer :one
 INSERT INTO customers (id, user_id, email, credit_balance_cents)
 VALUES ($1, $2, $3, 0)
@@ -39,6 +66,47 @@ func (q *Queries) CreateCustomer(ctx context.Context, arg CreateCustomerParams)
 	return i, err
 }
 
+const getCustomerByEmail = `-- name: GetCustomerByEmail :one
+SELECT id, user_id, email, default_payment_method, credit_balance_cents, created_at, updated_at FROM customers
+WHERE lower(email) = lower($1::text)
+ORDER BY created_at
+LIMIT 1
+`
+
+func (q *Queries) GetCustomerByEmail(ctx context.Context, email string) (Customer, error) {
+	row := q.db.QueryRow(ctx, getCustomerByEmail, email)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Email,
+		&i.DefaultPaymentMethod,
+		&i.CreditBalanceCents,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCustomerByID = `-- name: GetCustomerByID :one
+SELECT id, user_id, email, default_payment_method, credit_balance_cents, created_at, updated_at FROM customers WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetCustomerByID(ctx context.Context, id uuid.UUID) (Customer, error) {
+	row := q.db.QueryRow(ctx, getCustomerByID, id)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Email,
+		&i.DefaultPaymentMethod,
+		&i.CreditBalanceCents,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getCustomerByUserID = `-- name: GetCustomerByUserID :one
 SELECT id, user_id, email, default_payment_method, credit_balance_cents, created_at, updated_at FROM customers WHERE user_id = $1 LIMIT 1
 `
@@ -84,3 +152,31 @@ func (q *Queries) UpdateCustomerCredits(ctx context.Context, arg UpdateCustomerC
 	)
 	return i, err
 }
+
+const updateCustomerPaymentMethod = `-- name: UpdateCustomerPaymentMethod :one
+UPDATE customers
+SET default_payment_method = $2,
+    updated_at = now()
+WHERE id = $1
+RETURNING id, user_id, email, default_payment_method, credit_balance_cents, created_at, updated_at
+`
+
+type UpdateCustomerPaymentMethodParams struct {
+	ID                   uuid.UUID `json:"id"`
+	DefaultPaymentMethod []byte    `json:"default_payment_method"`
+}
+
+func (q *Queries) UpdateCustomerPaymentMethod(ctx context.Context, arg UpdateCustomerPaymentMethodParams) (Customer, error) {
+	row := q.db.QueryRow(ctx, updateCustomerPaymentMethod, arg.ID, arg.DefaultPaymentMethod)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Email,
+		&i.DefaultPaymentMethod,
+		&i.CreditBalanceCents,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
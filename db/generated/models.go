@@ -18,6 +18,8 @@ type ApiKey struct {
 	CreatedAt  pgtype.Timestamptz `json:"created_at"`
 	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
 	Meta       []byte             `json:"meta"`
+	Name       string             `json:"name"`
+	Scopes     []string           `json:"scopes"`
 }
 
 type Customer struct {
@@ -30,6 +32,19 @@ type Customer struct {
 	UpdatedAt            pgtype.Timestamptz `json:"updated_at"`
 }
 
+type IdempotencyKey struct {
+	Scope           string             `json:"scope"`
+	IdempotencyKey  string             `json:"idempotency_key"`
+	Fingerprint     string             `json:"fingerprint"`
+	Status          string             `json:"status"`
+	ResponseStatus  pgtype.Int4        `json:"response_status"`
+	ResponseHeaders []byte             `json:"response_headers"`
+	ResponseBody    []byte             `json:"response_body"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	CompletedAt     pgtype.Timestamptz `json:"completed_at"`
+	ExpiresAt       pgtype.Timestamptz `json:"expires_at"`
+}
+
 type Invoice struct {
 	ID               uuid.UUID          `json:"id"`
 	CustomerID       pgtype.UUID        `json:"customer_id"`
@@ -44,6 +59,30 @@ type Invoice struct {
 	PdfUrl           pgtype.Text        `json:"pdf_url"`
 	Metadata         []byte             `json:"metadata"`
 	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	InvoiceNumber    pgtype.Text        `json:"invoice_number"`
+}
+
+type InvoiceLineItem struct {
+	ID              uuid.UUID          `json:"id"`
+	InvoiceID       pgtype.UUID        `json:"invoice_id"`
+	CustomerID      uuid.UUID          `json:"customer_id"`
+	SubscriptionID  pgtype.UUID        `json:"subscription_id"`
+	Kind            string             `json:"kind"`
+	Description     string             `json:"description"`
+	Quantity        int64              `json:"quantity"`
+	UnitAmountCents int64              `json:"unit_amount_cents"`
+	AmountCents     int64              `json:"amount_cents"`
+	Currency        string             `json:"currency"`
+	PeriodStart     pgtype.Timestamptz `json:"period_start"`
+	PeriodEnd       pgtype.Timestamptz `json:"period_end"`
+	Metadata        []byte             `json:"metadata"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+}
+
+type InvoiceNumberSequence struct {
+	Prefix    string `json:"prefix"`
+	Year      int32  `json:"year"`
+	LastValue int64  `json:"last_value"`
 }
 
 type Plan struct {
@@ -73,20 +112,29 @@ type Subscription struct {
 	Metadata              []byte             `json:"metadata"`
 	CreatedAt             pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt             pgtype.Timestamptz `json:"updated_at"`
+	CanceledAt            pgtype.Timestamptz `json:"canceled_at"`
+}
+
+type SubscriptionPeriod struct {
+	SubscriptionID uuid.UUID          `json:"subscription_id"`
+	CustomerID     pgtype.UUID        `json:"customer_id"`
+	PeriodStart    pgtype.Timestamptz `json:"period_start"`
+	PeriodEnd      pgtype.Timestamptz `json:"period_end"`
 }
 
 type Transaction struct {
-	ID               uuid.UUID          `json:"id"`
-	InvoiceID        pgtype.UUID        `json:"invoice_id"`
-	CustomerID       pgtype.UUID        `json:"customer_id"`
-	Gateway          string             `json:"gateway"`
-	GatewayPaymentID pgtype.Text        `json:"gateway_payment_id"`
-	AmountCents      int64              `json:"amount_cents"`
-	Currency         string             `json:"currency"`
-	Status           string             `json:"status"`
-	IdempotencyKey   pgtype.Text        `json:"idempotency_key"`
-	RawResponse      []byte             `json:"raw_response"`
-	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	ID                    uuid.UUID          `json:"id"`
+	InvoiceID             pgtype.UUID        `json:"invoice_id"`
+	CustomerID            pgtype.UUID        `json:"customer_id"`
+	Gateway               string             `json:"gateway"`
+	GatewayPaymentID      pgtype.Text        `json:"gateway_payment_id"`
+	AmountCents           int64              `json:"amount_cents"`
+	Currency              string             `json:"currency"`
+	Status                string             `json:"status"`
+	IdempotencyKey        pgtype.Text        `json:"idempotency_key"`
+	RawResponse           []byte             `json:"raw_response"`
+	CreatedAt             pgtype.Timestamptz `json:"created_at"`
+	RefundedTransactionID pgtype.UUID        `json:"refunded_transaction_id"`
 }
 
 type UsageAggregate struct {
@@ -101,15 +149,19 @@ type UsageAggregate struct {
 }
 
 type UsageEvent struct {
-	ID         uuid.UUID          `json:"id"`
-	CustomerID pgtype.UUID        `json:"customer_id"`
-	ApiKeyID   pgtype.UUID        `json:"api_key_id"`
-	Metric     string             `json:"metric"`
-	Quantity   int64              `json:"quantity"`
-	CostCents  pgtype.Int8        `json:"cost_cents"`
-	ReportedAt pgtype.Timestamptz `json:"reported_at"`
-	Processed  pgtype.Bool        `json:"processed"`
-	Metadata   []byte             `json:"metadata"`
+	ID          uuid.UUID          `json:"id"`
+	CustomerID  pgtype.UUID        `json:"customer_id"`
+	ApiKeyID    pgtype.UUID        `json:"api_key_id"`
+	Metric      string             `json:"metric"`
+	Quantity    int64              `json:"quantity"`
+	CostCents   pgtype.Int8        `json:"cost_cents"`
+	ReportedAt  pgtype.Timestamptz `json:"reported_at"`
+	Processed   pgtype.Bool        `json:"processed"`
+	Metadata    []byte             `json:"metadata"`
+	EventID     pgtype.Text        `json:"event_id"`
+	OccurredAt  pgtype.Timestamptz `json:"occurred_at"`
+	PeriodStart pgtype.Date        `json:"period_start"`
+	PeriodEnd   pgtype.Date        `json:"period_end"`
 }
 
 type User struct {
@@ -120,3 +172,15 @@ type User struct {
 	CreatedAt    pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
 }
+
+type WebhookEvent struct {
+	ID          uuid.UUID          `json:"id"`
+	Gateway     string             `json:"gateway"`
+	EventID     string             `json:"event_id"`
+	EventType   string             `json:"event_type"`
+	Payload     []byte             `json:"payload"`
+	Status      string             `json:"status"`
+	Error       pgtype.Text        `json:"error"`
+	ReceivedAt  pgtype.Timestamptz `json:"received_at"`
+	ProcessedAt pgtype.Timestamptz `json:"processed_at"`
+}
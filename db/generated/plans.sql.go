@@ -60,6 +60,31 @@ func (q *Queries) CreatePlan(ctx context.Context, arg CreatePlanParams) (Plan, e
 	return i, err
 }
 
+const getPlanByID = `-- name: GetPlanByID :one
+SELECT id, slug, name, description, price_cents, currency, interval, quota_limits, meta, created_at, updated_at FROM plans
+WHERE id = $1
+LIMIT 1
+`
+
+func (q *Queries) GetPlanByID(ctx context.Context, id uuid.UUID) (Plan, error) {
+	row := q.db.QueryRow(ctx, getPlanByID, id)
+	var i Plan
+	err := row.Scan(
+		&i.ID,
+		&i.Slug,
+		&i.Name,
+		&i.Description,
+		&i.PriceCents,
+		&i.Currency,
+		&i.Interval,
+		&i.QuotaLimits,
+		&i.Meta,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const getPlanBySlug = `-- name: GetPlanBySlug :one
 SELECT id, slug, name, description, price_cents, currency, interval, quota_limits, meta, created_at, updated_at FROM plans
 WHERE slug = $1
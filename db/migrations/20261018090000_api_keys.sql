@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE api_keys (
+  id              UUID PRIMARY KEY,
+  customer_id     UUID REFERENCES customers(id),
+  api_key         TEXT UNIQUE NOT NULL,
+  hashed_key      TEXT NOT NULL,
+  revoked         BOOLEAN DEFAULT FALSE,
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  last_used_at    TIMESTAMP WITH TIME ZONE,
+  meta            JSONB
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE api_keys;
+-- +goose StatementEnd
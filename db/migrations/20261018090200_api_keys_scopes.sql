@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE api_keys ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}';
+-- keys issued before scopes existed keep the access they already had
+UPDATE api_keys SET scopes = ARRAY['usage:write', 'usage:read', 'quota:read'];
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE api_keys DROP COLUMN scopes;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE subscriptions (
+  id                      UUID PRIMARY KEY,
+  customer_id             UUID REFERENCES customers(id),
+  plan_id                 UUID REFERENCES plans(id),
+  status                  TEXT NOT NULL,
+  trial_ends_at           TIMESTAMP WITH TIME ZONE,
+  current_period_start    TIMESTAMP WITH TIME ZONE,
+  current_period_end      TIMESTAMP WITH TIME ZONE,
+  cancel_at_period_end    BOOLEAN DEFAULT FALSE,
+  gateway_subscription_id TEXT,
+  metadata                JSONB,
+  created_at              TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  updated_at              TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  canceled_at             TIMESTAMP WITH TIME ZONE
+);
+
+-- a customer holds at most one subscription that is not canceled
+CREATE UNIQUE INDEX subscriptions_current_customer_idx
+  ON subscriptions (customer_id)
+  WHERE status <> 'canceled';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE subscriptions;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- users registered before customers were provisioned automatically
+INSERT INTO customers (id, user_id, email, credit_balance_cents)
+SELECT gen_random_uuid(), u.id, u.email, 0
+FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM customers c WHERE c.user_id = u.id);
+
+CREATE UNIQUE INDEX customers_user_id_idx ON customers (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX customers_user_id_idx;
+-- +goose StatementEnd
@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE invoices (
+  id                 UUID PRIMARY KEY,
+  customer_id        UUID REFERENCES customers(id),
+  subscription_id    UUID REFERENCES subscriptions(id),
+  gateway_invoice_id TEXT,
+  status             TEXT NOT NULL,
+  amount_cents       BIGINT NOT NULL,
+  currency           TEXT NOT NULL DEFAULT 'USD',
+  issued_at          TIMESTAMP WITH TIME ZONE,
+  due_at             TIMESTAMP WITH TIME ZONE,
+  paid_at            TIMESTAMP WITH TIME ZONE,
+  pdf_url            TEXT,
+  metadata           JSONB,
+  created_at         TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE TABLE invoice_line_items (
+  id                UUID PRIMARY KEY,
+  invoice_id        UUID REFERENCES invoices(id),
+  customer_id       UUID NOT NULL REFERENCES customers(id),
+  subscription_id   UUID REFERENCES subscriptions(id),
+  kind              TEXT NOT NULL,
+  description       TEXT NOT NULL,
+  quantity          BIGINT NOT NULL DEFAULT 1,
+  unit_amount_cents BIGINT NOT NULL,
+  amount_cents      BIGINT NOT NULL,
+  currency          TEXT NOT NULL,
+  period_start      TIMESTAMP WITH TIME ZONE,
+  period_end        TIMESTAMP WITH TIME ZONE,
+  metadata          JSONB,
+  created_at        TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX invoice_line_items_invoice_idx ON invoice_line_items (invoice_id);
+CREATE INDEX invoice_line_items_pending_idx ON invoice_line_items (customer_id) WHERE invoice_id IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE invoice_line_items;
+DROP TABLE invoices;
+-- +goose StatementEnd
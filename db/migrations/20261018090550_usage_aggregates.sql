@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE usage_aggregates (
+  id               UUID PRIMARY KEY,
+  customer_id      UUID REFERENCES customers(id),
+  period_start     DATE NOT NULL,
+  period_end       DATE NOT NULL,
+  metric           TEXT NOT NULL,
+  total_quantity   BIGINT DEFAULT 0,
+  total_cost_cents BIGINT DEFAULT 0,
+  updated_at       TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  UNIQUE (customer_id, period_start, metric)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE usage_aggregates;
+-- +goose StatementEnd
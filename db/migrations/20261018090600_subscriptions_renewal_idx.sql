@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX subscriptions_renewal_due_idx
+  ON subscriptions (current_period_end)
+  WHERE status IN ('active', 'trialing');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX subscriptions_renewal_due_idx;
+-- +goose StatementEnd
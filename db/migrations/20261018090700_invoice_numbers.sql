@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE invoices ADD COLUMN invoice_number TEXT;
+
+CREATE UNIQUE INDEX invoices_number_idx ON invoices (invoice_number);
+
+CREATE TABLE invoice_number_sequences (
+  prefix     TEXT NOT NULL,
+  year       INTEGER NOT NULL,
+  last_value BIGINT NOT NULL,
+  PRIMARY KEY (prefix, year)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE invoice_number_sequences;
+DROP INDEX invoices_number_idx;
+ALTER TABLE invoices DROP COLUMN invoice_number;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE transactions (
+  id                 UUID PRIMARY KEY,
+  invoice_id         UUID REFERENCES invoices(id),
+  customer_id        UUID REFERENCES customers(id),
+  gateway            TEXT NOT NULL,
+  gateway_payment_id TEXT,
+  amount_cents       BIGINT NOT NULL,
+  currency           TEXT NOT NULL,
+  status             TEXT NOT NULL,
+  idempotency_key    TEXT,
+  raw_response       JSONB,
+  created_at         TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE transactions;
+-- +goose StatementEnd
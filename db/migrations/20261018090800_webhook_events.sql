@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE webhook_events (
+  id           UUID PRIMARY KEY,
+  gateway      TEXT NOT NULL,
+  event_id     TEXT NOT NULL,
+  event_type   TEXT NOT NULL,
+  payload      JSONB NOT NULL,
+  status       TEXT NOT NULL DEFAULT 'received',
+  error        TEXT,
+  received_at  TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  processed_at TIMESTAMP WITH TIME ZONE,
+  UNIQUE (gateway, event_id)
+);
+
+CREATE INDEX subscriptions_gateway_id_idx ON subscriptions (gateway_subscription_id);
+CREATE INDEX invoices_gateway_id_idx ON invoices (gateway_invoice_id);
+CREATE INDEX transactions_gateway_payment_idx ON transactions (gateway, gateway_payment_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX transactions_gateway_payment_idx;
+DROP INDEX invoices_gateway_id_idx;
+DROP INDEX subscriptions_gateway_id_idx;
+DROP TABLE webhook_events;
+-- +goose StatementEnd
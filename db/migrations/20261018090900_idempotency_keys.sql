@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE idempotency_keys (
+  scope            TEXT NOT NULL,
+  idempotency_key  TEXT NOT NULL,
+  fingerprint      TEXT NOT NULL,
+  status           TEXT NOT NULL,
+  response_status  INTEGER,
+  response_headers JSONB,
+  response_body    BYTEA,
+  created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  completed_at     TIMESTAMP WITH TIME ZONE,
+  expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (scope, idempotency_key)
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE idempotency_keys;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE transactions ADD COLUMN refunded_transaction_id UUID REFERENCES transactions(id);
+
+CREATE INDEX transactions_refunded_transaction_idx ON transactions (refunded_transaction_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX transactions_refunded_transaction_idx;
+ALTER TABLE transactions DROP COLUMN refunded_transaction_id;
+-- +goose StatementEnd
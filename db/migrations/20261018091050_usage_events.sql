@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE usage_events (
+  id          UUID PRIMARY KEY,
+  customer_id UUID REFERENCES customers(id),
+  api_key_id  UUID REFERENCES api_keys(id),
+  metric      TEXT NOT NULL,
+  quantity    BIGINT NOT NULL,
+  cost_cents  BIGINT DEFAULT 0,
+  reported_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  processed   BOOLEAN DEFAULT FALSE,
+  metadata    JSONB
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE usage_events;
+-- +goose StatementEnd
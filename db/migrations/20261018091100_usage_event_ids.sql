@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE usage_events
+  ADD COLUMN event_id TEXT,
+  ADD COLUMN occurred_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX usage_events_customer_event_idx ON usage_events (customer_id, event_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX usage_events_customer_event_idx;
+ALTER TABLE usage_events
+  DROP COLUMN occurred_at,
+  DROP COLUMN event_id;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE usage_events
+  ADD COLUMN period_start DATE,
+  ADD COLUMN period_end DATE;
+
+CREATE INDEX usage_events_unprocessed_idx ON usage_events (id) WHERE NOT processed;
+CREATE INDEX usage_events_period_idx ON usage_events (period_start, customer_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX usage_events_period_idx;
+DROP INDEX usage_events_unprocessed_idx;
+ALTER TABLE usage_events
+  DROP COLUMN period_end,
+  DROP COLUMN period_start;
+-- +goose StatementEnd
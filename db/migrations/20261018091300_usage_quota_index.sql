@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX usage_events_unprocessed_customer_idx ON usage_events (customer_id) WHERE NOT processed;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX usage_events_unprocessed_customer_idx;
+-- +goose StatementEnd
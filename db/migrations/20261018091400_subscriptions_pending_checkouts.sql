@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- pending checkouts no longer count, so a new one never has to cancel another
+DROP INDEX subscriptions_current_customer_idx;
+CREATE UNIQUE INDEX subscriptions_current_customer_idx
+  ON subscriptions (customer_id)
+  WHERE status NOT IN ('canceled', 'pending');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX subscriptions_current_customer_idx;
+CREATE UNIQUE INDEX subscriptions_current_customer_idx
+  ON subscriptions (customer_id)
+  WHERE status <> 'canceled';
+-- +goose StatementEnd
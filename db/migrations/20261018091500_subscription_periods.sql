@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every billing period a subscription went through, so usage can be counted
+-- in the period it occurred in
+CREATE TABLE subscription_periods (
+  subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+  customer_id     UUID REFERENCES customers(id),
+  period_start    TIMESTAMP WITH TIME ZONE NOT NULL,
+  period_end      TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (subscription_id, period_start)
+);
+
+CREATE INDEX subscription_periods_customer_idx ON subscription_periods (customer_id, period_start);
+
+INSERT INTO subscription_periods (subscription_id, customer_id, period_start, period_end)
+SELECT id, customer_id, current_period_start, current_period_end
+FROM subscriptions
+WHERE current_period_start IS NOT NULL
+  AND current_period_end IS NOT NULL
+  AND status <> 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE subscription_periods;
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- name: GetApiKeyByPrefix :one
+SELECT * FROM api_keys
+WHERE api_key = $1
+LIMIT 1;
+
+-- name: CreateApiKey :one
+INSERT INTO api_keys (id, customer_id, name, api_key, hashed_key, scopes)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListApiKeysByCustomer :many
+SELECT * FROM api_keys
+WHERE customer_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetApiKeyForCustomer :one
+SELECT * FROM api_keys
+WHERE id = $1 AND customer_id = $2
+LIMIT 1;
+
+-- name: UpdateApiKey :one
+UPDATE api_keys
+SET name = $3,
+    scopes = $4
+WHERE id = $1 AND customer_id = $2
+RETURNING *;
+
+-- name: RevokeApiKey :one
+UPDATE api_keys
+SET revoked = TRUE
+WHERE id = $1 AND customer_id = $2
+RETURNING *;
+
+-- name: RotateApiKey :one
+UPDATE api_keys
+SET api_key = $3,
+    hashed_key = $4
+WHERE id = $1 AND customer_id = $2 AND revoked IS NOT TRUE
+RETURNING *;
+
+-- name: TouchApiKeysLastUsed :exec
+UPDATE api_keys AS k
+SET last_used_at = u.used_at
+FROM unnest(@ids::uuid[], @used_at::timestamptz[]) AS u(id, used_at)
+WHERE k.id = u.id
+  AND (k.last_used_at IS NULL OR k.last_used_at < u.used_at);
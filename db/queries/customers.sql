@@ -11,3 +11,33 @@ UPDATE customers
 SET credit_balance_cents = credit_balance_cents + $2
 WHERE id = $1
 RETURNING *;
+
+-- name: GetCustomerByID :one
+SELECT * FROM customers WHERE id = $1 LIMIT 1;
+
+-- name: UpdateCustomerPaymentMethod :one
+UPDATE customers
+SET default_payment_method = $2,
+    updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: ConsumeCustomerCredit :one
+WITH c AS (
+  SELECT id, LEAST(GREATEST(COALESCE(credit_balance_cents, 0), 0), @max_amount::bigint) AS applied
+  FROM customers
+  WHERE id = @id
+  FOR UPDATE
+)
+UPDATE customers
+SET credit_balance_cents = COALESCE(customers.credit_balance_cents, 0) - c.applied,
+    updated_at = now()
+FROM c
+WHERE customers.id = c.id
+RETURNING c.applied;
+
+-- name: GetCustomerByEmail :one
+SELECT * FROM customers
+WHERE lower(email) = lower(@email::text)
+ORDER BY created_at
+LIMIT 1;
@@ -0,0 +1,32 @@
+-- name: DeleteStaleIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = @scope
+  AND idempotency_key = @idempotency_key
+  AND (expires_at < now() OR (status = 'in_flight' AND created_at < @stale_before));
+
+-- name: CreateIdempotencyKey :one
+INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, status, expires_at)
+VALUES ($1, $2, $3, 'in_flight', $4)
+ON CONFLICT (scope, idempotency_key) DO NOTHING
+RETURNING *;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status = 'completed',
+    response_status = @response_status,
+    response_headers = @response_headers,
+    response_body = @response_body,
+    completed_at = now()
+WHERE scope = @scope AND idempotency_key = @idempotency_key;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- name: PurgeExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1;
@@ -0,0 +1,31 @@
+-- name: CreateInvoiceLineItem :one
+INSERT INTO invoice_line_items (
+  id, invoice_id, customer_id, subscription_id, kind, description,
+  quantity, unit_amount_cents, amount_cents, currency, period_start, period_end, metadata
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+RETURNING *;
+
+-- name: ListPendingLineItems :many
+SELECT * FROM invoice_line_items
+WHERE customer_id = $1 AND invoice_id IS NULL
+ORDER BY created_at
+FOR UPDATE;
+
+-- name: AttachLineItems :exec
+UPDATE invoice_line_items
+SET invoice_id = @invoice_id
+WHERE id = ANY(@ids::uuid[]) AND invoice_id IS NULL;
+
+-- name: ListLineItemsByInvoice :many
+SELECT * FROM invoice_line_items
+WHERE invoice_id = $1
+ORDER BY created_at, id;
+
+-- name: CountBilledLineItems :one
+SELECT count(*) FROM invoice_line_items li
+LEFT JOIN invoices i ON i.id = li.invoice_id
+WHERE li.subscription_id = @subscription_id
+  AND li.kind = @kind
+  AND li.period_start = @period_start
+  AND (li.invoice_id IS NULL OR i.status <> 'void');
@@ -0,0 +1,72 @@
+-- name: CreateInvoice :one
+INSERT INTO invoices (id, customer_id, subscription_id, status, amount_cents, currency, issued_at, due_at, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING *;
+
+-- name: GetInvoiceByID :one
+SELECT * FROM invoices
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetInvoiceForUpdate :one
+SELECT * FROM invoices
+WHERE id = $1
+LIMIT 1
+FOR UPDATE;
+
+-- name: FinalizeInvoice :one
+UPDATE invoices
+SET status = 'issued',
+    invoice_number = @invoice_number,
+    amount_cents = @amount_cents,
+    issued_at = @issued_at,
+    due_at = @due_at
+WHERE id = @id AND status = 'draft'
+RETURNING *;
+
+-- name: NextInvoiceNumber :one
+INSERT INTO invoice_number_sequences (prefix, year, last_value)
+VALUES (@prefix, @year, 1)
+ON CONFLICT (prefix, year) DO UPDATE
+SET last_value = invoice_number_sequences.last_value + 1
+RETURNING last_value;
+
+-- name: SetInvoicePdfUrl :exec
+UPDATE invoices
+SET pdf_url = $2
+WHERE id = $1;
+
+-- name: ListInvoicesByCustomer :many
+SELECT * FROM invoices
+WHERE customer_id = @customer_id
+  AND status <> 'draft'
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(issued_from)::timestamptz IS NULL OR issued_at >= sqlc.narg(issued_from))
+  AND (sqlc.narg(issued_to)::timestamptz IS NULL OR issued_at < sqlc.narg(issued_to))
+ORDER BY issued_at DESC, id DESC
+LIMIT @limit_count OFFSET @offset_count;
+
+-- name: MarkInvoicePaid :one
+UPDATE invoices
+SET status = 'paid',
+    paid_at = @paid_at
+WHERE id = @id AND status IN ('issued', 'failed')
+RETURNING *;
+
+-- name: MarkInvoiceFailed :one
+UPDATE invoices
+SET status = 'failed'
+WHERE id = $1 AND status IN ('issued', 'failed')
+RETURNING *;
+
+-- name: GetInvoiceByGatewayIDForUpdate :one
+SELECT * FROM invoices
+WHERE gateway_invoice_id = $1
+LIMIT 1
+FOR UPDATE;
+
+-- name: MarkInvoiceRefunded :one
+UPDATE invoices
+SET status = @status
+WHERE id = @id AND status IN ('paid', 'partially_refunded')
+RETURNING *;
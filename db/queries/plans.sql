@@ -11,3 +11,8 @@ SELECT * FROM plans
 WHERE slug = $1
 LIMIT 1;
 
+
+-- name: GetPlanByID :one
+SELECT * FROM plans
+WHERE id = $1
+LIMIT 1;
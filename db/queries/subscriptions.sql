@@ -0,0 +1,91 @@
+-- name: CreateSubscription :one
+INSERT INTO subscriptions (id, customer_id, plan_id, status, trial_ends_at, current_period_start, current_period_end)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetSubscriptionByID :one
+SELECT * FROM subscriptions
+WHERE id = $1
+LIMIT 1;
+
+-- name: GetSubscriptionForUpdate :one
+SELECT * FROM subscriptions
+WHERE id = $1
+LIMIT 1
+FOR UPDATE;
+
+-- name: GetCurrentSubscriptionByCustomer :one
+SELECT * FROM subscriptions
+WHERE customer_id = $1 AND status <> 'canceled'
+ORDER BY status = 'pending', created_at DESC
+LIMIT 1;
+
+-- name: UpdateSubscriptionState :one
+UPDATE subscriptions
+SET status = @status,
+    trial_ends_at = @trial_ends_at,
+    current_period_start = @current_period_start,
+    current_period_end = @current_period_end,
+    cancel_at_period_end = @cancel_at_period_end,
+    canceled_at = @canceled_at,
+    updated_at = now()
+WHERE id = @id AND status = @expected_status
+RETURNING *;
+
+-- name: CancelPendingSubscriptions :exec
+UPDATE subscriptions
+SET status = 'canceled',
+    canceled_at = @canceled_at,
+    updated_at = now()
+WHERE customer_id = @customer_id AND status = 'pending' AND id <> @id;
+
+-- name: UpdateSubscriptionPlan :one
+UPDATE subscriptions
+SET plan_id = @plan_id,
+    current_period_start = @current_period_start,
+    current_period_end = @current_period_end,
+    updated_at = now()
+WHERE id = @id AND status = @expected_status
+RETURNING *;
+
+-- name: MergeSubscriptionMetadata :exec
+UPDATE subscriptions
+SET metadata = COALESCE(metadata, '{}'::jsonb) || @patch::jsonb,
+    updated_at = now()
+WHERE id = @id;
+
+-- name: ClaimDueSubscription :one
+SELECT * FROM subscriptions
+WHERE status IN ('active', 'trialing')
+  AND current_period_end <= @now
+  AND NOT (id = ANY(@skip::uuid[]))
+ORDER BY current_period_end
+LIMIT 1
+FOR UPDATE SKIP LOCKED;
+
+-- name: GetSubscriptionByGatewayID :one
+SELECT * FROM subscriptions
+WHERE gateway_subscription_id = $1
+LIMIT 1;
+
+-- name: SetGatewaySubscriptionID :exec
+UPDATE subscriptions
+SET gateway_subscription_id = @gateway_subscription_id,
+    updated_at = now()
+WHERE id = @id;
+
+-- name: RecordSubscriptionPeriod :exec
+INSERT INTO subscription_periods (subscription_id, customer_id, period_start, period_end)
+SELECT id, customer_id, current_period_start, current_period_end
+FROM subscriptions
+WHERE id = $1
+  AND current_period_start IS NOT NULL
+  AND current_period_end IS NOT NULL
+  AND status <> 'pending'
+ON CONFLICT (subscription_id, period_start) DO UPDATE
+SET period_end = EXCLUDED.period_end;
+
+-- name: ListSubscriptionPeriodsSince :many
+SELECT * FROM subscription_periods
+WHERE customer_id = @customer_id AND period_end > @since
+ORDER BY period_start;
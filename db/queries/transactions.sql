@@ -0,0 +1,38 @@
+-- name: CreateTransaction :one
+INSERT INTO transactions (
+  id, invoice_id, customer_id, gateway, gateway_payment_id,
+  amount_cents, currency, status, idempotency_key, raw_response,
+  refunded_transaction_id
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+RETURNING *;
+
+-- name: ListTransactionsByInvoice :many
+SELECT * FROM transactions
+WHERE invoice_id = $1
+ORDER BY created_at, id;
+
+-- name: GetTransactionByGatewayPayment :one
+SELECT * FROM transactions
+WHERE gateway = $1 AND gateway_payment_id = $2
+ORDER BY created_at DESC
+LIMIT 1
+FOR UPDATE;
+
+-- name: UpdateTransactionStatus :one
+UPDATE transactions
+SET status = @status,
+    gateway_payment_id = COALESCE(sqlc.narg(gateway_payment_id), gateway_payment_id),
+    raw_response = COALESCE(@raw_response, raw_response)
+WHERE id = @id
+RETURNING *;
+
+-- name: GetTransactionForUpdate :one
+SELECT * FROM transactions
+WHERE id = $1
+FOR UPDATE;
+
+-- name: ListTransactionRefunds :many
+SELECT * FROM transactions
+WHERE refunded_transaction_id = $1
+ORDER BY created_at, id;
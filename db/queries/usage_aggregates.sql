@@ -0,0 +1,24 @@
+-- name: ListUsageAggregatesForPeriod :many
+SELECT * FROM usage_aggregates
+WHERE customer_id = @customer_id
+  AND period_start >= @period_start
+  AND period_start < @period_end
+ORDER BY metric, period_start;
+
+-- name: AddUsageAggregate :exec
+INSERT INTO usage_aggregates (
+  id, customer_id, period_start, period_end, metric, total_quantity, total_cost_cents
+)
+VALUES (@id, @customer_id, @period_start, @period_end, @metric, @total_quantity, @total_cost_cents)
+ON CONFLICT (customer_id, period_start, metric) DO UPDATE
+SET total_quantity = COALESCE(usage_aggregates.total_quantity, 0) + EXCLUDED.total_quantity,
+    total_cost_cents = COALESCE(usage_aggregates.total_cost_cents, 0) + EXCLUDED.total_cost_cents,
+    period_end = EXCLUDED.period_end,
+    updated_at = now();
+
+-- name: DeleteUsageAggregatesForPeriod :exec
+DELETE FROM usage_aggregates
+WHERE customer_id = @customer_id AND period_start = @period_start;
+
+-- name: LockUsageAggregates :exec
+LOCK TABLE usage_aggregates IN SHARE ROW EXCLUSIVE MODE;
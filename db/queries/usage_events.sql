@@ -0,0 +1,65 @@
+-- name: CreateUsageEvent :one
+INSERT INTO usage_events (
+  id, customer_id, api_key_id, metric, quantity, metadata, event_id, occurred_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (customer_id, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetUsageEventByEventID :one
+SELECT * FROM usage_events
+WHERE customer_id = $1 AND event_id = $2;
+
+-- name: ListUsageEventsByEventIDs :many
+SELECT * FROM usage_events
+WHERE customer_id = @customer_id AND event_id = ANY(@event_ids::text[]);
+
+-- name: CreateUsageEvents :many
+INSERT INTO usage_events (
+  id, customer_id, api_key_id, metric, quantity, metadata, event_id, occurred_at
+)
+SELECT e.id, @customer_id::uuid, @api_key_id::uuid, e.metric, e.quantity,
+       NULLIF(e.metadata, '')::jsonb, e.event_id, e.occurred_at
+FROM unnest(
+  @ids::uuid[], @metrics::text[], @quantities::bigint[],
+  @metadata::text[], @event_ids::text[], @occurred_at::timestamptz[]
+) AS e(id, metric, quantity, metadata, event_id, occurred_at)
+ON CONFLICT (customer_id, event_id) DO NOTHING
+RETURNING *;
+
+-- name: ClaimUnprocessedUsageEvents :many
+SELECT * FROM usage_events
+WHERE NOT processed
+ORDER BY id
+LIMIT @batch_size
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkUsageEventsProcessed :exec
+UPDATE usage_events
+SET processed = TRUE,
+    period_start = sqlc.narg(period_start),
+    period_end = sqlc.narg(period_end)
+WHERE id = ANY(@ids::uuid[]);
+
+-- name: SumUsageEventsForPeriod :many
+SELECT metric,
+       MAX(period_end)::date AS period_end,
+       SUM(quantity)::bigint AS total_quantity,
+       COALESCE(SUM(cost_cents), 0)::bigint AS total_cost_cents
+FROM usage_events
+WHERE customer_id = @customer_id AND period_start = @period_start AND processed
+GROUP BY metric
+ORDER BY metric;
+
+-- name: ListUsagePeriodCustomers :many
+SELECT customer_id FROM usage_events
+WHERE period_start = @period_start AND processed
+UNION
+SELECT customer_id FROM usage_aggregates
+WHERE period_start = @period_start;
+
+-- name: SumUnprocessedUsageEvents :many
+SELECT metric, SUM(quantity)::bigint AS total_quantity
+FROM usage_events
+WHERE customer_id = @customer_id AND NOT processed
+GROUP BY metric;
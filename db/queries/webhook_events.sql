@@ -0,0 +1,17 @@
+-- name: RecordWebhookEvent :one
+INSERT INTO webhook_events (id, gateway, event_id, event_type, payload)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (gateway, event_id) DO UPDATE SET event_type = EXCLUDED.event_type
+RETURNING *;
+
+-- name: GetWebhookEventForUpdate :one
+SELECT * FROM webhook_events
+WHERE id = $1
+FOR UPDATE;
+
+-- name: SetWebhookEventStatus :exec
+UPDATE webhook_events
+SET status = @status,
+    error = @error,
+    processed_at = @processed_at
+WHERE id = @id;
@@ -17,7 +17,7 @@ CREATE TABLE plans (
   price_cents   BIGINT NOT NULL, -- price per period
   currency      TEXT NOT NULL DEFAULT 'USD',
   interval      TEXT NOT NULL, -- "month", "year"
-  quota_limits  JSONB, -- {"requests_per_month": 100000, "tokens_per_month": 1000000}
+  quota_limits  JSONB, -- {"requests_per_month": 100000, "tokens_per_month": {"limit": 1000000, "enforcement": "overage", "overage_unit_price_cents": 2, "overage_unit_size": 1000}}
   meta          JSONB,
   created_at    TIMESTAMP WITH TIME ZONE DEFAULT now(),
   updated_at    TIMESTAMP WITH TIME ZONE DEFAULT now()
@@ -34,12 +34,14 @@ CREATE TABLE customers (
   updated_at             TIMESTAMP WITH TIME ZONE DEFAULT now()
 );
 
+CREATE UNIQUE INDEX customers_user_id_idx ON customers (user_id);
+
 -- subscriptions
 CREATE TABLE subscriptions (
   id                      UUID PRIMARY KEY,
   customer_id             UUID REFERENCES customers(id),
   plan_id                 UUID REFERENCES plans(id),
-  status                  TEXT NOT NULL, -- "trialing","active","past_due","canceled"
+  status                  TEXT NOT NULL, -- "pending","trialing","active","past_due","canceled"
   trial_ends_at           TIMESTAMP WITH TIME ZONE,
   current_period_start    TIMESTAMP WITH TIME ZONE,
   current_period_end      TIMESTAMP WITH TIME ZONE,
@@ -47,19 +49,43 @@ CREATE TABLE subscriptions (
   gateway_subscription_id TEXT, -- e.g. stripe subscription id
   metadata                JSONB,
   created_at              TIMESTAMP WITH TIME ZONE DEFAULT now(),
-  updated_at              TIMESTAMP WITH TIME ZONE DEFAULT now()
+  updated_at              TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  canceled_at             TIMESTAMP WITH TIME ZONE -- when cancellation was requested
 );
 
+CREATE UNIQUE INDEX subscriptions_current_customer_idx
+  ON subscriptions (customer_id)
+  WHERE status NOT IN ('canceled', 'pending');
+
+CREATE INDEX subscriptions_renewal_due_idx
+  ON subscriptions (current_period_end)
+  WHERE status IN ('active', 'trialing');
+
+CREATE INDEX subscriptions_gateway_id_idx ON subscriptions (gateway_subscription_id);
+
+-- billing periods a subscription went through
+CREATE TABLE subscription_periods (
+  subscription_id UUID NOT NULL REFERENCES subscriptions(id),
+  customer_id     UUID REFERENCES customers(id),
+  period_start    TIMESTAMP WITH TIME ZONE NOT NULL,
+  period_end      TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (subscription_id, period_start)
+);
+
+CREATE INDEX subscription_periods_customer_idx ON subscription_periods (customer_id, period_start);
+
 -- api keys
 CREATE TABLE api_keys (
   id              UUID PRIMARY KEY,
   customer_id     UUID REFERENCES customers(id),
-  api_key         TEXT UNIQUE NOT NULL, -- hashed identifier or token id (store hashed)
-  hashed_key      TEXT NOT NULL,
+  api_key         TEXT UNIQUE NOT NULL, -- public key prefix, e.g. "sk_live_3f9a1c2b7d4e"
+  hashed_key      TEXT NOT NULL, -- sha256 of the full key
   revoked         BOOLEAN DEFAULT FALSE,
   created_at      TIMESTAMP WITH TIME ZONE DEFAULT now(),
   last_used_at    TIMESTAMP WITH TIME ZONE,
-  meta            JSONB
+  meta            JSONB,
+  name            TEXT NOT NULL DEFAULT '', -- human readable label, e.g. "Production Key"
+  scopes          TEXT[] NOT NULL DEFAULT '{}' -- e.g. {"usage:write","quota:read"}
 );
 
 -- invoices
@@ -68,7 +94,7 @@ CREATE TABLE invoices (
   customer_id        UUID REFERENCES customers(id),
   subscription_id    UUID REFERENCES subscriptions(id),
   gateway_invoice_id TEXT,
-  status             TEXT NOT NULL, -- draft, issued, paid, failed, void
+  status             TEXT NOT NULL, -- draft, issued, paid, failed, void, partially_refunded, refunded
   amount_cents       BIGINT NOT NULL,
   currency           TEXT NOT NULL DEFAULT 'USD',
   issued_at          TIMESTAMP WITH TIME ZONE,
@@ -76,9 +102,42 @@ CREATE TABLE invoices (
   paid_at            TIMESTAMP WITH TIME ZONE,
   pdf_url            TEXT,
   metadata           JSONB,
-  created_at         TIMESTAMP WITH TIME ZONE DEFAULT now()
+  created_at         TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  invoice_number     TEXT -- e.g. "INV-2026-000123", assigned when issued
+);
+
+CREATE UNIQUE INDEX invoices_number_idx ON invoices (invoice_number);
+CREATE INDEX invoices_gateway_id_idx ON invoices (gateway_invoice_id);
+
+-- invoice number sequences (one gap-free counter per issuer prefix and year)
+CREATE TABLE invoice_number_sequences (
+  prefix     TEXT NOT NULL,
+  year       INTEGER NOT NULL,
+  last_value BIGINT NOT NULL,
+  PRIMARY KEY (prefix, year)
 );
 
+-- invoice line items (pending items have no invoice yet and are picked up by the next invoice)
+CREATE TABLE invoice_line_items (
+  id                UUID PRIMARY KEY,
+  invoice_id        UUID REFERENCES invoices(id), -- NULL while pending
+  customer_id       UUID NOT NULL REFERENCES customers(id),
+  subscription_id   UUID REFERENCES subscriptions(id),
+  kind              TEXT NOT NULL, -- subscription, usage, overage, proration, credit
+  description       TEXT NOT NULL,
+  quantity          BIGINT NOT NULL DEFAULT 1,
+  unit_amount_cents BIGINT NOT NULL,
+  amount_cents      BIGINT NOT NULL, -- negative for credits
+  currency          TEXT NOT NULL,
+  period_start      TIMESTAMP WITH TIME ZONE,
+  period_end        TIMESTAMP WITH TIME ZONE,
+  metadata          JSONB,
+  created_at        TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX invoice_line_items_invoice_idx ON invoice_line_items (invoice_id);
+CREATE INDEX invoice_line_items_pending_idx ON invoice_line_items (customer_id) WHERE invoice_id IS NULL;
+
 -- transactions
 CREATE TABLE transactions (
   id                 UUID PRIMARY KEY,
@@ -88,10 +147,28 @@ CREATE TABLE transactions (
   gateway_payment_id TEXT,
   amount_cents       BIGINT NOT NULL,
   currency           TEXT NOT NULL,
-  status             TEXT NOT NULL, -- succeeded, failed, refunded
+  status             TEXT NOT NULL, -- pending, succeeded, failed, refunded
   idempotency_key    TEXT,
   raw_response       JSONB,
-  created_at         TIMESTAMP WITH TIME ZONE DEFAULT now()
+  created_at         TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  refunded_transaction_id UUID REFERENCES transactions(id) -- the payment a refunded row pays back
+);
+
+CREATE INDEX transactions_gateway_payment_idx ON transactions (gateway, gateway_payment_id);
+CREATE INDEX transactions_refunded_transaction_idx ON transactions (refunded_transaction_id);
+
+-- webhook events (every verified delivery, kept for replay; one row per gateway event)
+CREATE TABLE webhook_events (
+  id           UUID PRIMARY KEY,
+  gateway      TEXT NOT NULL,
+  event_id     TEXT NOT NULL,
+  event_type   TEXT NOT NULL,
+  payload      JSONB NOT NULL,
+  status       TEXT NOT NULL DEFAULT 'received', -- received, processed, ignored, flagged, failed
+  error        TEXT,
+  received_at  TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  processed_at TIMESTAMP WITH TIME ZONE,
+  UNIQUE (gateway, event_id)
 );
 
 -- usage events (append-only)
@@ -104,9 +181,18 @@ CREATE TABLE usage_events (
   cost_cents      BIGINT DEFAULT 0,
   reported_at     TIMESTAMP WITH TIME ZONE DEFAULT now(),
   processed       BOOLEAN DEFAULT FALSE,
-  metadata        JSONB
+  metadata        JSONB,
+  event_id        TEXT, -- client-supplied, deduplicates retried reports
+  occurred_at     TIMESTAMP WITH TIME ZONE, -- client timestamp
+  period_start    DATE, -- billing period the event was aggregated into
+  period_end      DATE
 );
 
+CREATE UNIQUE INDEX usage_events_customer_event_idx ON usage_events (customer_id, event_id);
+CREATE INDEX usage_events_unprocessed_idx ON usage_events (id) WHERE NOT processed;
+CREATE INDEX usage_events_unprocessed_customer_idx ON usage_events (customer_id) WHERE NOT processed;
+CREATE INDEX usage_events_period_idx ON usage_events (period_start, customer_id);
+
 -- aggregated monthly usage (rollups)
 CREATE TABLE usage_aggregates (
   id                UUID PRIMARY KEY,
@@ -120,3 +206,20 @@ CREATE TABLE usage_aggregates (
   UNIQUE(customer_id, period_start, metric)
 );
 
+-- idempotency keys (responses of mutating requests, replayed when a client retries with the same key)
+CREATE TABLE idempotency_keys (
+  scope            TEXT NOT NULL, -- "user:<id>", "customer:<id>" or "public"
+  idempotency_key  TEXT NOT NULL, -- Idempotency-Key header sent by the client
+  fingerprint      TEXT NOT NULL, -- sha256 of method, path and body
+  status           TEXT NOT NULL, -- in_flight, completed
+  response_status  INTEGER,
+  response_headers JSONB,
+  response_body    BYTEA,
+  created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  completed_at     TIMESTAMP WITH TIME ZONE,
+  expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (scope, idempotency_key)
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
+
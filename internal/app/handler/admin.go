@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+type RefundTransactionRequest struct {
+	AmountCents int64  `json:"amount_cents"`
+	Reason      string `json:"reason"`
+}
+
+// AdminHandler serves the /admin endpoints used by finance and support.
+type AdminHandler struct {
+	refunds service.RefundService
+}
+
+func NewAdminHandler(refunds service.RefundService) *AdminHandler {
+	return &AdminHandler{refunds: refunds}
+}
+
+// RefundTransaction refunds a payment. Without amount_cents, everything that
+// is still refundable is refunded.
+func (h *AdminHandler) RefundTransaction(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid transaction ID", err))
+		return
+	}
+
+	var req RefundTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	refund, err := h.refunds.Refund(r.Context(), service.RefundRequest{
+		TransactionID: id,
+		AmountCents:   req.AmountCents,
+		Reason:        req.Reason,
+	})
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteCreated(w, refund)
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+type ApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type ApiKeyHandler struct {
+	service service.ApiKeyService
+}
+
+func NewApiKeyHandler(s service.ApiKeyService) *ApiKeyHandler {
+	return &ApiKeyHandler{service: s}
+}
+
+func (h *ApiKeyHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	keys, err := h.service.List(r.Context(), userID)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, keys)
+}
+
+func (h *ApiKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	var req ApiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	key, err := h.service.Create(r.Context(), userID, req.Name, req.Scopes)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteCreated(w, key)
+}
+
+func (h *ApiKeyHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid API key ID", err))
+		return
+	}
+
+	var req ApiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	key, err := h.service.Update(r.Context(), userID, id, req.Name, req.Scopes)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, key)
+}
+
+func (h *ApiKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid API key ID", err))
+		return
+	}
+
+	if err := h.service.Revoke(r.Context(), userID, id); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, "API key revoked successfully")
+}
+
+func (h *ApiKeyHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid API key ID", err))
+		return
+	}
+
+	key, err := h.service.Rotate(r.Context(), userID, id)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, key)
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	"github.com/novaru/billing-service/internal/middleware"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+type CreateCheckoutRequest struct {
+	PlanID        string `json:"plan_id"`
+	CustomerEmail string `json:"customer_email"`
+	SuccessURL    string `json:"success_url"`
+	CancelURL     string `json:"cancel_url"`
+}
+
+type CheckoutHandler struct {
+	service service.CheckoutService
+}
+
+func NewCheckoutHandler(s service.CheckoutService) *CheckoutHandler {
+	return &CheckoutHandler{service: s}
+}
+
+func (h *CheckoutHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req CreateCheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	planID, err := uuid.Parse(req.PlanID)
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid plan_id", err))
+		return
+	}
+
+	// Signing in is optional; an anonymous checkout leaves UserID nil
+	var userID uuid.UUID
+	if _, ok := middleware.GetUserID(r); ok {
+		if userID, err = currentUserID(r); err != nil {
+			response.WriteError(w, err)
+			return
+		}
+	}
+
+	session, err := h.service.Create(r.Context(), service.CheckoutRequest{
+		PlanID:        planID,
+		UserID:        userID,
+		CustomerEmail: req.CustomerEmail,
+		SuccessURL:    req.SuccessURL,
+		CancelURL:     req.CancelURL,
+	})
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteCreated(w, session)
+}
+
+// Success is where the gateway redirects after the hosted page. The redirect
+// only names the session; whether it was paid is asked from the gateway.
+func (h *CheckoutHandler) Success(w http.ResponseWriter, r *http.Request) {
+	status, err := h.service.Confirm(r.Context(), r.URL.Query().Get("session_id"))
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, status)
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+type SettlePaymentRequest struct {
+	Status string `json:"status"`
+}
+
+type FakePaymentResponse struct {
+	ID          string `json:"id"`
+	Reference   string `json:"reference"`
+	Status      string `json:"status"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency"`
+}
+
+// FakeGatewayHandler plays the customer and the provider of the fake payment
+// gateway, so checkouts and payments left pending can be completed offline.
+// It is only routed outside production.
+type FakeGatewayHandler struct {
+	gateway  *payment.FakeGateway
+	webhooks service.WebhookService
+}
+
+func NewFakeGatewayHandler(gateway *payment.FakeGateway, webhooks service.WebhookService) *FakeGatewayHandler {
+	return &FakeGatewayHandler{gateway: gateway, webhooks: webhooks}
+}
+
+// CompleteCheckout pays a checkout session like the customer would on the
+// hosted page. The payment follows the fake's next outcome.
+func (h *FakeGatewayHandler) CompleteCheckout(w http.ResponseWriter, r *http.Request) {
+	p, err := h.gateway.CompleteCheckout(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, payment.ErrNotFound) {
+		response.WriteError(w, E.NewNotFoundError("checkout session"))
+		return
+	}
+
+	// Declined and timed-out payments are outcomes to report, not failures
+	h.deliver(w, r, p)
+}
+
+// SettlePayment resolves a pending payment, e.g. one that timed out.
+func (h *FakeGatewayHandler) SettlePayment(w http.ResponseWriter, r *http.Request) {
+	var req SettlePaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+	if req.Status != payment.StatusSucceeded && req.Status != payment.StatusFailed {
+		response.WriteError(w, E.NewInvalidInputError("status must be succeeded or failed", nil))
+		return
+	}
+
+	p, err := h.gateway.Settle(chi.URLParam(r, "id"), req.Status)
+	if err != nil {
+		response.WriteError(w, E.NewNotFoundError("payment"))
+		return
+	}
+
+	h.deliver(w, r, p)
+}
+
+// deliver sends the webhook the provider would send once p settles.
+func (h *FakeGatewayHandler) deliver(w http.ResponseWriter, r *http.Request, p payment.Payment) {
+	if p.Status != payment.StatusPending {
+		header, body, err := h.gateway.SignEvent(h.gateway.PaymentEvent(p))
+		if err != nil {
+			response.WriteError(w, E.NewInternalError("could not sign webhook event", err))
+			return
+		}
+		if err := h.webhooks.Handle(r.Context(), payment.GatewayFake, header, body); err != nil {
+			response.WriteError(w, err)
+			return
+		}
+	}
+
+	response.WriteSuccess(w, FakePaymentResponse{
+		ID:          p.ID,
+		Reference:   p.Reference,
+		Status:      p.Status,
+		AmountCents: p.AmountCents,
+		Currency:    p.Currency,
+	})
+}
@@ -1,18 +1,76 @@
 package handler
 
-import "github.com/novaru/billing-service/internal/app/service"
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	"github.com/novaru/billing-service/internal/middleware"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
 
 type Handlers struct {
-	User *UserHandler
-	Plan *PlanHandler
+	User         *UserHandler
+	Plan         *PlanHandler
+	ApiKey       *ApiKeyHandler
+	Subscription *SubscriptionHandler
+	Invoice      *InvoiceHandler
+	Webhook      *WebhookHandler
+	Checkout     *CheckoutHandler
+	Admin        *AdminHandler
+	Usage        *UsageHandler
+
+	// FakeGateway is only set when the fake payment gateway runs outside
+	// production.
+	FakeGateway *FakeGatewayHandler
 }
 
 func New(
 	userService service.UserService,
 	planService service.PlanService,
+	apiKeyService service.ApiKeyService,
+	subscriptionService service.SubscriptionService,
+	customerService service.CustomerService,
+	invoiceService service.InvoiceService,
+	webhookService service.WebhookService,
+	checkoutService service.CheckoutService,
+	refundService service.RefundService,
+	usageService service.UsageService,
 ) *Handlers {
 	return &Handlers{
-		User: NewUserHandler(userService),
-		Plan: NewPlanHandler(planService),
+		User:         NewUserHandler(userService),
+		Plan:         NewPlanHandler(planService),
+		ApiKey:       NewApiKeyHandler(apiKeyService),
+		Subscription: NewSubscriptionHandler(subscriptionService, customerService),
+		Invoice:      NewInvoiceHandler(invoiceService, customerService),
+		Webhook:      NewWebhookHandler(webhookService),
+		Checkout:     NewCheckoutHandler(checkoutService),
+		Admin:        NewAdminHandler(refundService),
+		Usage:        NewUsageHandler(usageService),
+	}
+}
+
+// currentUserID returns the ID of the user authenticated by AuthMiddleware.
+func currentUserID(r *http.Request) (uuid.UUID, error) {
+	sub, ok := middleware.GetUserID(r)
+	if !ok {
+		return uuid.Nil, E.NewUnauthorizedError("missing user in request context", nil)
+	}
+
+	id, err := uuid.Parse(sub)
+	if err != nil {
+		return uuid.Nil, E.NewUnauthorizedError("invalid token subject", err)
+	}
+
+	return id, nil
+}
+
+// currentCustomer resolves the billing customer of the JWT user.
+func currentCustomer(r *http.Request, customers service.CustomerService) (service.CustomerResponse, error) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		return service.CustomerResponse{}, err
 	}
+	return customers.FindByUserID(r.Context(), userID)
 }
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// maxInvoicePageSize caps the limit query parameter of the invoice list.
+const maxInvoicePageSize = 100
+
+type PayInvoiceRequest struct {
+	UseCreditBalance *bool `json:"use_credit_balance"`
+	UsePaymentMethod *bool `json:"use_payment_method"`
+}
+
+type InvoiceHandler struct {
+	service   service.InvoiceService
+	customers service.CustomerService
+}
+
+func NewInvoiceHandler(s service.InvoiceService, customers service.CustomerService) *InvoiceHandler {
+	return &InvoiceHandler{service: s, customers: customers}
+}
+
+func (h *InvoiceHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	limit = min(limit, maxInvoicePageSize)
+
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	params := service.InvoiceListParams{
+		Status: query.Get("status"),
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	}
+	if params.From, err = parseDateParam(query.Get("from")); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid from date", err))
+		return
+	}
+	if params.To, err = parseDateParam(query.Get("to")); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid to date", err))
+		return
+	}
+
+	customer, err := currentCustomer(r, h.customers)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	invoices, err := h.service.List(r.Context(), customer.ID, params)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, invoices)
+}
+
+func (h *InvoiceHandler) FindByID(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid invoice ID", err))
+		return
+	}
+
+	customer, err := currentCustomer(r, h.customers)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	invoice, err := h.service.Get(r.Context(), customer.ID, id)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, invoice)
+}
+
+// Pay settles an invoice from the credit balance and/or the default payment
+// method. Both are used unless switched off in the body, which may be empty.
+func (h *InvoiceHandler) Pay(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid invoice ID", err))
+		return
+	}
+
+	var req PayInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	customer, err := currentCustomer(r, h.customers)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	invoice, err := h.service.Pay(r.Context(), customer.ID, id, service.PayOptions{
+		UseCreditBalance: req.UseCreditBalance == nil || *req.UseCreditBalance,
+		UsePaymentMethod: req.UsePaymentMethod == nil || *req.UsePaymentMethod,
+	})
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, invoice)
+}
+
+// PDF streams the document of one of the user's invoices.
+func (h *InvoiceHandler) PDF(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid invoice ID", err))
+		return
+	}
+
+	customer, err := currentCustomer(r, h.customers)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	doc, err := h.service.PDF(r.Context(), customer.ID, id)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+	defer doc.Content.Close()
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", doc.Filename))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, doc.Content); err != nil {
+		logger.Error("failed to stream invoice document", zap.String("invoice_id", id.String()), zap.Error(err))
+	}
+}
+
+// parseDateParam accepts a date ("2026-01-31") or an RFC 3339 timestamp. An
+// empty value yields the zero time.
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -53,6 +53,11 @@ func (h *PlanHandler) Create(w http.ResponseWriter, r *http.Request) {
 	)
 	if err != nil {
 		logger.Debug("could not create plan", zap.Error(err))
+		var appErr *E.AppError
+		if errors.As(err, &appErr) {
+			response.WriteError(w, err)
+			return
+		}
 		response.WriteError(w, E.NewInvalidInputError("could not create plan", err))
 		return
 	}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+type CancelSubscriptionRequest struct {
+	Reason            string `json:"reason"`
+	CancelImmediately bool   `json:"cancel_immediately"`
+}
+
+type ChangePlanRequest struct {
+	NewPlanID string `json:"new_plan_id"`
+	Prorate   *bool  `json:"prorate"`
+}
+
+type UpdatePaymentMethodRequest struct {
+	PaymentMethodID string `json:"payment_method_id"`
+}
+
+type SubscriptionHandler struct {
+	service   service.SubscriptionService
+	customers service.CustomerService
+}
+
+func NewSubscriptionHandler(s service.SubscriptionService, customers service.CustomerService) *SubscriptionHandler {
+	return &SubscriptionHandler{service: s, customers: customers}
+}
+
+func (h *SubscriptionHandler) FindCurrent(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.currentSubscription(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, sub)
+}
+
+func (h *SubscriptionHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	var req CancelSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	sub, err := h.currentSubscription(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	canceled, err := h.service.Cancel(r.Context(), sub.ID, !req.CancelImmediately, req.Reason)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, canceled)
+}
+
+func (h *SubscriptionHandler) Reactivate(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.currentSubscription(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	reactivated, err := h.service.Reactivate(r.Context(), sub.ID)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, reactivated)
+}
+
+func (h *SubscriptionHandler) ChangePlan(w http.ResponseWriter, r *http.Request) {
+	var req ChangePlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	planID, err := uuid.Parse(req.NewPlanID)
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid new_plan_id", err))
+		return
+	}
+
+	sub, err := h.currentSubscription(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	prorate := req.Prorate == nil || *req.Prorate
+	changed, err := h.service.ChangePlan(r.Context(), sub.ID, planID, prorate)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, changed)
+}
+
+// PreviewChangePlan returns the proration a plan change would produce now,
+// without changing the subscription.
+func (h *SubscriptionHandler) PreviewChangePlan(w http.ResponseWriter, r *http.Request) {
+	planID, err := uuid.Parse(r.URL.Query().Get("new_plan_id"))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid new_plan_id", err))
+		return
+	}
+
+	sub, err := h.currentSubscription(r)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	preview, err := h.service.PreviewPlanChange(r.Context(), sub.ID, planID)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, preview)
+}
+
+func (h *SubscriptionHandler) UpdatePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	var req UpdatePaymentMethodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	customer, err := currentCustomer(r, h.customers)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	if err := h.customers.UpdatePaymentMethod(r.Context(), customer.ID, req.PaymentMethodID); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, "Payment method updated successfully")
+}
+
+// currentSubscription returns the JWT user's subscription that is not canceled.
+func (h *SubscriptionHandler) currentSubscription(r *http.Request) (service.SubscriptionResponse, error) {
+	customer, err := currentCustomer(r, h.customers)
+	if err != nil {
+		return service.SubscriptionResponse{}, err
+	}
+	return h.service.FindCurrent(r.Context(), customer.ID)
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	"github.com/novaru/billing-service/internal/middleware"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+type ReportUsageRequest struct {
+	EventID   string         `json:"event_id"`
+	Metric    string         `json:"metric"`
+	Quantity  int64          `json:"quantity"`
+	Timestamp *time.Time     `json:"timestamp"`
+	Metadata  map[string]any `json:"metadata"`
+}
+
+// UsageHandler serves the usage endpoints called with a customer API key.
+type UsageHandler struct {
+	service service.UsageService
+}
+
+func NewUsageHandler(s service.UsageService) *UsageHandler {
+	return &UsageHandler{service: s}
+}
+
+// Report records one usage event. A retried event ID answers 200 with the
+// stored event instead of 201.
+func (h *UsageHandler) Report(w http.ResponseWriter, r *http.Request) {
+	customer, ok := middleware.GetCustomer(r)
+	if !ok {
+		response.WriteError(w, E.NewUnauthorizedError("API key required", nil))
+		return
+	}
+
+	var req ReportUsageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	event, err := h.service.Report(r.Context(), customer, req.toReport())
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	if event.Duplicate {
+		response.WriteSuccess(w, event)
+		return
+	}
+	response.WriteCreated(w, event)
+}
+
+func (req ReportUsageRequest) toReport() service.UsageReport {
+	report := service.UsageReport{
+		EventID:  req.EventID,
+		Metric:   req.Metric,
+		Quantity: req.Quantity,
+		Metadata: req.Metadata,
+	}
+	if req.Timestamp != nil {
+		report.Timestamp = *req.Timestamp
+	}
+	return report
+}
+
+type BatchReportUsageRequest struct {
+	Events []ReportUsageRequest `json:"events"`
+}
+
+// BatchReport records many usage events at once. The response lists the
+// outcome of every event, so a partly invalid batch still answers 200.
+func (h *UsageHandler) BatchReport(w http.ResponseWriter, r *http.Request) {
+	customer, ok := middleware.GetCustomer(r)
+	if !ok {
+		response.WriteError(w, E.NewUnauthorizedError("API key required", nil))
+		return
+	}
+
+	var req BatchReportUsageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, E.NewInvalidInputError("invalid JSON format", err))
+		return
+	}
+
+	reports := make([]service.UsageReport, len(req.Events))
+	for i, event := range req.Events {
+		reports[i] = event.toReport()
+	}
+
+	result, err := h.service.BatchReport(r.Context(), customer, reports)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, result)
+}
+
+// QuotaCheck tells whether the quantities in <metric>_needed query params,
+// e.g. ?tokens_needed=1000&requests_needed=1, fit in the remaining quota.
+func (h *UsageHandler) QuotaCheck(w http.ResponseWriter, r *http.Request) {
+	customer, ok := middleware.GetCustomer(r)
+	if !ok {
+		response.WriteError(w, E.NewUnauthorizedError("API key required", nil))
+		return
+	}
+
+	needed := make(map[string]int64)
+	for key, values := range r.URL.Query() {
+		metric, ok := strings.CutSuffix(key, "_needed")
+		if !ok || metric == "" {
+			continue
+		}
+		quantity, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			response.WriteError(w, E.NewInvalidInputError(fmt.Sprintf("%s must be an integer", key), err))
+			return
+		}
+		needed[metric] = quantity
+	}
+
+	quota, err := h.service.CheckQuota(r.Context(), customer, needed)
+	if err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteSuccess(w, quota)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+)
+
+// maxWebhookBody bounds the size of a webhook payload.
+const maxWebhookBody = 1 << 20
+
+type WebhookHandler struct {
+	service service.WebhookService
+}
+
+func NewWebhookHandler(service service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{service: service}
+}
+
+func (h *WebhookHandler) Stripe(w http.ResponseWriter, r *http.Request) {
+	h.receive(w, r, payment.GatewayStripe)
+}
+
+func (h *WebhookHandler) Xendit(w http.ResponseWriter, r *http.Request) {
+	h.receive(w, r, payment.GatewayXendit)
+}
+
+func (h *WebhookHandler) Midtrans(w http.ResponseWriter, r *http.Request) {
+	h.receive(w, r, payment.GatewayMidtrans)
+}
+
+func (h *WebhookHandler) Fake(w http.ResponseWriter, r *http.Request) {
+	h.receive(w, r, payment.GatewayFake)
+}
+
+// receive hands the raw body to the service, since signatures are computed
+// over the exact bytes the gateway sent.
+func (h *WebhookHandler) receive(w http.ResponseWriter, r *http.Request, gateway string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		response.WriteError(w, E.NewInvalidInputError("could not read webhook body", err))
+		return
+	}
+
+	if err := h.service.Handle(r.Context(), gateway, r.Header, body); err != nil {
+		response.WriteError(w, err)
+		return
+	}
+
+	response.WriteJSON(w, http.StatusOK, map[string]bool{"received": true})
+}
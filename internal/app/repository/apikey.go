@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type ApiKeyRepository interface {
+	Create(ctx context.Context, customerID uuid.UUID, name, prefix, hashedKey string, scopes []string) (generated.ApiKey, error)
+	FindByPrefix(ctx context.Context, prefix string) (generated.ApiKey, error)
+	FindForCustomer(ctx context.Context, id, customerID uuid.UUID) (generated.ApiKey, error)
+	ListByCustomer(ctx context.Context, customerID uuid.UUID) ([]generated.ApiKey, error)
+	Update(ctx context.Context, id, customerID uuid.UUID, name string, scopes []string) (generated.ApiKey, error)
+	Revoke(ctx context.Context, id, customerID uuid.UUID) (generated.ApiKey, error)
+	Rotate(ctx context.Context, id, customerID uuid.UUID, prefix, hashedKey string) (generated.ApiKey, error)
+	TouchLastUsed(ctx context.Context, usedAt map[uuid.UUID]time.Time) error
+}
+
+type apiKeyRepository struct {
+	q *generated.Queries
+}
+
+func NewApiKeyRepository(q *generated.Queries) ApiKeyRepository {
+	return &apiKeyRepository{q: q}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, customerID uuid.UUID, name, prefix, hashedKey string, scopes []string) (generated.ApiKey, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	return r.q.CreateApiKey(ctx, generated.CreateApiKeyParams{
+		ID:         id,
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+		Name:       name,
+		ApiKey:     prefix,
+		HashedKey:  hashedKey,
+		Scopes:     scopes,
+	})
+}
+
+func (r *apiKeyRepository) FindByPrefix(ctx context.Context, prefix string) (generated.ApiKey, error) {
+	key, err := r.q.GetApiKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("api key not found", zap.String("prefix", prefix))
+			return generated.ApiKey{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve api key by prefix", zap.String("prefix", prefix), zap.Error(err))
+		return generated.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+func (r *apiKeyRepository) FindForCustomer(ctx context.Context, id, customerID uuid.UUID) (generated.ApiKey, error) {
+	key, err := r.q.GetApiKeyForCustomer(ctx, generated.GetApiKeyForCustomerParams{
+		ID:         id,
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+	})
+	return key, r.mapError(err, id)
+}
+
+func (r *apiKeyRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID) ([]generated.ApiKey, error) {
+	return r.q.ListApiKeysByCustomer(ctx, pgtype.UUID{Bytes: customerID, Valid: true})
+}
+
+func (r *apiKeyRepository) Update(ctx context.Context, id, customerID uuid.UUID, name string, scopes []string) (generated.ApiKey, error) {
+	key, err := r.q.UpdateApiKey(ctx, generated.UpdateApiKeyParams{
+		ID:         id,
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+		Name:       name,
+		Scopes:     scopes,
+	})
+	return key, r.mapError(err, id)
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id, customerID uuid.UUID) (generated.ApiKey, error) {
+	key, err := r.q.RevokeApiKey(ctx, generated.RevokeApiKeyParams{
+		ID:         id,
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+	})
+	return key, r.mapError(err, id)
+}
+
+func (r *apiKeyRepository) Rotate(ctx context.Context, id, customerID uuid.UUID, prefix, hashedKey string) (generated.ApiKey, error) {
+	key, err := r.q.RotateApiKey(ctx, generated.RotateApiKeyParams{
+		ID:         id,
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+		ApiKey:     prefix,
+		HashedKey:  hashedKey,
+	})
+	return key, r.mapError(err, id)
+}
+
+// TouchLastUsed stores last_used_at for many keys in one statement. A stored
+// timestamp is never moved backwards.
+func (r *apiKeyRepository) TouchLastUsed(ctx context.Context, usedAt map[uuid.UUID]time.Time) error {
+	ids := make([]uuid.UUID, 0, len(usedAt))
+	times := make([]pgtype.Timestamptz, 0, len(usedAt))
+	for id, at := range usedAt {
+		ids = append(ids, id)
+		times = append(times, pgtype.Timestamptz{Time: at, Valid: true})
+	}
+
+	return r.q.TouchApiKeysLastUsed(ctx, generated.TouchApiKeysLastUsedParams{
+		Ids:    ids,
+		UsedAt: times,
+	})
+}
+
+// mapError translates a missing row into E.ErrNotFound. Keys owned by another
+// customer are indistinguishable from keys that do not exist.
+func (r *apiKeyRepository) mapError(err error, id uuid.UUID) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Debug("api key not found", zap.String("api_key_id", id.String()))
+		return E.ErrNotFound
+	}
+
+	logger.Error("api key query failed", zap.String("api_key_id", id.String()), zap.Error(err))
+	return err
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type CustomerRepository interface {
+	Create(ctx context.Context, userID uuid.UUID, email string) (generated.Customer, error)
+	FindByID(ctx context.Context, id uuid.UUID) (generated.Customer, error)
+	FindByUserID(ctx context.Context, userID uuid.UUID) (generated.Customer, error)
+	FindByEmail(ctx context.Context, email string) (generated.Customer, error)
+	UpdatePaymentMethod(ctx context.Context, id uuid.UUID, method []byte) (generated.Customer, error)
+	ConsumeCredit(ctx context.Context, id uuid.UUID, maxAmount int64) (int64, error)
+	AddCredit(ctx context.Context, id uuid.UUID, amount int64) (generated.Customer, error)
+}
+
+type customerRepository struct {
+	q *generated.Queries
+}
+
+func NewCustomerRepository(q *generated.Queries) CustomerRepository {
+	return &customerRepository{q: q}
+}
+
+func (r *customerRepository) Create(ctx context.Context, userID uuid.UUID, email string) (generated.Customer, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	return r.q.CreateCustomer(ctx, generated.CreateCustomerParams{
+		ID:     id,
+		UserID: pgtype.UUID{Bytes: userID, Valid: userID != uuid.Nil},
+		Email:  pgtype.Text{String: email, Valid: email != ""},
+	})
+}
+
+func (r *customerRepository) FindByID(ctx context.Context, id uuid.UUID) (generated.Customer, error) {
+	logger.Debug("retrieving customer by ID", zap.String("customer_id", id.String()))
+
+	customer, err := r.q.GetCustomerByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("customer not found", zap.String("customer_id", id.String()))
+			return generated.Customer{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve customer by ID", zap.String("customer_id", id.String()), zap.Error(err))
+		return generated.Customer{}, err
+	}
+
+	return customer, nil
+}
+
+func (r *customerRepository) FindByUserID(ctx context.Context, userID uuid.UUID) (generated.Customer, error) {
+	logger.Debug("retrieving customer by user ID", zap.String("user_id", userID.String()))
+
+	customer, err := r.q.GetCustomerByUserID(ctx, pgtype.UUID{Bytes: userID, Valid: true})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("customer not found", zap.String("user_id", userID.String()))
+			return generated.Customer{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve customer by user ID", zap.String("user_id", userID.String()), zap.Error(err))
+		return generated.Customer{}, err
+	}
+
+	return customer, nil
+}
+
+// FindByEmail returns the oldest customer with the email, ignoring case.
+func (r *customerRepository) FindByEmail(ctx context.Context, email string) (generated.Customer, error) {
+	customer, err := r.q.GetCustomerByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("customer not found by email")
+			return generated.Customer{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve customer by email", zap.Error(err))
+		return generated.Customer{}, err
+	}
+
+	return customer, nil
+}
+
+func (r *customerRepository) UpdatePaymentMethod(ctx context.Context, id uuid.UUID, method []byte) (generated.Customer, error) {
+	return r.q.UpdateCustomerPaymentMethod(ctx, generated.UpdateCustomerPaymentMethodParams{
+		ID:                   id,
+		DefaultPaymentMethod: method,
+	})
+}
+
+// ConsumeCredit takes up to maxAmount from the customer's credit balance and
+// returns the amount taken. The customer row is locked while doing so.
+func (r *customerRepository) ConsumeCredit(ctx context.Context, id uuid.UUID, maxAmount int64) (int64, error) {
+	applied, err := r.q.ConsumeCustomerCredit(ctx, generated.ConsumeCustomerCreditParams{
+		MaxAmount: maxAmount,
+		ID:        id,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, E.ErrNotFound
+		}
+
+		logger.Error("failed to consume customer credit", zap.String("customer_id", id.String()), zap.Error(err))
+		return 0, err
+	}
+
+	return applied, nil
+}
+
+// AddCredit adds amount to the customer's credit balance.
+func (r *customerRepository) AddCredit(ctx context.Context, id uuid.UUID, amount int64) (generated.Customer, error) {
+	return r.q.UpdateCustomerCredits(ctx, generated.UpdateCustomerCreditsParams{
+		ID:                 id,
+		CreditBalanceCents: pgtype.Int8{Int64: amount, Valid: true},
+	})
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type IdempotencyRepository interface {
+	Claim(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (generated.IdempotencyKey, bool, error)
+	Complete(ctx context.Context, scope, key string, status int, header, body []byte) error
+	Release(ctx context.Context, scope, key string) error
+	PurgeExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type idempotencyRepository struct {
+	q *generated.Queries
+}
+
+func NewIdempotencyRepository(q *generated.Queries) IdempotencyRepository {
+	return &idempotencyRepository{q: q}
+}
+
+// Claim reserves key for a new request. When the key is already taken it
+// returns the stored row and false. Expired keys, and in-flight keys created
+// before staleBefore by a request that never finished, are taken over.
+// E.ErrNotFound means the key was released between the two lookups.
+func (r *idempotencyRepository) Claim(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (generated.IdempotencyKey, bool, error) {
+	if err := r.q.DeleteStaleIdempotencyKey(ctx, generated.DeleteStaleIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+		StaleBefore:    pgtype.Timestamptz{Time: staleBefore, Valid: true},
+	}); err != nil {
+		logger.Error("failed to delete stale idempotency key", zap.String("scope", scope), zap.Error(err))
+		return generated.IdempotencyKey{}, false, err
+	}
+
+	row, err := r.q.CreateIdempotencyKey(ctx, generated.CreateIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+		Fingerprint:    fingerprint,
+		ExpiresAt:      pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
+	if err == nil {
+		return row, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("failed to claim idempotency key", zap.String("scope", scope), zap.Error(err))
+		return generated.IdempotencyKey{}, false, err
+	}
+
+	row, err = r.q.GetIdempotencyKey(ctx, generated.GetIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.IdempotencyKey{}, false, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve idempotency key", zap.String("scope", scope), zap.Error(err))
+		return generated.IdempotencyKey{}, false, err
+	}
+
+	return row, false, nil
+}
+
+// Complete stores the response of the request that claimed key.
+func (r *idempotencyRepository) Complete(ctx context.Context, scope, key string, status int, header, body []byte) error {
+	return r.q.CompleteIdempotencyKey(ctx, generated.CompleteIdempotencyKeyParams{
+		ResponseStatus:  pgtype.Int4{Int32: int32(status), Valid: true},
+		ResponseHeaders: header,
+		ResponseBody:    body,
+		Scope:           scope,
+		IdempotencyKey:  key,
+	})
+}
+
+// Release forgets key so the request can be retried.
+func (r *idempotencyRepository) Release(ctx context.Context, scope, key string) error {
+	return r.q.DeleteIdempotencyKey(ctx, generated.DeleteIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+	})
+}
+
+// PurgeExpired deletes keys that expired before now and returns how many.
+func (r *idempotencyRepository) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	return r.q.PurgeExpiredIdempotencyKeys(ctx, pgtype.Timestamptz{Time: now, Valid: true})
+}
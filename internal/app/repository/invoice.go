@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// InvoiceFilter narrows a customer's invoice list. Zero values do not filter.
+type InvoiceFilter struct {
+	Status string
+	From   time.Time
+	To     time.Time
+	Limit  int32
+	Offset int32
+}
+
+type InvoiceRepository interface {
+	Create(ctx context.Context, arg generated.CreateInvoiceParams) (generated.Invoice, error)
+	CreateLineItem(ctx context.Context, arg generated.CreateInvoiceLineItemParams) (generated.InvoiceLineItem, error)
+	ListPendingLineItems(ctx context.Context, customerID uuid.UUID) ([]generated.InvoiceLineItem, error)
+	AttachLineItems(ctx context.Context, invoiceID uuid.UUID, ids []uuid.UUID) error
+	FindByID(ctx context.Context, id uuid.UUID) (generated.Invoice, error)
+	FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Invoice, error)
+	ListLineItems(ctx context.Context, invoiceID uuid.UUID) ([]generated.InvoiceLineItem, error)
+	CountBilledLineItems(ctx context.Context, subscriptionID uuid.UUID, kind string, periodStart time.Time) (int64, error)
+	Finalize(ctx context.Context, id uuid.UUID, number string, amountCents int64, issuedAt, dueAt time.Time) (generated.Invoice, error)
+	NextNumber(ctx context.Context, prefix string, year int) (int64, error)
+	SetPdfURL(ctx context.Context, id uuid.UUID, url string) error
+	ListByCustomer(ctx context.Context, customerID uuid.UUID, filter InvoiceFilter) ([]generated.Invoice, error)
+	MarkPaid(ctx context.Context, id uuid.UUID, paidAt time.Time) (generated.Invoice, error)
+	FindByGatewayIDForUpdate(ctx context.Context, gatewayInvoiceID string) (generated.Invoice, error)
+	MarkFailed(ctx context.Context, id uuid.UUID) (generated.Invoice, error)
+	MarkRefunded(ctx context.Context, id uuid.UUID, status string) (generated.Invoice, error)
+}
+
+type invoiceRepository struct {
+	q *generated.Queries
+}
+
+func NewInvoiceRepository(q *generated.Queries) InvoiceRepository {
+	return &invoiceRepository{q: q}
+}
+
+func (r *invoiceRepository) Create(ctx context.Context, arg generated.CreateInvoiceParams) (generated.Invoice, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	arg.ID = id
+	return r.q.CreateInvoice(ctx, arg)
+}
+
+// CreateLineItem stores a line item. Items without an invoice ID stay pending
+// until the next invoice for the customer is built.
+func (r *invoiceRepository) CreateLineItem(ctx context.Context, arg generated.CreateInvoiceLineItemParams) (generated.InvoiceLineItem, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	arg.ID = id
+	return r.q.CreateInvoiceLineItem(ctx, arg)
+}
+
+// ListPendingLineItems returns the customer's line items not yet on an
+// invoice and locks them until the transaction ends.
+func (r *invoiceRepository) ListPendingLineItems(ctx context.Context, customerID uuid.UUID) ([]generated.InvoiceLineItem, error) {
+	return r.q.ListPendingLineItems(ctx, customerID)
+}
+
+// AttachLineItems moves pending line items onto an invoice.
+func (r *invoiceRepository) AttachLineItems(ctx context.Context, invoiceID uuid.UUID, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.q.AttachLineItems(ctx, generated.AttachLineItemsParams{
+		InvoiceID: pgtype.UUID{Bytes: invoiceID, Valid: true},
+		Ids:       ids,
+	})
+}
+
+func (r *invoiceRepository) FindByID(ctx context.Context, id uuid.UUID) (generated.Invoice, error) {
+	invoice, err := r.q.GetInvoiceByID(ctx, id)
+	if err != nil {
+		return generated.Invoice{}, r.mapError(err, id, "failed to retrieve invoice by ID")
+	}
+
+	return invoice, nil
+}
+
+// FindForUpdate loads the invoice and locks it until the transaction ends.
+func (r *invoiceRepository) FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Invoice, error) {
+	invoice, err := r.q.GetInvoiceForUpdate(ctx, id)
+	if err != nil {
+		return generated.Invoice{}, r.mapError(err, id, "failed to lock invoice")
+	}
+
+	return invoice, nil
+}
+
+func (r *invoiceRepository) ListLineItems(ctx context.Context, invoiceID uuid.UUID) ([]generated.InvoiceLineItem, error) {
+	return r.q.ListLineItemsByInvoice(ctx, pgtype.UUID{Bytes: invoiceID, Valid: true})
+}
+
+// CountBilledLineItems counts line items of the given kind and period start
+// of the subscription that are pending or on a non-void invoice.
+func (r *invoiceRepository) CountBilledLineItems(ctx context.Context, subscriptionID uuid.UUID, kind string, periodStart time.Time) (int64, error) {
+	return r.q.CountBilledLineItems(ctx, generated.CountBilledLineItemsParams{
+		SubscriptionID: pgtype.UUID{Bytes: subscriptionID, Valid: true},
+		Kind:           kind,
+		PeriodStart:    pgtype.Timestamptz{Time: periodStart, Valid: true},
+	})
+}
+
+// Finalize issues a draft invoice with its number and final total.
+// E.ErrNotFound is returned when the invoice is no longer a draft.
+func (r *invoiceRepository) Finalize(ctx context.Context, id uuid.UUID, number string, amountCents int64, issuedAt, dueAt time.Time) (generated.Invoice, error) {
+	invoice, err := r.q.FinalizeInvoice(ctx, generated.FinalizeInvoiceParams{
+		InvoiceNumber: pgtype.Text{String: number, Valid: true},
+		AmountCents:   amountCents,
+		IssuedAt:      pgtype.Timestamptz{Time: issuedAt, Valid: true},
+		DueAt:         pgtype.Timestamptz{Time: dueAt, Valid: true},
+		ID:            id,
+	})
+	if err != nil {
+		return generated.Invoice{}, r.mapError(err, id, "failed to finalize invoice")
+	}
+
+	return invoice, nil
+}
+
+// NextNumber increments and returns the invoice counter of prefix and year.
+// The counter row stays locked until the transaction ends, so concurrent
+// finalizations queue behind each other and a rollback leaves no gap.
+func (r *invoiceRepository) NextNumber(ctx context.Context, prefix string, year int) (int64, error) {
+	return r.q.NextInvoiceNumber(ctx, generated.NextInvoiceNumberParams{
+		Prefix: prefix,
+		Year:   int32(year),
+	})
+}
+
+func (r *invoiceRepository) SetPdfURL(ctx context.Context, id uuid.UUID, url string) error {
+	return r.q.SetInvoicePdfUrl(ctx, generated.SetInvoicePdfUrlParams{
+		ID:     id,
+		PdfUrl: pgtype.Text{String: url, Valid: url != ""},
+	})
+}
+
+// ListByCustomer returns the customer's issued invoices, newest first.
+// Drafts are never listed.
+func (r *invoiceRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID, filter InvoiceFilter) ([]generated.Invoice, error) {
+	return r.q.ListInvoicesByCustomer(ctx, generated.ListInvoicesByCustomerParams{
+		CustomerID:  pgtype.UUID{Bytes: customerID, Valid: true},
+		Status:      pgtype.Text{String: filter.Status, Valid: filter.Status != ""},
+		IssuedFrom:  pgtype.Timestamptz{Time: filter.From, Valid: !filter.From.IsZero()},
+		IssuedTo:    pgtype.Timestamptz{Time: filter.To, Valid: !filter.To.IsZero()},
+		LimitCount:  filter.Limit,
+		OffsetCount: filter.Offset,
+	})
+}
+
+// MarkPaid moves an issued or failed invoice to paid. E.ErrNotFound is
+// returned when the invoice is in any other status.
+func (r *invoiceRepository) MarkPaid(ctx context.Context, id uuid.UUID, paidAt time.Time) (generated.Invoice, error) {
+	invoice, err := r.q.MarkInvoicePaid(ctx, generated.MarkInvoicePaidParams{
+		PaidAt: pgtype.Timestamptz{Time: paidAt, Valid: true},
+		ID:     id,
+	})
+	if err != nil {
+		return generated.Invoice{}, r.mapError(err, id, "failed to mark invoice paid")
+	}
+
+	return invoice, nil
+}
+
+// MarkFailed records a failed payment attempt on an issued invoice.
+func (r *invoiceRepository) MarkFailed(ctx context.Context, id uuid.UUID) (generated.Invoice, error) {
+	invoice, err := r.q.MarkInvoiceFailed(ctx, id)
+	if err != nil {
+		return generated.Invoice{}, r.mapError(err, id, "failed to mark invoice failed")
+	}
+
+	return invoice, nil
+}
+
+// MarkRefunded moves a paid or partially refunded invoice to status.
+// E.ErrNotFound is returned when the invoice is in any other status.
+func (r *invoiceRepository) MarkRefunded(ctx context.Context, id uuid.UUID, status string) (generated.Invoice, error) {
+	invoice, err := r.q.MarkInvoiceRefunded(ctx, generated.MarkInvoiceRefundedParams{
+		Status: status,
+		ID:     id,
+	})
+	if err != nil {
+		return generated.Invoice{}, r.mapError(err, id, "failed to mark invoice refunded")
+	}
+
+	return invoice, nil
+}
+
+// FindByGatewayIDForUpdate locks the invoice the payment gateway knows as
+// gatewayInvoiceID. It must run inside a transaction.
+func (r *invoiceRepository) FindByGatewayIDForUpdate(ctx context.Context, gatewayInvoiceID string) (generated.Invoice, error) {
+	invoice, err := r.q.GetInvoiceByGatewayIDForUpdate(ctx, pgtype.Text{String: gatewayInvoiceID, Valid: true})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.Invoice{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to lock invoice by gateway ID", zap.String("gateway_invoice_id", gatewayInvoiceID), zap.Error(err))
+		return generated.Invoice{}, err
+	}
+
+	return invoice, nil
+}
+
+func (r *invoiceRepository) mapError(err error, id uuid.UUID, msg string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Debug("invoice not found", zap.String("invoice_id", id.String()))
+		return E.ErrNotFound
+	}
+
+	logger.Error(msg, zap.String("invoice_id", id.String()), zap.Error(err))
+	return err
+}
@@ -17,6 +17,7 @@ type PlanRepository interface {
 	Create(ctx context.Context, arg generated.CreatePlanParams) (generated.Plan, error)
 	FindAll(ctx context.Context) ([]generated.Plan, error)
 	FindBySlug(ctx context.Context, slug string) (generated.Plan, error)
+	FindByID(ctx context.Context, id uuid.UUID) (generated.Plan, error)
 }
 
 type planRepository struct {
@@ -66,3 +67,18 @@ func (r *planRepository) FindBySlug(ctx context.Context, slug string) (generated
 	logger.Debug("plan retrieved successfully", zap.String("slug", slug))
 	return plan, nil
 }
+
+func (r *planRepository) FindByID(ctx context.Context, id uuid.UUID) (generated.Plan, error) {
+	plan, err := r.q.GetPlanByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("plan not found", zap.String("plan_id", id.String()))
+			return generated.Plan{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve plan by ID", zap.String("plan_id", id.String()), zap.Error(err))
+		return generated.Plan{}, err
+	}
+
+	return plan, nil
+}
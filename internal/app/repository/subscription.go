@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type SubscriptionRepository interface {
+	Create(ctx context.Context, arg generated.CreateSubscriptionParams) (generated.Subscription, error)
+	FindByID(ctx context.Context, id uuid.UUID) (generated.Subscription, error)
+	FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Subscription, error)
+	FindCurrentByCustomer(ctx context.Context, customerID uuid.UUID) (generated.Subscription, error)
+	UpdateState(ctx context.Context, expectedStatus string, sub generated.Subscription) (generated.Subscription, error)
+	CancelPending(ctx context.Context, customerID, keepID uuid.UUID, now time.Time) error
+	UpdatePlan(ctx context.Context, expectedStatus string, sub generated.Subscription) (generated.Subscription, error)
+	MergeMetadata(ctx context.Context, id uuid.UUID, patch []byte) error
+	ClaimDue(ctx context.Context, now time.Time, skip []uuid.UUID) (generated.Subscription, error)
+	FindByGatewayID(ctx context.Context, gatewaySubscriptionID string) (generated.Subscription, error)
+	SetGatewayID(ctx context.Context, id uuid.UUID, gatewaySubscriptionID string) error
+	ListPeriodsSince(ctx context.Context, customerID uuid.UUID, since time.Time) ([]generated.SubscriptionPeriod, error)
+}
+
+type subscriptionRepository struct {
+	q *generated.Queries
+}
+
+func NewSubscriptionRepository(q *generated.Queries) SubscriptionRepository {
+	return &subscriptionRepository{q: q}
+}
+
+func (r *subscriptionRepository) Create(ctx context.Context, arg generated.CreateSubscriptionParams) (generated.Subscription, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	sub, err := r.q.CreateSubscription(ctx, generated.CreateSubscriptionParams{
+		ID:                 id,
+		CustomerID:         arg.CustomerID,
+		PlanID:             arg.PlanID,
+		Status:             arg.Status,
+		TrialEndsAt:        arg.TrialEndsAt,
+		CurrentPeriodStart: arg.CurrentPeriodStart,
+		CurrentPeriodEnd:   arg.CurrentPeriodEnd,
+	})
+	if err != nil {
+		return generated.Subscription{}, err
+	}
+
+	if err := r.recordPeriod(ctx, sub.ID); err != nil {
+		return generated.Subscription{}, err
+	}
+
+	return sub, nil
+}
+
+// recordPeriod adds the current period of the subscription to its period
+// history, or moves the end of a period already in it. Pending checkouts
+// have no period yet.
+func (r *subscriptionRepository) recordPeriod(ctx context.Context, id uuid.UUID) error {
+	if err := r.q.RecordSubscriptionPeriod(ctx, id); err != nil {
+		logger.Error("failed to record subscription period", zap.String("subscription_id", id.String()), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ListPeriodsSince returns the customer's subscription periods that end
+// after since, oldest first.
+func (r *subscriptionRepository) ListPeriodsSince(ctx context.Context, customerID uuid.UUID, since time.Time) ([]generated.SubscriptionPeriod, error) {
+	return r.q.ListSubscriptionPeriodsSince(ctx, generated.ListSubscriptionPeriodsSinceParams{
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+		Since:      pgtype.Timestamptz{Time: since, Valid: true},
+	})
+}
+
+func (r *subscriptionRepository) FindByID(ctx context.Context, id uuid.UUID) (generated.Subscription, error) {
+	sub, err := r.q.GetSubscriptionByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("subscription not found", zap.String("subscription_id", id.String()))
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve subscription by ID", zap.String("subscription_id", id.String()), zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	return sub, nil
+}
+
+// FindForUpdate loads the subscription and locks it until the transaction
+// ends.
+func (r *subscriptionRepository) FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Subscription, error) {
+	sub, err := r.q.GetSubscriptionForUpdate(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("subscription not found", zap.String("subscription_id", id.String()))
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to lock subscription", zap.String("subscription_id", id.String()), zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	return sub, nil
+}
+
+func (r *subscriptionRepository) FindCurrentByCustomer(ctx context.Context, customerID uuid.UUID) (generated.Subscription, error) {
+	sub, err := r.q.GetCurrentSubscriptionByCustomer(ctx, pgtype.UUID{Bytes: customerID, Valid: true})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("no current subscription", zap.String("customer_id", customerID.String()))
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve current subscription", zap.String("customer_id", customerID.String()), zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	return sub, nil
+}
+
+// CancelPending cancels the customer's pending subscriptions other than
+// keepID.
+func (r *subscriptionRepository) CancelPending(ctx context.Context, customerID, keepID uuid.UUID, now time.Time) error {
+	return r.q.CancelPendingSubscriptions(ctx, generated.CancelPendingSubscriptionsParams{
+		CanceledAt: pgtype.Timestamptz{Time: now, Valid: true},
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+		ID:         keepID,
+	})
+}
+
+// UpdateState persists the lifecycle fields of sub, but only if the stored
+// status still equals expectedStatus. E.ErrNotFound is returned when another
+// writer changed the status first. The current period is recorded in the
+// subscription's period history.
+func (r *subscriptionRepository) UpdateState(ctx context.Context, expectedStatus string, sub generated.Subscription) (generated.Subscription, error) {
+	updated, err := r.q.UpdateSubscriptionState(ctx, generated.UpdateSubscriptionStateParams{
+		Status:             sub.Status,
+		TrialEndsAt:        sub.TrialEndsAt,
+		CurrentPeriodStart: sub.CurrentPeriodStart,
+		CurrentPeriodEnd:   sub.CurrentPeriodEnd,
+		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+		CanceledAt:         sub.CanceledAt,
+		ID:                 sub.ID,
+		ExpectedStatus:     expectedStatus,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("subscription state changed concurrently",
+				zap.String("subscription_id", sub.ID.String()),
+				zap.String("expected_status", expectedStatus))
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to update subscription state", zap.String("subscription_id", sub.ID.String()), zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	if err := r.recordPeriod(ctx, updated.ID); err != nil {
+		return generated.Subscription{}, err
+	}
+
+	return updated, nil
+}
+
+// UpdatePlan stores the plan and current period of sub, but only if the stored
+// status still equals expectedStatus. E.ErrNotFound is returned when the
+// status changed meanwhile. The current period is recorded in the
+// subscription's period history.
+func (r *subscriptionRepository) UpdatePlan(ctx context.Context, expectedStatus string, sub generated.Subscription) (generated.Subscription, error) {
+	id := sub.ID
+	updated, err := r.q.UpdateSubscriptionPlan(ctx, generated.UpdateSubscriptionPlanParams{
+		PlanID:             sub.PlanID,
+		CurrentPeriodStart: sub.CurrentPeriodStart,
+		CurrentPeriodEnd:   sub.CurrentPeriodEnd,
+		ID:                 id,
+		ExpectedStatus:     expectedStatus,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to update subscription plan", zap.String("subscription_id", id.String()), zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	if err := r.recordPeriod(ctx, id); err != nil {
+		return generated.Subscription{}, err
+	}
+
+	return updated, nil
+}
+
+// MergeMetadata shallow-merges patch, a JSON object, into subscriptions.metadata.
+func (r *subscriptionRepository) MergeMetadata(ctx context.Context, id uuid.UUID, patch []byte) error {
+	return r.q.MergeSubscriptionMetadata(ctx, generated.MergeSubscriptionMetadataParams{
+		Patch: patch,
+		ID:    id,
+	})
+}
+
+// ClaimDue locks one active or trialing subscription whose period ended at or
+// before now, skipping rows locked by other workers and the IDs in skip. It
+// must run inside a transaction; E.ErrNotFound means nothing is due.
+func (r *subscriptionRepository) ClaimDue(ctx context.Context, now time.Time, skip []uuid.UUID) (generated.Subscription, error) {
+	if skip == nil {
+		skip = []uuid.UUID{}
+	}
+
+	sub, err := r.q.ClaimDueSubscription(ctx, generated.ClaimDueSubscriptionParams{
+		Now:  pgtype.Timestamptz{Time: now, Valid: true},
+		Skip: skip,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to claim due subscription", zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	return sub, nil
+}
+
+func (r *subscriptionRepository) FindByGatewayID(ctx context.Context, gatewaySubscriptionID string) (generated.Subscription, error) {
+	sub, err := r.q.GetSubscriptionByGatewayID(ctx, pgtype.Text{String: gatewaySubscriptionID, Valid: true})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("subscription not found", zap.String("gateway_subscription_id", gatewaySubscriptionID))
+			return generated.Subscription{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve subscription by gateway ID", zap.String("gateway_subscription_id", gatewaySubscriptionID), zap.Error(err))
+		return generated.Subscription{}, err
+	}
+
+	return sub, nil
+}
+
+// SetGatewayID links the subscription to its copy at the payment gateway.
+func (r *subscriptionRepository) SetGatewayID(ctx context.Context, id uuid.UUID, gatewaySubscriptionID string) error {
+	return r.q.SetGatewaySubscriptionID(ctx, generated.SetGatewaySubscriptionIDParams{
+		GatewaySubscriptionID: pgtype.Text{String: gatewaySubscriptionID, Valid: true},
+		ID:                    id,
+	})
+}
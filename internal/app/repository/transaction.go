@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type TransactionRepository interface {
+	Create(ctx context.Context, arg generated.CreateTransactionParams) (generated.Transaction, error)
+	ListByInvoice(ctx context.Context, invoiceID uuid.UUID) ([]generated.Transaction, error)
+	FindByGatewayPaymentForUpdate(ctx context.Context, gateway, paymentID string) (generated.Transaction, error)
+	UpdateStatus(ctx context.Context, id uuid.UUID, status, paymentID string, raw []byte) (generated.Transaction, error)
+	FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Transaction, error)
+	ListRefunds(ctx context.Context, id uuid.UUID) ([]generated.Transaction, error)
+}
+
+type transactionRepository struct {
+	q *generated.Queries
+}
+
+func NewTransactionRepository(q *generated.Queries) TransactionRepository {
+	return &transactionRepository{q: q}
+}
+
+func (r *transactionRepository) Create(ctx context.Context, arg generated.CreateTransactionParams) (generated.Transaction, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	arg.ID = id
+	return r.q.CreateTransaction(ctx, arg)
+}
+
+func (r *transactionRepository) ListByInvoice(ctx context.Context, invoiceID uuid.UUID) ([]generated.Transaction, error) {
+	return r.q.ListTransactionsByInvoice(ctx, pgtype.UUID{Bytes: invoiceID, Valid: true})
+}
+
+// FindByGatewayPaymentForUpdate locks the latest transaction recorded for a
+// gateway payment. It must run inside a transaction.
+func (r *transactionRepository) FindByGatewayPaymentForUpdate(ctx context.Context, gateway, paymentID string) (generated.Transaction, error) {
+	txn, err := r.q.GetTransactionByGatewayPayment(ctx, generated.GetTransactionByGatewayPaymentParams{
+		Gateway:          gateway,
+		GatewayPaymentID: pgtype.Text{String: paymentID, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.Transaction{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to lock transaction",
+			zap.String("gateway", gateway),
+			zap.String("gateway_payment_id", paymentID),
+			zap.Error(err))
+		return generated.Transaction{}, err
+	}
+
+	return txn, nil
+}
+
+// UpdateStatus settles a transaction. An empty paymentID keeps the stored
+// gateway payment ID and a nil raw keeps the stored response.
+func (r *transactionRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status, paymentID string, raw []byte) (generated.Transaction, error) {
+	return r.q.UpdateTransactionStatus(ctx, generated.UpdateTransactionStatusParams{
+		Status:           status,
+		GatewayPaymentID: pgtype.Text{String: paymentID, Valid: paymentID != ""},
+		RawResponse:      raw,
+		ID:               id,
+	})
+}
+
+// FindForUpdate loads the transaction and locks it until the transaction
+// ends.
+func (r *transactionRepository) FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Transaction, error) {
+	txn, err := r.q.GetTransactionForUpdate(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.Transaction{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to lock transaction", zap.String("transaction_id", id.String()), zap.Error(err))
+		return generated.Transaction{}, err
+	}
+
+	return txn, nil
+}
+
+// ListRefunds returns the refunds recorded against a payment transaction.
+func (r *transactionRepository) ListRefunds(ctx context.Context, id uuid.UUID) ([]generated.Transaction, error) {
+	return r.q.ListTransactionRefunds(ctx, pgtype.UUID{Bytes: id, Valid: true})
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+const (
+	// maxTxAttempts bounds how often a unit of work is retried after a
+	// serialization failure or deadlock.
+	maxTxAttempts = 5
+	// txRetryBaseDelay is the base backoff between attempts.
+	txRetryBaseDelay = 10 * time.Millisecond
+)
+
+// Repositories groups every repository bound to the same database handle,
+// either the pool or a single transaction.
+type Repositories struct {
+	Users         UserRepository
+	Plans         PlanRepository
+	Customers     CustomerRepository
+	ApiKeys       ApiKeyRepository
+	Subscriptions SubscriptionRepository
+	Invoices      InvoiceRepository
+	Usage         UsageRepository
+	Transactions  TransactionRepository
+	Webhooks      WebhookEventRepository
+	Idempotency   IdempotencyRepository
+}
+
+func NewRepositories(q *generated.Queries) Repositories {
+	return Repositories{
+		Users:         NewUserRepository(q),
+		Plans:         NewPlanRepository(q),
+		Customers:     NewCustomerRepository(q),
+		ApiKeys:       NewApiKeyRepository(q),
+		Subscriptions: NewSubscriptionRepository(q),
+		Invoices:      NewInvoiceRepository(q),
+		Usage:         NewUsageRepository(q),
+		Transactions:  NewTransactionRepository(q),
+		Webhooks:      NewWebhookEventRepository(q),
+		Idempotency:   NewIdempotencyRepository(q),
+	}
+}
+
+// UnitOfWork runs a function against repositories bound to one transaction.
+// The transaction commits when fn returns nil and rolls back otherwise.
+//
+// Serialization failures and deadlocks roll back and run fn again, so fn must
+// not have side effects outside the database other than assigning results.
+type UnitOfWork interface {
+	// Do runs fn in a READ COMMITTED transaction.
+	Do(ctx context.Context, fn func(repos Repositories) error) error
+	// DoSerializable runs fn in a SERIALIZABLE transaction. Use it when fn
+	// reads data it later writes based on, such as balances.
+	DoSerializable(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+type pgxUnitOfWork struct {
+	pool *pgxpool.Pool
+}
+
+func NewUnitOfWork(pool *pgxpool.Pool) UnitOfWork {
+	return &pgxUnitOfWork{pool: pool}
+}
+
+func (u *pgxUnitOfWork) Do(ctx context.Context, fn func(repos Repositories) error) error {
+	return u.run(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted}, fn)
+}
+
+func (u *pgxUnitOfWork) DoSerializable(ctx context.Context, fn func(repos Repositories) error) error {
+	return u.run(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable}, fn)
+}
+
+func (u *pgxUnitOfWork) run(ctx context.Context, opts pgx.TxOptions, fn func(repos Repositories) error) error {
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = pgx.BeginTxFunc(ctx, u.pool, opts, func(tx pgx.Tx) error {
+			return fn(NewRepositories(generated.New(tx)))
+		})
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		logger.Debug("retrying transaction", zap.Int("attempt", attempt), zap.Error(err))
+
+		// Linear backoff with jitter so competing transactions spread out
+		delay := time.Duration(attempt)*txRetryBaseDelay + rand.N(txRetryBaseDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	logger.Error("transaction failed after retries", zap.Int("attempts", maxTxAttempts), zap.Error(err))
+	return err
+}
+
+// isRetryable reports whether err is a serialization failure or a deadlock.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "wrapped", err: fmt.Errorf("lock invoice: %w", &pgconn.PgError{Code: "40001"}), want: true},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "not a database error", err: errors.New("connection reset")},
+		{name: "nil", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Fatalf("isRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type UsageRepository interface {
+	ListAggregatesForPeriod(ctx context.Context, customerID uuid.UUID, from, to time.Time) ([]generated.UsageAggregate, error)
+	RecordEvent(ctx context.Context, arg generated.CreateUsageEventParams) (generated.UsageEvent, bool, error)
+	RecordEvents(ctx context.Context, arg generated.CreateUsageEventsParams) ([]generated.UsageEvent, error)
+	FindEvents(ctx context.Context, customerID uuid.UUID, eventIDs []string) ([]generated.UsageEvent, error)
+	ClaimUnprocessed(ctx context.Context, limit int) ([]generated.UsageEvent, error)
+	MarkProcessed(ctx context.Context, ids []uuid.UUID, periodStart, periodEnd time.Time) error
+	AddAggregate(ctx context.Context, arg generated.AddUsageAggregateParams) error
+	LockAggregates(ctx context.Context) error
+	DeleteAggregatesForPeriod(ctx context.Context, customerID uuid.UUID, periodStart time.Time) error
+	SumEventsForPeriod(ctx context.Context, customerID uuid.UUID, periodStart time.Time) ([]generated.SumUsageEventsForPeriodRow, error)
+	ListPeriodCustomers(ctx context.Context, periodStart time.Time) ([]uuid.UUID, error)
+	SumUnprocessed(ctx context.Context, customerID uuid.UUID) ([]generated.SumUnprocessedUsageEventsRow, error)
+}
+
+type usageRepository struct {
+	q *generated.Queries
+}
+
+func NewUsageRepository(q *generated.Queries) UsageRepository {
+	return &usageRepository{q: q}
+}
+
+// ListAggregatesForPeriod returns the customer's usage rollups whose period
+// starts on or after from and before to.
+func (r *usageRepository) ListAggregatesForPeriod(ctx context.Context, customerID uuid.UUID, from, to time.Time) ([]generated.UsageAggregate, error) {
+	return r.q.ListUsageAggregatesForPeriod(ctx, generated.ListUsageAggregatesForPeriodParams{
+		CustomerID:  pgtype.UUID{Bytes: customerID, Valid: true},
+		PeriodStart: pgtype.Date{Time: from, Valid: true},
+		PeriodEnd:   pgtype.Date{Time: to, Valid: true},
+	})
+}
+
+// RecordEvent appends a usage event. When the customer already reported an
+// event with the same event ID it returns the stored event and false.
+func (r *usageRepository) RecordEvent(ctx context.Context, arg generated.CreateUsageEventParams) (generated.UsageEvent, bool, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	arg.ID = id
+	event, err := r.q.CreateUsageEvent(ctx, arg)
+	if err == nil {
+		return event, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logger.Error("failed to record usage event", zap.String("event_id", arg.EventID.String), zap.Error(err))
+		return generated.UsageEvent{}, false, err
+	}
+
+	event, err = r.q.GetUsageEventByEventID(ctx, generated.GetUsageEventByEventIDParams{
+		CustomerID: arg.CustomerID,
+		EventID:    arg.EventID,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.UsageEvent{}, false, E.ErrNotFound
+		}
+
+		logger.Error("failed to retrieve usage event", zap.String("event_id", arg.EventID.String), zap.Error(err))
+		return generated.UsageEvent{}, false, err
+	}
+
+	return event, false, nil
+}
+
+// RecordEvents appends a batch of usage events in one statement. Events whose
+// event ID the customer already reported are skipped; only the inserted
+// events are returned.
+func (r *usageRepository) RecordEvents(ctx context.Context, arg generated.CreateUsageEventsParams) ([]generated.UsageEvent, error) {
+	arg.Ids = make([]uuid.UUID, len(arg.EventIds))
+	for i := range arg.Ids {
+		id, err := uuid.NewV7()
+		if err != nil {
+			logger.Fatal("failed to generate uuid:", zap.Error(err))
+		}
+		arg.Ids[i] = id
+	}
+
+	events, err := r.q.CreateUsageEvents(ctx, arg)
+	if err != nil {
+		logger.Error("failed to record usage events", zap.Int("count", len(arg.EventIds)), zap.Error(err))
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// FindEvents returns the customer's events reported with any of eventIDs.
+func (r *usageRepository) FindEvents(ctx context.Context, customerID uuid.UUID, eventIDs []string) ([]generated.UsageEvent, error) {
+	return r.q.ListUsageEventsByEventIDs(ctx, generated.ListUsageEventsByEventIDsParams{
+		CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+		EventIds:   eventIDs,
+	})
+}
+
+// ClaimUnprocessed locks up to limit events that were not aggregated yet,
+// skipping events another worker holds. It must run inside a transaction.
+func (r *usageRepository) ClaimUnprocessed(ctx context.Context, limit int) ([]generated.UsageEvent, error) {
+	return r.q.ClaimUnprocessedUsageEvents(ctx, int32(limit))
+}
+
+// MarkProcessed records that events were counted in the aggregates of a
+// billing period. Zero times leave the period empty for events that were not
+// counted anywhere.
+func (r *usageRepository) MarkProcessed(ctx context.Context, ids []uuid.UUID, periodStart, periodEnd time.Time) error {
+	return r.q.MarkUsageEventsProcessed(ctx, generated.MarkUsageEventsProcessedParams{
+		PeriodStart: pgtype.Date{Time: periodStart, Valid: !periodStart.IsZero()},
+		PeriodEnd:   pgtype.Date{Time: periodEnd, Valid: !periodEnd.IsZero()},
+		Ids:         ids,
+	})
+}
+
+// AddAggregate adds quantities and costs to a customer's rollup of a metric
+// for a period, creating the rollup when needed.
+func (r *usageRepository) AddAggregate(ctx context.Context, arg generated.AddUsageAggregateParams) error {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	arg.ID = id
+	return r.q.AddUsageAggregate(ctx, arg)
+}
+
+// LockAggregates blocks aggregate writes by other transactions until the
+// transaction ends, so a rebuild does not race with the aggregation worker.
+func (r *usageRepository) LockAggregates(ctx context.Context) error {
+	return r.q.LockUsageAggregates(ctx)
+}
+
+func (r *usageRepository) DeleteAggregatesForPeriod(ctx context.Context, customerID uuid.UUID, periodStart time.Time) error {
+	return r.q.DeleteUsageAggregatesForPeriod(ctx, generated.DeleteUsageAggregatesForPeriodParams{
+		CustomerID:  pgtype.UUID{Bytes: customerID, Valid: true},
+		PeriodStart: pgtype.Date{Time: periodStart, Valid: true},
+	})
+}
+
+// SumEventsForPeriod totals, per metric, the processed events that were
+// counted in the customer's period starting at periodStart.
+func (r *usageRepository) SumEventsForPeriod(ctx context.Context, customerID uuid.UUID, periodStart time.Time) ([]generated.SumUsageEventsForPeriodRow, error) {
+	return r.q.SumUsageEventsForPeriod(ctx, generated.SumUsageEventsForPeriodParams{
+		CustomerID:  pgtype.UUID{Bytes: customerID, Valid: true},
+		PeriodStart: pgtype.Date{Time: periodStart, Valid: true},
+	})
+}
+
+// ListPeriodCustomers returns the customers with usage events or rollups for
+// the period starting at periodStart.
+func (r *usageRepository) ListPeriodCustomers(ctx context.Context, periodStart time.Time) ([]uuid.UUID, error) {
+	rows, err := r.q.ListUsagePeriodCustomers(ctx, pgtype.Date{Time: periodStart, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		if row.Valid {
+			ids = append(ids, row.Bytes)
+		}
+	}
+	return ids, nil
+}
+
+// SumUnprocessed totals, per metric, the customer's events that were not
+// aggregated yet.
+func (r *usageRepository) SumUnprocessed(ctx context.Context, customerID uuid.UUID) ([]generated.SumUnprocessedUsageEventsRow, error) {
+	return r.q.SumUnprocessedUsageEvents(ctx, pgtype.UUID{Bytes: customerID, Valid: true})
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+type WebhookEventRepository interface {
+	Record(ctx context.Context, gateway, eventID, eventType string, payload []byte) (generated.WebhookEvent, error)
+	FindForUpdate(ctx context.Context, id uuid.UUID) (generated.WebhookEvent, error)
+	SetStatus(ctx context.Context, id uuid.UUID, status, errMsg string, processedAt time.Time) error
+}
+
+type webhookEventRepository struct {
+	q *generated.Queries
+}
+
+func NewWebhookEventRepository(q *generated.Queries) WebhookEventRepository {
+	return &webhookEventRepository{q: q}
+}
+
+// Record stores a delivered event. Redeliveries of the same gateway event
+// return the stored row, so callers can tell from its status whether the
+// event was handled before.
+func (r *webhookEventRepository) Record(ctx context.Context, gateway, eventID, eventType string, payload []byte) (generated.WebhookEvent, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		logger.Fatal("failed to generate uuid:", zap.Error(err))
+	}
+
+	return r.q.RecordWebhookEvent(ctx, generated.RecordWebhookEventParams{
+		ID:        id,
+		Gateway:   gateway,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+	})
+}
+
+// FindForUpdate locks a stored event. It must run inside a transaction.
+func (r *webhookEventRepository) FindForUpdate(ctx context.Context, id uuid.UUID) (generated.WebhookEvent, error) {
+	event, err := r.q.GetWebhookEventForUpdate(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return generated.WebhookEvent{}, E.ErrNotFound
+		}
+
+		logger.Error("failed to lock webhook event", zap.String("webhook_event_id", id.String()), zap.Error(err))
+		return generated.WebhookEvent{}, err
+	}
+
+	return event, nil
+}
+
+// SetStatus records the outcome of handling an event. An empty errMsg clears
+// the stored error.
+func (r *webhookEventRepository) SetStatus(ctx context.Context, id uuid.UUID, status, errMsg string, processedAt time.Time) error {
+	return r.q.SetWebhookEventStatus(ctx, generated.SetWebhookEventStatusParams{
+		Status:      status,
+		Error:       pgtype.Text{String: errMsg, Valid: errMsg != ""},
+		ProcessedAt: pgtype.Timestamptz{Time: processedAt, Valid: !processedAt.IsZero()},
+		ID:          id,
+	})
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+const (
+	// apiKeyScheme is the fixed leading part of every API key.
+	apiKeyScheme = "sk_live_"
+	// apiKeyIDLength is the length of the random public identifier that
+	// follows the scheme. Scheme plus identifier form the stored prefix.
+	apiKeyIDLength = 12
+	// apiKeySecretBytes is the number of random bytes in the secret part.
+	apiKeySecretBytes = 32
+)
+
+// API key scopes. A key may only call routes guarded by a scope it was granted.
+const (
+	ScopeUsageWrite = "usage:write"
+	ScopeUsageRead  = "usage:read"
+	ScopeQuotaRead  = "quota:read"
+)
+
+// AllScopes lists every scope a key can be granted. Keys created without an
+// explicit scope list receive all of them.
+var AllScopes = []string{ScopeUsageWrite, ScopeUsageRead, ScopeQuotaRead}
+
+type ApiKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Revoked    bool       `json:"revoked"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ApiKeySecretResponse carries the plaintext key. It is only returned when a
+// key is created or rotated and can never be retrieved again.
+type ApiKeySecretResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+// CustomerPrincipal identifies the customer that owns an authenticated API key.
+type CustomerPrincipal struct {
+	APIKeyID   uuid.UUID
+	CustomerID uuid.UUID
+	UserID     uuid.UUID
+	Email      string
+	Scopes     []string
+}
+
+// HasScope reports whether the key was granted the given scope.
+func (p CustomerPrincipal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type ApiKeyService interface {
+	Authenticate(ctx context.Context, rawKey string) (CustomerPrincipal, error)
+	Create(ctx context.Context, userID uuid.UUID, name string, scopes []string) (ApiKeySecretResponse, error)
+	List(ctx context.Context, userID uuid.UUID) ([]ApiKeyResponse, error)
+	Update(ctx context.Context, userID, id uuid.UUID, name string, scopes []string) (ApiKeyResponse, error)
+	Revoke(ctx context.Context, userID, id uuid.UUID) error
+	Rotate(ctx context.Context, userID, id uuid.UUID) (ApiKeySecretResponse, error)
+}
+
+type apiKeyService struct {
+	repo      repository.ApiKeyRepository
+	customers repository.CustomerRepository
+	tracker   *LastUsedTracker
+}
+
+func NewApiKeyService(repo repository.ApiKeyRepository, customers repository.CustomerRepository, tracker *LastUsedTracker) ApiKeyService {
+	return &apiKeyService{repo: repo, customers: customers, tracker: tracker}
+}
+
+// Authenticate resolves a raw API key of the form sk_live_<id>_<secret> to the
+// customer owning it. Every failure is reported as the same unauthorized error
+// so callers cannot probe which part of a key was wrong.
+func (s *apiKeyService) Authenticate(ctx context.Context, rawKey string) (CustomerPrincipal, error) {
+	invalid := E.NewUnauthorizedError("invalid API key", nil)
+
+	prefix, ok := parseAPIKey(rawKey)
+	if !ok {
+		return CustomerPrincipal{}, invalid
+	}
+
+	key, err := s.repo.FindByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return CustomerPrincipal{}, invalid
+		}
+		return CustomerPrincipal{}, E.NewInternalError("could not validate API key", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(rawKey)), []byte(key.HashedKey)) != 1 {
+		return CustomerPrincipal{}, invalid
+	}
+	if key.Revoked.Valid && key.Revoked.Bool {
+		return CustomerPrincipal{}, E.NewUnauthorizedError("API key has been revoked", nil)
+	}
+	if !key.CustomerID.Valid {
+		return CustomerPrincipal{}, invalid
+	}
+
+	customer, err := s.customers.FindByID(ctx, key.CustomerID.Bytes)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return CustomerPrincipal{}, invalid
+		}
+		return CustomerPrincipal{}, E.NewInternalError("could not validate API key", err)
+	}
+
+	principal := CustomerPrincipal{
+		APIKeyID:   key.ID,
+		CustomerID: customer.ID,
+		Email:      customer.Email.String,
+		Scopes:     key.Scopes,
+	}
+	if customer.UserID.Valid {
+		principal.UserID = customer.UserID.Bytes
+	}
+
+	s.tracker.Record(key.ID, time.Now())
+
+	return principal, nil
+}
+
+func (s *apiKeyService) Create(ctx context.Context, userID uuid.UUID, name string, scopes []string) (ApiKeySecretResponse, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ApiKeySecretResponse{}, E.NewInvalidInputError("name is required", nil)
+	}
+
+	if scopes == nil {
+		scopes = AllScopes
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return ApiKeySecretResponse{}, err
+	}
+
+	customer, err := s.customerFor(ctx, userID)
+	if err != nil {
+		return ApiKeySecretResponse{}, err
+	}
+
+	rawKey, prefix, err := generateAPIKey()
+	if err != nil {
+		return ApiKeySecretResponse{}, E.NewInternalError("could not generate API key", err)
+	}
+
+	key, err := s.repo.Create(ctx, customer.ID, name, prefix, hashAPIKey(rawKey), scopes)
+	if err != nil {
+		return ApiKeySecretResponse{}, E.NewInternalError("could not create API key", err)
+	}
+
+	return ApiKeySecretResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.ApiKey,
+		Key:       rawKey,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt.Time,
+	}, nil
+}
+
+func (s *apiKeyService) List(ctx context.Context, userID uuid.UUID) ([]ApiKeyResponse, error) {
+	customer, err := s.customerFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.repo.ListByCustomer(ctx, customer.ID)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve API keys", err)
+	}
+
+	resp := make([]ApiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, s.convertToResponse(key))
+	}
+
+	return resp, nil
+}
+
+// Update changes the name and/or scopes of a key. An empty name or a nil
+// scope list leaves the corresponding value unchanged.
+func (s *apiKeyService) Update(ctx context.Context, userID, id uuid.UUID, name string, scopes []string) (ApiKeyResponse, error) {
+	customer, err := s.customerFor(ctx, userID)
+	if err != nil {
+		return ApiKeyResponse{}, err
+	}
+
+	existing, err := s.repo.FindForCustomer(ctx, id, customer.ID)
+	if err != nil {
+		return ApiKeyResponse{}, s.wrapKeyError(err, "could not update API key")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = existing.Name
+	}
+	if scopes == nil {
+		scopes = existing.Scopes
+	} else if scopes, err = normalizeScopes(scopes); err != nil {
+		return ApiKeyResponse{}, err
+	}
+
+	key, err := s.repo.Update(ctx, id, customer.ID, name, scopes)
+	if err != nil {
+		return ApiKeyResponse{}, s.wrapKeyError(err, "could not update API key")
+	}
+
+	return s.convertToResponse(key), nil
+}
+
+func (s *apiKeyService) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	customer, err := s.customerFor(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.repo.Revoke(ctx, id, customer.ID); err != nil {
+		return s.wrapKeyError(err, "could not revoke API key")
+	}
+
+	return nil
+}
+
+// Rotate replaces the secret of an existing key in place. The old key stops
+// working immediately while the key ID, name and history are preserved.
+func (s *apiKeyService) Rotate(ctx context.Context, userID, id uuid.UUID) (ApiKeySecretResponse, error) {
+	customer, err := s.customerFor(ctx, userID)
+	if err != nil {
+		return ApiKeySecretResponse{}, err
+	}
+
+	existing, err := s.repo.FindForCustomer(ctx, id, customer.ID)
+	if err != nil {
+		return ApiKeySecretResponse{}, s.wrapKeyError(err, "could not rotate API key")
+	}
+	if existing.Revoked.Valid && existing.Revoked.Bool {
+		return ApiKeySecretResponse{}, E.NewInvalidInputError("revoked API keys cannot be rotated", nil)
+	}
+
+	rawKey, prefix, err := generateAPIKey()
+	if err != nil {
+		return ApiKeySecretResponse{}, E.NewInternalError("could not generate API key", err)
+	}
+
+	key, err := s.repo.Rotate(ctx, id, customer.ID, prefix, hashAPIKey(rawKey))
+	if err != nil {
+		return ApiKeySecretResponse{}, s.wrapKeyError(err, "could not rotate API key")
+	}
+
+	rotatedAt := time.Now()
+	return ApiKeySecretResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.ApiKey,
+		Key:       rawKey,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt.Time,
+		RotatedAt: &rotatedAt,
+	}, nil
+}
+
+// customerFor resolves the billing customer owning the given user.
+func (s *apiKeyService) customerFor(ctx context.Context, userID uuid.UUID) (generated.Customer, error) {
+	customer, err := s.customers.FindByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Customer{}, E.NewNotFoundError("customer", "no billing customer is linked to this user")
+		}
+		return generated.Customer{}, E.NewInternalError("could not retrieve customer", err)
+	}
+	return customer, nil
+}
+
+func (s *apiKeyService) wrapKeyError(err error, msg string) error {
+	if errors.Is(err, E.ErrNotFound) {
+		return E.NewNotFoundError("API key")
+	}
+	return E.NewInternalError(msg, err)
+}
+
+func (s *apiKeyService) convertToResponse(key generated.ApiKey) ApiKeyResponse {
+	resp := ApiKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.ApiKey,
+		Scopes:    key.Scopes,
+		Revoked:   key.Revoked.Valid && key.Revoked.Bool,
+		CreatedAt: key.CreatedAt.Time,
+	}
+	if key.LastUsedAt.Valid {
+		resp.LastUsedAt = &key.LastUsedAt.Time
+	}
+	return resp
+}
+
+// normalizeScopes validates the requested scopes and returns them sorted and
+// without duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, E.NewInvalidInputError("at least one scope is required", nil)
+	}
+
+	out := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(AllScopes, scope) {
+			return nil, E.NewInvalidInputError(fmt.Sprintf("unknown scope %q", scope), nil)
+		}
+		if !slices.Contains(out, scope) {
+			out = append(out, scope)
+		}
+	}
+	slices.Sort(out)
+
+	return out, nil
+}
+
+// generateAPIKey returns a new plaintext key and its public prefix.
+func generateAPIKey() (string, string, error) {
+	id := make([]byte, apiKeyIDLength/2)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	prefix := apiKeyScheme + hex.EncodeToString(id)
+	return prefix + "_" + hex.EncodeToString(secret), prefix, nil
+}
+
+// parseAPIKey validates the shape of a raw key and returns its public prefix.
+func parseAPIKey(rawKey string) (string, bool) {
+	rest, ok := strings.CutPrefix(rawKey, apiKeyScheme)
+	if !ok {
+		return "", false
+	}
+
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(id) != apiKeyIDLength || secret == "" {
+		return "", false
+	}
+
+	return apiKeyScheme + id, true
+}
+
+// hashAPIKey returns the hex encoded SHA-256 digest stored in api_keys.hashed_key.
+// Keys carry enough entropy that a slow password hash is unnecessary.
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
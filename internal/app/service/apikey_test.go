@@ -0,0 +1,128 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		rawKey     string
+		wantPrefix string
+		wantOK     bool
+	}{
+		{
+			name:       "valid",
+			rawKey:     "sk_live_0123456789ab_deadbeef",
+			wantPrefix: "sk_live_0123456789ab",
+			wantOK:     true,
+		},
+		{
+			name:       "secret with underscores",
+			rawKey:     "sk_live_0123456789ab_dead_beef",
+			wantPrefix: "sk_live_0123456789ab",
+			wantOK:     true,
+		},
+		{name: "empty", rawKey: ""},
+		{name: "wrong scheme", rawKey: "sk_test_0123456789ab_deadbeef"},
+		{name: "missing secret", rawKey: "sk_live_0123456789ab"},
+		{name: "empty secret", rawKey: "sk_live_0123456789ab_"},
+		{name: "short id", rawKey: "sk_live_0123_deadbeef"},
+		{name: "long id", rawKey: "sk_live_0123456789abcd_deadbeef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, ok := parseAPIKey(tt.rawKey)
+			if prefix != tt.wantPrefix || ok != tt.wantOK {
+				t.Fatalf("parseAPIKey(%q) = %q, %t; want %q, %t", tt.rawKey, prefix, ok, tt.wantPrefix, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, ok := parseAPIKey(key)
+	if !ok || parsed != prefix {
+		t.Fatalf("parseAPIKey(%q) = %q, %t; want %q", key, parsed, ok, prefix)
+	}
+	if !strings.HasPrefix(key, prefix+"_") {
+		t.Fatalf("key %q does not start with its prefix %q", key, prefix)
+	}
+
+	other, _, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Fatal("two generated keys are equal")
+	}
+}
+
+func TestHashAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		rawKey string
+		want   string
+	}{
+		{
+			name:   "empty",
+			rawKey: "",
+			want:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name:   "key",
+			rawKey: "abc",
+			want:   "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashAPIKey(tt.rawKey); got != tt.want {
+				t.Fatalf("hashAPIKey(%q) = %s, want %s", tt.rawKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "sorted",
+			scopes: []string{ScopeUsageWrite, ScopeQuotaRead},
+			want:   []string{ScopeQuotaRead, ScopeUsageWrite},
+		},
+		{
+			name:   "duplicates and spaces",
+			scopes: []string{ScopeUsageRead, " usage:read "},
+			want:   []string{ScopeUsageRead},
+		},
+		{name: "empty", wantErr: true},
+		{name: "unknown", scopes: []string{ScopeUsageRead, "usage:delete"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeScopes(tt.scopes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeScopes(%q) error = %v, want error: %t", tt.scopes, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("normalizeScopes(%q) = %q, want %q", tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// LastUsedTracker coalesces API key usage in memory and periodically writes
+// the latest use of each key to api_keys.last_used_at in a single batch, so
+// metered traffic does not cost one UPDATE per request.
+type LastUsedTracker struct {
+	repo     repository.ApiKeyRepository
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]time.Time
+}
+
+func NewLastUsedTracker(repo repository.ApiKeyRepository, interval time.Duration) *LastUsedTracker {
+	return &LastUsedTracker{
+		repo:     repo,
+		interval: interval,
+		pending:  make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Record notes that the key was used at the given time. Only the latest use
+// per key is kept until the next flush.
+func (t *LastUsedTracker) Record(keyID uuid.UUID, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if prev, ok := t.pending[keyID]; !ok || at.After(prev) {
+		t.pending[keyID] = at
+	}
+}
+
+// Run flushes pending uses every interval until ctx is canceled. Callers
+// should Flush once more after Run returns to persist the final batch.
+func (t *LastUsedTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Flush(ctx); err != nil {
+				logger.Error("failed to flush api key usage", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush writes all pending uses. On failure the batch is put back so it is
+// retried by the next flush.
+func (t *LastUsedTracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[uuid.UUID]time.Time, len(batch))
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := t.repo.TouchLastUsed(ctx, batch); err != nil {
+		for id, at := range batch {
+			t.Record(id, at)
+		}
+		return err
+	}
+
+	logger.Debug("flushed api key usage", zap.Int("keys", len(batch)))
+	return nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/app/repository"
+)
+
+// touchRecorder records every batch written by the tracker and fails the
+// writes it is told to.
+type touchRecorder struct {
+	repository.ApiKeyRepository
+	fail    int
+	batches []map[uuid.UUID]time.Time
+}
+
+func (r *touchRecorder) TouchLastUsed(ctx context.Context, usedAt map[uuid.UUID]time.Time) error {
+	if r.fail > 0 {
+		r.fail--
+		return errors.New("connection reset")
+	}
+	r.batches = append(r.batches, maps.Clone(usedAt))
+	return nil
+}
+
+func TestLastUsedTrackerFlush(t *testing.T) {
+	keyA, keyB := uuid.New(), uuid.New()
+	base := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	type use struct {
+		key uuid.UUID
+		at  time.Time
+	}
+
+	tests := []struct {
+		name        string
+		uses        []use
+		failures    int
+		wantBatches []map[uuid.UUID]time.Time
+	}{
+		{
+			name: "latest use per key",
+			uses: []use{
+				{keyA, base.Add(time.Minute)},
+				{keyA, base},
+				{keyB, base},
+				{keyA, base.Add(2 * time.Minute)},
+			},
+			wantBatches: []map[uuid.UUID]time.Time{
+				{keyA: base.Add(2 * time.Minute), keyB: base},
+			},
+		},
+		{
+			name:        "nothing to flush",
+			wantBatches: nil,
+		},
+		{
+			name:     "failed batch is retried",
+			uses:     []use{{keyA, base}},
+			failures: 1,
+			wantBatches: []map[uuid.UUID]time.Time{
+				{keyA: base},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &touchRecorder{fail: tt.failures}
+			tracker := NewLastUsedTracker(repo, time.Minute)
+			for _, u := range tt.uses {
+				tracker.Record(u.key, u.at)
+			}
+
+			for i := 0; i < tt.failures; i++ {
+				if err := tracker.Flush(context.Background()); err == nil {
+					t.Fatalf("flush %d succeeded, want the write to fail", i)
+				}
+			}
+			if err := tracker.Flush(context.Background()); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+			if err := tracker.Flush(context.Background()); err != nil {
+				t.Fatalf("second flush: %v", err)
+			}
+
+			if len(repo.batches) != len(tt.wantBatches) {
+				t.Fatalf("wrote %d batches, want %d", len(repo.batches), len(tt.wantBatches))
+			}
+			for i, want := range tt.wantBatches {
+				if !maps.Equal(repo.batches[i], want) {
+					t.Fatalf("batch %d = %v, want %v", i, repo.batches[i], want)
+				}
+			}
+		})
+	}
+}
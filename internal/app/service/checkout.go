@@ -0,0 +1,422 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// CheckoutRequest opens a checkout for the signed-in user with UserID, or
+// for the customer with CustomerEmail when UserID is uuid.Nil. Anonymous
+// checkouts may not check out for the customer of a registered user.
+type CheckoutRequest struct {
+	PlanID        uuid.UUID
+	UserID        uuid.UUID
+	CustomerEmail string
+	SuccessURL    string
+	CancelURL     string
+}
+
+type CheckoutSessionResponse struct {
+	CheckoutURL    string    `json:"checkout_url"`
+	SessionID      string    `json:"session_id"`
+	SubscriptionID uuid.UUID `json:"subscription_id"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// CheckoutStatusResponse reports a checkout as the gateway sees it. The
+// subscription only becomes active once PaymentStatus is succeeded.
+type CheckoutStatusResponse struct {
+	PaymentStatus string               `json:"payment_status"`
+	Subscription  SubscriptionResponse `json:"subscription"`
+}
+
+type CheckoutService interface {
+	// Create opens a hosted payment page for the first period of a plan and
+	// records a pending subscription that the payment activates. Earlier
+	// pending checkouts of the customer are kept; the first one paid cancels
+	// the others.
+	Create(ctx context.Context, req CheckoutRequest) (CheckoutSessionResponse, error)
+	// Confirm asks the gateway about a checkout session and activates its
+	// subscription when the payment succeeded.
+	Confirm(ctx context.Context, sessionID string) (CheckoutStatusResponse, error)
+}
+
+type checkoutService struct {
+	repos   repository.Repositories
+	uow     repository.UnitOfWork
+	builder *InvoiceBuilder
+	gateway payment.PaymentGateway
+}
+
+func NewCheckoutService(repos repository.Repositories, uow repository.UnitOfWork, builder *InvoiceBuilder, gateway payment.PaymentGateway) CheckoutService {
+	return &checkoutService{repos: repos, uow: uow, builder: builder, gateway: gateway}
+}
+
+func (s *checkoutService) Create(ctx context.Context, req CheckoutRequest) (CheckoutSessionResponse, error) {
+	email := strings.TrimSpace(req.CustomerEmail)
+	if req.UserID == uuid.Nil {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return CheckoutSessionResponse{}, E.NewInvalidInputError("customer_email must be a valid email address", err)
+		}
+	}
+	if err := validateRedirectURL("success_url", req.SuccessURL); err != nil {
+		return CheckoutSessionResponse{}, err
+	}
+	if err := validateRedirectURL("cancel_url", req.CancelURL); err != nil {
+		return CheckoutSessionResponse{}, err
+	}
+
+	plan, err := s.repos.Plans.FindByID(ctx, req.PlanID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return CheckoutSessionResponse{}, E.NewNotFoundError("plan")
+		}
+		return CheckoutSessionResponse{}, E.NewInternalError("could not retrieve plan", err)
+	}
+	if plan.PriceCents <= 0 {
+		return CheckoutSessionResponse{}, E.NewInvalidInputError("free plans do not need a checkout", nil)
+	}
+
+	var sub generated.Subscription
+	err = s.uow.Do(ctx, func(repos repository.Repositories) error {
+		customer, err := checkoutCustomer(ctx, repos, req.UserID, email)
+		if err != nil {
+			return err
+		}
+
+		current, err := repos.Subscriptions.FindCurrentByCustomer(ctx, customer.ID)
+		switch {
+		case err == nil && current.Status != SubscriptionPending:
+			return E.NewAlreadyExistsError("subscription", "customer already has a subscription that is not canceled")
+		case err != nil && !errors.Is(err, E.ErrNotFound):
+			return E.NewInternalError("could not retrieve subscription", err)
+		}
+
+		sub, err = repos.Subscriptions.Create(ctx, generated.CreateSubscriptionParams{
+			CustomerID: pgtype.UUID{Bytes: customer.ID, Valid: true},
+			PlanID:     pgtype.UUID{Bytes: plan.ID, Valid: true},
+			Status:     SubscriptionPending,
+		})
+		if err != nil {
+			return E.NewInternalError("could not create subscription", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return CheckoutSessionResponse{}, err
+	}
+
+	session, err := s.gateway.CreateCheckoutSession(ctx, payment.CheckoutRequest{
+		CustomerID:     sub.CustomerID.Bytes,
+		Reference:      sub.ID.String(),
+		AmountCents:    plan.PriceCents,
+		Currency:       plan.Currency,
+		Description:    plan.Name,
+		SuccessURL:     req.SuccessURL,
+		CancelURL:      req.CancelURL,
+		IdempotencyKey: "checkout-" + sub.ID.String(),
+	})
+	if err != nil {
+		return CheckoutSessionResponse{}, E.NewInternalError("could not create checkout session", err)
+	}
+
+	patch, err := json.Marshal(map[string]string{"checkout_session_id": session.ID})
+	if err != nil {
+		return CheckoutSessionResponse{}, E.NewInternalError("could not encode checkout session", err)
+	}
+	if err := s.repos.Subscriptions.MergeMetadata(ctx, sub.ID, patch); err != nil {
+		return CheckoutSessionResponse{}, E.NewInternalError("could not store checkout session", err)
+	}
+
+	return CheckoutSessionResponse{
+		CheckoutURL:    session.URL,
+		SessionID:      session.ID,
+		SubscriptionID: sub.ID,
+		ExpiresAt:      session.ExpiresAt,
+	}, nil
+}
+
+// checkoutCustomer returns the customer of the signed-in user, created with
+// the user's email if missing. Anonymous checkouts use the customer with
+// email, which must not belong to a registered user.
+func checkoutCustomer(ctx context.Context, repos repository.Repositories, userID uuid.UUID, email string) (generated.Customer, error) {
+	if userID != uuid.Nil {
+		customer, err := repos.Customers.FindByUserID(ctx, userID)
+		if err == nil {
+			return customer, nil
+		}
+		if !errors.Is(err, E.ErrNotFound) {
+			return generated.Customer{}, E.NewInternalError("could not retrieve customer", err)
+		}
+
+		user, err := repos.Users.FindByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return generated.Customer{}, E.NewUnauthorizedError("user no longer exists", err)
+			}
+			return generated.Customer{}, E.NewInternalError("could not retrieve user", err)
+		}
+		customer, err = repos.Customers.Create(ctx, userID, user.Email)
+		if err != nil {
+			return generated.Customer{}, E.NewInternalError("could not create customer", err)
+		}
+		return customer, nil
+	}
+
+	customer, err := repos.Customers.FindByEmail(ctx, email)
+	if errors.Is(err, E.ErrNotFound) {
+		customer, err = repos.Customers.Create(ctx, uuid.Nil, email)
+	}
+	if err != nil {
+		return generated.Customer{}, E.NewInternalError("could not retrieve customer", err)
+	}
+	if customer.UserID.Valid {
+		return generated.Customer{}, E.NewUnauthorizedError("customer_email belongs to a registered user, sign in to check out", nil)
+	}
+	return customer, nil
+}
+
+// Confirm never trusts the redirect itself: the payment status always comes
+// from the gateway.
+func (s *checkoutService) Confirm(ctx context.Context, sessionID string) (CheckoutStatusResponse, error) {
+	if sessionID == "" {
+		return CheckoutStatusResponse{}, E.NewInvalidInputError("session_id is required", nil)
+	}
+
+	paid, err := s.gateway.CheckoutPayment(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, payment.ErrNotFound) {
+			return CheckoutStatusResponse{}, E.NewNotFoundError("checkout session")
+		}
+		return CheckoutStatusResponse{}, E.NewInternalError("could not retrieve checkout session", err)
+	}
+
+	subID, err := uuid.Parse(paid.Reference)
+	if err != nil {
+		return CheckoutStatusResponse{}, E.NewNotFoundError("checkout session")
+	}
+
+	var resp CheckoutStatusResponse
+	err = s.uow.Do(ctx, func(repos repository.Repositories) error {
+		sub, err := repos.Subscriptions.FindByID(ctx, subID)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.NewNotFoundError("checkout session")
+			}
+			return E.NewInternalError("could not retrieve subscription", err)
+		}
+
+		if paid.Status == payment.StatusSucceeded {
+			var note string
+			sub, note, err = activateCheckout(ctx, repos, s.builder, sub.ID, checkoutPayment{
+				gateway:   s.gateway.Name(),
+				paymentID: paid.ID,
+				amount:    paid.AmountCents,
+				currency:  paid.Currency,
+				raw:       paid.Raw,
+			}, time.Now())
+			if err != nil {
+				return err
+			}
+			if note != "" {
+				logger.Error("checkout payment needs review",
+					zap.String("subscription_id", sub.ID.String()),
+					zap.String("payment_id", paid.ID),
+					zap.String("note", note))
+			}
+		}
+
+		plan, err := repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+		if err != nil {
+			return E.NewInternalError("could not retrieve plan", err)
+		}
+
+		resp.PaymentStatus = paid.Status
+		resp.Subscription, err = convertSubscription(sub, plan)
+		return err
+	})
+	if err != nil {
+		return CheckoutStatusResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// checkoutPayment is a succeeded payment made on a checkout's hosted page.
+type checkoutPayment struct {
+	gateway   string
+	paymentID string
+	amount    int64
+	currency  string
+	raw       []byte
+}
+
+// activateCheckout starts the first period of a subscription whose checkout
+// payment succeeded and records the payment on a paid invoice for that
+// period. The success redirect and the gateway's webhook both call it; a
+// payment that was already recorded changes nothing.
+//
+// A payment that cannot activate the subscription, because the checkout was
+// canceled or superseded or the payment does not match the plan price, is
+// still recorded. It is added to the customer's credit balance when it is in
+// the plan currency, and the returned note describes it for review.
+func activateCheckout(ctx context.Context, repos repository.Repositories, builder *InvoiceBuilder, subID uuid.UUID, paid checkoutPayment, now time.Time) (generated.Subscription, string, error) {
+	// The lock makes concurrent confirmations of one payment run in turn
+	sub, err := repos.Subscriptions.FindForUpdate(ctx, subID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Subscription{}, "", E.NewNotFoundError("checkout session")
+		}
+		return generated.Subscription{}, "", E.NewInternalError("could not retrieve subscription", err)
+	}
+
+	if paid.paymentID != "" {
+		txn, err := repos.Transactions.FindByGatewayPaymentForUpdate(ctx, paid.gateway, paid.paymentID)
+		switch {
+		case err == nil:
+			if !txn.InvoiceID.Valid {
+				return sub, "checkout payment was already recorded without activating the subscription", nil
+			}
+			return sub, "", nil
+		case !errors.Is(err, E.ErrNotFound):
+			return generated.Subscription{}, "", E.NewInternalError("could not retrieve transaction", err)
+		}
+	}
+
+	plan, err := repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return generated.Subscription{}, "", E.NewInternalError("could not retrieve plan", err)
+	}
+
+	if !strings.EqualFold(paid.currency, plan.Currency) {
+		note := fmt.Sprintf("checkout paid in %s for a plan priced in %s; refund it", paid.currency, plan.Currency)
+		return sub, note, recordUnappliedCheckout(ctx, repos, sub, paid, false)
+	}
+	if paid.amount != plan.PriceCents {
+		note := fmt.Sprintf("checkout paid %d for a plan priced at %d; the payment was credited", paid.amount, plan.PriceCents)
+		return sub, note, recordUnappliedCheckout(ctx, repos, sub, paid, true)
+	}
+	if sub.Status != SubscriptionPending {
+		note := fmt.Sprintf("checkout paid for a %s subscription; the payment was credited", sub.Status)
+		return sub, note, recordUnappliedCheckout(ctx, repos, sub, paid, true)
+	}
+
+	// Another checkout of the customer may have been paid first
+	current, err := repos.Subscriptions.FindCurrentByCustomer(ctx, sub.CustomerID.Bytes)
+	if err != nil && !errors.Is(err, E.ErrNotFound) {
+		return generated.Subscription{}, "", E.NewInternalError("could not retrieve subscription", err)
+	}
+	if err == nil && current.Status != SubscriptionPending {
+		note := "checkout paid after the customer subscribed through another checkout; the payment was credited"
+		return sub, note, recordUnappliedCheckout(ctx, repos, sub, paid, true)
+	}
+
+	periodEnd, err := addInterval(now, plan.Interval)
+	if err != nil {
+		return generated.Subscription{}, "", err
+	}
+
+	sub.Status = SubscriptionActive
+	sub.CurrentPeriodStart = timestamptz(now)
+	sub.CurrentPeriodEnd = timestamptz(periodEnd)
+	activated, err := repos.Subscriptions.UpdateState(ctx, SubscriptionPending, sub)
+	if err != nil {
+		return generated.Subscription{}, "", E.NewInternalError("could not activate subscription", err)
+	}
+	if err := repos.Subscriptions.CancelPending(ctx, activated.CustomerID.Bytes, activated.ID, now); err != nil {
+		return generated.Subscription{}, "", E.NewInternalError("could not cancel other checkouts", err)
+	}
+
+	draft, _, err := builder.Draft(ctx, repos, InvoiceDraftRequest{
+		SubscriptionID: activated.ID,
+		PeriodStart:    now,
+		PeriodEnd:      periodEnd,
+	})
+	if err != nil {
+		return generated.Subscription{}, "", err
+	}
+	invoice, _, err := builder.Finalize(ctx, repos, draft.ID, now)
+	if err != nil {
+		return generated.Subscription{}, "", err
+	}
+
+	if _, err := repos.Transactions.Create(ctx, generated.CreateTransactionParams{
+		InvoiceID:        pgtype.UUID{Bytes: invoice.ID, Valid: true},
+		CustomerID:       invoice.CustomerID,
+		Gateway:          paid.gateway,
+		GatewayPaymentID: pgtype.Text{String: paid.paymentID, Valid: paid.paymentID != ""},
+		AmountCents:      paid.amount,
+		Currency:         invoice.Currency,
+		Status:           TransactionSucceeded,
+		RawResponse:      paid.raw,
+	}); err != nil {
+		return generated.Subscription{}, "", E.NewInternalError("could not record checkout payment", err)
+	}
+
+	balance, err := invoiceBalance(ctx, repos, invoice)
+	if err != nil {
+		return generated.Subscription{}, "", err
+	}
+	if balance.due < 0 {
+		// The checkout charged the full price, so credit applied on
+		// finalizing was paid twice; give it back
+		if _, err := repos.Customers.AddCredit(ctx, invoice.CustomerID.Bytes, -balance.due); err != nil {
+			return generated.Subscription{}, "", E.NewInternalError("could not restore customer credit", err)
+		}
+	}
+	if invoice.Status == InvoiceIssued && balance.due <= 0 {
+		if _, err := repos.Invoices.MarkPaid(ctx, invoice.ID, now); err != nil {
+			return generated.Subscription{}, "", E.NewInternalError("could not mark invoice paid", err)
+		}
+	}
+
+	return activated, "", nil
+}
+
+// recordUnappliedCheckout records a checkout payment that did not activate
+// its subscription, without an invoice, and credits it to the customer when
+// credit is true.
+func recordUnappliedCheckout(ctx context.Context, repos repository.Repositories, sub generated.Subscription, paid checkoutPayment, credit bool) error {
+	if _, err := repos.Transactions.Create(ctx, generated.CreateTransactionParams{
+		CustomerID:       sub.CustomerID,
+		Gateway:          paid.gateway,
+		GatewayPaymentID: pgtype.Text{String: paid.paymentID, Valid: paid.paymentID != ""},
+		AmountCents:      paid.amount,
+		Currency:         strings.ToUpper(paid.currency),
+		Status:           TransactionSucceeded,
+		RawResponse:      paid.raw,
+	}); err != nil {
+		return E.NewInternalError("could not record checkout payment", err)
+	}
+
+	if credit {
+		if _, err := repos.Customers.AddCredit(ctx, sub.CustomerID.Bytes, paid.amount); err != nil {
+			return E.NewInternalError("could not credit checkout payment", err)
+		}
+	}
+	return nil
+}
+
+func validateRedirectURL(field, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return E.NewInvalidInputError(fmt.Sprintf("%s must be an absolute http(s) URL", field), err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateCheckoutRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(f *paymentFlow, req *CheckoutRequest)
+		wantStatus int
+	}{
+		{
+			name:       "invalid email",
+			edit:       func(_ *paymentFlow, req *CheckoutRequest) { req.CustomerEmail = "buyer" },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "relative success url",
+			edit:       func(_ *paymentFlow, req *CheckoutRequest) { req.SuccessURL = "/success" },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "cancel url without host",
+			edit:       func(_ *paymentFlow, req *CheckoutRequest) { req.CancelURL = "https://" },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "cancel url not http",
+			edit:       func(_ *paymentFlow, req *CheckoutRequest) { req.CancelURL = "javascript:alert(1)" },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown plan",
+			edit:       func(_ *paymentFlow, req *CheckoutRequest) { req.PlanID = uuid.New() },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "free plan",
+			edit:       func(f *paymentFlow, req *CheckoutRequest) { req.PlanID = f.addPlan(0).ID },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "user no longer exists",
+			edit:       func(_ *paymentFlow, req *CheckoutRequest) { req.UserID = uuid.New() },
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			req := CheckoutRequest{
+				PlanID:        f.addPlan(1500).ID,
+				CustomerEmail: "buyer@example.com",
+				SuccessURL:    "https://example.com/success",
+				CancelURL:     "https://example.com/cancel",
+			}
+			tt.edit(f, &req)
+
+			_, err := f.checkout.Create(context.Background(), req)
+			wantHTTPStatus(t, err, tt.wantStatus)
+			if len(f.store.subscriptions) != 0 {
+				t.Fatalf("got %d subscriptions, want none", len(f.store.subscriptions))
+			}
+		})
+	}
+}
+
+func TestCreateCheckoutWhileSubscribed(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFlow()
+	plan := f.addPlan(1500)
+
+	// A pending checkout does not block another one
+	first := f.createCheckout(t, plan)
+	second := f.createCheckout(t, plan)
+	if first.SubscriptionID == second.SubscriptionID {
+		t.Fatal("second checkout reused the pending subscription")
+	}
+
+	paid, err := f.gateway.CompleteCheckout(ctx, second.SessionID)
+	if err != nil {
+		t.Fatalf("complete checkout: %v", err)
+	}
+	f.deliver(t, paid)
+
+	_, err = f.checkout.Create(ctx, CheckoutRequest{
+		PlanID:        plan.ID,
+		CustomerEmail: "buyer@example.com",
+		SuccessURL:    "https://example.com/success",
+		CancelURL:     "https://example.com/cancel",
+	})
+	wantHTTPStatus(t, err, http.StatusConflict)
+}
+
+func TestConfirmCheckoutRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		sessionID  string
+		wantStatus int
+	}{
+		{name: "empty session", sessionID: "", wantStatus: http.StatusBadRequest},
+		{name: "unknown session", sessionID: "cs_unknown", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newPaymentFlow().checkout.Confirm(context.Background(), tt.sessionID)
+			wantHTTPStatus(t, err, tt.wantStatus)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// PaymentMethod is the shape stored in customers.default_payment_method.
+type PaymentMethod struct {
+	PaymentMethodID string `json:"payment_method_id"`
+}
+
+type CustomerResponse struct {
+	ID                   uuid.UUID      `json:"id"`
+	UserID               *uuid.UUID     `json:"user_id"`
+	Email                string         `json:"email"`
+	CreditBalanceCents   int64          `json:"credit_balance_cents"`
+	DefaultPaymentMethod *PaymentMethod `json:"default_payment_method"`
+}
+
+type CustomerService interface {
+	FindByUserID(ctx context.Context, userID uuid.UUID) (CustomerResponse, error)
+	UpdatePaymentMethod(ctx context.Context, customerID uuid.UUID, paymentMethodID string) error
+}
+
+type customerService struct {
+	repo repository.CustomerRepository
+}
+
+func NewCustomerService(repo repository.CustomerRepository) CustomerService {
+	return &customerService{repo: repo}
+}
+
+// FindByUserID resolves the billing customer linked to an authenticated user.
+func (s *customerService) FindByUserID(ctx context.Context, userID uuid.UUID) (CustomerResponse, error) {
+	customer, err := s.repo.FindByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return CustomerResponse{}, E.NewNotFoundError("customer", "no billing customer is linked to this user")
+		}
+		return CustomerResponse{}, E.NewInternalError("could not retrieve customer", err)
+	}
+
+	return s.convertToResponse(customer)
+}
+
+func (s *customerService) UpdatePaymentMethod(ctx context.Context, customerID uuid.UUID, paymentMethodID string) error {
+	paymentMethodID = strings.TrimSpace(paymentMethodID)
+	if paymentMethodID == "" {
+		return E.NewInvalidInputError("payment_method_id is required", nil)
+	}
+
+	method, err := json.Marshal(PaymentMethod{PaymentMethodID: paymentMethodID})
+	if err != nil {
+		return E.NewInternalError("could not encode payment method", err)
+	}
+
+	if _, err := s.repo.UpdatePaymentMethod(ctx, customerID, method); err != nil {
+		return E.NewInternalError("could not update payment method", err)
+	}
+
+	return nil
+}
+
+func (s *customerService) convertToResponse(customer generated.Customer) (CustomerResponse, error) {
+	resp := CustomerResponse{
+		ID:                 customer.ID,
+		Email:              customer.Email.String,
+		CreditBalanceCents: customer.CreditBalanceCents.Int64,
+	}
+	if customer.UserID.Valid {
+		userID := uuid.UUID(customer.UserID.Bytes)
+		resp.UserID = &userID
+	}
+	if len(customer.DefaultPaymentMethod) > 0 {
+		var method PaymentMethod
+		if err := json.Unmarshal(customer.DefaultPaymentMethod, &method); err != nil {
+			return CustomerResponse{}, E.NewInternalError("could not decode payment method", err)
+		}
+		resp.DefaultPaymentMethod = &method
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestUpdatePaymentMethod(t *testing.T) {
+	tests := []struct {
+		name            string
+		paymentMethodID string
+		want            *PaymentMethod
+		wantErr         bool
+	}{
+		{
+			name:            "stored",
+			paymentMethodID: "pm_card",
+			want:            &PaymentMethod{PaymentMethodID: "pm_card"},
+		},
+		{
+			name:            "trimmed",
+			paymentMethodID: "  pm_card  ",
+			want:            &PaymentMethod{PaymentMethodID: "pm_card"},
+		},
+		{name: "blank", paymentMethodID: "  ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			svc := NewCustomerService(store.repos().Customers)
+			userID := uuid.New()
+			customer := &generated.Customer{ID: uuid.New(), UserID: pgtype.UUID{Bytes: userID, Valid: true}}
+			store.customers = append(store.customers, customer)
+
+			err := svc.UpdatePaymentMethod(context.Background(), customer.ID, tt.paymentMethodID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %t", err, tt.wantErr)
+			}
+
+			resp, err := svc.FindByUserID(context.Background(), userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (resp.DefaultPaymentMethod == nil) != (tt.want == nil) || (tt.want != nil && *resp.DefaultPaymentMethod != *tt.want) {
+				t.Fatalf("payment method = %v, want %v", resp.DefaultPaymentMethod, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// Idempotency key statuses as stored in idempotency_keys.status.
+const (
+	IdempotencyInFlight  = "in_flight"
+	IdempotencyCompleted = "completed"
+)
+
+// StoredResponse is the response recorded for an idempotency key.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type IdempotencyService interface {
+	// Begin claims key within scope for a request with the given fingerprint.
+	// It returns the stored response when the key already completed, and a
+	// conflict error when the key is still in flight or was used for a
+	// different request. A nil response means the caller should run the
+	// request and then Complete or Release the key.
+	Begin(ctx context.Context, scope, key, fingerprint string) (*StoredResponse, error)
+	// Complete stores the response replayed for later requests with the key.
+	Complete(ctx context.Context, scope, key string, resp StoredResponse) error
+	// Release forgets the key so the request can be retried.
+	Release(ctx context.Context, scope, key string) error
+}
+
+// IdempotencyStore keeps idempotency keys in the database. Keys expire after
+// ttl; a key left in flight longer than lockTimeout belongs to a request that
+// never finished and is handed to the next one.
+type IdempotencyStore struct {
+	repo        repository.IdempotencyRepository
+	ttl         time.Duration
+	lockTimeout time.Duration
+}
+
+func NewIdempotencyStore(repo repository.IdempotencyRepository, ttl, lockTimeout time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{repo: repo, ttl: ttl, lockTimeout: lockTimeout}
+}
+
+func (s *IdempotencyStore) Begin(ctx context.Context, scope, key, fingerprint string) (*StoredResponse, error) {
+	now := time.Now()
+	row, claimed, err := s.repo.Claim(ctx, scope, key, fingerprint, now.Add(s.ttl), now.Add(-s.lockTimeout))
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return nil, E.NewConflictError("a request with this Idempotency-Key is still in progress", nil)
+		}
+		return nil, E.NewInternalError("could not claim idempotency key", err)
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	if row.Fingerprint != fingerprint {
+		return nil, E.NewConflictError("Idempotency-Key was already used for a different request", nil)
+	}
+	if row.Status != IdempotencyCompleted {
+		return nil, E.NewConflictError("a request with this Idempotency-Key is still in progress", nil)
+	}
+
+	resp := &StoredResponse{
+		Status: int(row.ResponseStatus.Int32),
+		Header: http.Header{},
+		Body:   row.ResponseBody,
+	}
+	if len(row.ResponseHeaders) > 0 {
+		if err := json.Unmarshal(row.ResponseHeaders, &resp.Header); err != nil {
+			return nil, E.NewInternalError("could not decode stored response", err)
+		}
+	}
+	return resp, nil
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, scope, key string, resp StoredResponse) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return E.NewInternalError("could not encode response headers", err)
+	}
+
+	if err := s.repo.Complete(ctx, scope, key, resp.Status, header, resp.Body); err != nil {
+		return E.NewInternalError("could not store idempotent response", err)
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	if err := s.repo.Release(ctx, scope, key); err != nil {
+		return E.NewInternalError("could not release idempotency key", err)
+	}
+	return nil
+}
+
+// Run deletes expired keys every interval until ctx is canceled.
+func (s *IdempotencyStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.repo.PurgeExpired(ctx, time.Now())
+			if err != nil {
+				logger.Error("failed to purge idempotency keys", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				logger.Debug("purged idempotency keys", zap.Int64("count", purged))
+			}
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/storage"
+)
+
+// Invoice statuses as stored in invoices.status.
+const (
+	InvoiceDraft  = "draft"
+	InvoiceIssued = "issued"
+	InvoicePaid   = "paid"
+	InvoiceFailed = "failed"
+	InvoiceVoid   = "void"
+
+	// A paid invoice moves to these once some or all of its payments are
+	// refunded.
+	InvoicePartiallyRefunded = "partially_refunded"
+	InvoiceRefunded          = "refunded"
+)
+
+// Invoice line item kinds as stored in invoice_line_items.kind.
+const (
+	LineItemSubscription = "subscription"
+	LineItemUsage        = "usage"
+	LineItemOverage      = "overage"
+	LineItemProration    = "proration"
+	LineItemCredit       = "credit"
+)
+
+type InvoiceLineItemResponse struct {
+	ID              uuid.UUID  `json:"id"`
+	Kind            string     `json:"kind"`
+	Description     string     `json:"description"`
+	Quantity        int64      `json:"quantity"`
+	UnitAmountCents int64      `json:"unit_amount_cents"`
+	AmountCents     int64      `json:"amount_cents"`
+	Currency        string     `json:"currency"`
+	PeriodStart     *time.Time `json:"period_start"`
+	PeriodEnd       *time.Time `json:"period_end"`
+}
+
+type InvoiceResponse struct {
+	ID             uuid.UUID                 `json:"id"`
+	InvoiceNumber  *string                   `json:"invoice_number"`
+	CustomerID     uuid.UUID                 `json:"customer_id"`
+	SubscriptionID *uuid.UUID                `json:"subscription_id"`
+	Status         string                    `json:"status"`
+	TotalCents     int64                     `json:"total_cents"`
+	Currency       string                    `json:"currency"`
+	IssuedAt       *time.Time                `json:"issued_at"`
+	DueAt          *time.Time                `json:"due_at"`
+	PaidAt         *time.Time                `json:"paid_at"`
+	PdfURL         *string                   `json:"pdf_url"`
+	LineItems      []InvoiceLineItemResponse `json:"line_items,omitempty"`
+	CreatedAt      time.Time                 `json:"created_at"`
+}
+
+// InvoiceDetailResponse is an invoice with its line items and the payment
+// transactions recorded against it.
+type InvoiceDetailResponse struct {
+	InvoiceResponse
+	Transactions []TransactionResponse `json:"transactions"`
+}
+
+// InvoiceListParams filters a customer's invoices. Zero values do not filter;
+// From and To bound issued_at, To exclusive.
+type InvoiceListParams struct {
+	Status string
+	From   time.Time
+	To     time.Time
+	Limit  int32
+	Offset int32
+}
+
+// InvoicePDF is a rendered invoice document.
+type InvoicePDF struct {
+	Filename string
+	Content  io.ReadCloser
+}
+
+type InvoiceService interface {
+	Draft(ctx context.Context, req InvoiceDraftRequest) (InvoiceResponse, error)
+	Finalize(ctx context.Context, id uuid.UUID) (InvoiceResponse, error)
+	PDF(ctx context.Context, customerID, id uuid.UUID) (InvoicePDF, error)
+	List(ctx context.Context, customerID uuid.UUID, params InvoiceListParams) ([]InvoiceResponse, error)
+	Get(ctx context.Context, customerID, id uuid.UUID) (InvoiceDetailResponse, error)
+	Pay(ctx context.Context, customerID, id uuid.UUID, opts PayOptions) (InvoiceDetailResponse, error)
+}
+
+type invoiceService struct {
+	repos   repository.Repositories
+	uow     repository.UnitOfWork
+	builder *InvoiceBuilder
+	files   storage.Storage
+	gateway payment.PaymentGateway
+}
+
+func NewInvoiceService(repos repository.Repositories, uow repository.UnitOfWork, builder *InvoiceBuilder, files storage.Storage, gateway payment.PaymentGateway) InvoiceService {
+	return &invoiceService{repos: repos, uow: uow, builder: builder, files: files, gateway: gateway}
+}
+
+// Draft builds a draft invoice for a subscription. See InvoiceDraftRequest for
+// what it covers.
+func (s *invoiceService) Draft(ctx context.Context, req InvoiceDraftRequest) (InvoiceResponse, error) {
+	var resp InvoiceResponse
+	err := s.uow.Do(ctx, func(repos repository.Repositories) error {
+		invoice, items, err := s.builder.Draft(ctx, repos, req)
+		if err != nil {
+			if errors.Is(err, errNothingToInvoice) {
+				return E.NewInvalidInputError("there is nothing to invoice", nil)
+			}
+			return err
+		}
+
+		resp = convertInvoice(invoice, items)
+		return nil
+	})
+	if err != nil {
+		return InvoiceResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// Finalize issues a draft invoice.
+func (s *invoiceService) Finalize(ctx context.Context, id uuid.UUID) (InvoiceResponse, error) {
+	var resp InvoiceResponse
+	err := s.uow.Do(ctx, func(repos repository.Repositories) error {
+		invoice, items, err := s.builder.Finalize(ctx, repos, id, time.Now())
+		if err != nil {
+			return err
+		}
+
+		resp = convertInvoice(invoice, items)
+		return nil
+	})
+	if err != nil {
+		return InvoiceResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// List returns the customer's invoices, newest first. Drafts are not listed.
+func (s *invoiceService) List(ctx context.Context, customerID uuid.UUID, params InvoiceListParams) ([]InvoiceResponse, error) {
+	switch params.Status {
+	case "", InvoiceIssued, InvoicePaid, InvoiceFailed, InvoiceVoid, InvoicePartiallyRefunded, InvoiceRefunded:
+	default:
+		return nil, E.NewInvalidInputError(fmt.Sprintf("unknown invoice status %q", params.Status), nil)
+	}
+	if !params.From.IsZero() && !params.To.IsZero() && !params.From.Before(params.To) {
+		return nil, E.NewInvalidInputError("from must be before to", nil)
+	}
+
+	invoices, err := s.repos.Invoices.ListByCustomer(ctx, customerID, repository.InvoiceFilter{
+		Status: params.Status,
+		From:   params.From,
+		To:     params.To,
+		Limit:  params.Limit,
+		Offset: params.Offset,
+	})
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve invoices", err)
+	}
+
+	resp := make([]InvoiceResponse, 0, len(invoices))
+	for _, invoice := range invoices {
+		resp = append(resp, convertInvoice(invoice, nil))
+	}
+
+	return resp, nil
+}
+
+// Get returns one of the customer's invoices with line items and transactions.
+func (s *invoiceService) Get(ctx context.Context, customerID, id uuid.UUID) (InvoiceDetailResponse, error) {
+	invoice, err := s.findForCustomer(ctx, customerID, id)
+	if err != nil {
+		return InvoiceDetailResponse{}, err
+	}
+	if invoice.Status == InvoiceDraft {
+		return InvoiceDetailResponse{}, E.NewNotFoundError("invoice")
+	}
+
+	return s.detail(ctx, s.repos, invoice)
+}
+
+func (s *invoiceService) detail(ctx context.Context, repos repository.Repositories, invoice generated.Invoice) (InvoiceDetailResponse, error) {
+	items, err := repos.Invoices.ListLineItems(ctx, invoice.ID)
+	if err != nil {
+		return InvoiceDetailResponse{}, E.NewInternalError("could not retrieve invoice line items", err)
+	}
+
+	transactions, err := repos.Transactions.ListByInvoice(ctx, invoice.ID)
+	if err != nil {
+		return InvoiceDetailResponse{}, E.NewInternalError("could not retrieve invoice transactions", err)
+	}
+
+	resp := InvoiceDetailResponse{
+		InvoiceResponse: convertInvoice(invoice, items),
+		Transactions:    make([]TransactionResponse, 0, len(transactions)),
+	}
+	for _, txn := range transactions {
+		resp.Transactions = append(resp.Transactions, convertTransaction(txn))
+	}
+
+	return resp, nil
+}
+
+// PDF returns the document of one of the customer's issued invoices. It is
+// rendered on first request and kept in storage per invoice status, so a
+// status change such as payment produces a fresh document.
+func (s *invoiceService) PDF(ctx context.Context, customerID, id uuid.UUID) (InvoicePDF, error) {
+	invoice, err := s.findForCustomer(ctx, customerID, id)
+	if err != nil {
+		return InvoicePDF{}, err
+	}
+	if invoice.Status == InvoiceDraft {
+		return InvoicePDF{}, E.NewInvalidInputError("draft invoices have no document yet", nil)
+	}
+
+	filename := invoice.ID.String() + ".pdf"
+	if invoice.InvoiceNumber.Valid {
+		filename = invoice.InvoiceNumber.String + ".pdf"
+	}
+	key := fmt.Sprintf("invoices/%s-%s.pdf", invoice.ID, invoice.Status)
+
+	content, err := s.files.Open(ctx, key)
+	if err == nil {
+		return InvoicePDF{Filename: filename, Content: content}, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return InvoicePDF{}, E.NewInternalError("could not open invoice document", err)
+	}
+
+	data, err := s.render(ctx, invoice)
+	if err != nil {
+		return InvoicePDF{}, err
+	}
+	if err := s.files.Put(ctx, key, data); err != nil {
+		return InvoicePDF{}, E.NewInternalError("could not store invoice document", err)
+	}
+	if err := s.repos.Invoices.SetPdfURL(ctx, invoice.ID, invoicePDFPath(invoice.ID)); err != nil {
+		return InvoicePDF{}, E.NewInternalError("could not update invoice", err)
+	}
+
+	return InvoicePDF{Filename: filename, Content: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (s *invoiceService) render(ctx context.Context, invoice generated.Invoice) ([]byte, error) {
+	items, err := s.repos.Invoices.ListLineItems(ctx, invoice.ID)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve invoice line items", err)
+	}
+
+	customer, err := s.repos.Customers.FindByID(ctx, invoice.CustomerID.Bytes)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve customer", err)
+	}
+
+	doc := invoiceDocument{invoice: invoice, items: items, customer: customer}
+	if customer.UserID.Valid {
+		if user, err := s.repos.Users.FindByID(ctx, customer.UserID.Bytes); err == nil {
+			doc.name = user.Name
+		}
+	}
+
+	return renderInvoicePDF(doc), nil
+}
+
+// findForCustomer loads an invoice owned by the customer. Invoices of other
+// customers are reported as not found.
+func (s *invoiceService) findForCustomer(ctx context.Context, customerID, id uuid.UUID) (generated.Invoice, error) {
+	invoice, err := s.repos.Invoices.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Invoice{}, E.NewNotFoundError("invoice")
+		}
+		return generated.Invoice{}, E.NewInternalError("could not retrieve invoice", err)
+	}
+	if !ownedBy(invoice, customerID) {
+		return generated.Invoice{}, E.NewNotFoundError("invoice")
+	}
+
+	return invoice, nil
+}
+
+func ownedBy(invoice generated.Invoice, customerID uuid.UUID) bool {
+	return invoice.CustomerID.Valid && uuid.UUID(invoice.CustomerID.Bytes) == customerID
+}
+
+// invoicePDFPath is where the owner downloads an invoice document.
+func invoicePDFPath(id uuid.UUID) string {
+	return fmt.Sprintf("/api/v1/invoices/%s/pdf", id)
+}
+
+func convertInvoice(invoice generated.Invoice, items []generated.InvoiceLineItem) InvoiceResponse {
+	resp := InvoiceResponse{
+		ID:         invoice.ID,
+		CustomerID: invoice.CustomerID.Bytes,
+		Status:     invoice.Status,
+		TotalCents: invoice.AmountCents,
+		Currency:   invoice.Currency,
+		IssuedAt:   timePtr(invoice.IssuedAt),
+		DueAt:      timePtr(invoice.DueAt),
+		PaidAt:     timePtr(invoice.PaidAt),
+		CreatedAt:  invoice.CreatedAt.Time,
+	}
+	if invoice.PdfUrl.Valid {
+		resp.PdfURL = &invoice.PdfUrl.String
+	}
+	if invoice.InvoiceNumber.Valid {
+		resp.InvoiceNumber = &invoice.InvoiceNumber.String
+	}
+	if invoice.SubscriptionID.Valid {
+		subscriptionID := uuid.UUID(invoice.SubscriptionID.Bytes)
+		resp.SubscriptionID = &subscriptionID
+	}
+	for _, item := range items {
+		resp.LineItems = append(resp.LineItems, convertLineItem(item))
+	}
+
+	return resp
+}
+
+func convertLineItem(item generated.InvoiceLineItem) InvoiceLineItemResponse {
+	return InvoiceLineItemResponse{
+		ID:              item.ID,
+		Kind:            item.Kind,
+		Description:     item.Description,
+		Quantity:        item.Quantity,
+		UnitAmountCents: item.UnitAmountCents,
+		AmountCents:     item.AmountCents,
+		Currency:        item.Currency,
+		PeriodStart:     timePtr(item.PeriodStart),
+		PeriodEnd:       timePtr(item.PeriodEnd),
+	}
+}
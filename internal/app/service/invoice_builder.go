@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// errNothingToInvoice is returned by InvoiceBuilder.Draft when a draft would
+// have no lines.
+var errNothingToInvoice = errors.New("nothing to invoice")
+
+// InvoiceDraftRequest describes what a subscription invoice covers.
+type InvoiceDraftRequest struct {
+	SubscriptionID uuid.UUID
+	// PeriodStart and PeriodEnd bound the period whose plan fee is charged in
+	// advance. The fee is left out when PeriodStart is zero or the period is
+	// already on another invoice.
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	// UsageStart and UsageEnd bound the period whose metered usage is charged
+	// in arrears. Usage is left out when UsageStart is zero or the period is
+	// already on another invoice.
+	UsageStart time.Time
+	UsageEnd   time.Time
+	// Lines are added as given, e.g. prorations.
+	Lines []generated.CreateInvoiceLineItemParams
+}
+
+// InvoiceNumbering picks the number series of an invoice. Every prefix is an
+// issuer with its own gap-free counter per calendar year.
+type InvoiceNumbering struct {
+	DefaultPrefix string
+	// CurrencyPrefixes overrides DefaultPrefix for invoices in a currency,
+	// keyed by upper-case currency code.
+	CurrencyPrefixes map[string]string
+}
+
+func (n InvoiceNumbering) prefixFor(currency string) string {
+	if prefix, ok := n.CurrencyPrefixes[strings.ToUpper(currency)]; ok {
+		return prefix
+	}
+	return n.DefaultPrefix
+}
+
+// InvoiceBuilder assembles and finalizes invoices with the repositories of
+// the caller's transaction, so invoicing commits or rolls back together with
+// the change that caused it.
+type InvoiceBuilder struct {
+	dueAfter  time.Duration
+	numbering InvoiceNumbering
+}
+
+// NewInvoiceBuilder returns a builder whose invoices are due dueAfter they
+// are issued and numbered according to numbering.
+func NewInvoiceBuilder(dueAfter time.Duration, numbering InvoiceNumbering) *InvoiceBuilder {
+	return &InvoiceBuilder{dueAfter: dueAfter, numbering: numbering}
+}
+
+// Draft creates a draft invoice from the plan fee, metered usage and extra
+// lines described by d, and attaches every pending line item of the customer.
+// The invoice total is the sum of its lines.
+func (b *InvoiceBuilder) Draft(ctx context.Context, repos repository.Repositories, d InvoiceDraftRequest) (generated.Invoice, []generated.InvoiceLineItem, error) {
+	sub, err := repos.Subscriptions.FindByID(ctx, d.SubscriptionID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Invoice{}, nil, E.NewNotFoundError("subscription")
+		}
+		return generated.Invoice{}, nil, E.NewInternalError("could not retrieve subscription", err)
+	}
+
+	plan, err := repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return generated.Invoice{}, nil, E.NewInternalError("could not retrieve plan", err)
+	}
+
+	var lines []generated.CreateInvoiceLineItemParams
+
+	if !d.PeriodStart.IsZero() {
+		billed, err := repos.Invoices.CountBilledLineItems(ctx, sub.ID, LineItemSubscription, d.PeriodStart)
+		if err != nil {
+			return generated.Invoice{}, nil, E.NewInternalError("could not check billed periods", err)
+		}
+		if billed == 0 {
+			lines = append(lines, generated.CreateInvoiceLineItemParams{
+				Kind:            LineItemSubscription,
+				Description:     fmt.Sprintf("%s (%s to %s)", plan.Name, d.PeriodStart.Format(time.DateOnly), d.PeriodEnd.Format(time.DateOnly)),
+				Quantity:        1,
+				UnitAmountCents: plan.PriceCents,
+				AmountCents:     plan.PriceCents,
+				Currency:        plan.Currency,
+				PeriodStart:     timestamptz(d.PeriodStart),
+				PeriodEnd:       timestamptz(d.PeriodEnd),
+			})
+		}
+	}
+
+	if !d.UsageStart.IsZero() {
+		usage, err := b.usageLines(ctx, repos, sub, plan, d.UsageStart, d.UsageEnd)
+		if err != nil {
+			return generated.Invoice{}, nil, err
+		}
+		lines = append(lines, usage...)
+	}
+
+	lines = append(lines, d.Lines...)
+
+	pending, err := repos.Invoices.ListPendingLineItems(ctx, sub.CustomerID.Bytes)
+	if err != nil {
+		return generated.Invoice{}, nil, E.NewInternalError("could not retrieve pending line items", err)
+	}
+	if len(lines) == 0 && len(pending) == 0 {
+		return generated.Invoice{}, nil, errNothingToInvoice
+	}
+
+	var total int64
+	for _, line := range lines {
+		total += line.AmountCents
+	}
+	for _, item := range pending {
+		total += item.AmountCents
+	}
+
+	subscriptionID := pgtype.UUID{Bytes: sub.ID, Valid: true}
+	invoice, err := repos.Invoices.Create(ctx, generated.CreateInvoiceParams{
+		CustomerID:     sub.CustomerID,
+		SubscriptionID: subscriptionID,
+		Status:         InvoiceDraft,
+		AmountCents:    total,
+		Currency:       plan.Currency,
+	})
+	if err != nil {
+		return generated.Invoice{}, nil, E.NewInternalError("could not create invoice", err)
+	}
+
+	invoiceID := pgtype.UUID{Bytes: invoice.ID, Valid: true}
+	items := make([]generated.InvoiceLineItem, 0, len(lines)+len(pending))
+	for _, line := range lines {
+		line.InvoiceID = invoiceID
+		line.CustomerID = sub.CustomerID.Bytes
+		line.SubscriptionID = subscriptionID
+
+		item, err := repos.Invoices.CreateLineItem(ctx, line)
+		if err != nil {
+			return generated.Invoice{}, nil, E.NewInternalError("could not create invoice line item", err)
+		}
+		items = append(items, item)
+	}
+
+	pendingIDs := make([]uuid.UUID, 0, len(pending))
+	for _, item := range pending {
+		pendingIDs = append(pendingIDs, item.ID)
+		item.InvoiceID = invoiceID
+		items = append(items, item)
+	}
+	if err := repos.Invoices.AttachLineItems(ctx, invoice.ID, pendingIDs); err != nil {
+		return generated.Invoice{}, nil, E.NewInternalError("could not attach pending line items", err)
+	}
+
+	return invoice, items, nil
+}
+
+// usageLines turns the customer's usage rollups for a period into one line
+// per metric, followed by an overage line for metrics used beyond an
+// overage quota of the plan. The rolled-up cost is authoritative; the unit
+// amount is only indicative when the cost does not divide evenly by the
+// quantity.
+func (b *InvoiceBuilder) usageLines(ctx context.Context, repos repository.Repositories, sub generated.Subscription, plan generated.Plan, start, end time.Time) ([]generated.CreateInvoiceLineItemParams, error) {
+	billed, err := repos.Invoices.CountBilledLineItems(ctx, sub.ID, LineItemUsage, start)
+	if err != nil {
+		return nil, E.NewInternalError("could not check billed usage", err)
+	}
+	if billed > 0 {
+		return nil, nil
+	}
+
+	limits, err := parseQuotaLimits(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates, err := repos.Usage.ListAggregatesForPeriod(ctx, sub.CustomerID.Bytes, start, end)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve usage", err)
+	}
+
+	var lines []generated.CreateInvoiceLineItemParams
+	var metrics []string
+	for _, agg := range aggregates {
+		// Aggregates are ordered by metric, so rollups of one metric are adjacent
+		if n := len(lines); n > 0 && metrics[n-1] == agg.Metric {
+			lines[n-1].Quantity += agg.TotalQuantity.Int64
+			lines[n-1].AmountCents += agg.TotalCostCents.Int64
+			continue
+		}
+
+		lines = append(lines, generated.CreateInvoiceLineItemParams{
+			Kind:        LineItemUsage,
+			Description: usageDescription(agg.Metric),
+			Quantity:    agg.TotalQuantity.Int64,
+			AmountCents: agg.TotalCostCents.Int64,
+			Currency:    plan.Currency,
+			PeriodStart: timestamptz(start),
+			PeriodEnd:   timestamptz(end),
+		})
+		metrics = append(metrics, agg.Metric)
+	}
+
+	var result []generated.CreateInvoiceLineItemParams
+	for i, line := range lines {
+		if line.Quantity == 0 && line.AmountCents == 0 {
+			continue
+		}
+		if line.Quantity > 0 {
+			line.UnitAmountCents = line.AmountCents / line.Quantity
+		}
+		result = append(result, line)
+
+		quota := limits[metrics[i]]
+		if units, amount := quota.overage(line.Quantity); units > 0 {
+			result = append(result, generated.CreateInvoiceLineItemParams{
+				Kind:            LineItemOverage,
+				Description:     overageDescription(metrics[i], quota),
+				Quantity:        units,
+				UnitAmountCents: quota.overageUnitPriceCents,
+				AmountCents:     amount,
+				Currency:        plan.Currency,
+				PeriodStart:     timestamptz(start),
+				PeriodEnd:       timestamptz(end),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// Finalize issues a draft invoice and assigns its number. Customer credit is
+// applied to a positive subtotal, and a negative subtotal is moved to the
+// customer's credit balance, each recorded as a credit line so the total
+// stays the sum of the lines.
+func (b *InvoiceBuilder) Finalize(ctx context.Context, repos repository.Repositories, id uuid.UUID, now time.Time) (generated.Invoice, []generated.InvoiceLineItem, error) {
+	invoice, err := repos.Invoices.FindForUpdate(ctx, id)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Invoice{}, nil, E.NewNotFoundError("invoice")
+		}
+		return generated.Invoice{}, nil, E.NewInternalError("could not retrieve invoice", err)
+	}
+	if invoice.Status != InvoiceDraft {
+		return generated.Invoice{}, nil, E.NewInvalidTransitionError("invoice", invoice.Status, InvoiceIssued)
+	}
+
+	items, err := repos.Invoices.ListLineItems(ctx, id)
+	if err != nil {
+		return generated.Invoice{}, nil, E.NewInternalError("could not retrieve invoice line items", err)
+	}
+
+	var subtotal int64
+	for _, item := range items {
+		subtotal += item.AmountCents
+	}
+
+	customerID := invoice.CustomerID.Bytes
+	var adjustment int64
+	var description string
+	switch {
+	case subtotal > 0:
+		applied, err := repos.Customers.ConsumeCredit(ctx, customerID, subtotal)
+		if err != nil {
+			return generated.Invoice{}, nil, E.NewInternalError("could not apply customer credit", err)
+		}
+		adjustment = -applied
+		description = "Applied account credit"
+	case subtotal < 0:
+		if _, err := repos.Customers.AddCredit(ctx, customerID, -subtotal); err != nil {
+			return generated.Invoice{}, nil, E.NewInternalError("could not store customer credit", err)
+		}
+		adjustment = -subtotal
+		description = "Credit carried to account balance"
+	}
+
+	if adjustment != 0 {
+		item, err := repos.Invoices.CreateLineItem(ctx, generated.CreateInvoiceLineItemParams{
+			InvoiceID:       pgtype.UUID{Bytes: invoice.ID, Valid: true},
+			CustomerID:      customerID,
+			SubscriptionID:  invoice.SubscriptionID,
+			Kind:            LineItemCredit,
+			Description:     description,
+			Quantity:        1,
+			UnitAmountCents: adjustment,
+			AmountCents:     adjustment,
+			Currency:        invoice.Currency,
+		})
+		if err != nil {
+			return generated.Invoice{}, nil, E.NewInternalError("could not create credit line item", err)
+		}
+		items = append(items, item)
+	}
+
+	number, err := b.nextNumber(ctx, repos, invoice.Currency, now)
+	if err != nil {
+		return generated.Invoice{}, nil, err
+	}
+
+	issued, err := repos.Invoices.Finalize(ctx, id, number, subtotal+adjustment, now, now.Add(b.dueAfter))
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Invoice{}, nil, E.NewInvalidTransitionError("invoice", invoice.Status, InvoiceIssued)
+		}
+		return generated.Invoice{}, nil, E.NewInternalError("could not finalize invoice", err)
+	}
+
+	return issued, items, nil
+}
+
+// nextNumber takes the next number of the invoice's series. Numbers are only
+// taken inside the finalizing transaction, so drafts never consume one and a
+// failed finalization gives its number back.
+func (b *InvoiceBuilder) nextNumber(ctx context.Context, repos repository.Repositories, currency string, issuedAt time.Time) (string, error) {
+	prefix := b.numbering.prefixFor(currency)
+	year := issuedAt.UTC().Year()
+
+	seq, err := repos.Invoices.NextNumber(ctx, prefix, year)
+	if err != nil {
+		return "", E.NewInternalError("could not assign invoice number", err)
+	}
+
+	return fmt.Sprintf("%s-%d-%06d", prefix, year, seq), nil
+}
+
+func usageDescription(metric string) string {
+	return fmt.Sprintf("%s usage", metric)
+}
+
+func overageDescription(metric string, quota usageQuota) string {
+	if quota.overageUnitSize > 1 {
+		return fmt.Sprintf("%s overage beyond %d included, per %d", metric, quota.limit, quota.overageUnitSize)
+	}
+	return fmt.Sprintf("%s overage beyond %d included", metric, quota.limit)
+}
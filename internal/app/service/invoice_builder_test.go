@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestDraftAndFinalize(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	now := start.Add(time.Hour)
+
+	tests := []struct {
+		name         string
+		fee          bool
+		feeBilled    bool
+		pending      []int64
+		credit       int64
+		wantErr      error
+		wantLines    int
+		wantAmount   int64
+		wantCredit   int64
+		wantSubtotal int64
+	}{
+		{
+			name:         "plan fee",
+			fee:          true,
+			wantLines:    1,
+			wantAmount:   1000,
+			wantSubtotal: 1000,
+		},
+		{
+			name:         "plan fee with pending items",
+			fee:          true,
+			pending:      []int64{250, -100},
+			wantLines:    3,
+			wantAmount:   1150,
+			wantSubtotal: 1150,
+		},
+		{
+			name:         "credit applied",
+			fee:          true,
+			credit:       300,
+			wantLines:    2,
+			wantAmount:   700,
+			wantSubtotal: 1000,
+		},
+		{
+			name:         "credit covers the invoice",
+			fee:          true,
+			credit:       5000,
+			wantLines:    2,
+			wantAmount:   0,
+			wantCredit:   4000,
+			wantSubtotal: 1000,
+		},
+		{
+			name:         "net credit carried to the balance",
+			pending:      []int64{-400},
+			wantLines:    2,
+			wantAmount:   0,
+			wantCredit:   400,
+			wantSubtotal: -400,
+		},
+		{
+			name:      "fee already billed",
+			fee:       true,
+			feeBilled: true,
+			wantErr:   errNothingToInvoice,
+		},
+		{
+			name:    "nothing to invoice",
+			wantErr: errNothingToInvoice,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemStore()
+			repos := store.repos()
+			builder := NewInvoiceBuilder(7*24*time.Hour, InvoiceNumbering{DefaultPrefix: "INV"})
+
+			plan := &generated.Plan{ID: uuid.New(), Name: "Pro", PriceCents: 1000, Currency: "USD", Interval: "month"}
+			customer := &generated.Customer{ID: uuid.New(), CreditBalanceCents: pgtype.Int8{Int64: tt.credit, Valid: true}}
+			sub := &generated.Subscription{
+				ID:         uuid.New(),
+				CustomerID: pgtype.UUID{Bytes: customer.ID, Valid: true},
+				PlanID:     pgtype.UUID{Bytes: plan.ID, Valid: true},
+				Status:     SubscriptionActive,
+			}
+			store.plans = append(store.plans, plan)
+			store.customers = append(store.customers, customer)
+			store.subscriptions = append(store.subscriptions, sub)
+			if tt.feeBilled {
+				store.lineItems = append(store.lineItems, &generated.InvoiceLineItem{
+					ID:             uuid.New(),
+					InvoiceID:      pgtype.UUID{Bytes: uuid.New(), Valid: true},
+					SubscriptionID: pgtype.UUID{Bytes: sub.ID, Valid: true},
+					Kind:           LineItemSubscription,
+					PeriodStart:    timestamptz(start),
+				})
+			}
+			for _, amount := range tt.pending {
+				store.lineItems = append(store.lineItems, &generated.InvoiceLineItem{
+					ID:          uuid.New(),
+					CustomerID:  customer.ID,
+					Kind:        LineItemProration,
+					Quantity:    1,
+					AmountCents: amount,
+					Currency:    "USD",
+				})
+			}
+
+			d := InvoiceDraftRequest{SubscriptionID: sub.ID}
+			if tt.fee {
+				d.PeriodStart, d.PeriodEnd = start, end
+			}
+			draft, items, err := builder.Draft(ctx, repos, d)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("draft error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			wantDraftLines := len(tt.pending)
+			if tt.fee {
+				wantDraftLines++
+			}
+			if draft.Status != InvoiceDraft || draft.AmountCents != tt.wantSubtotal || len(items) != wantDraftLines {
+				t.Fatalf("draft %s of %d with %d lines, want %s of %d", draft.Status, draft.AmountCents, len(items), InvoiceDraft, tt.wantSubtotal)
+			}
+
+			issued, items, err := builder.Finalize(ctx, repos, draft.ID, now)
+			if err != nil {
+				t.Fatalf("finalize: %v", err)
+			}
+			if issued.Status != InvoiceIssued || issued.AmountCents != tt.wantAmount || len(items) != tt.wantLines {
+				t.Fatalf("invoice %s of %d with %d lines, want %s of %d with %d", issued.Status, issued.AmountCents, len(items), InvoiceIssued, tt.wantAmount, tt.wantLines)
+			}
+			if !issued.DueAt.Time.Equal(now.Add(7 * 24 * time.Hour)) {
+				t.Fatalf("due at %s, want a week after %s", issued.DueAt.Time, now)
+			}
+
+			var sum int64
+			for _, item := range items {
+				sum += item.AmountCents
+			}
+			if sum != issued.AmountCents {
+				t.Fatalf("lines add up to %d, invoice total is %d", sum, issued.AmountCents)
+			}
+			if customer.CreditBalanceCents.Int64 != tt.wantCredit {
+				t.Fatalf("credit balance = %d, want %d", customer.CreditBalanceCents.Int64, tt.wantCredit)
+			}
+
+			if _, _, err := builder.Finalize(ctx, repos, draft.ID, now); err == nil {
+				t.Fatal("finalized an issued invoice again")
+			}
+		})
+	}
+}
+
+func TestInvoiceNumbers(t *testing.T) {
+	numbering := InvoiceNumbering{
+		DefaultPrefix:    "INV",
+		CurrencyPrefixes: map[string]string{"IDR": "INV-ID"},
+	}
+	lastYear := time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC)
+	thisYear := time.Date(2026, 1, 1, 0, 30, 0, 0, time.UTC)
+
+	// Each step shares the counters of the steps before it.
+	steps := []struct {
+		currency string
+		issuedAt time.Time
+		want     string
+	}{
+		{"USD", lastYear, "INV-2025-000001"},
+		{"USD", lastYear, "INV-2025-000002"},
+		{"EUR", lastYear, "INV-2025-000003"},
+		{"IDR", lastYear, "INV-ID-2025-000001"},
+		{"idr", lastYear, "INV-ID-2025-000002"},
+		{"USD", thisYear, "INV-2026-000001"},
+		{"USD", thisYear.In(time.FixedZone("UTC-5", -5*60*60)), "INV-2026-000002"},
+		{"IDR", thisYear, "INV-ID-2026-000001"},
+	}
+
+	store := newMemStore()
+	repos := store.repos()
+	builder := NewInvoiceBuilder(time.Hour, numbering)
+	for i, st := range steps {
+		got, err := builder.nextNumber(context.Background(), repos, st.currency, st.issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != st.want {
+			t.Fatalf("step %d: number of %s invoice issued %s = %s, want %s", i, st.currency, st.issuedAt, got, st.want)
+		}
+	}
+}
+
+func TestDraftBillsOverage(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	tests := []struct {
+		name        string
+		quotaLimits string
+		used        []int64 // rollups of the period
+		usageCost   int64
+		wantOverage *generated.CreateInvoiceLineItemParams
+	}{
+		{
+			name:        "within the limit",
+			quotaLimits: `{"tokens": {"limit": 1000, "enforcement": "overage", "overage_unit_price_cents": 2, "overage_unit_size": 100}}`,
+			used:        []int64{600, 400},
+		},
+		{
+			name:        "beyond the limit in partial units",
+			quotaLimits: `{"tokens": {"limit": 1000, "enforcement": "overage", "overage_unit_price_cents": 2, "overage_unit_size": 100}}`,
+			used:        []int64{900, 350},
+			usageCost:   40,
+			wantOverage: &generated.CreateInvoiceLineItemParams{
+				Description:     "tokens overage beyond 1000 included, per 100",
+				Quantity:        3,
+				UnitAmountCents: 2,
+				AmountCents:     6,
+			},
+		},
+		{
+			name:        "soft limit is not billed",
+			quotaLimits: `{"tokens": {"limit": 1000, "enforcement": "soft"}}`,
+			used:        []int64{5000},
+		},
+		{
+			name:        "hard limit is not billed",
+			quotaLimits: `{"tokens": 1000}`,
+			used:        []int64{1200},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemStore()
+			repos := store.repos()
+			builder := NewInvoiceBuilder(7*24*time.Hour, InvoiceNumbering{DefaultPrefix: "INV"})
+
+			plan := &generated.Plan{ID: uuid.New(), Name: "Metered", PriceCents: 1000, Currency: "USD", Interval: "month", QuotaLimits: []byte(tt.quotaLimits)}
+			customer := &generated.Customer{ID: uuid.New(), CreditBalanceCents: pgtype.Int8{Valid: true}}
+			sub := &generated.Subscription{
+				ID:         uuid.New(),
+				CustomerID: pgtype.UUID{Bytes: customer.ID, Valid: true},
+				PlanID:     pgtype.UUID{Bytes: plan.ID, Valid: true},
+				Status:     SubscriptionActive,
+			}
+			store.plans = append(store.plans, plan)
+			store.customers = append(store.customers, customer)
+			store.subscriptions = append(store.subscriptions, sub)
+
+			var used int64
+			for i, quantity := range tt.used {
+				var cost int64
+				if i == 0 {
+					cost = tt.usageCost
+				}
+				store.aggregates = append(store.aggregates, &generated.UsageAggregate{
+					ID:             uuid.New(),
+					CustomerID:     sub.CustomerID,
+					PeriodStart:    pgtype.Date{Time: start.AddDate(0, 0, i), Valid: true},
+					Metric:         "tokens",
+					TotalQuantity:  pgtype.Int8{Int64: quantity, Valid: true},
+					TotalCostCents: pgtype.Int8{Int64: cost, Valid: true},
+				})
+				used += quantity
+			}
+
+			draft, items, err := builder.Draft(ctx, repos, InvoiceDraftRequest{
+				SubscriptionID: sub.ID,
+				PeriodStart:    end,
+				PeriodEnd:      end.AddDate(0, 1, 0),
+				UsageStart:     start,
+				UsageEnd:       end,
+			})
+			if err != nil {
+				t.Fatalf("draft: %v", err)
+			}
+
+			var usage, overage []generated.InvoiceLineItem
+			for _, item := range items {
+				switch item.Kind {
+				case LineItemUsage:
+					usage = append(usage, item)
+				case LineItemOverage:
+					overage = append(overage, item)
+				}
+			}
+			if len(usage) != 1 || usage[0].Quantity != used || usage[0].AmountCents != tt.usageCost {
+				t.Fatalf("usage lines = %+v, want one of %d for %d cents", usage, used, tt.usageCost)
+			}
+
+			var overageCents int64
+			if tt.wantOverage == nil {
+				if len(overage) != 0 {
+					t.Fatalf("overage lines = %+v, want none", overage)
+				}
+			} else {
+				want := tt.wantOverage
+				if len(overage) != 1 || overage[0].Description != want.Description || overage[0].Quantity != want.Quantity ||
+					overage[0].UnitAmountCents != want.UnitAmountCents || overage[0].AmountCents != want.AmountCents {
+					t.Fatalf("overage lines = %+v, want one like %+v", overage, want)
+				}
+				overageCents = want.AmountCents
+			}
+			if want := plan.PriceCents + tt.usageCost + overageCents; draft.AmountCents != want {
+				t.Fatalf("draft total = %d, want %d", draft.AmountCents, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// Transaction statuses as stored in transactions.status.
+const (
+	TransactionPending   = "pending"
+	TransactionSucceeded = "succeeded"
+	TransactionFailed    = "failed"
+	TransactionRefunded  = "refunded"
+)
+
+// GatewayCreditBalance marks transactions paid from the customer's credit
+// balance rather than through a payment gateway.
+const GatewayCreditBalance = "credit_balance"
+
+type TransactionResponse struct {
+	ID               uuid.UUID `json:"id"`
+	Gateway          string    `json:"gateway"`
+	GatewayPaymentID *string   `json:"gateway_payment_id"`
+	AmountCents      int64     `json:"amount_cents"`
+	Currency         string    `json:"currency"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+	// RefundedTransactionID is the payment a refund pays back.
+	RefundedTransactionID *uuid.UUID `json:"refunded_transaction_id,omitempty"`
+}
+
+// PayOptions selects the funding sources of an invoice payment. The credit
+// balance is used first; the default payment method covers the rest.
+type PayOptions struct {
+	UseCreditBalance bool
+	UsePaymentMethod bool
+}
+
+// Pay settles an issued or failed invoice of the customer. The credit
+// balance is applied first and the default payment method is charged for the
+// rest.
+//
+// The charge is recorded as pending and committed before the gateway is
+// called, so the invoice is not locked while the gateway answers, and settled
+// in a second transaction. A declined charge marks the invoice failed and
+// gives the credit back. A charge whose outcome is unknown stays pending; the
+// gateway's webhook settles it, and paying again replays it with the same
+// idempotency key instead of charging twice.
+func (s *invoiceService) Pay(ctx context.Context, customerID, id uuid.UUID, opts PayOptions) (InvoiceDetailResponse, error) {
+	if !opts.UseCreditBalance && !opts.UsePaymentMethod {
+		return InvoiceDetailResponse{}, E.NewInvalidInputError("select at least one way to pay", nil)
+	}
+
+	var resp InvoiceDetailResponse
+	var charge *pendingCharge
+	err := s.uow.Do(ctx, func(repos repository.Repositories) error {
+		charge = nil
+
+		invoice, err := repos.Invoices.FindForUpdate(ctx, id)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.NewNotFoundError("invoice")
+			}
+			return E.NewInternalError("could not retrieve invoice", err)
+		}
+		if !ownedBy(invoice, customerID) || invoice.Status == InvoiceDraft {
+			return E.NewNotFoundError("invoice")
+		}
+
+		switch invoice.Status {
+		case InvoiceIssued, InvoiceFailed:
+		case InvoicePaid:
+			return E.NewInvalidInputError("invoice is already paid", nil)
+		default:
+			return E.NewInvalidTransitionError("invoice", invoice.Status, InvoicePaid)
+		}
+
+		balance, err := invoiceBalance(ctx, repos, invoice)
+		if err != nil {
+			return err
+		}
+		if balance.unsettled != nil && opts.UsePaymentMethod {
+			// An earlier charge never got an answer, so ask again
+			charge, err = s.resumeCharge(ctx, repos, invoice, *balance.unsettled)
+			return err
+		}
+		if balance.pending {
+			return E.NewInvalidInputError("a payment for this invoice is still being processed", nil)
+		}
+		due := balance.due
+
+		var credit int64
+		if due > 0 && opts.UseCreditBalance {
+			credit, err = repos.Customers.ConsumeCredit(ctx, customerID, due)
+			if err != nil {
+				return E.NewInternalError("could not apply credit balance", err)
+			}
+		}
+
+		creditStatus := TransactionSucceeded
+		rest := due - credit
+		if rest > 0 {
+			if !opts.UsePaymentMethod {
+				return E.NewInvalidInputError("credit balance does not cover the amount due", nil)
+			}
+			// The credit is settled together with the charge
+			creditStatus = TransactionPending
+		}
+
+		if credit > 0 {
+			if _, err := repos.Transactions.Create(ctx, generated.CreateTransactionParams{
+				InvoiceID:   pgtype.UUID{Bytes: invoice.ID, Valid: true},
+				CustomerID:  invoice.CustomerID,
+				Gateway:     GatewayCreditBalance,
+				AmountCents: credit,
+				Currency:    invoice.Currency,
+				Status:      creditStatus,
+			}); err != nil {
+				return E.NewInternalError("could not record credit payment", err)
+			}
+		}
+
+		if rest > 0 {
+			charge, err = s.startCharge(ctx, repos, invoice, rest, balance.attempts)
+			return err
+		}
+
+		paid, err := repos.Invoices.MarkPaid(ctx, invoice.ID, time.Now())
+		if err != nil {
+			return E.NewInternalError("could not mark invoice paid", err)
+		}
+
+		resp, err = s.detail(ctx, repos, paid)
+		return err
+	})
+	if err != nil {
+		return InvoiceDetailResponse{}, err
+	}
+	if charge == nil {
+		return resp, nil
+	}
+
+	p, chargeErr := s.gateway.Charge(ctx, charge.request)
+
+	var payErr error
+	err = s.uow.Do(ctx, func(repos repository.Repositories) error {
+		var err error
+		resp, payErr, err = s.settleCharge(ctx, repos, *charge, p, chargeErr)
+		return err
+	})
+	if err != nil {
+		return InvoiceDetailResponse{}, err
+	}
+	if payErr != nil {
+		return InvoiceDetailResponse{}, payErr
+	}
+
+	return resp, nil
+}
+
+// pendingCharge is a charge of the default payment method that is recorded
+// as a pending transaction and still has to be sent to the gateway.
+type pendingCharge struct {
+	invoiceID     uuid.UUID
+	transactionID uuid.UUID
+	request       payment.ChargeRequest
+}
+
+// startCharge records a pending charge of amount for the invoice. The
+// idempotency key is derived from the number of earlier attempts, so every
+// attempt is charged at most once.
+func (s *invoiceService) startCharge(ctx context.Context, repos repository.Repositories, invoice generated.Invoice, amount int64, attempt int) (*pendingCharge, error) {
+	key := fmt.Sprintf("invoice-%s-attempt-%d", invoice.ID, attempt)
+	req, err := chargeRequest(ctx, repos, invoice, amount, key)
+	if err != nil {
+		return nil, err
+	}
+
+	txn, err := repos.Transactions.Create(ctx, generated.CreateTransactionParams{
+		InvoiceID:      pgtype.UUID{Bytes: invoice.ID, Valid: true},
+		CustomerID:     invoice.CustomerID,
+		Gateway:        s.gateway.Name(),
+		AmountCents:    amount,
+		Currency:       invoice.Currency,
+		Status:         TransactionPending,
+		IdempotencyKey: pgtype.Text{String: key, Valid: true},
+	})
+	if err != nil {
+		return nil, E.NewInternalError("could not record payment", err)
+	}
+
+	return &pendingCharge{invoiceID: invoice.ID, transactionID: txn.ID, request: req}, nil
+}
+
+// resumeCharge rebuilds the charge of a pending transaction that never got
+// an answer from the gateway.
+func (s *invoiceService) resumeCharge(ctx context.Context, repos repository.Repositories, invoice generated.Invoice, txn generated.Transaction) (*pendingCharge, error) {
+	req, err := chargeRequest(ctx, repos, invoice, txn.AmountCents, txn.IdempotencyKey.String)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingCharge{invoiceID: invoice.ID, transactionID: txn.ID, request: req}, nil
+}
+
+// chargeRequest charges amount of the invoice to the customer's default
+// payment method.
+func chargeRequest(ctx context.Context, repos repository.Repositories, invoice generated.Invoice, amount int64, key string) (payment.ChargeRequest, error) {
+	customer, err := repos.Customers.FindByID(ctx, invoice.CustomerID.Bytes)
+	if err != nil {
+		return payment.ChargeRequest{}, E.NewInternalError("could not retrieve customer", err)
+	}
+
+	var method PaymentMethod
+	if len(customer.DefaultPaymentMethod) > 0 {
+		if err := json.Unmarshal(customer.DefaultPaymentMethod, &method); err != nil {
+			return payment.ChargeRequest{}, E.NewInternalError("could not decode payment method", err)
+		}
+	}
+	if method.PaymentMethodID == "" {
+		return payment.ChargeRequest{}, E.NewInvalidInputError("no default payment method is set", nil)
+	}
+
+	return payment.ChargeRequest{
+		CustomerID:      customer.ID,
+		PaymentMethodID: method.PaymentMethodID,
+		Reference:       invoice.ID.String(),
+		AmountCents:     amount,
+		Currency:        invoice.Currency,
+		IdempotencyKey:  key,
+	}, nil
+}
+
+// settleCharge records the gateway's answer to a pending charge. Declines
+// and timeouts are returned as payErr so the caller can commit them; other
+// errors abort the transaction. A charge the webhook settled meanwhile is
+// left as it is.
+func (s *invoiceService) settleCharge(ctx context.Context, repos repository.Repositories, charge pendingCharge, p payment.Payment, chargeErr error) (resp InvoiceDetailResponse, payErr error, err error) {
+	invoice, err := repos.Invoices.FindForUpdate(ctx, charge.invoiceID)
+	if err != nil {
+		return InvoiceDetailResponse{}, nil, E.NewInternalError("could not retrieve invoice", err)
+	}
+	txn, err := repos.Transactions.FindForUpdate(ctx, charge.transactionID)
+	if err != nil {
+		return InvoiceDetailResponse{}, nil, E.NewInternalError("could not retrieve payment", err)
+	}
+
+	switch txn.Status {
+	case TransactionPending:
+		status := TransactionSucceeded
+		switch {
+		case chargeErr == nil:
+		case errors.Is(chargeErr, payment.ErrDeclined):
+			status = TransactionFailed
+			payErr = E.NewPaymentFailedError("payment was declined", chargeErr)
+		case errors.Is(chargeErr, payment.ErrTimeout):
+			status = TransactionPending
+			payErr = E.NewGatewayTimeoutError("payment is still being processed, the invoice is updated once the gateway confirms it", chargeErr)
+		default:
+			// The gateway rejected the request, so nothing was charged
+			status = TransactionFailed
+			payErr = E.NewInternalError("could not charge payment method", chargeErr)
+		}
+
+		if _, err := repos.Transactions.UpdateStatus(ctx, txn.ID, status, p.ID, p.Raw); err != nil {
+			return InvoiceDetailResponse{}, nil, E.NewInternalError("could not record payment", err)
+		}
+		if err := settleCreditPayments(ctx, repos, invoice, status); err != nil {
+			return InvoiceDetailResponse{}, nil, err
+		}
+		if errors.Is(chargeErr, payment.ErrDeclined) {
+			if _, err := repos.Invoices.MarkFailed(ctx, invoice.ID); err != nil {
+				return InvoiceDetailResponse{}, nil, E.NewInternalError("could not mark invoice failed", err)
+			}
+		}
+		if payErr != nil {
+			return InvoiceDetailResponse{}, payErr, nil
+		}
+	case TransactionFailed:
+		return InvoiceDetailResponse{}, E.NewPaymentFailedError("payment was declined", nil), nil
+	}
+
+	if invoice.Status == InvoiceIssued || invoice.Status == InvoiceFailed {
+		balance, err := invoiceBalance(ctx, repos, invoice)
+		if err != nil {
+			return InvoiceDetailResponse{}, nil, err
+		}
+		if balance.due <= 0 {
+			if invoice, err = repos.Invoices.MarkPaid(ctx, invoice.ID, time.Now()); err != nil {
+				return InvoiceDetailResponse{}, nil, E.NewInternalError("could not mark invoice paid", err)
+			}
+		}
+	}
+
+	resp, err = s.detail(ctx, repos, invoice)
+	return resp, nil, err
+}
+
+// settleCreditPayments settles the pending credit payments of an invoice
+// together with the charge they were made with. Credit taken for a failed
+// charge goes back to the customer's balance.
+func settleCreditPayments(ctx context.Context, repos repository.Repositories, invoice generated.Invoice, status string) error {
+	if status == TransactionPending {
+		return nil
+	}
+
+	transactions, err := repos.Transactions.ListByInvoice(ctx, invoice.ID)
+	if err != nil {
+		return E.NewInternalError("could not retrieve invoice transactions", err)
+	}
+	for _, txn := range transactions {
+		if txn.Gateway != GatewayCreditBalance || txn.Status != TransactionPending {
+			continue
+		}
+		if _, err := repos.Transactions.UpdateStatus(ctx, txn.ID, status, "", nil); err != nil {
+			return E.NewInternalError("could not settle credit payment", err)
+		}
+		if status == TransactionFailed {
+			if _, err := repos.Customers.AddCredit(ctx, txn.CustomerID.Bytes, txn.AmountCents); err != nil {
+				return E.NewInternalError("could not restore credit balance", err)
+			}
+		}
+	}
+	return nil
+}
+
+type invoiceBalanceInfo struct {
+	due      int64
+	attempts int
+	pending  bool
+	// unsettled is a pending charge the gateway never answered, e.g. because
+	// the service stopped during the call. Replaying it with its idempotency
+	// key settles it without charging twice.
+	unsettled *generated.Transaction
+}
+
+// invoiceBalance is the invoice total less what succeeded transactions
+// already paid, with the number of earlier attempts and whether one of them
+// is still pending.
+func invoiceBalance(ctx context.Context, repos repository.Repositories, invoice generated.Invoice) (invoiceBalanceInfo, error) {
+	transactions, err := repos.Transactions.ListByInvoice(ctx, invoice.ID)
+	if err != nil {
+		return invoiceBalanceInfo{}, E.NewInternalError("could not retrieve invoice transactions", err)
+	}
+
+	info := invoiceBalanceInfo{due: invoice.AmountCents, attempts: len(transactions)}
+	for _, txn := range transactions {
+		switch txn.Status {
+		case TransactionSucceeded:
+			info.due -= txn.AmountCents
+		case TransactionPending:
+			info.pending = true
+			if !txn.GatewayPaymentID.Valid && txn.IdempotencyKey.Valid {
+				info.unsettled = &txn
+			}
+		}
+	}
+	return info, nil
+}
+
+func convertTransaction(txn generated.Transaction) TransactionResponse {
+	resp := TransactionResponse{
+		ID:          txn.ID,
+		Gateway:     txn.Gateway,
+		AmountCents: txn.AmountCents,
+		Currency:    txn.Currency,
+		Status:      txn.Status,
+		CreatedAt:   txn.CreatedAt.Time,
+	}
+	if txn.GatewayPaymentID.Valid {
+		resp.GatewayPaymentID = &txn.GatewayPaymentID.String
+	}
+	if txn.RefundedTransactionID.Valid {
+		refundedID := uuid.UUID(txn.RefundedTransactionID.Bytes)
+		resp.RefundedTransactionID = &refundedID
+	}
+	return resp
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/payment"
+	"github.com/novaru/billing-service/pkg/pdf"
+)
+
+// Layout of the invoice document, in points.
+const (
+	pdfMarginLeft   = 50.0
+	pdfMarginRight  = pdf.PageWidth - 50
+	pdfMarginBottom = 90.0
+	pdfLineHeight   = 16.0
+
+	pdfColPeriod = 270.0
+	pdfColQty    = 400.0
+	pdfColUnit   = 470.0
+)
+
+// invoiceDocument is what goes on an invoice PDF.
+type invoiceDocument struct {
+	invoice  generated.Invoice
+	items    []generated.InvoiceLineItem
+	customer generated.Customer
+	name     string
+}
+
+// renderInvoicePDF lays out an issued invoice: header, customer details, line
+// items, totals and payment status. Prices are tax inclusive and no tax is
+// charged separately yet, so the tax row shows zero.
+func renderInvoicePDF(d invoiceDocument) []byte {
+	inv := d.invoice
+	number := inv.InvoiceNumber.String
+	if number == "" {
+		number = inv.ID.String()
+	}
+
+	doc := pdf.New("Invoice " + number)
+	page := doc.AddPage()
+	y := pdf.PageHeight - 60
+
+	page.Text(pdfMarginLeft, y, 22, true, "INVOICE")
+	page.TextRight(pdfMarginRight, y, 11, true, number)
+	y -= 2 * pdfLineHeight
+
+	details := [][2]string{
+		{"Issued", formatDate(inv.IssuedAt.Time)},
+		{"Due", formatDate(inv.DueAt.Time)},
+		{"Status", strings.ToUpper(inv.Status)},
+	}
+	billTo := []string{"Bill to"}
+	if d.name != "" {
+		billTo = append(billTo, d.name)
+	}
+	if d.customer.Email.Valid {
+		billTo = append(billTo, d.customer.Email.String)
+	}
+	billTo = append(billTo, "Customer "+d.customer.ID.String())
+
+	for i := 0; i < max(len(details), len(billTo)); i++ {
+		if i < len(billTo) {
+			page.Text(pdfMarginLeft, y, 10, i == 0, billTo[i])
+		}
+		if i < len(details) {
+			page.Text(pdfColQty, y, 10, true, details[i][0])
+			page.TextRight(pdfMarginRight, y, 10, false, details[i][1])
+		}
+		y -= pdfLineHeight
+	}
+	y -= pdfLineHeight
+
+	header := func() {
+		page.Text(pdfMarginLeft, y, 10, true, "Description")
+		page.Text(pdfColPeriod, y, 10, true, "Period")
+		page.TextRight(pdfColQty+30, y, 10, true, "Qty")
+		page.TextRight(pdfColUnit+40, y, 10, true, "Unit")
+		page.TextRight(pdfMarginRight, y, 10, true, "Amount")
+		y -= 6
+		page.Line(pdfMarginLeft, y, pdfMarginRight, y, 0.8)
+		y -= pdfLineHeight
+	}
+	header()
+
+	var subtotal, credits int64
+	for _, item := range d.items {
+		if y < pdfMarginBottom {
+			page = doc.AddPage()
+			y = pdf.PageHeight - 60
+			header()
+		}
+
+		if item.Kind == LineItemCredit {
+			credits += item.AmountCents
+		} else {
+			subtotal += item.AmountCents
+		}
+
+		page.Text(pdfMarginLeft, y, 9, false, truncate(item.Description, 215, 9))
+		if item.PeriodStart.Valid && item.PeriodEnd.Valid {
+			page.Text(pdfColPeriod, y, 9, false, formatDate(item.PeriodStart.Time)+" - "+formatDate(item.PeriodEnd.Time))
+		}
+		page.TextRight(pdfColQty+30, y, 9, false, fmt.Sprint(item.Quantity))
+		page.TextRight(pdfColUnit+40, y, 9, false, formatAmount(item.UnitAmountCents, item.Currency))
+		page.TextRight(pdfMarginRight, y, 9, false, formatAmount(item.AmountCents, item.Currency))
+		y -= pdfLineHeight
+	}
+
+	if y < pdfMarginBottom+5*pdfLineHeight {
+		page = doc.AddPage()
+		y = pdf.PageHeight - 60
+	}
+	y -= 4
+	page.Line(pdfColQty, y+pdfLineHeight-4, pdfMarginRight, y+pdfLineHeight-4, 0.5)
+
+	totals := [][2]string{
+		{"Subtotal", formatAmount(subtotal, inv.Currency)},
+		{"Credits", formatAmount(credits, inv.Currency)},
+		{"Tax", formatAmount(0, inv.Currency)},
+		{"Total", formatMoney(inv.AmountCents, inv.Currency)},
+	}
+	for i, row := range totals {
+		bold := i == len(totals)-1
+		page.Text(pdfColQty, y, 10, bold, row[0])
+		page.TextRight(pdfMarginRight, y, 10, bold, row[1])
+		y -= pdfLineHeight
+	}
+	y -= pdfLineHeight
+
+	var status string
+	switch inv.Status {
+	case InvoicePaid:
+		status = "Paid on " + formatDate(inv.PaidAt.Time) + ". Thank you."
+	case InvoiceVoid:
+		status = "This invoice has been voided."
+	default:
+		status = fmt.Sprintf("Amount due: %s by %s.", formatMoney(inv.AmountCents, inv.Currency), formatDate(inv.DueAt.Time))
+	}
+	page.Text(pdfMarginLeft, y, 11, true, status)
+
+	return doc.Bytes()
+}
+
+// formatAmount formats an amount in minor units, e.g. 2999 USD as "29.99".
+func formatAmount(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	exp := payment.CurrencyExponent(currency)
+	if exp == 0 {
+		return sign + groupThousands(amount)
+	}
+
+	unit := int64(1)
+	for range exp {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%s.%0*d", sign, groupThousands(amount/unit), exp, amount%unit)
+}
+
+func formatMoney(amount int64, currency string) string {
+	return strings.ToUpper(currency) + " " + formatAmount(amount, currency)
+}
+
+func groupThousands(n int64) string {
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.DateOnly)
+}
+
+// truncate shortens s to fit width points at the given font size.
+func truncate(s string, width, size float64) string {
+	if pdf.TextWidth(s, size) <= width {
+		return s
+	}
+	for len(s) > 0 && pdf.TextWidth(s+"...", size) > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{2999, "USD", "29.99"},
+		{5, "usd", "0.05"},
+		{0, "EUR", "0.00"},
+		{123456789, "USD", "1,234,567.89"},
+		{-1050, "USD", "-10.50"},
+		{150000, "IDR", "150,000"},
+		{999, "JPY", "999"},
+		{-1500000, "IDR", "-1,500,000"},
+	}
+
+	for _, tt := range tests {
+		if got := formatAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatAmount(%d, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width float64
+		want  string
+	}{
+		{name: "fits", s: "Pro plan", width: 200, want: "Pro plan"},
+		{name: "too wide", s: "Pro plan (2026-10-01 to 2026-11-01)", width: 60, want: "Pro plan (2..."},
+		{name: "nothing fits", s: "Pro", width: 1, want: "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncate(tt.s, tt.width, 10); got != tt.want {
+				t.Fatalf("truncate(%q, %g) = %q, want %q", tt.s, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderInvoicePDF(t *testing.T) {
+	issued := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	doc := renderInvoicePDF(invoiceDocument{
+		invoice: generated.Invoice{
+			ID:            uuid.New(),
+			InvoiceNumber: pgtype.Text{String: "INV-2026-000042", Valid: true},
+			Status:        InvoiceIssued,
+			AmountCents:   2999,
+			Currency:      "USD",
+			IssuedAt:      timestamptz(issued),
+			DueAt:         timestamptz(issued.AddDate(0, 0, 7)),
+		},
+		items: []generated.InvoiceLineItem{
+			{Kind: LineItemSubscription, Description: "Pro (2026-10-01 to 2026-11-01)", Quantity: 1, UnitAmountCents: 2999, AmountCents: 2999, Currency: "USD"},
+		},
+		customer: generated.Customer{Email: pgtype.Text{String: "payer@example.com", Valid: true}},
+		name:     "Ada",
+	})
+
+	if !bytes.HasPrefix(doc, []byte("%PDF-")) || !bytes.HasSuffix(doc, []byte("%%EOF\n")) {
+		t.Fatal("output is not a PDF document")
+	}
+	for _, want := range []string{"INV-2026-000042", "payer@example.com", "USD 29.99", "2026-10-08"} {
+		if !bytes.Contains(doc, []byte(want)) {
+			t.Errorf("document does not contain %q", want)
+		}
+	}
+}
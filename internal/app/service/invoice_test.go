@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+func wantHTTPStatus(t *testing.T, err error, status int) {
+	t.Helper()
+
+	var appErr *E.AppError
+	if !errors.As(err, &appErr) || appErr.HTTPStatus() != status {
+		t.Fatalf("error = %v, want status %d", err, status)
+	}
+}
+
+func TestGetInvoice(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		otherOwner bool
+		wantStatus int
+	}{
+		{name: "issued", status: InvoiceIssued},
+		{name: "paid", status: InvoicePaid},
+		{name: "draft", status: InvoiceDraft, wantStatus: http.StatusNotFound},
+		{name: "another customer's", status: InvoiceIssued, otherOwner: true, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			customer, invoice := f.addIssuedInvoice(2000)
+			f.store.invoices[0].Status = tt.status
+			f.store.lineItems = append(f.store.lineItems, &generated.InvoiceLineItem{
+				ID:          uuid.New(),
+				InvoiceID:   pgtype.UUID{Bytes: invoice.ID, Valid: true},
+				Kind:        LineItemSubscription,
+				Quantity:    1,
+				AmountCents: 2000,
+			})
+
+			customerID := customer.ID
+			if tt.otherOwner {
+				customerID = uuid.New()
+			}
+			resp, err := f.invoices.Get(context.Background(), customerID, invoice.ID)
+			if tt.wantStatus != 0 {
+				wantHTTPStatus(t, err, tt.wantStatus)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.ID != invoice.ID || resp.Status != tt.status || len(resp.LineItems) != 1 || resp.Transactions == nil {
+				t.Fatalf("got invoice %s (%s) with %d lines and transactions %v", resp.ID, resp.Status, len(resp.LineItems), resp.Transactions)
+			}
+		})
+	}
+}
+
+func TestListInvoicesRejectsFilters(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		params InvoiceListParams
+	}{
+		{name: "unknown status", params: InvoiceListParams{Status: "settled"}},
+		{name: "drafts", params: InvoiceListParams{Status: InvoiceDraft}},
+		{name: "empty range", params: InvoiceListParams{From: now, To: now}},
+		{name: "reversed range", params: InvoiceListParams{From: now, To: now.Add(-time.Hour)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			_, err := f.invoices.List(context.Background(), uuid.New(), tt.params)
+			wantHTTPStatus(t, err, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestPayRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		otherOwner bool
+		opts       PayOptions
+		wantStatus int
+	}{
+		{name: "no way to pay", status: InvoiceIssued, wantStatus: http.StatusBadRequest},
+		{name: "another customer's", status: InvoiceIssued, otherOwner: true, opts: PayOptions{UsePaymentMethod: true}, wantStatus: http.StatusNotFound},
+		{name: "draft", status: InvoiceDraft, opts: PayOptions{UsePaymentMethod: true}, wantStatus: http.StatusNotFound},
+		{name: "already paid", status: InvoicePaid, opts: PayOptions{UsePaymentMethod: true}, wantStatus: http.StatusBadRequest},
+		{name: "void", status: InvoiceVoid, opts: PayOptions{UsePaymentMethod: true}, wantStatus: http.StatusConflict},
+		{name: "credit does not cover it", status: InvoiceIssued, opts: PayOptions{UseCreditBalance: true}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			customer, invoice := f.addIssuedInvoice(2000)
+			f.store.invoices[0].Status = tt.status
+			f.store.customers[0].CreditBalanceCents.Int64 = 500
+
+			customerID := customer.ID
+			if tt.otherOwner {
+				customerID = uuid.New()
+			}
+			_, err := f.invoices.Pay(context.Background(), customerID, invoice.ID, tt.opts)
+			wantHTTPStatus(t, err, tt.wantStatus)
+
+			if got := f.invoice(t, invoice.ID).Status; got != tt.status {
+				t.Fatalf("invoice status = %s, want %s", got, tt.status)
+			}
+			if n := len(f.transactions(t, invoice.ID)); n != 0 {
+				t.Fatalf("got %d transactions, want none", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,777 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// memStore keeps the rows the payment flows touch in memory. Each repository
+// embeds its interface, so a flow calling a method the store does not
+// implement panics instead of passing silently.
+type memStore struct {
+	users         []*generated.User
+	plans         []*generated.Plan
+	customers     []*generated.Customer
+	subscriptions []*generated.Subscription
+	invoices      []*generated.Invoice
+	lineItems     []*generated.InvoiceLineItem
+	transactions  []*generated.Transaction
+	webhooks      []*generated.WebhookEvent
+	periods       []*generated.SubscriptionPeriod
+	usageEvents   []*generated.UsageEvent
+	aggregates    []*generated.UsageAggregate
+	numbers       map[string]int64
+}
+
+func newMemStore() *memStore {
+	return &memStore{numbers: make(map[string]int64)}
+}
+
+func (m *memStore) repos() repository.Repositories {
+	return repository.Repositories{
+		Users:         memUsers{m: m},
+		Plans:         memPlans{m: m},
+		Customers:     memCustomers{m: m},
+		Subscriptions: memSubscriptions{m: m},
+		Invoices:      memInvoices{m: m},
+		Transactions:  memTransactions{m: m},
+		Webhooks:      memWebhooks{m: m},
+		Usage:         memUsage{m: m},
+	}
+}
+
+// memUnitOfWork runs units of work directly against the store. Nothing is
+// rolled back, so flows must only be checked after units that succeed.
+type memUnitOfWork struct {
+	repos repository.Repositories
+}
+
+func (u memUnitOfWork) Do(_ context.Context, fn func(repos repository.Repositories) error) error {
+	return fn(u.repos)
+}
+
+func (u memUnitOfWork) DoSerializable(_ context.Context, fn func(repos repository.Repositories) error) error {
+	return fn(u.repos)
+}
+
+func newID(id uuid.UUID) uuid.UUID {
+	if id == uuid.Nil {
+		return uuid.New()
+	}
+	return id
+}
+
+type memUsers struct {
+	repository.UserRepository
+	m *memStore
+}
+
+func (r memUsers) Create(_ context.Context, name, email, passwordHash string) (generated.User, error) {
+	u := &generated.User{
+		ID:           uuid.New(),
+		Name:         name,
+		Email:        email,
+		PasswordHash: passwordHash,
+	}
+	r.m.users = append(r.m.users, u)
+	return *u, nil
+}
+
+func (r memUsers) FindByID(_ context.Context, id uuid.UUID) (generated.User, error) {
+	for _, u := range r.m.users {
+		if u.ID == id {
+			return *u, nil
+		}
+	}
+	return generated.User{}, E.ErrNotFound
+}
+
+type memPlans struct {
+	repository.PlanRepository
+	m *memStore
+}
+
+func (r memPlans) FindByID(_ context.Context, id uuid.UUID) (generated.Plan, error) {
+	for _, p := range r.m.plans {
+		if p.ID == id {
+			return *p, nil
+		}
+	}
+	return generated.Plan{}, E.ErrNotFound
+}
+
+func (r memPlans) FindBySlug(_ context.Context, slug string) (generated.Plan, error) {
+	for _, p := range r.m.plans {
+		if p.Slug == slug {
+			return *p, nil
+		}
+	}
+	return generated.Plan{}, E.ErrNotFound
+}
+
+type memCustomers struct {
+	repository.CustomerRepository
+	m *memStore
+}
+
+func (r memCustomers) Create(_ context.Context, userID uuid.UUID, email string) (generated.Customer, error) {
+	c := &generated.Customer{
+		ID:                 uuid.New(),
+		UserID:             pgtype.UUID{Bytes: userID, Valid: userID != uuid.Nil},
+		Email:              pgtype.Text{String: email, Valid: true},
+		CreditBalanceCents: pgtype.Int8{Valid: true},
+		CreatedAt:          timestamptz(time.Now()),
+	}
+	r.m.customers = append(r.m.customers, c)
+	return *c, nil
+}
+
+func (r memCustomers) find(id uuid.UUID) (*generated.Customer, error) {
+	for _, c := range r.m.customers {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, E.ErrNotFound
+}
+
+func (r memCustomers) FindByID(_ context.Context, id uuid.UUID) (generated.Customer, error) {
+	c, err := r.find(id)
+	if err != nil {
+		return generated.Customer{}, err
+	}
+	return *c, nil
+}
+
+func (r memCustomers) FindByUserID(_ context.Context, userID uuid.UUID) (generated.Customer, error) {
+	for _, c := range r.m.customers {
+		if c.UserID.Valid && c.UserID.Bytes == userID {
+			return *c, nil
+		}
+	}
+	return generated.Customer{}, E.ErrNotFound
+}
+
+func (r memCustomers) FindByEmail(_ context.Context, email string) (generated.Customer, error) {
+	for _, c := range r.m.customers {
+		if c.Email.String == email {
+			return *c, nil
+		}
+	}
+	return generated.Customer{}, E.ErrNotFound
+}
+
+func (r memCustomers) UpdatePaymentMethod(_ context.Context, id uuid.UUID, method []byte) (generated.Customer, error) {
+	c, err := r.find(id)
+	if err != nil {
+		return generated.Customer{}, err
+	}
+	c.DefaultPaymentMethod = method
+	return *c, nil
+}
+
+func (r memCustomers) ConsumeCredit(_ context.Context, id uuid.UUID, maxAmount int64) (int64, error) {
+	c, err := r.find(id)
+	if err != nil {
+		return 0, err
+	}
+	amount := min(c.CreditBalanceCents.Int64, maxAmount)
+	c.CreditBalanceCents.Int64 -= amount
+	return amount, nil
+}
+
+func (r memCustomers) AddCredit(_ context.Context, id uuid.UUID, amount int64) (generated.Customer, error) {
+	c, err := r.find(id)
+	if err != nil {
+		return generated.Customer{}, err
+	}
+	c.CreditBalanceCents.Int64 += amount
+	return *c, nil
+}
+
+type memSubscriptions struct {
+	repository.SubscriptionRepository
+	m *memStore
+}
+
+func (r memSubscriptions) Create(_ context.Context, arg generated.CreateSubscriptionParams) (generated.Subscription, error) {
+	s := &generated.Subscription{
+		ID:                 newID(arg.ID),
+		CustomerID:         arg.CustomerID,
+		PlanID:             arg.PlanID,
+		Status:             arg.Status,
+		TrialEndsAt:        arg.TrialEndsAt,
+		CurrentPeriodStart: arg.CurrentPeriodStart,
+		CurrentPeriodEnd:   arg.CurrentPeriodEnd,
+		CancelAtPeriodEnd:  pgtype.Bool{Valid: true},
+		Metadata:           []byte("{}"),
+		CreatedAt:          timestamptz(time.Now()),
+	}
+	r.m.subscriptions = append(r.m.subscriptions, s)
+	return *s, nil
+}
+
+func (r memSubscriptions) find(id uuid.UUID) (*generated.Subscription, error) {
+	for _, s := range r.m.subscriptions {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, E.ErrNotFound
+}
+
+func (r memSubscriptions) FindByID(_ context.Context, id uuid.UUID) (generated.Subscription, error) {
+	s, err := r.find(id)
+	if err != nil {
+		return generated.Subscription{}, err
+	}
+	return *s, nil
+}
+
+func (r memSubscriptions) FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Subscription, error) {
+	return r.FindByID(ctx, id)
+}
+
+// FindCurrentByCustomer prefers subscriptions that are not pending, then the
+// newest, like the query it stands in for.
+func (r memSubscriptions) FindCurrentByCustomer(_ context.Context, customerID uuid.UUID) (generated.Subscription, error) {
+	var current *generated.Subscription
+	for _, s := range r.m.subscriptions {
+		if s.CustomerID.Bytes != customerID || s.Status == SubscriptionCanceled {
+			continue
+		}
+		if current == nil || current.Status == SubscriptionPending || s.Status != SubscriptionPending {
+			current = s
+		}
+	}
+	if current == nil {
+		return generated.Subscription{}, E.ErrNotFound
+	}
+	return *current, nil
+}
+
+func (r memSubscriptions) ClaimDue(_ context.Context, now time.Time, skip []uuid.UUID) (generated.Subscription, error) {
+	var due *generated.Subscription
+	for _, s := range r.m.subscriptions {
+		if s.Status != SubscriptionActive && s.Status != SubscriptionTrialing {
+			continue
+		}
+		if s.CurrentPeriodEnd.Time.After(now) || slices.Contains(skip, s.ID) {
+			continue
+		}
+		if due == nil || s.CurrentPeriodEnd.Time.Before(due.CurrentPeriodEnd.Time) {
+			due = s
+		}
+	}
+	if due == nil {
+		return generated.Subscription{}, E.ErrNotFound
+	}
+	return *due, nil
+}
+
+func (r memSubscriptions) UpdateState(_ context.Context, expectedStatus string, sub generated.Subscription) (generated.Subscription, error) {
+	s, err := r.find(sub.ID)
+	if err != nil || s.Status != expectedStatus {
+		return generated.Subscription{}, E.ErrNotFound
+	}
+	s.Status = sub.Status
+	s.CurrentPeriodStart = sub.CurrentPeriodStart
+	s.CurrentPeriodEnd = sub.CurrentPeriodEnd
+	s.CancelAtPeriodEnd = sub.CancelAtPeriodEnd
+	s.CanceledAt = sub.CanceledAt
+	return *s, nil
+}
+
+func (r memSubscriptions) UpdatePlan(_ context.Context, expectedStatus string, sub generated.Subscription) (generated.Subscription, error) {
+	s, err := r.find(sub.ID)
+	if err != nil || s.Status != expectedStatus {
+		return generated.Subscription{}, E.ErrNotFound
+	}
+	s.PlanID = sub.PlanID
+	s.CurrentPeriodStart = sub.CurrentPeriodStart
+	s.CurrentPeriodEnd = sub.CurrentPeriodEnd
+	return *s, nil
+}
+
+func (r memSubscriptions) ListPeriodsSince(_ context.Context, customerID uuid.UUID, since time.Time) ([]generated.SubscriptionPeriod, error) {
+	var periods []generated.SubscriptionPeriod
+	for _, p := range r.m.periods {
+		if p.CustomerID.Bytes == customerID && p.PeriodEnd.Time.After(since) {
+			periods = append(periods, *p)
+		}
+	}
+	slices.SortFunc(periods, func(x, y generated.SubscriptionPeriod) int {
+		return x.PeriodStart.Time.Compare(y.PeriodStart.Time)
+	})
+	return periods, nil
+}
+
+func (r memSubscriptions) CancelPending(_ context.Context, customerID, keepID uuid.UUID, now time.Time) error {
+	for _, s := range r.m.subscriptions {
+		if s.CustomerID.Bytes == customerID && s.ID != keepID && s.Status == SubscriptionPending {
+			s.Status = SubscriptionCanceled
+			s.CanceledAt = timestamptz(now)
+		}
+	}
+	return nil
+}
+
+func (r memSubscriptions) SetGatewayID(_ context.Context, id uuid.UUID, gatewaySubscriptionID string) error {
+	s, err := r.find(id)
+	if err != nil {
+		return err
+	}
+	s.GatewaySubscriptionID = pgtype.Text{String: gatewaySubscriptionID, Valid: true}
+	return nil
+}
+
+func (r memSubscriptions) MergeMetadata(_ context.Context, id uuid.UUID, patch []byte) error {
+	s, err := r.find(id)
+	if err != nil {
+		return err
+	}
+	meta := map[string]any{}
+	if err := json.Unmarshal(s.Metadata, &meta); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patch, &meta); err != nil {
+		return err
+	}
+	s.Metadata, err = json.Marshal(meta)
+	return err
+}
+
+type memInvoices struct {
+	repository.InvoiceRepository
+	m *memStore
+}
+
+func (r memInvoices) Create(_ context.Context, arg generated.CreateInvoiceParams) (generated.Invoice, error) {
+	inv := &generated.Invoice{
+		ID:             newID(arg.ID),
+		CustomerID:     arg.CustomerID,
+		SubscriptionID: arg.SubscriptionID,
+		Status:         arg.Status,
+		AmountCents:    arg.AmountCents,
+		Currency:       arg.Currency,
+		IssuedAt:       arg.IssuedAt,
+		DueAt:          arg.DueAt,
+		Metadata:       arg.Metadata,
+		CreatedAt:      timestamptz(time.Now()),
+	}
+	r.m.invoices = append(r.m.invoices, inv)
+	return *inv, nil
+}
+
+func (r memInvoices) CreateLineItem(_ context.Context, arg generated.CreateInvoiceLineItemParams) (generated.InvoiceLineItem, error) {
+	item := &generated.InvoiceLineItem{
+		ID:              newID(arg.ID),
+		InvoiceID:       arg.InvoiceID,
+		CustomerID:      arg.CustomerID,
+		SubscriptionID:  arg.SubscriptionID,
+		Kind:            arg.Kind,
+		Description:     arg.Description,
+		Quantity:        arg.Quantity,
+		UnitAmountCents: arg.UnitAmountCents,
+		AmountCents:     arg.AmountCents,
+		Currency:        arg.Currency,
+		PeriodStart:     arg.PeriodStart,
+		PeriodEnd:       arg.PeriodEnd,
+		Metadata:        arg.Metadata,
+		CreatedAt:       timestamptz(time.Now()),
+	}
+	r.m.lineItems = append(r.m.lineItems, item)
+	return *item, nil
+}
+
+func (r memInvoices) ListPendingLineItems(_ context.Context, customerID uuid.UUID) ([]generated.InvoiceLineItem, error) {
+	var items []generated.InvoiceLineItem
+	for _, item := range r.m.lineItems {
+		if item.CustomerID == customerID && !item.InvoiceID.Valid {
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+func (r memInvoices) AttachLineItems(_ context.Context, invoiceID uuid.UUID, ids []uuid.UUID) error {
+	for _, item := range r.m.lineItems {
+		for _, id := range ids {
+			if item.ID == id {
+				item.InvoiceID = pgtype.UUID{Bytes: invoiceID, Valid: true}
+			}
+		}
+	}
+	return nil
+}
+
+func (r memInvoices) find(id uuid.UUID) (*generated.Invoice, error) {
+	for _, inv := range r.m.invoices {
+		if inv.ID == id {
+			return inv, nil
+		}
+	}
+	return nil, E.ErrNotFound
+}
+
+func (r memInvoices) FindByID(_ context.Context, id uuid.UUID) (generated.Invoice, error) {
+	inv, err := r.find(id)
+	if err != nil {
+		return generated.Invoice{}, err
+	}
+	return *inv, nil
+}
+
+func (r memInvoices) FindForUpdate(ctx context.Context, id uuid.UUID) (generated.Invoice, error) {
+	return r.FindByID(ctx, id)
+}
+
+func (r memInvoices) FindByGatewayIDForUpdate(_ context.Context, gatewayInvoiceID string) (generated.Invoice, error) {
+	for _, inv := range r.m.invoices {
+		if inv.GatewayInvoiceID.Valid && inv.GatewayInvoiceID.String == gatewayInvoiceID {
+			return *inv, nil
+		}
+	}
+	return generated.Invoice{}, E.ErrNotFound
+}
+
+func (r memInvoices) ListLineItems(_ context.Context, invoiceID uuid.UUID) ([]generated.InvoiceLineItem, error) {
+	var items []generated.InvoiceLineItem
+	for _, item := range r.m.lineItems {
+		if item.InvoiceID.Valid && item.InvoiceID.Bytes == invoiceID {
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+func (r memInvoices) CountBilledLineItems(_ context.Context, subscriptionID uuid.UUID, kind string, periodStart time.Time) (int64, error) {
+	var n int64
+	for _, item := range r.m.lineItems {
+		if item.SubscriptionID.Bytes == subscriptionID && item.Kind == kind && item.PeriodStart.Time.Equal(periodStart) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memInvoices) Finalize(_ context.Context, id uuid.UUID, number string, amountCents int64, issuedAt, dueAt time.Time) (generated.Invoice, error) {
+	inv, err := r.find(id)
+	if err != nil || inv.Status != InvoiceDraft {
+		return generated.Invoice{}, E.ErrNotFound
+	}
+	inv.Status = InvoiceIssued
+	inv.InvoiceNumber = pgtype.Text{String: number, Valid: true}
+	inv.AmountCents = amountCents
+	inv.IssuedAt = timestamptz(issuedAt)
+	inv.DueAt = timestamptz(dueAt)
+	return *inv, nil
+}
+
+func (r memInvoices) NextNumber(_ context.Context, prefix string, year int) (int64, error) {
+	key := fmt.Sprintf("%s-%d", prefix, year)
+	r.m.numbers[key]++
+	return r.m.numbers[key], nil
+}
+
+func (r memInvoices) MarkPaid(_ context.Context, id uuid.UUID, paidAt time.Time) (generated.Invoice, error) {
+	inv, err := r.find(id)
+	if err != nil {
+		return generated.Invoice{}, err
+	}
+	inv.Status = InvoicePaid
+	inv.PaidAt = timestamptz(paidAt)
+	return *inv, nil
+}
+
+func (r memInvoices) MarkRefunded(_ context.Context, id uuid.UUID, status string) (generated.Invoice, error) {
+	inv, err := r.find(id)
+	if err != nil {
+		return generated.Invoice{}, err
+	}
+	inv.Status = status
+	return *inv, nil
+}
+
+func (r memInvoices) MarkFailed(_ context.Context, id uuid.UUID) (generated.Invoice, error) {
+	inv, err := r.find(id)
+	if err != nil {
+		return generated.Invoice{}, err
+	}
+	inv.Status = InvoiceFailed
+	return *inv, nil
+}
+
+type memTransactions struct {
+	repository.TransactionRepository
+	m *memStore
+}
+
+func (r memTransactions) Create(_ context.Context, arg generated.CreateTransactionParams) (generated.Transaction, error) {
+	txn := &generated.Transaction{
+		ID:                    newID(arg.ID),
+		InvoiceID:             arg.InvoiceID,
+		CustomerID:            arg.CustomerID,
+		Gateway:               arg.Gateway,
+		GatewayPaymentID:      arg.GatewayPaymentID,
+		AmountCents:           arg.AmountCents,
+		Currency:              arg.Currency,
+		Status:                arg.Status,
+		IdempotencyKey:        arg.IdempotencyKey,
+		RawResponse:           arg.RawResponse,
+		CreatedAt:             timestamptz(time.Now()),
+		RefundedTransactionID: arg.RefundedTransactionID,
+	}
+	r.m.transactions = append(r.m.transactions, txn)
+	return *txn, nil
+}
+
+func (r memTransactions) ListByInvoice(_ context.Context, invoiceID uuid.UUID) ([]generated.Transaction, error) {
+	var txns []generated.Transaction
+	for _, txn := range r.m.transactions {
+		if txn.InvoiceID.Valid && txn.InvoiceID.Bytes == invoiceID {
+			txns = append(txns, *txn)
+		}
+	}
+	return txns, nil
+}
+
+func (r memTransactions) FindByGatewayPaymentForUpdate(_ context.Context, gateway, paymentID string) (generated.Transaction, error) {
+	for _, txn := range r.m.transactions {
+		if txn.Gateway == gateway && txn.GatewayPaymentID.String == paymentID {
+			return *txn, nil
+		}
+	}
+	return generated.Transaction{}, E.ErrNotFound
+}
+
+func (r memTransactions) FindForUpdate(_ context.Context, id uuid.UUID) (generated.Transaction, error) {
+	for _, txn := range r.m.transactions {
+		if txn.ID == id {
+			return *txn, nil
+		}
+	}
+	return generated.Transaction{}, E.ErrNotFound
+}
+
+func (r memTransactions) ListRefunds(_ context.Context, id uuid.UUID) ([]generated.Transaction, error) {
+	var refunds []generated.Transaction
+	for _, txn := range r.m.transactions {
+		if txn.RefundedTransactionID.Valid && txn.RefundedTransactionID.Bytes == id {
+			refunds = append(refunds, *txn)
+		}
+	}
+	return refunds, nil
+}
+
+func (r memTransactions) UpdateStatus(_ context.Context, id uuid.UUID, status, paymentID string, raw []byte) (generated.Transaction, error) {
+	for _, txn := range r.m.transactions {
+		if txn.ID == id {
+			txn.Status = status
+			if paymentID != "" {
+				txn.GatewayPaymentID = pgtype.Text{String: paymentID, Valid: true}
+			}
+			if raw != nil {
+				txn.RawResponse = raw
+			}
+			return *txn, nil
+		}
+	}
+	return generated.Transaction{}, E.ErrNotFound
+}
+
+type memWebhooks struct {
+	repository.WebhookEventRepository
+	m *memStore
+}
+
+// Record returns the stored event when the gateway redelivers it.
+func (r memWebhooks) Record(_ context.Context, gateway, eventID, eventType string, payload []byte) (generated.WebhookEvent, error) {
+	for _, e := range r.m.webhooks {
+		if e.Gateway == gateway && e.EventID == eventID {
+			return *e, nil
+		}
+	}
+	e := &generated.WebhookEvent{
+		ID:         uuid.New(),
+		Gateway:    gateway,
+		EventID:    eventID,
+		EventType:  eventType,
+		Payload:    payload,
+		Status:     WebhookReceived,
+		ReceivedAt: timestamptz(time.Now()),
+	}
+	r.m.webhooks = append(r.m.webhooks, e)
+	return *e, nil
+}
+
+func (r memWebhooks) FindForUpdate(_ context.Context, id uuid.UUID) (generated.WebhookEvent, error) {
+	for _, e := range r.m.webhooks {
+		if e.ID == id {
+			return *e, nil
+		}
+	}
+	return generated.WebhookEvent{}, E.ErrNotFound
+}
+
+func (r memWebhooks) SetStatus(_ context.Context, id uuid.UUID, status, errMsg string, processedAt time.Time) error {
+	for _, e := range r.m.webhooks {
+		if e.ID == id {
+			e.Status = status
+			e.Error = pgtype.Text{String: errMsg, Valid: errMsg != ""}
+			e.ProcessedAt = pgtype.Timestamptz{Time: processedAt, Valid: !processedAt.IsZero()}
+			return nil
+		}
+	}
+	return E.ErrNotFound
+}
+
+type memUsage struct {
+	repository.UsageRepository
+	m *memStore
+}
+
+func (r memUsage) ListAggregatesForPeriod(_ context.Context, customerID uuid.UUID, from, to time.Time) ([]generated.UsageAggregate, error) {
+	from, to = from.Truncate(24*time.Hour), to.Truncate(24*time.Hour)
+
+	var aggregates []generated.UsageAggregate
+	for _, agg := range r.m.aggregates {
+		start := agg.PeriodStart.Time
+		if agg.CustomerID.Bytes == customerID && !start.Before(from) && start.Before(to) {
+			aggregates = append(aggregates, *agg)
+		}
+	}
+	return aggregates, nil
+}
+
+// findEvent returns the event the customer reported with eventID.
+func (r memUsage) findEvent(customerID pgtype.UUID, eventID string) *generated.UsageEvent {
+	for _, e := range r.m.usageEvents {
+		if e.CustomerID == customerID && e.EventID.String == eventID {
+			return e
+		}
+	}
+	return nil
+}
+
+func (r memUsage) RecordEvent(_ context.Context, arg generated.CreateUsageEventParams) (generated.UsageEvent, bool, error) {
+	if e := r.findEvent(arg.CustomerID, arg.EventID.String); e != nil {
+		return *e, false, nil
+	}
+	event := &generated.UsageEvent{
+		ID:         newID(arg.ID),
+		CustomerID: arg.CustomerID,
+		ApiKeyID:   arg.ApiKeyID,
+		Metric:     arg.Metric,
+		Quantity:   arg.Quantity,
+		ReportedAt: timestamptz(time.Now()),
+		Metadata:   arg.Metadata,
+		EventID:    arg.EventID,
+		OccurredAt: arg.OccurredAt,
+	}
+	r.m.usageEvents = append(r.m.usageEvents, event)
+	return *event, true, nil
+}
+
+func (r memUsage) RecordEvents(ctx context.Context, arg generated.CreateUsageEventsParams) ([]generated.UsageEvent, error) {
+	var events []generated.UsageEvent
+	for i, eventID := range arg.EventIds {
+		var metadata []byte
+		if arg.Metadata[i] != "" {
+			metadata = []byte(arg.Metadata[i])
+		}
+		event, created, err := r.RecordEvent(ctx, generated.CreateUsageEventParams{
+			CustomerID: arg.CustomerID,
+			ApiKeyID:   arg.ApiKeyID,
+			Metric:     arg.Metrics[i],
+			Quantity:   arg.Quantities[i],
+			Metadata:   metadata,
+			EventID:    pgtype.Text{String: eventID, Valid: true},
+			OccurredAt: arg.OccurredAt[i],
+		})
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r memUsage) FindEvents(_ context.Context, customerID uuid.UUID, eventIDs []string) ([]generated.UsageEvent, error) {
+	var events []generated.UsageEvent
+	for _, eventID := range eventIDs {
+		if e := r.findEvent(pgtype.UUID{Bytes: customerID, Valid: true}, eventID); e != nil {
+			events = append(events, *e)
+		}
+	}
+	return events, nil
+}
+
+func (r memUsage) ClaimUnprocessed(_ context.Context, limit int) ([]generated.UsageEvent, error) {
+	var events []generated.UsageEvent
+	for _, e := range r.m.usageEvents {
+		if !e.Processed.Bool && len(events) < limit {
+			events = append(events, *e)
+		}
+	}
+	return events, nil
+}
+
+func (r memUsage) MarkProcessed(_ context.Context, ids []uuid.UUID, periodStart, periodEnd time.Time) error {
+	for _, e := range r.m.usageEvents {
+		if slices.Contains(ids, e.ID) {
+			e.Processed = pgtype.Bool{Bool: true, Valid: true}
+			e.PeriodStart = pgtype.Date{Time: periodStart, Valid: !periodStart.IsZero()}
+			e.PeriodEnd = pgtype.Date{Time: periodEnd, Valid: !periodEnd.IsZero()}
+		}
+	}
+	return nil
+}
+
+func (r memUsage) AddAggregate(_ context.Context, arg generated.AddUsageAggregateParams) error {
+	for _, agg := range r.m.aggregates {
+		if agg.CustomerID == arg.CustomerID && agg.PeriodStart.Time.Equal(arg.PeriodStart.Time) && agg.Metric == arg.Metric {
+			agg.TotalQuantity.Int64 += arg.TotalQuantity.Int64
+			agg.TotalCostCents.Int64 += arg.TotalCostCents.Int64
+			return nil
+		}
+	}
+	r.m.aggregates = append(r.m.aggregates, &generated.UsageAggregate{
+		ID:             newID(arg.ID),
+		CustomerID:     arg.CustomerID,
+		PeriodStart:    arg.PeriodStart,
+		PeriodEnd:      arg.PeriodEnd,
+		Metric:         arg.Metric,
+		TotalQuantity:  arg.TotalQuantity,
+		TotalCostCents: arg.TotalCostCents,
+	})
+	return nil
+}
+
+func (r memUsage) SumUnprocessed(_ context.Context, customerID uuid.UUID) ([]generated.SumUnprocessedUsageEventsRow, error) {
+	var rows []generated.SumUnprocessedUsageEventsRow
+	for _, e := range r.m.usageEvents {
+		if e.Processed.Bool || e.CustomerID.Bytes != customerID {
+			continue
+		}
+		i := slices.IndexFunc(rows, func(row generated.SumUnprocessedUsageEventsRow) bool { return row.Metric == e.Metric })
+		if i < 0 {
+			rows = append(rows, generated.SumUnprocessedUsageEventsRow{Metric: e.Metric})
+			i = len(rows) - 1
+		}
+		rows[i].TotalQuantity += e.Quantity
+	}
+	return rows, nil
+}
@@ -0,0 +1,485 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// paymentFlow wires the checkout, invoice and webhook services to the fake
+// gateway, which also delivers their webhooks.
+type paymentFlow struct {
+	store    *memStore
+	gateway  *payment.FakeGateway
+	checkout CheckoutService
+	invoices InvoiceService
+	webhooks WebhookService
+}
+
+func newPaymentFlow() *paymentFlow {
+	store := newMemStore()
+	gateway := payment.NewFakeGateway("whsec_test", 10*time.Millisecond)
+	repos := store.repos()
+	uow := memUnitOfWork{repos: repos}
+	builder := NewInvoiceBuilder(7*24*time.Hour, InvoiceNumbering{DefaultPrefix: "INV"})
+	verifiers := map[string]payment.WebhookVerifier{payment.GatewayFake: gateway}
+
+	return &paymentFlow{
+		store:    store,
+		gateway:  gateway,
+		checkout: NewCheckoutService(repos, uow, builder, gateway),
+		invoices: NewInvoiceService(repos, uow, builder, nil, gateway),
+		webhooks: NewWebhookService(repos, uow, builder, verifiers),
+	}
+}
+
+// deliver sends the webhook the fake gateway announces p with.
+func (f *paymentFlow) deliver(t *testing.T, p payment.Payment) {
+	t.Helper()
+
+	header, body, err := f.gateway.SignEvent(f.gateway.PaymentEvent(p))
+	if err != nil {
+		t.Fatalf("sign event: %v", err)
+	}
+	if err := f.webhooks.Handle(context.Background(), payment.GatewayFake, header, body); err != nil {
+		t.Fatalf("handle webhook: %v", err)
+	}
+}
+
+func (f *paymentFlow) addPlan(priceCents int64) generated.Plan {
+	plan := &generated.Plan{
+		ID:         uuid.New(),
+		Slug:       "pro",
+		Name:       "Pro",
+		PriceCents: priceCents,
+		Currency:   "USD",
+		Interval:   "month",
+	}
+	f.store.plans = append(f.store.plans, plan)
+	return *plan
+}
+
+// addIssuedInvoice issues an invoice to a new customer whose default payment
+// method can be charged.
+func (f *paymentFlow) addIssuedInvoice(amountCents int64) (generated.Customer, generated.Invoice) {
+	customer := &generated.Customer{
+		ID:                   uuid.New(),
+		Email:                pgtype.Text{String: "payer@example.com", Valid: true},
+		DefaultPaymentMethod: []byte(`{"payment_method_id":"pm_card"}`),
+		CreditBalanceCents:   pgtype.Int8{Valid: true},
+	}
+	f.store.customers = append(f.store.customers, customer)
+
+	invoice := &generated.Invoice{
+		ID:          uuid.New(),
+		CustomerID:  pgtype.UUID{Bytes: customer.ID, Valid: true},
+		Status:      InvoiceIssued,
+		AmountCents: amountCents,
+		Currency:    "USD",
+		IssuedAt:    timestamptz(time.Now()),
+	}
+	f.store.invoices = append(f.store.invoices, invoice)
+	return *customer, *invoice
+}
+
+func (f *paymentFlow) invoice(t *testing.T, id uuid.UUID) generated.Invoice {
+	t.Helper()
+
+	invoice, err := f.store.repos().Invoices.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("find invoice: %v", err)
+	}
+	return invoice
+}
+
+func (f *paymentFlow) transactions(t *testing.T, invoiceID uuid.UUID) []generated.Transaction {
+	t.Helper()
+
+	txns, err := f.store.repos().Transactions.ListByInvoice(context.Background(), invoiceID)
+	if err != nil {
+		t.Fatalf("list transactions: %v", err)
+	}
+	return txns
+}
+
+func TestCheckoutThroughFakeGateway(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFlow()
+	plan := f.addPlan(1500)
+
+	session, err := f.checkout.Create(ctx, CheckoutRequest{
+		PlanID:        plan.ID,
+		CustomerEmail: "buyer@example.com",
+		SuccessURL:    "https://example.com/success",
+		CancelURL:     "https://example.com/cancel",
+	})
+	if err != nil {
+		t.Fatalf("create checkout: %v", err)
+	}
+
+	status, err := f.checkout.Confirm(ctx, session.SessionID)
+	if err != nil {
+		t.Fatalf("confirm unpaid checkout: %v", err)
+	}
+	if status.PaymentStatus != payment.StatusPending || status.Subscription.Status != SubscriptionPending {
+		t.Fatalf("unpaid checkout = %s/%s, want pending/pending", status.PaymentStatus, status.Subscription.Status)
+	}
+
+	paid, err := f.gateway.CompleteCheckout(ctx, session.SessionID)
+	if err != nil {
+		t.Fatalf("complete checkout: %v", err)
+	}
+	f.deliver(t, paid)
+
+	sub, err := f.store.repos().Subscriptions.FindByID(ctx, session.SubscriptionID)
+	if err != nil {
+		t.Fatalf("find subscription: %v", err)
+	}
+	if sub.Status != SubscriptionActive {
+		t.Fatalf("subscription status = %s, want %s", sub.Status, SubscriptionActive)
+	}
+
+	// The success redirect arriving after the webhook changes nothing
+	status, err = f.checkout.Confirm(ctx, session.SessionID)
+	if err != nil {
+		t.Fatalf("confirm paid checkout: %v", err)
+	}
+	if status.PaymentStatus != payment.StatusSucceeded || status.Subscription.Status != SubscriptionActive {
+		t.Fatalf("paid checkout = %s/%s, want succeeded/active", status.PaymentStatus, status.Subscription.Status)
+	}
+
+	if len(f.store.invoices) != 1 {
+		t.Fatalf("got %d invoices, want 1", len(f.store.invoices))
+	}
+	invoice := *f.store.invoices[0]
+	if invoice.Status != InvoicePaid || invoice.AmountCents != plan.PriceCents {
+		t.Fatalf("invoice = %s %d, want %s %d", invoice.Status, invoice.AmountCents, InvoicePaid, plan.PriceCents)
+	}
+	if txns := f.transactions(t, invoice.ID); len(txns) != 1 || txns[0].GatewayPaymentID.String != paid.ID {
+		t.Fatalf("transactions = %+v, want one for payment %s", txns, paid.ID)
+	}
+}
+
+func (f *paymentFlow) createCheckout(t *testing.T, plan generated.Plan) CheckoutSessionResponse {
+	t.Helper()
+
+	session, err := f.checkout.Create(context.Background(), CheckoutRequest{
+		PlanID:        plan.ID,
+		CustomerEmail: "buyer@example.com",
+		SuccessURL:    "https://example.com/success",
+		CancelURL:     "https://example.com/cancel",
+	})
+	if err != nil {
+		t.Fatalf("create checkout: %v", err)
+	}
+	return session
+}
+
+func TestCheckoutCustomer(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFlow()
+	plan := f.addPlan(1500)
+
+	// The email was used anonymously before the user registered with it
+	anonymous := f.createCheckout(t, plan)
+	user := &generated.User{ID: uuid.New(), Email: "buyer@example.com"}
+	f.store.users = append(f.store.users, user)
+
+	signedIn, err := f.checkout.Create(ctx, CheckoutRequest{
+		PlanID:     plan.ID,
+		UserID:     user.ID,
+		SuccessURL: "https://example.com/success",
+		CancelURL:  "https://example.com/cancel",
+	})
+	if err != nil {
+		t.Fatalf("create signed-in checkout: %v", err)
+	}
+
+	repos := f.store.repos()
+	anonymousSub, _ := repos.Subscriptions.FindByID(ctx, anonymous.SubscriptionID)
+	signedInSub, _ := repos.Subscriptions.FindByID(ctx, signedIn.SubscriptionID)
+	if anonymousSub.CustomerID == signedInSub.CustomerID {
+		t.Fatal("signed-in checkout reused the anonymous customer of the same email")
+	}
+	customer, err := repos.Customers.FindByID(ctx, signedInSub.CustomerID.Bytes)
+	if err != nil {
+		t.Fatalf("find customer: %v", err)
+	}
+	if customer.UserID.Bytes != user.ID {
+		t.Fatalf("signed-in checkout customer belongs to %v, want %v", customer.UserID, user.ID)
+	}
+
+	// Once only the user's customer has the email, anonymous checkouts are refused
+	f.store.customers = []*generated.Customer{f.store.customers[1]}
+	if _, err := f.checkout.Create(ctx, CheckoutRequest{
+		PlanID:        plan.ID,
+		CustomerEmail: "buyer@example.com",
+		SuccessURL:    "https://example.com/success",
+		CancelURL:     "https://example.com/cancel",
+	}); err == nil || err.(*E.AppError).HTTPStatus() != http.StatusUnauthorized {
+		t.Fatalf("anonymous checkout for a registered email = %v, want 401", err)
+	}
+}
+
+func TestCheckoutPaymentThatCannotActivate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		pay        func(t *testing.T, f *paymentFlow, plan generated.Plan) (payment.Payment, uuid.UUID)
+		wantStatus string
+		wantCredit int64
+	}{
+		{
+			name: "superseded by another paid checkout",
+			pay: func(t *testing.T, f *paymentFlow, plan generated.Plan) (payment.Payment, uuid.UUID) {
+				first := f.createCheckout(t, plan)
+				second := f.createCheckout(t, plan)
+
+				paidFirst, err := f.gateway.CompleteCheckout(ctx, first.SessionID)
+				if err != nil {
+					t.Fatalf("complete first checkout: %v", err)
+				}
+				paidSecond, err := f.gateway.CompleteCheckout(ctx, second.SessionID)
+				if err != nil {
+					t.Fatalf("complete second checkout: %v", err)
+				}
+				f.deliver(t, paidFirst)
+				return paidSecond, second.SubscriptionID
+			},
+			wantStatus: SubscriptionCanceled,
+			wantCredit: 1500,
+		},
+		{
+			name: "underpaid",
+			pay: func(t *testing.T, f *paymentFlow, plan generated.Plan) (payment.Payment, uuid.UUID) {
+				session := f.createCheckout(t, plan)
+				paid, err := f.gateway.CompleteCheckout(ctx, session.SessionID)
+				if err != nil {
+					t.Fatalf("complete checkout: %v", err)
+				}
+				paid.AmountCents = 100
+				return paid, session.SubscriptionID
+			},
+			wantStatus: SubscriptionPending,
+			wantCredit: 100,
+		},
+		{
+			name: "paid in another currency",
+			pay: func(t *testing.T, f *paymentFlow, plan generated.Plan) (payment.Payment, uuid.UUID) {
+				session := f.createCheckout(t, plan)
+				paid, err := f.gateway.CompleteCheckout(ctx, session.SessionID)
+				if err != nil {
+					t.Fatalf("complete checkout: %v", err)
+				}
+				paid.Currency = "EUR"
+				return paid, session.SubscriptionID
+			},
+			wantStatus: SubscriptionPending,
+			wantCredit: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			plan := f.addPlan(1500)
+
+			paid, subID := tt.pay(t, f, plan)
+			f.deliver(t, paid)
+			// A redelivery or the success redirect must not credit it twice
+			f.deliver(t, paid)
+
+			sub, err := f.store.repos().Subscriptions.FindByID(ctx, subID)
+			if err != nil {
+				t.Fatalf("find subscription: %v", err)
+			}
+			if sub.Status != tt.wantStatus {
+				t.Fatalf("subscription status = %s, want %s", sub.Status, tt.wantStatus)
+			}
+
+			txn, err := f.store.repos().Transactions.FindByGatewayPaymentForUpdate(ctx, payment.GatewayFake, paid.ID)
+			if err != nil {
+				t.Fatalf("payment %s was not recorded: %v", paid.ID, err)
+			}
+			if txn.InvoiceID.Valid || txn.AmountCents != paid.AmountCents {
+				t.Fatalf("transaction = %+v, want %d without invoice", txn, paid.AmountCents)
+			}
+
+			customer, err := f.store.repos().Customers.FindByID(ctx, sub.CustomerID.Bytes)
+			if err != nil {
+				t.Fatalf("find customer: %v", err)
+			}
+			if got := customer.CreditBalanceCents.Int64; got != tt.wantCredit {
+				t.Fatalf("credit balance = %d, want %d", got, tt.wantCredit)
+			}
+
+			last := f.store.webhooks[len(f.store.webhooks)-1]
+			if last.Status != WebhookFlagged || last.Error.String == "" {
+				t.Fatalf("webhook event = %s %q, want flagged with a note", last.Status, last.Error.String)
+			}
+		})
+	}
+}
+
+func TestPayThroughFakeGateway(t *testing.T) {
+	ctx := context.Background()
+	opts := PayOptions{UsePaymentMethod: true}
+
+	t.Run("succeeded", func(t *testing.T) {
+		f := newPaymentFlow()
+		customer, invoice := f.addIssuedInvoice(2000)
+
+		resp, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, opts)
+		if err != nil {
+			t.Fatalf("pay: %v", err)
+		}
+		if resp.Status != InvoicePaid {
+			t.Fatalf("invoice status = %s, want %s", resp.Status, InvoicePaid)
+		}
+	})
+
+	t.Run("declined then retried", func(t *testing.T) {
+		f := newPaymentFlow()
+		customer, invoice := f.addIssuedInvoice(2000)
+		f.gateway.QueueOutcomes(payment.OutcomeDecline)
+
+		if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, opts); !errors.Is(err, payment.ErrDeclined) {
+			t.Fatalf("pay error = %v, want %v", err, payment.ErrDeclined)
+		}
+		if got := f.invoice(t, invoice.ID).Status; got != InvoiceFailed {
+			t.Fatalf("invoice status = %s, want %s", got, InvoiceFailed)
+		}
+
+		if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, opts); err != nil {
+			t.Fatalf("retry: %v", err)
+		}
+		if got := f.invoice(t, invoice.ID).Status; got != InvoicePaid {
+			t.Fatalf("invoice status = %s, want %s", got, InvoicePaid)
+		}
+	})
+
+	t.Run("timed out then settled", func(t *testing.T) {
+		f := newPaymentFlow()
+		customer, invoice := f.addIssuedInvoice(2000)
+		f.gateway.QueueOutcomes(payment.OutcomeTimeout)
+
+		if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, opts); !errors.Is(err, payment.ErrTimeout) {
+			t.Fatalf("pay error = %v, want %v", err, payment.ErrTimeout)
+		}
+		txns := f.transactions(t, invoice.ID)
+		if len(txns) != 1 || txns[0].Status != TransactionPending {
+			t.Fatalf("transactions = %+v, want one pending", txns)
+		}
+
+		// Paying again is refused while the gateway has not settled
+		if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, opts); err == nil {
+			t.Fatal("pay with a pending payment succeeded")
+		}
+
+		settled, err := f.gateway.Settle(txns[0].GatewayPaymentID.String, payment.StatusSucceeded)
+		if err != nil {
+			t.Fatalf("settle: %v", err)
+		}
+		f.deliver(t, settled)
+
+		if got := f.invoice(t, invoice.ID).Status; got != InvoicePaid {
+			t.Fatalf("invoice status = %s, want %s", got, InvoicePaid)
+		}
+		if txns := f.transactions(t, invoice.ID); len(txns) != 1 || txns[0].Status != TransactionSucceeded {
+			t.Fatalf("transactions = %+v, want one succeeded", txns)
+		}
+	})
+	t.Run("credit and charge", func(t *testing.T) {
+		f := newPaymentFlow()
+		customer, invoice := f.addIssuedInvoice(2000)
+		f.store.customers[0].CreditBalanceCents.Int64 = 500
+
+		resp, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, PayOptions{UseCreditBalance: true, UsePaymentMethod: true})
+		if err != nil {
+			t.Fatalf("pay: %v", err)
+		}
+		if resp.Status != InvoicePaid {
+			t.Fatalf("invoice status = %s, want %s", resp.Status, InvoicePaid)
+		}
+		txns := f.transactions(t, invoice.ID)
+		if len(txns) != 2 || txns[0].AmountCents != 500 || txns[1].AmountCents != 1500 {
+			t.Fatalf("transactions = %+v, want 500 of credit and a charge of 1500", txns)
+		}
+		for _, txn := range txns {
+			if txn.Status != TransactionSucceeded {
+				t.Fatalf("%s transaction is %s, want %s", txn.Gateway, txn.Status, TransactionSucceeded)
+			}
+		}
+	})
+
+	t.Run("declined gives the credit back", func(t *testing.T) {
+		f := newPaymentFlow()
+		customer, invoice := f.addIssuedInvoice(2000)
+		f.store.customers[0].CreditBalanceCents.Int64 = 500
+		f.gateway.QueueOutcomes(payment.OutcomeDecline)
+
+		if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, PayOptions{UseCreditBalance: true, UsePaymentMethod: true}); !errors.Is(err, payment.ErrDeclined) {
+			t.Fatalf("pay error = %v, want %v", err, payment.ErrDeclined)
+		}
+		if got := f.store.customers[0].CreditBalanceCents.Int64; got != 500 {
+			t.Fatalf("credit balance = %d, want 500", got)
+		}
+		for _, txn := range f.transactions(t, invoice.ID) {
+			if txn.Status != TransactionFailed {
+				t.Fatalf("%s transaction is %s, want %s", txn.Gateway, txn.Status, TransactionFailed)
+			}
+		}
+	})
+
+	t.Run("interrupted charge is replayed", func(t *testing.T) {
+		f := newPaymentFlow()
+		customer, invoice := f.addIssuedInvoice(2000)
+
+		// The service stopped after the gateway took the charge but before
+		// its answer was recorded
+		key := fmt.Sprintf("invoice-%s-attempt-0", invoice.ID)
+		f.store.transactions = append(f.store.transactions, &generated.Transaction{
+			ID:             uuid.New(),
+			InvoiceID:      pgtype.UUID{Bytes: invoice.ID, Valid: true},
+			CustomerID:     invoice.CustomerID,
+			Gateway:        payment.GatewayFake,
+			AmountCents:    2000,
+			Currency:       "USD",
+			Status:         TransactionPending,
+			IdempotencyKey: pgtype.Text{String: key, Valid: true},
+		})
+		charged, err := f.gateway.Charge(ctx, payment.ChargeRequest{
+			CustomerID:      customer.ID,
+			PaymentMethodID: "pm_card",
+			Reference:       invoice.ID.String(),
+			AmountCents:     2000,
+			Currency:        "USD",
+			IdempotencyKey:  key,
+		})
+		if err != nil {
+			t.Fatalf("charge: %v", err)
+		}
+
+		if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, opts); err != nil {
+			t.Fatalf("pay: %v", err)
+		}
+		txns := f.transactions(t, invoice.ID)
+		if len(txns) != 1 || txns[0].Status != TransactionSucceeded || txns[0].GatewayPaymentID.String != charged.ID {
+			t.Fatalf("transactions = %+v, want the interrupted charge %s succeeded", txns, charged.ID)
+		}
+		if got := f.invoice(t, invoice.ID).Status; got != InvoicePaid {
+			t.Fatalf("invoice status = %s, want %s", got, InvoicePaid)
+		}
+	})
+}
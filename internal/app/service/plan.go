@@ -3,10 +3,12 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/novaru/billing-service/db/generated"
 	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
 )
 
 type PlanResponse struct {
@@ -40,6 +42,9 @@ func (s *planService) Create(ctx context.Context, slug, name, description string
 	if err != nil {
 		return PlanResponse{}, err
 	}
+	if _, err := decodeQuotaLimits(quotaLimitsBytes); err != nil {
+		return PlanResponse{}, E.NewInvalidInputError(fmt.Sprintf("invalid quota_limits: %v", err), err)
+	}
 	metaBytes, err := json.Marshal(meta)
 	if err != nil {
 		return PlanResponse{}, err
@@ -81,26 +86,11 @@ func (s *planService) FindAll(ctx context.Context) ([]PlanResponse, error) {
 
 	var resp []PlanResponse
 	for _, plan := range plans {
-		var quotaLimits map[string]any
-		if err := json.Unmarshal(plan.QuotaLimits, &quotaLimits); err != nil {
+		planResp, err := convertPlan(plan)
+		if err != nil {
 			return nil, err
 		}
-		var meta map[string]any
-		if err := json.Unmarshal(plan.Meta, &meta); err != nil {
-			return nil, err
-		}
-
-		resp = append(resp, PlanResponse{
-			ID:          plan.ID.String(),
-			Slug:        plan.Slug,
-			Name:        plan.Name,
-			Description: plan.Description.String,
-			PriceCents:  plan.PriceCents,
-			Currency:    plan.Currency,
-			Interval:    plan.Interval,
-			QuotaLimits: quotaLimits,
-			Meta:        meta,
-		})
+		resp = append(resp, planResp)
 	}
 
 	return resp, nil
@@ -112,13 +102,21 @@ func (s *planService) FindBySlug(ctx context.Context, slug string) (PlanResponse
 		return PlanResponse{}, err
 	}
 
+	return convertPlan(plan)
+}
+
+func convertPlan(plan generated.Plan) (PlanResponse, error) {
 	var quotaLimits map[string]any
-	if err := json.Unmarshal(plan.QuotaLimits, &quotaLimits); err != nil {
-		return PlanResponse{}, err
+	if len(plan.QuotaLimits) > 0 {
+		if err := json.Unmarshal(plan.QuotaLimits, &quotaLimits); err != nil {
+			return PlanResponse{}, err
+		}
 	}
 	var meta map[string]any
-	if err := json.Unmarshal(plan.Meta, &meta); err != nil {
-		return PlanResponse{}, err
+	if len(plan.Meta) > 0 {
+		if err := json.Unmarshal(plan.Meta, &meta); err != nil {
+			return PlanResponse{}, err
+		}
 	}
 
 	return PlanResponse{
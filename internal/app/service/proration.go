@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// ProrationLine is one credit or charge produced by a plan change.
+type ProrationLine struct {
+	Description string    `json:"description"`
+	AmountCents int64     `json:"amount_cents"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+}
+
+// Proration describes the cost of moving a subscription to another plan at a
+// point in time. PeriodStart and PeriodEnd are the subscription period after
+// the change.
+type Proration struct {
+	ProratedAt     time.Time       `json:"prorated_at"`
+	Currency       string          `json:"currency"`
+	PeriodStart    time.Time       `json:"period_start"`
+	PeriodEnd      time.Time       `json:"period_end"`
+	CreditCents    int64           `json:"credit_cents"`
+	ChargeCents    int64           `json:"charge_cents"`
+	AmountDueCents int64           `json:"amount_due_cents"`
+	InvoiceNow     bool            `json:"invoice_now"`
+	Lines          []ProrationLine `json:"lines"`
+}
+
+// calculateProration credits the unused part of the current period on the old
+// plan and charges the new plan. Plans with the same interval keep the
+// current period and the new plan is charged for the remaining time only; a
+// change of interval starts a new full period at the moment of the change.
+//
+// A positive amount due is invoiced immediately; a net credit is left pending
+// for the next invoice.
+func calculateProration(oldPlan, newPlan generated.Plan, periodStart, periodEnd, at time.Time) (Proration, error) {
+	if oldPlan.Currency != newPlan.Currency {
+		return Proration{}, E.NewInvalidInputError("cannot change to a plan billed in a different currency", nil)
+	}
+	if !at.Before(periodEnd) || at.Before(periodStart) {
+		return Proration{}, E.NewInvalidInputError("current period has ended, the plan can be changed after renewal", nil)
+	}
+
+	total := periodEnd.Sub(periodStart)
+	remaining := periodEnd.Sub(at)
+
+	p := Proration{
+		ProratedAt:  at,
+		Currency:    newPlan.Currency,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		CreditCents: -prorate(oldPlan.PriceCents, remaining, total),
+	}
+
+	if oldPlan.Interval == newPlan.Interval {
+		p.ChargeCents = prorate(newPlan.PriceCents, remaining, total)
+	} else {
+		end, err := addInterval(at, newPlan.Interval)
+		if err != nil {
+			return Proration{}, err
+		}
+		p.PeriodStart = at
+		p.PeriodEnd = end
+		p.ChargeCents = newPlan.PriceCents
+	}
+
+	if p.CreditCents != 0 {
+		p.Lines = append(p.Lines, ProrationLine{
+			Description: fmt.Sprintf("Unused time on %s after %s", oldPlan.Name, at.Format(time.DateOnly)),
+			AmountCents: p.CreditCents,
+			PeriodStart: at,
+			PeriodEnd:   periodEnd,
+		})
+	}
+	if p.ChargeCents != 0 {
+		p.Lines = append(p.Lines, ProrationLine{
+			Description: fmt.Sprintf("Remaining time on %s after %s", newPlan.Name, at.Format(time.DateOnly)),
+			AmountCents: p.ChargeCents,
+			PeriodStart: at,
+			PeriodEnd:   p.PeriodEnd,
+		})
+	}
+
+	p.AmountDueCents = p.CreditCents + p.ChargeCents
+	p.InvoiceNow = p.AmountDueCents > 0
+
+	return p, nil
+}
+
+// prorate returns amount scaled by part/whole, rounded half up to whole cents.
+func prorate(amount int64, part, whole time.Duration) int64 {
+	partSec := int64(part / time.Second)
+	wholeSec := int64(whole / time.Second)
+	if wholeSec <= 0 {
+		return 0
+	}
+	return (amount*partSec + wholeSec/2) / wholeSec
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestCalculateProration(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)
+	halfway := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	plan := func(priceCents int64, currency, interval string) generated.Plan {
+		return generated.Plan{Name: "Plan", PriceCents: priceCents, Currency: currency, Interval: interval}
+	}
+
+	tests := []struct {
+		name            string
+		oldPlan         generated.Plan
+		newPlan         generated.Plan
+		at              time.Time
+		wantCredit      int64
+		wantCharge      int64
+		wantInvoiceNow  bool
+		wantLines       int
+		wantPeriodStart time.Time
+		wantPeriodEnd   time.Time
+		wantErr         bool
+	}{
+		{
+			name:            "upgrade halfway",
+			oldPlan:         plan(1000, "USD", "month"),
+			newPlan:         plan(3000, "USD", "month"),
+			at:              halfway,
+			wantCredit:      -500,
+			wantCharge:      1500,
+			wantInvoiceNow:  true,
+			wantLines:       2,
+			wantPeriodStart: start,
+			wantPeriodEnd:   end,
+		},
+		{
+			name:            "downgrade halfway",
+			oldPlan:         plan(3000, "USD", "month"),
+			newPlan:         plan(1000, "USD", "month"),
+			at:              halfway,
+			wantCredit:      -1500,
+			wantCharge:      500,
+			wantLines:       2,
+			wantPeriodStart: start,
+			wantPeriodEnd:   end,
+		},
+		{
+			name:            "from a free plan",
+			oldPlan:         plan(0, "USD", "month"),
+			newPlan:         plan(1000, "USD", "month"),
+			at:              start,
+			wantCharge:      1000,
+			wantInvoiceNow:  true,
+			wantLines:       1,
+			wantPeriodStart: start,
+			wantPeriodEnd:   end,
+		},
+		{
+			name:            "interval change starts a new period",
+			oldPlan:         plan(1000, "USD", "month"),
+			newPlan:         plan(10000, "USD", "year"),
+			at:              halfway,
+			wantCredit:      -500,
+			wantCharge:      10000,
+			wantInvoiceNow:  true,
+			wantLines:       2,
+			wantPeriodStart: halfway,
+			wantPeriodEnd:   halfway.AddDate(1, 0, 0),
+		},
+		{
+			name:    "different currency",
+			oldPlan: plan(1000, "USD", "month"),
+			newPlan: plan(1000, "EUR", "month"),
+			at:      halfway,
+			wantErr: true,
+		},
+		{
+			name:    "period ended",
+			oldPlan: plan(1000, "USD", "month"),
+			newPlan: plan(3000, "USD", "month"),
+			at:      end,
+			wantErr: true,
+		},
+		{
+			name:    "before the period",
+			oldPlan: plan(1000, "USD", "month"),
+			newPlan: plan(3000, "USD", "month"),
+			at:      start.Add(-time.Second),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported interval",
+			oldPlan: plan(1000, "USD", "month"),
+			newPlan: plan(3000, "USD", "week"),
+			at:      halfway,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := calculateProration(tt.oldPlan, tt.newPlan, start, end, tt.at)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if p.CreditCents != tt.wantCredit || p.ChargeCents != tt.wantCharge || p.AmountDueCents != tt.wantCredit+tt.wantCharge {
+				t.Fatalf("credit %d, charge %d, due %d; want %d, %d, %d", p.CreditCents, p.ChargeCents, p.AmountDueCents, tt.wantCredit, tt.wantCharge, tt.wantCredit+tt.wantCharge)
+			}
+			if p.InvoiceNow != tt.wantInvoiceNow {
+				t.Fatalf("invoice now = %t, want %t", p.InvoiceNow, tt.wantInvoiceNow)
+			}
+			if len(p.Lines) != tt.wantLines {
+				t.Fatalf("got %d lines, want %d", len(p.Lines), tt.wantLines)
+			}
+			if !p.PeriodStart.Equal(tt.wantPeriodStart) || !p.PeriodEnd.Equal(tt.wantPeriodEnd) {
+				t.Fatalf("period %s - %s, want %s - %s", p.PeriodStart, p.PeriodEnd, tt.wantPeriodStart, tt.wantPeriodEnd)
+			}
+		})
+	}
+}
+
+func TestProrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      int64
+		part, whole time.Duration
+		want        int64
+	}{
+		{name: "whole", amount: 1000, part: time.Hour, whole: time.Hour, want: 1000},
+		{name: "nothing", amount: 1000, part: 0, whole: time.Hour, want: 0},
+		{name: "rounds down", amount: 1000, part: time.Second, whole: 3 * time.Second, want: 333},
+		{name: "rounds half up", amount: 1000, part: 2 * time.Second, whole: 3 * time.Second, want: 667},
+		{name: "empty period", amount: 1000, part: time.Hour, whole: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prorate(tt.amount, tt.part, tt.whole); got != tt.want {
+				t.Fatalf("prorate(%d, %s, %s) = %d, want %d", tt.amount, tt.part, tt.whole, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// quotaKeySuffixes are stripped from quota_limits keys to get the metric they
+// limit, so "tokens_per_month" limits the "tokens" metric. Limits always
+// apply to the subscription's billing period.
+var quotaKeySuffixes = []string{"_per_month", "_per_year", "_per_period"}
+
+// Enforcement modes of a metric's quota.
+const (
+	QuotaHard    = "hard"    // usage beyond the limit is rejected
+	QuotaSoft    = "soft"    // usage beyond the limit is accepted and flagged
+	QuotaOverage = "overage" // usage beyond the limit is accepted and billed
+)
+
+// usageQuota is the limit of one metric in a plan's quota_limits. A quota is
+// either a bare limit, which is hard, or an object such as {"limit": 1000000,
+// "enforcement": "overage", "overage_unit_price_cents": 2,
+// "overage_unit_size": 1000} whose enforcement defaults to hard. A null limit, or a negative one, means the
+// metric is metered but unlimited.
+type usageQuota struct {
+	limit       int64
+	unlimited   bool
+	enforcement string
+	// Overage is billed overageUnitPriceCents per started overageUnitSize
+	overageUnitPriceCents int64
+	overageUnitSize       int64
+}
+
+// quotaDefinition is the object form of a quota in quota_limits.
+type quotaDefinition struct {
+	Limit                 *int64 `json:"limit"`
+	Enforcement           string `json:"enforcement"`
+	OverageUnitPriceCents int64  `json:"overage_unit_price_cents"`
+	OverageUnitSize       int64  `json:"overage_unit_size"`
+}
+
+// parseQuotaLimits decodes the quota_limits of a plan, keyed by metric.
+func parseQuotaLimits(plan generated.Plan) (map[string]usageQuota, error) {
+	limits, err := decodeQuotaLimits(plan.QuotaLimits)
+	if err != nil {
+		return nil, E.NewInternalError("could not decode plan quota limits", err)
+	}
+	return limits, nil
+}
+
+func decodeQuotaLimits(data []byte) (map[string]usageQuota, error) {
+	limits := make(map[string]usageQuota)
+	if len(data) == 0 {
+		return limits, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for key, value := range raw {
+		quota, err := decodeQuota(value)
+		if err != nil {
+			return nil, fmt.Errorf("quota %q: %w", key, err)
+		}
+		limits[quotaMetric(key)] = quota
+	}
+	return limits, nil
+}
+
+func decodeQuota(value json.RawMessage) (usageQuota, error) {
+	def := quotaDefinition{Enforcement: QuotaHard, OverageUnitSize: 1}
+	if value = bytes.TrimSpace(value); len(value) > 0 && value[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&def); err != nil {
+			return usageQuota{}, err
+		}
+	} else if err := json.Unmarshal(value, &def.Limit); err != nil {
+		return usageQuota{}, err
+	}
+
+	switch def.Enforcement {
+	case QuotaHard, QuotaSoft:
+	case QuotaOverage:
+		if def.OverageUnitPriceCents <= 0 {
+			return usageQuota{}, errors.New("overage_unit_price_cents must be positive")
+		}
+		if def.OverageUnitSize <= 0 {
+			return usageQuota{}, errors.New("overage_unit_size must be positive")
+		}
+	default:
+		return usageQuota{}, fmt.Errorf("unknown enforcement %q", def.Enforcement)
+	}
+
+	quota := usageQuota{
+		unlimited:             def.Limit == nil || *def.Limit < 0,
+		enforcement:           def.Enforcement,
+		overageUnitPriceCents: def.OverageUnitPriceCents,
+		overageUnitSize:       def.OverageUnitSize,
+	}
+	if !quota.unlimited {
+		quota.limit = *def.Limit
+	}
+	return quota, nil
+}
+
+// overage returns the billed units of usage above an overage quota and
+// their amount.
+func (q usageQuota) overage(used int64) (units, amountCents int64) {
+	if q.enforcement != QuotaOverage || q.unlimited || used <= q.limit {
+		return 0, 0
+	}
+	units = (used - q.limit + q.overageUnitSize - 1) / q.overageUnitSize
+	return units, units * q.overageUnitPriceCents
+}
+
+func quotaMetric(key string) string {
+	for _, suffix := range quotaKeySuffixes {
+		if metric, ok := strings.CutSuffix(key, suffix); ok && metric != "" {
+			return metric
+		}
+	}
+	return key
+}
+
+// QuotaCheckResponse tells whether the requested quantities would be
+// accepted in the current period. Only hard quotas make Allowed false;
+// OverQuota flags every metric whose needed quantity exceeds what remains,
+// which soft and overage quotas accept. Remaining is null for unlimited
+// metrics.
+type QuotaCheckResponse struct {
+	Allowed     bool              `json:"allowed"`
+	Remaining   map[string]*int64 `json:"remaining"`
+	Used        map[string]int64  `json:"used"`
+	OverQuota   map[string]bool   `json:"over_quota"`
+	Enforcement map[string]string `json:"enforcement"`
+	PeriodEnd   time.Time         `json:"period_end"`
+}
+
+// QuotaTracker answers quota checks and enforces hard limits from in-memory
+// usage counters, so both can sit in the hot path of a customer's API. A
+// customer's counters are loaded on their first check or report from the
+// rollups of the current period plus the events not rolled up yet, bumped by
+// every event this replica records, and reconciled with the database every
+// interval to pick up events recorded by other replicas. Hard limits are
+// therefore exact per replica and may be overrun by what other replicas
+// accepted since the last reconciliation. Customers not seen for idle are
+// dropped.
+type QuotaTracker struct {
+	repos    repository.Repositories
+	interval time.Duration
+	idle     time.Duration
+
+	mu        sync.Mutex
+	customers map[uuid.UUID]*quotaState
+}
+
+// quotaState holds the counters of one customer. used includes what this
+// replica reserved: inflight is reserved for events not recorded yet, and
+// recorded was confirmed since the last reconciliation started. Both are
+// carried over when a reconciliation swaps the counters, since the database
+// may not have shown them yet. epoch counts reconciliations, so only the
+// latest one to start swaps the counters.
+type quotaState struct {
+	periodStart time.Time
+	periodEnd   time.Time
+	limits      map[string]usageQuota
+	used        map[string]int64
+	inflight    map[string]int64
+	recorded    map[string]int64
+	epoch       uint64
+	lastChecked time.Time
+}
+
+func NewQuotaTracker(repos repository.Repositories, interval, idle time.Duration) *QuotaTracker {
+	return &QuotaTracker{
+		repos:     repos,
+		interval:  interval,
+		idle:      idle,
+		customers: make(map[uuid.UUID]*quotaState),
+	}
+}
+
+// Check compares needed quantities, keyed by metric, with the customer's
+// remaining quota.
+func (t *QuotaTracker) Check(ctx context.Context, customerID uuid.UUID, needed map[string]int64) (QuotaCheckResponse, error) {
+	now := time.Now()
+	state, err := t.state(ctx, customerID)
+	if err != nil {
+		return QuotaCheckResponse{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state.lastChecked = now
+	for metric, quantity := range needed {
+		if err := checkUsageMetric(state.limits, metric); err != nil {
+			return QuotaCheckResponse{}, err
+		}
+		if quantity < 0 {
+			return QuotaCheckResponse{}, E.NewInvalidInputError(fmt.Sprintf("%s needed must not be negative", metric), nil)
+		}
+	}
+
+	resp := QuotaCheckResponse{
+		Allowed:     true,
+		Remaining:   make(map[string]*int64, len(state.limits)),
+		Used:        make(map[string]int64, len(state.limits)),
+		OverQuota:   make(map[string]bool, len(state.limits)),
+		Enforcement: make(map[string]string, len(state.limits)),
+		PeriodEnd:   state.periodEnd,
+	}
+	for metric, quota := range state.limits {
+		used := state.used[metric]
+		resp.Used[metric] = used
+		resp.Enforcement[metric] = quota.enforcement
+		if quota.unlimited {
+			resp.Remaining[metric] = nil
+			resp.OverQuota[metric] = false
+			continue
+		}
+
+		remaining := max(quota.limit-used, 0)
+		resp.Remaining[metric] = &remaining
+		resp.OverQuota[metric] = needed[metric] > remaining
+		if resp.OverQuota[metric] && quota.enforcement == QuotaHard {
+			resp.Allowed = false
+		}
+	}
+	return resp, nil
+}
+
+// quotaDecision is the outcome of reserving usage against a quota.
+type quotaDecision struct {
+	allowed   bool
+	overQuota bool // allowed beyond a soft or overage limit
+}
+
+// Reserve counts quantity of metric against the customer's quota before the
+// event is recorded, so concurrent reports cannot all slip under a hard
+// limit. Usage beyond a hard limit is refused and not counted. A reservation
+// must be confirmed once its event is recorded, or released when it is not.
+func (t *QuotaTracker) Reserve(ctx context.Context, customerID uuid.UUID, metric string, quantity int64) (quotaDecision, error) {
+	now := time.Now()
+	state, err := t.state(ctx, customerID)
+	if err != nil {
+		return quotaDecision{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// A reconciliation may have replaced the counters since they were read
+	if current, ok := t.customers[customerID]; ok {
+		state = current
+	}
+	state.lastChecked = now
+	if err := checkUsageMetric(state.limits, metric); err != nil {
+		return quotaDecision{}, err
+	}
+
+	quota := state.limits[metric]
+	over := !quota.unlimited && state.used[metric]+quantity > quota.limit
+	if over && quota.enforcement == QuotaHard {
+		return quotaDecision{}, nil
+	}
+	state.used[metric] += quantity
+	state.inflight[metric] += quantity
+	return quotaDecision{allowed: true, overQuota: over}, nil
+}
+
+// Confirm marks a reservation whose event was recorded. It stays counted until
+// a reconciliation that started afterwards reads the event from the database.
+func (t *QuotaTracker) Confirm(customerID uuid.UUID, metric string, quantity int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.customers[customerID]
+	if !ok {
+		return
+	}
+	quantity = min(quantity, state.inflight[metric])
+	state.inflight[metric] -= quantity
+	state.recorded[metric] += quantity
+}
+
+// Release takes back a reservation whose event was not recorded. What a
+// reconciliation already dropped from the counters, e.g. when the period
+// ended, is not taken back again.
+func (t *QuotaTracker) Release(customerID uuid.UUID, metric string, quantity int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.customers[customerID]
+	if !ok {
+		return
+	}
+	quantity = min(quantity, state.inflight[metric])
+	state.used[metric] -= quantity
+	state.inflight[metric] -= quantity
+}
+
+// Limits returns the quotas of the customer's plan, keyed by metric.
+func (t *QuotaTracker) Limits(ctx context.Context, customerID uuid.UUID) (map[string]usageQuota, error) {
+	state, err := t.state(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	return state.limits, nil
+}
+
+// state returns the customer's counters, loading them when they are not
+// loaded yet or their period has ended.
+func (t *QuotaTracker) state(ctx context.Context, customerID uuid.UUID) (*quotaState, error) {
+	t.mu.Lock()
+	state, ok := t.customers[customerID]
+	t.mu.Unlock()
+	if ok && time.Now().Before(state.periodEnd) {
+		return state, nil
+	}
+	return t.reconcile(ctx, customerID)
+}
+
+// Run reconciles the loaded counters every interval until ctx is canceled.
+func (t *QuotaTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.reconcileAll(ctx, time.Now())
+		}
+	}
+}
+
+func (t *QuotaTracker) reconcileAll(ctx context.Context, now time.Time) {
+	t.mu.Lock()
+	ids := make([]uuid.UUID, 0, len(t.customers))
+	for id, state := range t.customers {
+		if now.Sub(state.lastChecked) > t.idle {
+			delete(t.customers, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+
+	for _, id := range ids {
+		if _, err := t.reconcile(ctx, id); err != nil {
+			// Stale counters are dropped; the next check loads them again
+			// or reports why it cannot, e.g. an ended subscription
+			t.mu.Lock()
+			delete(t.customers, id)
+			t.mu.Unlock()
+			logger.Debug("dropped usage quota counters", zap.String("customer_id", id.String()), zap.Error(err))
+		}
+	}
+}
+
+// reconcile reloads a customer's counters from the database. Reservations
+// whose events are not recorded yet, and those confirmed after the reload
+// started, are kept on top, since the database read may have missed them.
+// Events committed just before the read are then counted twice until the
+// next reconciliation, but no reservation is dropped before the database
+// shows it.
+func (t *QuotaTracker) reconcile(ctx context.Context, customerID uuid.UUID) (*quotaState, error) {
+	t.mu.Lock()
+	var epoch uint64
+	if state, ok := t.customers[customerID]; ok {
+		state.epoch++
+		epoch = state.epoch
+		// Events confirmed so far are committed, so the reload sees them
+		state.recorded = make(map[string]int64)
+	}
+	t.mu.Unlock()
+
+	fresh, err := t.load(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.customers[customerID]; ok {
+		if state.epoch != epoch {
+			// A later reconciliation started meanwhile; its reload is newer
+			return state, nil
+		}
+		if state.periodStart.Equal(fresh.periodStart) {
+			for metric, quantity := range state.inflight {
+				fresh.used[metric] += quantity
+			}
+			for metric, quantity := range state.recorded {
+				fresh.used[metric] += quantity
+			}
+			fresh.inflight = state.inflight
+			fresh.recorded = state.recorded
+		}
+		fresh.epoch = state.epoch
+		fresh.lastChecked = state.lastChecked
+	}
+	t.customers[customerID] = fresh
+	return fresh, nil
+}
+
+func (t *QuotaTracker) load(ctx context.Context, customerID uuid.UUID) (*quotaState, error) {
+	sub, err := t.repos.Subscriptions.FindCurrentByCustomer(ctx, customerID)
+	if err != nil && !errors.Is(err, E.ErrNotFound) {
+		return nil, E.NewInternalError("could not retrieve subscription", err)
+	}
+	if err != nil || !meteredSubscription(sub.Status) || !sub.CurrentPeriodStart.Valid {
+		return nil, E.NewForbiddenError("customer has no active subscription", nil)
+	}
+
+	plan, err := t.repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve plan", err)
+	}
+	limits, err := parseQuotaLimits(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &quotaState{
+		periodStart: sub.CurrentPeriodStart.Time,
+		periodEnd:   sub.CurrentPeriodEnd.Time,
+		limits:      limits,
+		used:        make(map[string]int64),
+		inflight:    make(map[string]int64),
+		recorded:    make(map[string]int64),
+	}
+
+	aggregates, err := t.repos.Usage.ListAggregatesForPeriod(ctx, customerID, state.periodStart, state.periodEnd)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve usage", err)
+	}
+	for _, agg := range aggregates {
+		state.used[agg.Metric] += agg.TotalQuantity.Int64
+	}
+
+	// Events not rolled up yet are counted in the current period
+	pending, err := t.repos.Usage.SumUnprocessed(ctx, customerID)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve pending usage", err)
+	}
+	for _, row := range pending {
+		state.used[row.Metric] += row.TotalQuantity
+	}
+
+	return state, nil
+}
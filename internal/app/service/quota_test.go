@@ -0,0 +1,410 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestDecodeQuotaLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		metric  string
+		want    usageQuota
+		wantErr bool
+	}{
+		{
+			name:   "bare limit is hard",
+			data:   `{"requests_per_month": 100000}`,
+			metric: "requests",
+			want:   usageQuota{limit: 100000, enforcement: QuotaHard, overageUnitSize: 1},
+		},
+		{
+			name:   "null limit is unlimited",
+			data:   `{"requests_per_month": null}`,
+			metric: "requests",
+			want:   usageQuota{unlimited: true, enforcement: QuotaHard, overageUnitSize: 1},
+		},
+		{
+			name:   "negative limit is unlimited",
+			data:   `{"requests": -1}`,
+			metric: "requests",
+			want:   usageQuota{unlimited: true, enforcement: QuotaHard, overageUnitSize: 1},
+		},
+		{
+			name:   "object without enforcement is hard",
+			data:   `{"images_per_month": {"limit": 500}}`,
+			metric: "images",
+			want:   usageQuota{limit: 500, enforcement: QuotaHard, overageUnitSize: 1},
+		},
+		{
+			name:   "soft",
+			data:   `{"images_per_month": {"limit": 500, "enforcement": "soft"}}`,
+			metric: "images",
+			want:   usageQuota{limit: 500, enforcement: QuotaSoft, overageUnitSize: 1},
+		},
+		{
+			name:   "overage",
+			data:   `{"tokens_per_month": {"limit": 1000000, "enforcement": "overage", "overage_unit_price_cents": 2, "overage_unit_size": 1000}}`,
+			metric: "tokens",
+			want:   usageQuota{limit: 1000000, enforcement: QuotaOverage, overageUnitPriceCents: 2, overageUnitSize: 1000},
+		},
+		{
+			name:    "overage without price",
+			data:    `{"tokens": {"limit": 10, "enforcement": "overage"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "overage with zero unit size",
+			data:    `{"tokens": {"limit": 10, "enforcement": "overage", "overage_unit_price_cents": 2, "overage_unit_size": 0}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown enforcement",
+			data:    `{"tokens": {"limit": 10, "enforcement": "strict"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			data:    `{"tokens": {"limit": 10, "enforcment": "soft"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			data:    `{"tokens": "10"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits, err := decodeQuotaLimits([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeQuotaLimits(%s) succeeded, want an error", tt.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeQuotaLimits(%s): %v", tt.data, err)
+			}
+			if got := limits[tt.metric]; got != tt.want {
+				t.Fatalf("quota of %s = %+v, want %+v", tt.metric, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageQuotaOverage(t *testing.T) {
+	quota := usageQuota{limit: 1000000, enforcement: QuotaOverage, overageUnitPriceCents: 2, overageUnitSize: 1000}
+
+	tests := []struct {
+		used       int64
+		wantUnits  int64
+		wantAmount int64
+	}{
+		{used: 999999},
+		{used: 1000000},
+		{used: 1000001, wantUnits: 1, wantAmount: 2},
+		{used: 1001000, wantUnits: 1, wantAmount: 2},
+		{used: 1002500, wantUnits: 3, wantAmount: 6},
+	}
+
+	for _, tt := range tests {
+		units, amount := quota.overage(tt.used)
+		if units != tt.wantUnits || amount != tt.wantAmount {
+			t.Errorf("overage(%d) = %d units, %d cents; want %d, %d", tt.used, units, amount, tt.wantUnits, tt.wantAmount)
+		}
+	}
+
+	for _, q := range []usageQuota{
+		{limit: 10, enforcement: QuotaHard, overageUnitSize: 1},
+		{limit: 10, enforcement: QuotaSoft, overageUnitSize: 1},
+		{unlimited: true, enforcement: QuotaOverage, overageUnitPriceCents: 2, overageUnitSize: 1},
+	} {
+		if units, amount := q.overage(100); units != 0 || amount != 0 {
+			t.Errorf("overage of %+v = %d units, %d cents; want none", q, units, amount)
+		}
+	}
+}
+
+// newQuotaCustomer subscribes a new customer to a plan with the given quota
+// limits and returns its ID.
+func newQuotaCustomer(t *testing.T, store *memStore, quotaLimits string) uuid.UUID {
+	t.Helper()
+
+	plan := &generated.Plan{ID: uuid.New(), Name: "Metered", Currency: "USD", Interval: "month", QuotaLimits: []byte(quotaLimits)}
+	store.plans = append(store.plans, plan)
+
+	customerID := uuid.New()
+	start := time.Now().Add(-time.Hour)
+	if _, err := store.repos().Subscriptions.Create(context.Background(), generated.CreateSubscriptionParams{
+		CustomerID:         pgtype.UUID{Bytes: customerID, Valid: true},
+		PlanID:             pgtype.UUID{Bytes: plan.ID, Valid: true},
+		Status:             SubscriptionActive,
+		CurrentPeriodStart: timestamptz(start),
+		CurrentPeriodEnd:   timestamptz(start.AddDate(0, 1, 0)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return customerID
+}
+
+func TestQuotaReconcileKeepsReservations(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	tracker := NewQuotaTracker(store.repos(), time.Minute, time.Hour)
+	customerID := newQuotaCustomer(t, store, `{"requests": 10}`)
+
+	record := func(quantity int64) {
+		store.usageEvents = append(store.usageEvents, &generated.UsageEvent{
+			ID:         uuid.New(),
+			CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+			Metric:     "requests",
+			Quantity:   quantity,
+		})
+	}
+	reserve := func(quantity int64) {
+		t.Helper()
+		if decision, err := tracker.Reserve(ctx, customerID, "requests", quantity); err != nil || !decision.allowed {
+			t.Fatalf("reserve %d = %+v, %v; want allowed", quantity, decision, err)
+		}
+	}
+	reconcile := func(want int64) {
+		t.Helper()
+		state, err := tracker.reconcile(ctx, customerID)
+		if err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+		if got := state.used["requests"]; got != want {
+			t.Fatalf("used after reconciling = %d, want %d", got, want)
+		}
+	}
+
+	// Reserved but not recorded while the database is read
+	reserve(4)
+	reconcile(4)
+	record(4)
+	tracker.Confirm(customerID, "requests", 4)
+	reconcile(4)
+
+	// Recorded after the database was read, confirmed after the swap
+	reserve(3)
+	reconcile(7)
+	record(3)
+	tracker.Confirm(customerID, "requests", 3)
+	reconcile(7)
+
+	if decision, err := tracker.Reserve(ctx, customerID, "requests", 4); err != nil || decision.allowed {
+		t.Fatalf("reserve beyond the hard limit = %+v, %v; want refused", decision, err)
+	}
+
+	// A released reservation is not counted again
+	reserve(2)
+	tracker.Release(customerID, "requests", 2)
+	reconcile(7)
+}
+
+func TestQuotaCheck(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	tracker := NewQuotaTracker(store.repos(), time.Minute, time.Hour)
+	customerID := newQuotaCustomer(t, store, `{
+		"requests": 100,
+		"images": {"limit": 10, "enforcement": "soft"},
+		"tokens": {"limit": 1000, "enforcement": "overage", "overage_unit_price_cents": 2},
+		"minutes": null
+	}`)
+
+	// Usage of the period is read from the rollups and the events not rolled up yet
+	periodStart := store.subscriptions[0].CurrentPeriodStart.Time
+	store.aggregates = append(store.aggregates, &generated.UsageAggregate{
+		ID:            uuid.New(),
+		CustomerID:    pgtype.UUID{Bytes: customerID, Valid: true},
+		PeriodStart:   pgtype.Date{Time: periodStart.Truncate(24 * time.Hour), Valid: true},
+		Metric:        "requests",
+		TotalQuantity: pgtype.Int8{Int64: 30, Valid: true},
+	})
+	for metric, quantity := range map[string]int64{"requests": 10, "images": 12, "minutes": 500} {
+		store.usageEvents = append(store.usageEvents, &generated.UsageEvent{
+			ID:         uuid.New(),
+			CustomerID: pgtype.UUID{Bytes: customerID, Valid: true},
+			Metric:     metric,
+			Quantity:   quantity,
+		})
+	}
+
+	remaining := func(n int64) *int64 { return &n }
+	wantRemaining := map[string]*int64{"requests": remaining(60), "images": remaining(0), "tokens": remaining(1000), "minutes": nil}
+	wantUsed := map[string]int64{"requests": 40, "images": 12, "tokens": 0, "minutes": 500}
+
+	tests := []struct {
+		name        string
+		needed      map[string]int64
+		wantAllowed bool
+		wantOver    []string
+	}{
+		{name: "nothing needed", wantAllowed: true},
+		{name: "within the hard limit", needed: map[string]int64{"requests": 60}, wantAllowed: true},
+		{name: "beyond the hard limit", needed: map[string]int64{"requests": 61}, wantOver: []string{"requests"}},
+		{name: "beyond the soft limit", needed: map[string]int64{"images": 1}, wantAllowed: true, wantOver: []string{"images"}},
+		{name: "beyond the overage limit", needed: map[string]int64{"tokens": 1001}, wantAllowed: true, wantOver: []string{"tokens"}},
+		{name: "unlimited", needed: map[string]int64{"minutes": 1 << 40}, wantAllowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tracker.Check(ctx, customerID, tt.needed)
+			if err != nil {
+				t.Fatalf("check: %v", err)
+			}
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("allowed = %t, want %t", resp.Allowed, tt.wantAllowed)
+			}
+			for metric, want := range wantRemaining {
+				got := resp.Remaining[metric]
+				if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+					t.Errorf("remaining %s = %v, want %v", metric, got, want)
+				}
+				if resp.Used[metric] != wantUsed[metric] {
+					t.Errorf("used %s = %d, want %d", metric, resp.Used[metric], wantUsed[metric])
+				}
+				if over := slices.Contains(tt.wantOver, metric); resp.OverQuota[metric] != over {
+					t.Errorf("over quota %s = %t, want %t", metric, resp.OverQuota[metric], over)
+				}
+			}
+			if !resp.PeriodEnd.Equal(store.subscriptions[0].CurrentPeriodEnd.Time) {
+				t.Errorf("period end = %s, want %s", resp.PeriodEnd, store.subscriptions[0].CurrentPeriodEnd.Time)
+			}
+		})
+	}
+}
+
+func TestQuotaCheckRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		needed     map[string]int64
+		wantStatus int
+	}{
+		{name: "metric not metered", status: SubscriptionActive, needed: map[string]int64{"images": 1}, wantStatus: http.StatusBadRequest},
+		{name: "negative quantity", status: SubscriptionActive, needed: map[string]int64{"requests": -1}, wantStatus: http.StatusBadRequest},
+		{name: "pending subscription", status: SubscriptionPending, wantStatus: http.StatusForbidden},
+		{name: "canceled subscription", status: SubscriptionCanceled, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			tracker := NewQuotaTracker(store.repos(), time.Minute, time.Hour)
+			customerID := newQuotaCustomer(t, store, `{"requests": 10}`)
+			store.subscriptions[0].Status = tt.status
+
+			_, err := tracker.Check(context.Background(), customerID, tt.needed)
+			wantHTTPStatus(t, err, tt.wantStatus)
+		})
+	}
+}
+
+func TestQuotaTrackerDropsIdleCustomers(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	tracker := NewQuotaTracker(store.repos(), time.Minute, time.Hour)
+	active := newQuotaCustomer(t, store, `{"requests": 10}`)
+	idle := newQuotaCustomer(t, store, `{"requests": 10}`)
+	ended := newQuotaCustomer(t, store, `{"requests": 10}`)
+	for _, id := range []uuid.UUID{active, idle, ended} {
+		if _, err := tracker.Check(ctx, id, nil); err != nil {
+			t.Fatalf("check: %v", err)
+		}
+	}
+	tracker.customers[idle].lastChecked = time.Now().Add(-2 * time.Hour)
+	store.subscriptions[2].Status = SubscriptionCanceled
+
+	tracker.reconcileAll(ctx, time.Now())
+
+	if _, ok := tracker.customers[active]; !ok {
+		t.Error("counters of an active customer were dropped")
+	}
+	if _, ok := tracker.customers[idle]; ok {
+		t.Error("counters of an idle customer were kept")
+	}
+	if _, ok := tracker.customers[ended]; ok {
+		t.Error("counters of a customer without a subscription were kept")
+	}
+}
+
+func TestReportUsageEnforcement(t *testing.T) {
+	tests := []struct {
+		name          string
+		quotaLimits   string
+		wantStatus    int // of the report that goes over the limit
+		wantOverQuota bool
+		wantRecorded  int
+	}{
+		{
+			name:         "hard",
+			quotaLimits:  `{"requests": {"limit": 10, "enforcement": "hard"}}`,
+			wantStatus:   http.StatusTooManyRequests,
+			wantRecorded: 1,
+		},
+		{
+			name:          "soft",
+			quotaLimits:   `{"requests": {"limit": 10, "enforcement": "soft"}}`,
+			wantOverQuota: true,
+			wantRecorded:  2,
+		},
+		{
+			name:          "overage",
+			quotaLimits:   `{"requests": {"limit": 10, "enforcement": "overage", "overage_unit_price_cents": 5}}`,
+			wantOverQuota: true,
+			wantRecorded:  2,
+		},
+		{
+			name:         "unlimited",
+			quotaLimits:  `{"requests": null}`,
+			wantRecorded: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemStore()
+			usage := NewUsageService(store.repos(), NewQuotaTracker(store.repos(), time.Minute, time.Hour))
+			principal := CustomerPrincipal{CustomerID: newQuotaCustomer(t, store, tt.quotaLimits)}
+
+			first, err := usage.Report(ctx, principal, UsageReport{EventID: "evt_1", Metric: "requests", Quantity: 6})
+			if err != nil || first.OverQuota {
+				t.Fatalf("report within the limit = %+v, %v; want accepted", first, err)
+			}
+
+			second, err := usage.Report(ctx, principal, UsageReport{EventID: "evt_2", Metric: "requests", Quantity: 6})
+			if tt.wantStatus != 0 {
+				wantHTTPStatus(t, err, tt.wantStatus)
+			} else if err != nil || second.OverQuota != tt.wantOverQuota {
+				t.Fatalf("report over the limit = %+v, %v; want accepted with over quota %t", second, err, tt.wantOverQuota)
+			}
+			if len(store.usageEvents) != tt.wantRecorded {
+				t.Fatalf("got %d usage events, want %d", len(store.usageEvents), tt.wantRecorded)
+			}
+
+			// A refused event is not counted, so smaller ones still fit
+			check, err := usage.CheckQuota(ctx, principal, map[string]int64{"requests": 4})
+			if err != nil {
+				t.Fatalf("check quota: %v", err)
+			}
+			if want := int64(6 * tt.wantRecorded); check.Used["requests"] != want {
+				t.Fatalf("used = %d, want %d", check.Used["requests"], want)
+			}
+		})
+	}
+}
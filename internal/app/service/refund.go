@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// RefundRequest pays back part or all of a payment transaction. A zero
+// AmountCents refunds everything that is still refundable.
+type RefundRequest struct {
+	TransactionID uuid.UUID
+	AmountCents   int64
+	Reason        string
+}
+
+// RefundResponse is the recorded refund with the payment it pays back and the
+// status its invoice moved to.
+type RefundResponse struct {
+	Refund          TransactionResponse `json:"refund"`
+	Transaction     TransactionResponse `json:"transaction"`
+	RefundedCents   int64               `json:"refunded_cents"`
+	RefundableCents int64               `json:"refundable_cents"`
+	InvoiceID       *uuid.UUID          `json:"invoice_id"`
+	InvoiceStatus   *string             `json:"invoice_status"`
+}
+
+type RefundService interface {
+	// Refund pays back a succeeded payment through the gateway that took it,
+	// or to the credit balance for credit payments, and records the refund
+	// as a refunded transaction linked to the payment.
+	Refund(ctx context.Context, req RefundRequest) (RefundResponse, error)
+}
+
+type refundService struct {
+	uow     repository.UnitOfWork
+	gateway payment.PaymentGateway
+}
+
+func NewRefundService(uow repository.UnitOfWork, gateway payment.PaymentGateway) RefundService {
+	return &refundService{uow: uow, gateway: gateway}
+}
+
+// Refund locks the payment so concurrent refunds cannot pay back more than
+// was captured. A gateway refund is recorded as pending and committed before
+// the gateway is called, so the payment is not locked while the gateway
+// answers, and settled in a second transaction. The gateway's idempotency key
+// is derived from the number of earlier refunds; a refund whose outcome is
+// unknown stays pending and is replayed with the same key when it is
+// requested again.
+func (s *refundService) Refund(ctx context.Context, req RefundRequest) (RefundResponse, error) {
+	if req.AmountCents < 0 {
+		return RefundResponse{}, E.NewInvalidInputError("amount_cents must not be negative", nil)
+	}
+
+	var resp RefundResponse
+	var pending *pendingRefund
+	err := s.uow.Do(ctx, func(repos repository.Repositories) error {
+		pending = nil
+
+		original, err := repos.Transactions.FindForUpdate(ctx, req.TransactionID)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.NewNotFoundError("transaction")
+			}
+			return E.NewInternalError("could not retrieve transaction", err)
+		}
+		if original.RefundedTransactionID.Valid {
+			return E.NewInvalidInputError("a refund cannot be refunded", nil)
+		}
+		if original.Status != TransactionSucceeded {
+			return E.NewInvalidInputError(fmt.Sprintf("only succeeded payments can be refunded, this one is %s", original.Status), nil)
+		}
+
+		refunds, err := repos.Transactions.ListRefunds(ctx, original.ID)
+		if err != nil {
+			return E.NewInternalError("could not retrieve refunds", err)
+		}
+		var refunded int64
+		for _, refund := range refunds {
+			switch refund.Status {
+			case TransactionRefunded:
+				refunded += refund.AmountCents
+			case TransactionPending:
+				if req.AmountCents != 0 && req.AmountCents != refund.AmountCents {
+					return E.NewInvalidInputError("a refund of this payment is still being processed", nil)
+				}
+				// The gateway never confirmed this refund, so ask again
+				pending = &pendingRefund{
+					originalID: original.ID,
+					refundID:   refund.ID,
+					request:    gatewayRefund(original, refund.AmountCents, req.Reason, refund.IdempotencyKey.String),
+				}
+				return nil
+			}
+		}
+
+		refundable := original.AmountCents - refunded
+		if refundable <= 0 {
+			return E.NewInvalidInputError("payment is already fully refunded", nil)
+		}
+		amount := req.AmountCents
+		if amount == 0 {
+			amount = refundable
+		}
+		if amount > refundable {
+			return E.NewInvalidInputError(fmt.Sprintf("refund of %d exceeds the refundable amount of %d", amount, refundable), nil)
+		}
+
+		key := fmt.Sprintf("refund-%s-%d", original.ID, len(refunds))
+		status := TransactionRefunded
+		switch {
+		case original.Gateway == GatewayCreditBalance:
+			if _, err := repos.Customers.AddCredit(ctx, original.CustomerID.Bytes, amount); err != nil {
+				return E.NewInternalError("could not restore credit balance", err)
+			}
+		case original.Gateway == s.gateway.Name() && original.GatewayPaymentID.Valid:
+			status = TransactionPending
+		default:
+			return E.NewInvalidInputError(fmt.Sprintf("payments through %s cannot be refunded here", original.Gateway), nil)
+		}
+
+		refund, err := repos.Transactions.Create(ctx, generated.CreateTransactionParams{
+			InvoiceID:             original.InvoiceID,
+			CustomerID:            original.CustomerID,
+			Gateway:               original.Gateway,
+			AmountCents:           amount,
+			Currency:              original.Currency,
+			Status:                status,
+			IdempotencyKey:        pgtype.Text{String: key, Valid: true},
+			RefundedTransactionID: pgtype.UUID{Bytes: original.ID, Valid: true},
+		})
+		if err != nil {
+			return E.NewInternalError("could not record refund", err)
+		}
+
+		if status == TransactionPending {
+			pending = &pendingRefund{
+				originalID: original.ID,
+				refundID:   refund.ID,
+				request:    gatewayRefund(original, amount, req.Reason, key),
+			}
+			return nil
+		}
+
+		resp, err = refundResult(ctx, repos, original, refund)
+		return err
+	})
+	if err != nil {
+		return RefundResponse{}, err
+	}
+	if pending == nil {
+		return resp, nil
+	}
+
+	r, refundErr := s.gateway.Refund(ctx, pending.request)
+
+	var gatewayErr error
+	err = s.uow.Do(ctx, func(repos repository.Repositories) error {
+		var err error
+		resp, gatewayErr, err = settleRefund(ctx, repos, *pending, r, refundErr)
+		return err
+	})
+	if err != nil {
+		return RefundResponse{}, err
+	}
+	if gatewayErr != nil {
+		return RefundResponse{}, gatewayErr
+	}
+
+	return resp, nil
+}
+
+// pendingRefund is a gateway refund that is recorded as a pending transaction
+// and still has to be sent to the gateway.
+type pendingRefund struct {
+	originalID uuid.UUID
+	refundID   uuid.UUID
+	request    payment.RefundRequest
+}
+
+// gatewayRefund asks the gateway to pay back amount of the original payment.
+func gatewayRefund(original generated.Transaction, amount int64, reason, key string) payment.RefundRequest {
+	return payment.RefundRequest{
+		PaymentID:      original.GatewayPaymentID.String,
+		AmountCents:    amount,
+		Reason:         reason,
+		IdempotencyKey: key,
+	}
+}
+
+// settleRefund records the gateway's answer to a pending refund. Refusals
+// and timeouts are returned as gatewayErr so the caller can commit them;
+// other errors abort the transaction.
+func settleRefund(ctx context.Context, repos repository.Repositories, pending pendingRefund, r payment.Refund, refundErr error) (resp RefundResponse, gatewayErr error, err error) {
+	original, err := repos.Transactions.FindForUpdate(ctx, pending.originalID)
+	if err != nil {
+		return RefundResponse{}, nil, E.NewInternalError("could not retrieve transaction", err)
+	}
+	refund, err := repos.Transactions.FindForUpdate(ctx, pending.refundID)
+	if err != nil {
+		return RefundResponse{}, nil, E.NewInternalError("could not retrieve refund", err)
+	}
+
+	if refund.Status == TransactionPending {
+		status := TransactionRefunded
+		switch {
+		case refundErr == nil && r.Status == payment.StatusFailed:
+			status = TransactionFailed
+			gatewayErr = E.NewPaymentFailedError("the payment gateway refused the refund", nil)
+		case refundErr == nil:
+		case errors.Is(refundErr, payment.ErrTimeout):
+			status = TransactionPending
+			gatewayErr = E.NewGatewayTimeoutError("refund is still being processed, request it again to check on it", refundErr)
+		case errors.Is(refundErr, payment.ErrNotFound):
+			status = TransactionFailed
+			gatewayErr = E.NewInvalidInputError("the payment gateway does not know this payment", refundErr)
+		default:
+			status = TransactionFailed
+			gatewayErr = E.NewInternalError("could not refund payment", refundErr)
+		}
+
+		refund, err = repos.Transactions.UpdateStatus(ctx, refund.ID, status, r.ID, r.Raw)
+		if err != nil {
+			return RefundResponse{}, nil, E.NewInternalError("could not record refund", err)
+		}
+		if gatewayErr != nil {
+			return RefundResponse{}, gatewayErr, nil
+		}
+	}
+	if refund.Status != TransactionRefunded {
+		return RefundResponse{}, E.NewPaymentFailedError("the payment gateway refused the refund", nil), nil
+	}
+
+	resp, err = refundResult(ctx, repos, original, refund)
+	return resp, nil, err
+}
+
+// refundResult describes a recorded refund and moves the invoice of the
+// payment along.
+func refundResult(ctx context.Context, repos repository.Repositories, original, refund generated.Transaction) (RefundResponse, error) {
+	refunds, err := repos.Transactions.ListRefunds(ctx, original.ID)
+	if err != nil {
+		return RefundResponse{}, E.NewInternalError("could not retrieve refunds", err)
+	}
+	var refunded int64
+	for _, r := range refunds {
+		if r.Status == TransactionRefunded {
+			refunded += r.AmountCents
+		}
+	}
+
+	resp := RefundResponse{
+		Refund:          convertTransaction(refund),
+		Transaction:     convertTransaction(original),
+		RefundedCents:   refunded,
+		RefundableCents: original.AmountCents - refunded,
+	}
+
+	if original.InvoiceID.Valid {
+		invoice, err := refundInvoice(ctx, repos, original.InvoiceID.Bytes)
+		if err != nil {
+			return RefundResponse{}, err
+		}
+		resp.InvoiceID = &invoice.ID
+		resp.InvoiceStatus = &invoice.Status
+	}
+	return resp, nil
+}
+
+// refundInvoice moves a paid invoice to refunded once refunds cover all of
+// its payments, and to partially refunded before that. Invoices that were
+// never paid keep their status.
+func refundInvoice(ctx context.Context, repos repository.Repositories, id uuid.UUID) (generated.Invoice, error) {
+	invoice, err := repos.Invoices.FindForUpdate(ctx, id)
+	if err != nil {
+		return generated.Invoice{}, E.NewInternalError("could not retrieve invoice", err)
+	}
+	if invoice.Status != InvoicePaid && invoice.Status != InvoicePartiallyRefunded {
+		return invoice, nil
+	}
+
+	transactions, err := repos.Transactions.ListByInvoice(ctx, invoice.ID)
+	if err != nil {
+		return generated.Invoice{}, E.NewInternalError("could not retrieve invoice transactions", err)
+	}
+	var paid, refunded int64
+	for _, txn := range transactions {
+		switch txn.Status {
+		case TransactionSucceeded:
+			paid += txn.AmountCents
+		case TransactionRefunded:
+			refunded += txn.AmountCents
+		}
+	}
+
+	status := InvoicePartiallyRefunded
+	if refunded >= paid {
+		status = InvoiceRefunded
+	}
+	if status == invoice.Status {
+		return invoice, nil
+	}
+
+	invoice, err = repos.Invoices.MarkRefunded(ctx, invoice.ID, status)
+	if err != nil {
+		return generated.Invoice{}, E.NewInternalError("could not update invoice status", err)
+	}
+	return invoice, nil
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// paidInvoice pays an invoice of amountCents through the fake gateway and
+// returns the invoice with the transaction of its charge.
+func (f *paymentFlow) paidInvoice(t *testing.T, amountCents int64) (uuid.UUID, uuid.UUID) {
+	t.Helper()
+
+	customer, invoice := f.addIssuedInvoice(amountCents)
+	if _, err := f.invoices.Pay(context.Background(), customer.ID, invoice.ID, PayOptions{UsePaymentMethod: true}); err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	return invoice.ID, f.transactions(t, invoice.ID)[0].ID
+}
+
+func TestRefundTransitions(t *testing.T) {
+	type step struct {
+		amount         int64
+		wantStatus     string
+		wantRefundable int64
+		wantHTTPStatus int
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "full",
+			steps: []step{{wantStatus: InvoiceRefunded}},
+		},
+		{
+			name: "partial then the rest",
+			steps: []step{
+				{amount: 500, wantStatus: InvoicePartiallyRefunded, wantRefundable: 1500},
+				{amount: 1000, wantStatus: InvoicePartiallyRefunded, wantRefundable: 500},
+				{wantStatus: InvoiceRefunded},
+			},
+		},
+		{
+			name: "more than refundable",
+			steps: []step{
+				{amount: 1500, wantStatus: InvoicePartiallyRefunded, wantRefundable: 500},
+				{amount: 501, wantHTTPStatus: http.StatusBadRequest},
+			},
+		},
+		{
+			name: "already refunded",
+			steps: []step{
+				{wantStatus: InvoiceRefunded},
+				{wantHTTPStatus: http.StatusBadRequest},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			refunds := NewRefundService(memUnitOfWork{repos: f.store.repos()}, f.gateway)
+			invoiceID, txnID := f.paidInvoice(t, 2000)
+
+			for i, st := range tt.steps {
+				resp, err := refunds.Refund(context.Background(), RefundRequest{TransactionID: txnID, AmountCents: st.amount})
+				if st.wantHTTPStatus != 0 {
+					var appErr *E.AppError
+					if !errors.As(err, &appErr) || appErr.HTTPStatus() != st.wantHTTPStatus {
+						t.Fatalf("step %d: error = %v, want status %d", i, err, st.wantHTTPStatus)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d: refund: %v", i, err)
+				}
+				if resp.Refund.Status != TransactionRefunded || resp.RefundableCents != st.wantRefundable {
+					t.Fatalf("step %d: refund %s with %d refundable, want %s with %d", i, resp.Refund.Status, resp.RefundableCents, TransactionRefunded, st.wantRefundable)
+				}
+				if got := f.invoice(t, invoiceID).Status; got != st.wantStatus {
+					t.Fatalf("step %d: invoice status = %s, want %s", i, got, st.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+// slowRefunds lets the first refund reach the gateway but loses its answer.
+type slowRefunds struct {
+	*payment.FakeGateway
+	timedOut bool
+}
+
+func (g *slowRefunds) Refund(ctx context.Context, req payment.RefundRequest) (payment.Refund, error) {
+	r, err := g.FakeGateway.Refund(ctx, req)
+	if err == nil && !g.timedOut {
+		g.timedOut = true
+		return payment.Refund{}, payment.ErrTimeout
+	}
+	return r, err
+}
+
+func TestRefundTimeoutIsReplayed(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFlow()
+	refunds := NewRefundService(memUnitOfWork{repos: f.store.repos()}, &slowRefunds{FakeGateway: f.gateway})
+	invoiceID, txnID := f.paidInvoice(t, 2000)
+
+	if _, err := refunds.Refund(ctx, RefundRequest{TransactionID: txnID, AmountCents: 500}); !errors.Is(err, payment.ErrTimeout) {
+		t.Fatalf("refund error = %v, want %v", err, payment.ErrTimeout)
+	}
+	if _, err := refunds.Refund(ctx, RefundRequest{TransactionID: txnID, AmountCents: 700}); err == nil {
+		t.Fatal("another refund while one is pending succeeded")
+	}
+
+	resp, err := refunds.Refund(ctx, RefundRequest{TransactionID: txnID})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if resp.Refund.AmountCents != 500 || resp.RefundableCents != 1500 {
+		t.Fatalf("replayed refund of %d with %d refundable, want 500 with 1500", resp.Refund.AmountCents, resp.RefundableCents)
+	}
+	if got := f.invoice(t, invoiceID).Status; got != InvoicePartiallyRefunded {
+		t.Fatalf("invoice status = %s, want %s", got, InvoicePartiallyRefunded)
+	}
+	if n := len(f.transactions(t, invoiceID)); n != 2 {
+		t.Fatalf("got %d transactions, want the payment and one refund", n)
+	}
+}
+
+func TestRefundRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    func(t *testing.T, f *paymentFlow, refunds RefundService, txnID uuid.UUID) RefundRequest
+		wantStatus int
+	}{
+		{
+			name: "negative amount",
+			request: func(_ *testing.T, _ *paymentFlow, _ RefundService, txnID uuid.UUID) RefundRequest {
+				return RefundRequest{TransactionID: txnID, AmountCents: -1}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown transaction",
+			request: func(_ *testing.T, _ *paymentFlow, _ RefundService, _ uuid.UUID) RefundRequest {
+				return RefundRequest{TransactionID: uuid.New()}
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "refund of a refund",
+			request: func(t *testing.T, _ *paymentFlow, refunds RefundService, txnID uuid.UUID) RefundRequest {
+				resp, err := refunds.Refund(context.Background(), RefundRequest{TransactionID: txnID, AmountCents: 500})
+				if err != nil {
+					t.Fatalf("refund: %v", err)
+				}
+				return RefundRequest{TransactionID: resp.Refund.ID}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "failed payment",
+			request: func(_ *testing.T, f *paymentFlow, _ RefundService, txnID uuid.UUID) RefundRequest {
+				f.store.transactions[0].Status = TransactionFailed
+				return RefundRequest{TransactionID: txnID}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "payment through another gateway",
+			request: func(_ *testing.T, f *paymentFlow, _ RefundService, txnID uuid.UUID) RefundRequest {
+				f.store.transactions[0].Gateway = "stripe"
+				return RefundRequest{TransactionID: txnID}
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			refunds := NewRefundService(memUnitOfWork{repos: f.store.repos()}, f.gateway)
+			_, txnID := f.paidInvoice(t, 2000)
+
+			_, err := refunds.Refund(context.Background(), tt.request(t, f, refunds, txnID))
+			wantHTTPStatus(t, err, tt.wantStatus)
+		})
+	}
+}
+
+func TestRefundToCreditBalance(t *testing.T) {
+	ctx := context.Background()
+	f := newPaymentFlow()
+	refunds := NewRefundService(memUnitOfWork{repos: f.store.repos()}, f.gateway)
+	customer, invoice := f.addIssuedInvoice(2000)
+	f.store.customers[0].CreditBalanceCents.Int64 = 2000
+
+	if _, err := f.invoices.Pay(ctx, customer.ID, invoice.ID, PayOptions{UseCreditBalance: true}); err != nil {
+		t.Fatalf("pay: %v", err)
+	}
+	txn := f.transactions(t, invoice.ID)[0]
+	if txn.Gateway != GatewayCreditBalance {
+		t.Fatalf("payment gateway = %s, want %s", txn.Gateway, GatewayCreditBalance)
+	}
+
+	resp, err := refunds.Refund(ctx, RefundRequest{TransactionID: txn.ID, AmountCents: 800})
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if resp.Refund.Status != TransactionRefunded || resp.RefundableCents != 1200 {
+		t.Fatalf("refund %s with %d refundable, want %s with 1200", resp.Refund.Status, resp.RefundableCents, TransactionRefunded)
+	}
+	if got := f.store.customers[0].CreditBalanceCents.Int64; got != 800 {
+		t.Fatalf("credit balance = %d, want 800", got)
+	}
+	if got := f.invoice(t, invoice.ID).Status; got != InvoicePartiallyRefunded {
+		t.Fatalf("invoice status = %s, want %s", got, InvoicePartiallyRefunded)
+	}
+}
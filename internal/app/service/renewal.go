@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// SubscriptionRenewer rolls subscriptions into their next period once the
+// current one has ended. Every subscription is claimed with FOR UPDATE SKIP
+// LOCKED and renewed in its own transaction, so any number of replicas can run
+// a renewer at the same time without renewing a subscription twice.
+type SubscriptionRenewer struct {
+	uow       repository.UnitOfWork
+	invoices  *InvoiceBuilder
+	interval  time.Duration
+	batchSize int
+}
+
+func NewSubscriptionRenewer(uow repository.UnitOfWork, invoices *InvoiceBuilder, interval time.Duration, batchSize int) *SubscriptionRenewer {
+	return &SubscriptionRenewer{
+		uow:       uow,
+		invoices:  invoices,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Run renews due subscriptions every interval until ctx is canceled.
+func (r *SubscriptionRenewer) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := r.RenewDue(ctx, time.Now())
+			if err != nil {
+				logger.Error("failed to renew subscriptions", zap.Error(err))
+			}
+			if renewed > 0 {
+				logger.Info("renewed subscriptions", zap.Int("count", renewed))
+			}
+		}
+	}
+}
+
+// RenewDue renews up to batchSize subscriptions whose period ended at or
+// before now and returns how many were renewed. A subscription that fails is
+// logged and skipped for the rest of the batch; it is retried on the next call.
+func (r *SubscriptionRenewer) RenewDue(ctx context.Context, now time.Time) (int, error) {
+	var failed []uuid.UUID
+	renewed := 0
+
+	for renewed+len(failed) < r.batchSize {
+		var claimed uuid.UUID
+		err := r.uow.Do(ctx, func(repos repository.Repositories) error {
+			claimed = uuid.Nil
+
+			sub, err := repos.Subscriptions.ClaimDue(ctx, now, failed)
+			if err != nil {
+				return err
+			}
+			claimed = sub.ID
+
+			return r.renew(ctx, repos, sub, now)
+		})
+		if err != nil {
+			if claimed == uuid.Nil {
+				if errors.Is(err, E.ErrNotFound) {
+					return renewed, nil
+				}
+				return renewed, err
+			}
+
+			logger.Error("failed to renew subscription", zap.String("subscription_id", claimed.String()), zap.Error(err))
+			failed = append(failed, claimed)
+			continue
+		}
+
+		renewed++
+	}
+
+	return renewed, nil
+}
+
+// renew applies a scheduled cancellation, or starts the next period and
+// invoices its fee in advance. Usage of the ended period is invoiced in
+// arrears either way. An ended trial converts to active on its first paid
+// period.
+func (r *SubscriptionRenewer) renew(ctx context.Context, repos repository.Repositories, sub generated.Subscription, now time.Time) error {
+	plan, err := repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return E.NewInternalError("could not retrieve plan", err)
+	}
+
+	from := sub.Status
+	draft := InvoiceDraftRequest{
+		SubscriptionID: sub.ID,
+		UsageStart:     sub.CurrentPeriodStart.Time,
+		UsageEnd:       sub.CurrentPeriodEnd.Time,
+	}
+
+	if sub.CancelAtPeriodEnd.Bool {
+		sub.Status = SubscriptionCanceled
+		if !sub.CanceledAt.Valid {
+			sub.CanceledAt = sub.CurrentPeriodEnd
+		}
+		if _, err := repos.Subscriptions.UpdateState(ctx, from, sub); err != nil {
+			return E.NewInternalError("could not cancel subscription", err)
+		}
+
+		logger.Info("subscription canceled at period end", zap.String("subscription_id", sub.ID.String()))
+	} else {
+		nextEnd, err := addInterval(draft.UsageEnd, plan.Interval)
+		if err != nil {
+			return err
+		}
+		sub.Status = SubscriptionActive
+		sub.CurrentPeriodStart = timestamptz(draft.UsageEnd)
+		sub.CurrentPeriodEnd = timestamptz(nextEnd)
+
+		if _, err := repos.Subscriptions.UpdateState(ctx, from, sub); err != nil {
+			return E.NewInternalError("could not advance subscription period", err)
+		}
+
+		draft.PeriodStart = draft.UsageEnd
+		draft.PeriodEnd = nextEnd
+	}
+
+	invoice, _, err := r.invoices.Draft(ctx, repos, draft)
+	if errors.Is(err, errNothingToInvoice) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	invoice, _, err = r.invoices.Finalize(ctx, repos, invoice.ID, now)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("subscription invoiced",
+		zap.String("subscription_id", sub.ID.String()),
+		zap.String("invoice_id", invoice.ID.String()),
+		zap.Int64("amount_cents", invoice.AmountCents))
+	return nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestRenewDue(t *testing.T) {
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name              string
+		status            string
+		cancelAtPeriodEnd bool
+		now               time.Time
+		wantRenewed       int
+		wantStatus        string
+		wantPeriodEnd     time.Time
+		wantInvoices      int
+	}{
+		{
+			name:          "active period ended",
+			status:        SubscriptionActive,
+			now:           end.Add(time.Hour),
+			wantRenewed:   1,
+			wantStatus:    SubscriptionActive,
+			wantPeriodEnd: end.AddDate(0, 1, 0),
+			wantInvoices:  1,
+		},
+		{
+			name:          "trial ended",
+			status:        SubscriptionTrialing,
+			now:           end,
+			wantRenewed:   1,
+			wantStatus:    SubscriptionActive,
+			wantPeriodEnd: end.AddDate(0, 1, 0),
+			wantInvoices:  1,
+		},
+		{
+			name:              "canceled at period end",
+			status:            SubscriptionActive,
+			cancelAtPeriodEnd: true,
+			now:               end.Add(time.Hour),
+			wantRenewed:       1,
+			wantStatus:        SubscriptionCanceled,
+			wantPeriodEnd:     end,
+		},
+		{
+			name:          "two periods behind",
+			status:        SubscriptionActive,
+			now:           end.AddDate(0, 1, 1),
+			wantRenewed:   2,
+			wantStatus:    SubscriptionActive,
+			wantPeriodEnd: end.AddDate(0, 2, 0),
+			wantInvoices:  2,
+		},
+		{
+			name:          "not due",
+			status:        SubscriptionActive,
+			now:           end.Add(-time.Second),
+			wantStatus:    SubscriptionActive,
+			wantPeriodEnd: end,
+		},
+		{
+			name:          "past due is left alone",
+			status:        SubscriptionPastDue,
+			now:           end.Add(time.Hour),
+			wantStatus:    SubscriptionPastDue,
+			wantPeriodEnd: end,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repos := store.repos()
+			renewer := NewSubscriptionRenewer(memUnitOfWork{repos: repos}, NewInvoiceBuilder(7*24*time.Hour, InvoiceNumbering{DefaultPrefix: "INV"}), time.Minute, 10)
+
+			plan := &generated.Plan{ID: uuid.New(), Name: "Pro", PriceCents: 1000, Currency: "USD", Interval: "month"}
+			customer := &generated.Customer{ID: uuid.New(), CreditBalanceCents: pgtype.Int8{Valid: true}}
+			store.plans = append(store.plans, plan)
+			store.customers = append(store.customers, customer)
+			store.subscriptions = append(store.subscriptions, &generated.Subscription{
+				ID:                 uuid.New(),
+				CustomerID:         pgtype.UUID{Bytes: customer.ID, Valid: true},
+				PlanID:             pgtype.UUID{Bytes: plan.ID, Valid: true},
+				Status:             tt.status,
+				CurrentPeriodStart: timestamptz(start),
+				CurrentPeriodEnd:   timestamptz(end),
+				CancelAtPeriodEnd:  pgtype.Bool{Bool: tt.cancelAtPeriodEnd, Valid: true},
+			})
+
+			renewed, err := renewer.RenewDue(context.Background(), tt.now)
+			if err != nil || renewed != tt.wantRenewed {
+				t.Fatalf("RenewDue = %d, %v; want %d", renewed, err, tt.wantRenewed)
+			}
+
+			sub := store.subscriptions[0]
+			if sub.Status != tt.wantStatus || !sub.CurrentPeriodEnd.Time.Equal(tt.wantPeriodEnd) {
+				t.Fatalf("subscription %s until %s, want %s until %s", sub.Status, sub.CurrentPeriodEnd.Time, tt.wantStatus, tt.wantPeriodEnd)
+			}
+			if len(store.invoices) != tt.wantInvoices {
+				t.Fatalf("got %d invoices, want %d", len(store.invoices), tt.wantInvoices)
+			}
+			for _, inv := range store.invoices {
+				if inv.Status != InvoiceIssued || inv.AmountCents != plan.PriceCents {
+					t.Fatalf("invoice %s of %d, want %s of %d", inv.Status, inv.AmountCents, InvoiceIssued, plan.PriceCents)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,513 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// Subscription statuses as stored in subscriptions.status. Pending
+// subscriptions wait for their checkout payment.
+const (
+	SubscriptionPending  = "pending"
+	SubscriptionTrialing = "trialing"
+	SubscriptionActive   = "active"
+	SubscriptionPastDue  = "past_due"
+	SubscriptionCanceled = "canceled"
+)
+
+// subscriptionTransitions lists the statuses each status may move to.
+// Canceled is terminal; a customer has to subscribe again.
+var subscriptionTransitions = map[string][]string{
+	SubscriptionPending:  {SubscriptionActive, SubscriptionCanceled},
+	SubscriptionTrialing: {SubscriptionActive, SubscriptionPastDue, SubscriptionCanceled},
+	SubscriptionActive:   {SubscriptionPastDue, SubscriptionCanceled},
+	SubscriptionPastDue:  {SubscriptionActive, SubscriptionCanceled},
+	SubscriptionCanceled: {},
+}
+
+type SubscriptionResponse struct {
+	ID                 uuid.UUID    `json:"id"`
+	CustomerID         uuid.UUID    `json:"customer_id"`
+	Plan               PlanResponse `json:"plan"`
+	Status             string       `json:"status"`
+	TrialEndsAt        *time.Time   `json:"trial_ends_at"`
+	CurrentPeriodStart *time.Time   `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time   `json:"current_period_end"`
+	CancelAtPeriodEnd  bool         `json:"cancel_at_period_end"`
+	CanceledAt         *time.Time   `json:"canceled_at"`
+	CreatedAt          time.Time    `json:"created_at"`
+}
+
+type SubscriptionService interface {
+	Subscribe(ctx context.Context, customerID uuid.UUID, planSlug string) (SubscriptionResponse, error)
+	StartTrial(ctx context.Context, customerID uuid.UUID, planSlug string, trialDays int) (SubscriptionResponse, error)
+	FindCurrent(ctx context.Context, customerID uuid.UUID) (SubscriptionResponse, error)
+	Activate(ctx context.Context, id uuid.UUID) (SubscriptionResponse, error)
+	MarkPastDue(ctx context.Context, id uuid.UUID) (SubscriptionResponse, error)
+	Cancel(ctx context.Context, id uuid.UUID, atPeriodEnd bool, reason string) (SubscriptionResponse, error)
+	Reactivate(ctx context.Context, id uuid.UUID) (SubscriptionResponse, error)
+	ChangePlan(ctx context.Context, id, planID uuid.UUID, prorate bool) (PlanChangeResponse, error)
+	PreviewPlanChange(ctx context.Context, id, planID uuid.UUID) (Proration, error)
+}
+
+type subscriptionService struct {
+	repos    repository.Repositories
+	uow      repository.UnitOfWork
+	invoices *InvoiceBuilder
+}
+
+func NewSubscriptionService(repos repository.Repositories, uow repository.UnitOfWork, invoices *InvoiceBuilder) SubscriptionService {
+	return &subscriptionService{repos: repos, uow: uow, invoices: invoices}
+}
+
+// Subscribe starts a paid subscription whose first period begins now.
+func (s *subscriptionService) Subscribe(ctx context.Context, customerID uuid.UUID, planSlug string) (SubscriptionResponse, error) {
+	return s.create(ctx, customerID, planSlug, 0)
+}
+
+// StartTrial starts a trial that converts to a paid period once activated.
+// The trial occupies the first period so renewal picks it up when it ends.
+func (s *subscriptionService) StartTrial(ctx context.Context, customerID uuid.UUID, planSlug string, trialDays int) (SubscriptionResponse, error) {
+	if trialDays <= 0 {
+		return SubscriptionResponse{}, E.NewInvalidInputError("trial days must be positive", nil)
+	}
+	return s.create(ctx, customerID, planSlug, trialDays)
+}
+
+func (s *subscriptionService) create(ctx context.Context, customerID uuid.UUID, planSlug string, trialDays int) (SubscriptionResponse, error) {
+	plan, err := s.repos.Plans.FindBySlug(ctx, planSlug)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return SubscriptionResponse{}, E.NewNotFoundError("plan", "plan with given slug does not exist")
+		}
+		return SubscriptionResponse{}, E.NewInternalError("could not retrieve plan", err)
+	}
+
+	if _, err := s.repos.Subscriptions.FindCurrentByCustomer(ctx, customerID); err == nil {
+		return SubscriptionResponse{}, E.NewAlreadyExistsError("subscription", "customer already has a subscription that is not canceled")
+	} else if !errors.Is(err, E.ErrNotFound) {
+		return SubscriptionResponse{}, E.NewInternalError("could not retrieve subscription", err)
+	}
+
+	now := time.Now()
+	arg := generated.CreateSubscriptionParams{
+		CustomerID:         pgtype.UUID{Bytes: customerID, Valid: true},
+		PlanID:             pgtype.UUID{Bytes: plan.ID, Valid: true},
+		Status:             SubscriptionActive,
+		CurrentPeriodStart: timestamptz(now),
+	}
+
+	if trialDays > 0 {
+		trialEnd := now.AddDate(0, 0, trialDays)
+		arg.Status = SubscriptionTrialing
+		arg.TrialEndsAt = timestamptz(trialEnd)
+		arg.CurrentPeriodEnd = timestamptz(trialEnd)
+	} else {
+		periodEnd, err := addInterval(now, plan.Interval)
+		if err != nil {
+			return SubscriptionResponse{}, err
+		}
+		arg.CurrentPeriodEnd = timestamptz(periodEnd)
+	}
+
+	sub, err := s.repos.Subscriptions.Create(ctx, arg)
+	if err != nil {
+		return SubscriptionResponse{}, E.NewInternalError("could not create subscription", err)
+	}
+
+	return convertSubscription(sub, plan)
+}
+
+func (s *subscriptionService) FindCurrent(ctx context.Context, customerID uuid.UUID) (SubscriptionResponse, error) {
+	sub, err := s.repos.Subscriptions.FindCurrentByCustomer(ctx, customerID)
+	if err != nil {
+		return SubscriptionResponse{}, s.wrapError(err, "could not retrieve subscription")
+	}
+
+	return s.withPlan(ctx, sub)
+}
+
+// Activate moves a trialing or past_due subscription to active. Converting a
+// trial starts a fresh paid period; recovering from past_due keeps the period.
+func (s *subscriptionService) Activate(ctx context.Context, id uuid.UUID) (SubscriptionResponse, error) {
+	return s.transition(ctx, s.repos, id, SubscriptionActive, func(sub *generated.Subscription, plan generated.Plan, now time.Time) error {
+		if sub.Status != SubscriptionTrialing {
+			return nil
+		}
+
+		periodEnd, err := addInterval(now, plan.Interval)
+		if err != nil {
+			return err
+		}
+		sub.CurrentPeriodStart = timestamptz(now)
+		sub.CurrentPeriodEnd = timestamptz(periodEnd)
+		return nil
+	})
+}
+
+func (s *subscriptionService) MarkPastDue(ctx context.Context, id uuid.UUID) (SubscriptionResponse, error) {
+	return s.transition(ctx, s.repos, id, SubscriptionPastDue, nil)
+}
+
+// Cancel ends a subscription now, or schedules it to end with the current
+// period. A scheduled cancellation keeps the status until renewal applies it.
+// The optional reason is kept in the subscription metadata.
+func (s *subscriptionService) Cancel(ctx context.Context, id uuid.UUID, atPeriodEnd bool, reason string) (SubscriptionResponse, error) {
+	var resp SubscriptionResponse
+	err := s.uow.Do(ctx, func(repos repository.Repositories) error {
+		var err error
+		if atPeriodEnd {
+			resp, err = s.scheduleCancel(ctx, repos, id)
+		} else {
+			resp, err = s.transition(ctx, repos, id, SubscriptionCanceled, func(sub *generated.Subscription, _ generated.Plan, now time.Time) error {
+				sub.CancelAtPeriodEnd = pgtype.Bool{Bool: false, Valid: true}
+				sub.CanceledAt = timestamptz(now)
+				return nil
+			})
+		}
+		if err != nil || reason == "" {
+			return err
+		}
+
+		patch, err := json.Marshal(map[string]string{"cancel_reason": reason})
+		if err != nil {
+			return E.NewInternalError("could not encode cancel reason", err)
+		}
+		if err := repos.Subscriptions.MergeMetadata(ctx, id, patch); err != nil {
+			return E.NewInternalError("could not store cancel reason", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return SubscriptionResponse{}, err
+	}
+
+	return resp, nil
+}
+
+func (s *subscriptionService) scheduleCancel(ctx context.Context, repos repository.Repositories, id uuid.UUID) (SubscriptionResponse, error) {
+	return s.update(ctx, repos, id, func(sub *generated.Subscription, _ generated.Plan, now time.Time) error {
+		if sub.Status != SubscriptionActive && sub.Status != SubscriptionTrialing {
+			return E.NewInvalidTransitionError("subscription", sub.Status, "cancel at period end")
+		}
+		if sub.CancelAtPeriodEnd.Bool {
+			return E.NewInvalidInputError("subscription is already set to cancel at period end", nil)
+		}
+
+		sub.CancelAtPeriodEnd = pgtype.Bool{Bool: true, Valid: true}
+		sub.CanceledAt = timestamptz(now)
+		return nil
+	})
+}
+
+// Reactivate withdraws a scheduled cancellation before the period ends.
+func (s *subscriptionService) Reactivate(ctx context.Context, id uuid.UUID) (SubscriptionResponse, error) {
+	return s.update(ctx, s.repos, id, func(sub *generated.Subscription, _ generated.Plan, now time.Time) error {
+		if !sub.CancelAtPeriodEnd.Bool || sub.Status == SubscriptionCanceled {
+			return E.NewInvalidTransitionError("subscription", sub.Status, "reactivated")
+		}
+		if sub.CurrentPeriodEnd.Valid && !now.Before(sub.CurrentPeriodEnd.Time) {
+			return E.NewInvalidInputError("subscription period has already ended", nil)
+		}
+
+		sub.CancelAtPeriodEnd = pgtype.Bool{Bool: false, Valid: true}
+		sub.CanceledAt = pgtype.Timestamptz{}
+		return nil
+	})
+}
+
+// PlanChangeResponse is the result of a plan change. Proration is nil when the
+// change was not prorated; Invoice is set when the proration was invoiced
+// immediately.
+type PlanChangeResponse struct {
+	Subscription SubscriptionResponse `json:"subscription"`
+	Proration    *Proration           `json:"proration"`
+	Invoice      *InvoiceResponse     `json:"invoice"`
+}
+
+// ChangePlan moves a trialing or active subscription to another plan. When
+// prorate is set, active subscriptions are charged for the change: a positive
+// amount due is invoiced right away and a net credit is left as pending line
+// items for the next invoice. Otherwise, and for trials, the current period is
+// kept and the new price applies from the next renewal.
+func (s *subscriptionService) ChangePlan(ctx context.Context, id, planID uuid.UUID, prorate bool) (PlanChangeResponse, error) {
+	var resp PlanChangeResponse
+	err := s.uow.Do(ctx, func(repos repository.Repositories) error {
+		sub, oldPlan, newPlan, err := s.loadPlanChange(ctx, repos, id, planID)
+		if err != nil {
+			return err
+		}
+
+		from := sub.Status
+		sub.PlanID = pgtype.UUID{Bytes: newPlan.ID, Valid: true}
+
+		var proration *Proration
+		var usage []generated.CreateInvoiceLineItemParams
+		if prorate && from == SubscriptionActive {
+			p, err := calculateProration(oldPlan, newPlan, sub.CurrentPeriodStart.Time, sub.CurrentPeriodEnd.Time, time.Now())
+			if err != nil {
+				return err
+			}
+			// A new period leaves the usage of the old one behind, and renewal
+			// only invoices the period it ends, so the old usage is billed here
+			if start := sub.CurrentPeriodStart.Time; !p.PeriodStart.Equal(start) {
+				usage, err = s.invoices.usageLines(ctx, repos, sub, oldPlan, start, p.ProratedAt)
+				if err != nil {
+					return err
+				}
+			}
+			sub.CurrentPeriodStart = timestamptz(p.PeriodStart)
+			sub.CurrentPeriodEnd = timestamptz(p.PeriodEnd)
+			proration = &p
+		}
+
+		updated, err := repos.Subscriptions.UpdatePlan(ctx, from, sub)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.NewInvalidTransitionError("subscription", from, "change plan")
+			}
+			return E.NewInternalError("could not change subscription plan", err)
+		}
+
+		resp.Subscription, err = convertSubscription(updated, newPlan)
+		if err != nil {
+			return err
+		}
+		if proration == nil {
+			return nil
+		}
+
+		resp.Proration = proration
+		resp.Invoice, err = s.recordProration(ctx, repos, updated, *proration, usage)
+		return err
+	})
+	if err != nil {
+		return PlanChangeResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// PreviewPlanChange computes what ChangePlan would charge or credit right now
+// without changing anything.
+func (s *subscriptionService) PreviewPlanChange(ctx context.Context, id, planID uuid.UUID) (Proration, error) {
+	sub, oldPlan, newPlan, err := s.loadPlanChange(ctx, s.repos, id, planID)
+	if err != nil {
+		return Proration{}, err
+	}
+
+	now := time.Now()
+	if sub.Status != SubscriptionActive {
+		return Proration{
+			ProratedAt:  now,
+			Currency:    newPlan.Currency,
+			PeriodStart: sub.CurrentPeriodStart.Time,
+			PeriodEnd:   sub.CurrentPeriodEnd.Time,
+			Lines:       []ProrationLine{},
+		}, nil
+	}
+
+	return calculateProration(oldPlan, newPlan, sub.CurrentPeriodStart.Time, sub.CurrentPeriodEnd.Time, now)
+}
+
+// loadPlanChange loads the subscription with its current and requested plan
+// and checks that the change is allowed.
+func (s *subscriptionService) loadPlanChange(ctx context.Context, repos repository.Repositories, id, planID uuid.UUID) (generated.Subscription, generated.Plan, generated.Plan, error) {
+	var none generated.Plan
+
+	sub, err := repos.Subscriptions.FindByID(ctx, id)
+	if err != nil {
+		return generated.Subscription{}, none, none, s.wrapError(err, "could not retrieve subscription")
+	}
+	if sub.Status != SubscriptionActive && sub.Status != SubscriptionTrialing {
+		return generated.Subscription{}, none, none, E.NewInvalidTransitionError("subscription", sub.Status, "change plan")
+	}
+	if sub.PlanID.Valid && uuid.UUID(sub.PlanID.Bytes) == planID {
+		return generated.Subscription{}, none, none, E.NewInvalidInputError("subscription is already on this plan", nil)
+	}
+
+	oldPlan, err := repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return generated.Subscription{}, none, none, E.NewInternalError("could not retrieve plan", err)
+	}
+
+	newPlan, err := repos.Plans.FindByID(ctx, planID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return generated.Subscription{}, none, none, E.NewNotFoundError("plan")
+		}
+		return generated.Subscription{}, none, none, E.NewInternalError("could not retrieve plan", err)
+	}
+
+	return sub, oldPlan, newPlan, nil
+}
+
+// recordProration stores the proration lines together with the usage lines
+// of a period the change closed. When money is owed they go on an invoice
+// issued now; otherwise they stay pending for the next invoice.
+func (s *subscriptionService) recordProration(ctx context.Context, repos repository.Repositories, sub generated.Subscription, p Proration, usage []generated.CreateInvoiceLineItemParams) (*InvoiceResponse, error) {
+	lines := make([]generated.CreateInvoiceLineItemParams, 0, len(p.Lines)+len(usage))
+	for _, line := range p.Lines {
+		lines = append(lines, generated.CreateInvoiceLineItemParams{
+			CustomerID:      sub.CustomerID.Bytes,
+			SubscriptionID:  pgtype.UUID{Bytes: sub.ID, Valid: true},
+			Kind:            LineItemProration,
+			Description:     line.Description,
+			Quantity:        1,
+			UnitAmountCents: line.AmountCents,
+			AmountCents:     line.AmountCents,
+			Currency:        p.Currency,
+			PeriodStart:     timestamptz(line.PeriodStart),
+			PeriodEnd:       timestamptz(line.PeriodEnd),
+		})
+	}
+
+	for _, line := range usage {
+		line.CustomerID = sub.CustomerID.Bytes
+		line.SubscriptionID = pgtype.UUID{Bytes: sub.ID, Valid: true}
+		lines = append(lines, line)
+	}
+
+	if !p.InvoiceNow {
+		for _, line := range lines {
+			if _, err := repos.Invoices.CreateLineItem(ctx, line); err != nil {
+				return nil, E.NewInternalError("could not create proration line item", err)
+			}
+		}
+		return nil, nil
+	}
+
+	draft, _, err := s.invoices.Draft(ctx, repos, InvoiceDraftRequest{SubscriptionID: sub.ID, Lines: lines})
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, items, err := s.invoices.Finalize(ctx, repos, draft.ID, p.ProratedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := convertInvoice(invoice, items)
+	return &resp, nil
+}
+
+type subscriptionMutation func(sub *generated.Subscription, plan generated.Plan, now time.Time) error
+
+// transition validates a status change against subscriptionTransitions and
+// applies mutate to the remaining lifecycle fields before persisting.
+func (s *subscriptionService) transition(ctx context.Context, repos repository.Repositories, id uuid.UUID, to string, mutate subscriptionMutation) (SubscriptionResponse, error) {
+	return s.update(ctx, repos, id, func(sub *generated.Subscription, plan generated.Plan, now time.Time) error {
+		if !canTransition(sub.Status, to) {
+			return E.NewInvalidTransitionError("subscription", sub.Status, to)
+		}
+		if mutate != nil {
+			if err := mutate(sub, plan, now); err != nil {
+				return err
+			}
+		}
+		sub.Status = to
+		return nil
+	})
+}
+
+// update loads the subscription, applies mutate and stores the result guarded
+// by the status it was loaded with, so concurrent transitions cannot both win.
+func (s *subscriptionService) update(ctx context.Context, repos repository.Repositories, id uuid.UUID, mutate subscriptionMutation) (SubscriptionResponse, error) {
+	sub, err := repos.Subscriptions.FindByID(ctx, id)
+	if err != nil {
+		return SubscriptionResponse{}, s.wrapError(err, "could not retrieve subscription")
+	}
+
+	plan, err := repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return SubscriptionResponse{}, E.NewInternalError("could not retrieve plan", err)
+	}
+
+	from := sub.Status
+	if err := mutate(&sub, plan, time.Now()); err != nil {
+		return SubscriptionResponse{}, err
+	}
+
+	updated, err := repos.Subscriptions.UpdateState(ctx, from, sub)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return SubscriptionResponse{}, E.NewInvalidTransitionError("subscription", from, sub.Status)
+		}
+		return SubscriptionResponse{}, E.NewInternalError("could not update subscription", err)
+	}
+
+	return convertSubscription(updated, plan)
+}
+
+func (s *subscriptionService) withPlan(ctx context.Context, sub generated.Subscription) (SubscriptionResponse, error) {
+	plan, err := s.repos.Plans.FindByID(ctx, sub.PlanID.Bytes)
+	if err != nil {
+		return SubscriptionResponse{}, E.NewInternalError("could not retrieve plan", err)
+	}
+	return convertSubscription(sub, plan)
+}
+
+func (s *subscriptionService) wrapError(err error, msg string) error {
+	if errors.Is(err, E.ErrNotFound) {
+		return E.NewNotFoundError("subscription")
+	}
+	return E.NewInternalError(msg, err)
+}
+
+func convertSubscription(sub generated.Subscription, plan generated.Plan) (SubscriptionResponse, error) {
+	planResp, err := convertPlan(plan)
+	if err != nil {
+		return SubscriptionResponse{}, err
+	}
+
+	return SubscriptionResponse{
+		ID:                 sub.ID,
+		CustomerID:         sub.CustomerID.Bytes,
+		Plan:               planResp,
+		Status:             sub.Status,
+		TrialEndsAt:        timePtr(sub.TrialEndsAt),
+		CurrentPeriodStart: timePtr(sub.CurrentPeriodStart),
+		CurrentPeriodEnd:   timePtr(sub.CurrentPeriodEnd),
+		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd.Bool,
+		CanceledAt:         timePtr(sub.CanceledAt),
+		CreatedAt:          sub.CreatedAt.Time,
+	}, nil
+}
+
+func canTransition(from, to string) bool {
+	return slices.Contains(subscriptionTransitions[from], to)
+}
+
+// addInterval returns the end of a billing period of the given plan interval.
+func addInterval(start time.Time, interval string) (time.Time, error) {
+	switch interval {
+	case "month":
+		return start.AddDate(0, 1, 0), nil
+	case "year":
+		return start.AddDate(1, 0, 0), nil
+	default:
+		return time.Time{}, E.NewInternalError(fmt.Sprintf("unsupported plan interval %q", interval), nil)
+	}
+}
+
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
+
+func timePtr(ts pgtype.Timestamptz) *time.Time {
+	if !ts.Valid {
+		return nil
+	}
+	return &ts.Time
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{SubscriptionPending, SubscriptionActive, true},
+		{SubscriptionPending, SubscriptionPastDue, false},
+		{SubscriptionTrialing, SubscriptionActive, true},
+		{SubscriptionTrialing, SubscriptionPastDue, true},
+		{SubscriptionActive, SubscriptionPastDue, true},
+		{SubscriptionActive, SubscriptionTrialing, false},
+		{SubscriptionPastDue, SubscriptionActive, true},
+		{SubscriptionPastDue, SubscriptionCanceled, true},
+		{SubscriptionCanceled, SubscriptionActive, false},
+		{SubscriptionActive, SubscriptionActive, false},
+		{"unknown", SubscriptionActive, false},
+	}
+
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%s, %s) = %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	type step struct {
+		name           string
+		do             func(svc SubscriptionService, id uuid.UUID) (SubscriptionResponse, error)
+		wantStatus     string
+		wantHTTPStatus int
+	}
+
+	activate := step{name: "activate", do: func(svc SubscriptionService, id uuid.UUID) (SubscriptionResponse, error) {
+		return svc.Activate(context.Background(), id)
+	}}
+	pastDue := step{name: "past due", do: func(svc SubscriptionService, id uuid.UUID) (SubscriptionResponse, error) {
+		return svc.MarkPastDue(context.Background(), id)
+	}}
+	cancel := step{name: "cancel", do: func(svc SubscriptionService, id uuid.UUID) (SubscriptionResponse, error) {
+		return svc.Cancel(context.Background(), id, false, "")
+	}}
+	expect := func(s step, status string) step {
+		s.wantStatus = status
+		return s
+	}
+	reject := func(s step, httpStatus int) step {
+		s.wantHTTPStatus = httpStatus
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		trial bool
+		steps []step
+	}{
+		{
+			name:  "trial converts",
+			trial: true,
+			steps: []step{expect(activate, SubscriptionActive), expect(cancel, SubscriptionCanceled)},
+		},
+		{
+			name: "past due recovers",
+			steps: []step{
+				expect(pastDue, SubscriptionPastDue),
+				reject(pastDue, http.StatusConflict),
+				expect(activate, SubscriptionActive),
+			},
+		},
+		{
+			name:  "canceled is terminal",
+			trial: true,
+			steps: []step{
+				expect(cancel, SubscriptionCanceled),
+				reject(activate, http.StatusConflict),
+				reject(cancel, http.StatusConflict),
+			},
+		},
+		{
+			name:  "active cannot be activated again",
+			steps: []step{reject(activate, http.StatusConflict)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repos := store.repos()
+			svc := NewSubscriptionService(repos, memUnitOfWork{repos: repos}, nil)
+			store.plans = append(store.plans, &generated.Plan{ID: uuid.New(), Slug: "pro", Name: "Pro", PriceCents: 1000, Currency: "USD", Interval: "month"})
+
+			var sub SubscriptionResponse
+			var err error
+			if tt.trial {
+				sub, err = svc.StartTrial(context.Background(), uuid.New(), "pro", 14)
+			} else {
+				sub, err = svc.Subscribe(context.Background(), uuid.New(), "pro")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, st := range tt.steps {
+				got, err := st.do(svc, sub.ID)
+				if st.wantHTTPStatus != 0 {
+					var appErr *E.AppError
+					if !errors.As(err, &appErr) || appErr.HTTPStatus() != st.wantHTTPStatus {
+						t.Fatalf("%s: error = %v, want status %d", st.name, err, st.wantHTTPStatus)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", st.name, err)
+				}
+				if got.Status != st.wantStatus {
+					t.Fatalf("%s: status = %s, want %s", st.name, got.Status, st.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestActivateTrialStartsPaidPeriod(t *testing.T) {
+	store := newMemStore()
+	repos := store.repos()
+	svc := NewSubscriptionService(repos, memUnitOfWork{repos: repos}, nil)
+	store.plans = append(store.plans, &generated.Plan{ID: uuid.New(), Slug: "pro", Name: "Pro", PriceCents: 1000, Currency: "USD", Interval: "month"})
+
+	trial, err := svc.StartTrial(context.Background(), uuid.New(), "pro", 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := trial.CurrentPeriodEnd.Sub(*trial.CurrentPeriodStart); got != 14*24*time.Hour {
+		t.Fatalf("trial period lasts %s, want 14 days", got)
+	}
+
+	sub, err := svc.Activate(context.Background(), trial.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := addInterval(*sub.CurrentPeriodStart, "month")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub.CurrentPeriodStart.After(*trial.CurrentPeriodStart) || !sub.CurrentPeriodEnd.Equal(want) {
+		t.Fatalf("paid period %s - %s, want a month starting at activation", sub.CurrentPeriodStart, sub.CurrentPeriodEnd)
+	}
+}
+
+func TestCancelAndReactivate(t *testing.T) {
+	tests := []struct {
+		name           string
+		atPeriodEnd    bool
+		reason         string
+		cancelTwice    bool
+		reactivate     bool
+		wantStatus     string
+		wantScheduled  bool
+		wantHTTPStatus int
+	}{
+		{
+			name:       "now with a reason",
+			reason:     "too expensive",
+			wantStatus: SubscriptionCanceled,
+		},
+		{
+			name:          "at period end",
+			atPeriodEnd:   true,
+			wantStatus:    SubscriptionActive,
+			wantScheduled: true,
+		},
+		{
+			name:           "at period end twice",
+			atPeriodEnd:    true,
+			cancelTwice:    true,
+			wantHTTPStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "reactivated before the period ends",
+			atPeriodEnd: true,
+			reason:      "switching",
+			reactivate:  true,
+			wantStatus:  SubscriptionActive,
+		},
+		{
+			name:           "reactivated after canceling now",
+			reactivate:     true,
+			wantHTTPStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemStore()
+			repos := store.repos()
+			svc := NewSubscriptionService(repos, memUnitOfWork{repos: repos}, nil)
+			store.plans = append(store.plans, &generated.Plan{ID: uuid.New(), Slug: "pro", Name: "Pro", PriceCents: 1000, Currency: "USD", Interval: "month"})
+
+			sub, err := svc.Subscribe(ctx, uuid.New(), "pro")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := svc.Cancel(ctx, sub.ID, tt.atPeriodEnd, tt.reason)
+			if err == nil && tt.cancelTwice {
+				resp, err = svc.Cancel(ctx, sub.ID, tt.atPeriodEnd, tt.reason)
+			}
+			if err == nil && tt.reactivate {
+				resp, err = svc.Reactivate(ctx, sub.ID)
+			}
+			if tt.wantHTTPStatus != 0 {
+				var appErr *E.AppError
+				if !errors.As(err, &appErr) || appErr.HTTPStatus() != tt.wantHTTPStatus {
+					t.Fatalf("error = %v, want status %d", err, tt.wantHTTPStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Status != tt.wantStatus || resp.CancelAtPeriodEnd != tt.wantScheduled {
+				t.Fatalf("status %s, cancel at period end %t; want %s, %t", resp.Status, resp.CancelAtPeriodEnd, tt.wantStatus, tt.wantScheduled)
+			}
+			if (resp.CanceledAt != nil) != (tt.wantStatus == SubscriptionCanceled || tt.wantScheduled) {
+				t.Fatalf("canceled_at = %v", resp.CanceledAt)
+			}
+
+			var meta map[string]string
+			if err := json.Unmarshal(store.subscriptions[0].Metadata, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if meta["cancel_reason"] != tt.reason {
+				t.Fatalf("cancel reason = %q, want %q", meta["cancel_reason"], tt.reason)
+			}
+		})
+	}
+}
+
+func TestChangePlanIntervalBillsOldPeriodUsage(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    generated.Plan
+		wantInvoice bool
+	}{
+		{
+			name:        "upgrade is invoiced now",
+			from:        generated.Plan{ID: uuid.New(), Name: "Pro", PriceCents: 1000, Currency: "USD", Interval: "month"},
+			to:          generated.Plan{ID: uuid.New(), Name: "Pro yearly", PriceCents: 10000, Currency: "USD", Interval: "year"},
+			wantInvoice: true,
+		},
+		{
+			name: "net credit stays pending",
+			from: generated.Plan{ID: uuid.New(), Name: "Pro yearly", PriceCents: 10000, Currency: "USD", Interval: "year"},
+			to:   generated.Plan{ID: uuid.New(), Name: "Pro", PriceCents: 100, Currency: "USD", Interval: "month"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repos := store.repos()
+			svc := NewSubscriptionService(repos, memUnitOfWork{repos: repos}, NewInvoiceBuilder(7*24*time.Hour, InvoiceNumbering{DefaultPrefix: "INV"}))
+
+			from, to := tt.from, tt.to
+			store.plans = append(store.plans, &from, &to)
+			customer := &generated.Customer{ID: uuid.New(), CreditBalanceCents: pgtype.Int8{Valid: true}}
+			store.customers = append(store.customers, customer)
+
+			start := time.Now().UTC().AddDate(0, 0, -10).Truncate(24 * time.Hour)
+			end, err := addInterval(start, from.Interval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub, err := repos.Subscriptions.Create(context.Background(), generated.CreateSubscriptionParams{
+				CustomerID:         pgtype.UUID{Bytes: customer.ID, Valid: true},
+				PlanID:             pgtype.UUID{Bytes: from.ID, Valid: true},
+				Status:             SubscriptionActive,
+				CurrentPeriodStart: timestamptz(start),
+				CurrentPeriodEnd:   timestamptz(end),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.aggregates = append(store.aggregates, &generated.UsageAggregate{
+				CustomerID:     sub.CustomerID,
+				PeriodStart:    pgtype.Date{Time: start, Valid: true},
+				PeriodEnd:      pgtype.Date{Time: end, Valid: true},
+				Metric:         "requests",
+				TotalQuantity:  pgtype.Int8{Int64: 500, Valid: true},
+				TotalCostCents: pgtype.Int8{Int64: 250, Valid: true},
+			})
+
+			resp, err := svc.ChangePlan(context.Background(), sub.ID, to.ID, true)
+			if err != nil {
+				t.Fatalf("change plan: %v", err)
+			}
+			if got := resp.Subscription.CurrentPeriodStart; !got.After(start) {
+				t.Fatalf("period starts %s, want a new period after %s", got, start)
+			}
+			if (resp.Invoice != nil) != tt.wantInvoice {
+				t.Fatalf("invoice = %v, want invoiced now: %t", resp.Invoice, tt.wantInvoice)
+			}
+
+			var usage []*generated.InvoiceLineItem
+			for _, item := range store.lineItems {
+				if item.Kind == LineItemUsage {
+					usage = append(usage, item)
+				}
+			}
+			if len(usage) != 1 {
+				t.Fatalf("got %d usage lines, want 1", len(usage))
+			}
+			line := usage[0]
+			if line.Quantity != 500 || line.AmountCents != 250 || !line.PeriodStart.Time.Equal(start) {
+				t.Fatalf("usage line = %d units, %d cents from %s; want 500, 250 from %s", line.Quantity, line.AmountCents, line.PeriodStart.Time, start)
+			}
+			if line.InvoiceID.Valid != tt.wantInvoice {
+				t.Fatalf("usage line on invoice: %t, want %t", line.InvoiceID.Valid, tt.wantInvoice)
+			}
+		})
+	}
+}
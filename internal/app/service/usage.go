@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+)
+
+// maxUsageClockSkew is how far in the future a client timestamp may be.
+const maxUsageClockSkew = 5 * time.Minute
+
+// maxUsageEventIDLength caps client-supplied event IDs.
+const maxUsageEventIDLength = 255
+
+// MaxUsageBatchSize is the most events one batch report may carry.
+const MaxUsageBatchSize = 1000
+
+// Outcomes of the events of a batch report.
+const (
+	UsageEventAccepted  = "accepted"
+	UsageEventDuplicate = "duplicate"
+	UsageEventRejected  = "rejected"
+)
+
+// UsageReport is one usage event sent by a customer's service. EventID is
+// chosen by the client and makes retried reports count once.
+type UsageReport struct {
+	EventID   string
+	Metric    string
+	Quantity  int64
+	Timestamp time.Time // zero means now
+	Metadata  map[string]any
+}
+
+type UsageEventResponse struct {
+	ID         uuid.UUID      `json:"id"`
+	EventID    string         `json:"event_id"`
+	Metric     string         `json:"metric"`
+	Quantity   int64          `json:"quantity"`
+	Timestamp  time.Time      `json:"timestamp"`
+	RecordedAt time.Time      `json:"recorded_at"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+	// Duplicate is set when the event ID was already reported; the stored
+	// event is returned and nothing new is recorded.
+	Duplicate bool `json:"duplicate"`
+	// OverQuota is set when the event was accepted beyond a soft or overage
+	// limit of the customer's plan.
+	OverQuota bool `json:"over_quota"`
+}
+
+// UsageBatchResult is the outcome of one event of a batch report, in the
+// order the events were sent. Duplicates were already recorded, so only
+// rejected events should be fixed and sent again.
+type UsageBatchResult struct {
+	Index     int        `json:"index"`
+	EventID   string     `json:"event_id"`
+	Status    string     `json:"status"`
+	ID        *uuid.UUID `json:"id,omitempty"`
+	OverQuota bool       `json:"over_quota,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// UsageBatchResponse counts accepted and duplicate events as processed and
+// rejected events as failed.
+type UsageBatchResponse struct {
+	ProcessedCount int                `json:"processed_count"`
+	FailedCount    int                `json:"failed_count"`
+	Results        []UsageBatchResult `json:"results"`
+}
+
+type UsageService interface {
+	// Report records a usage event for the customer of the API key. The
+	// metric must be limited by the quota_limits of the customer's plan, and
+	// an event that would exceed a hard limit is rejected.
+	Report(ctx context.Context, principal CustomerPrincipal, report UsageReport) (UsageEventResponse, error)
+	// BatchReport records up to MaxUsageBatchSize events in one insert.
+	// Invalid events are rejected one by one without failing the batch.
+	BatchReport(ctx context.Context, principal CustomerPrincipal, reports []UsageReport) (UsageBatchResponse, error)
+	// CheckQuota tells whether the customer may use the needed quantities,
+	// keyed by metric, in the current period.
+	CheckQuota(ctx context.Context, principal CustomerPrincipal, needed map[string]int64) (QuotaCheckResponse, error)
+}
+
+type usageService struct {
+	repos repository.Repositories
+	quota *QuotaTracker
+}
+
+func NewUsageService(repos repository.Repositories, quota *QuotaTracker) UsageService {
+	return &usageService{repos: repos, quota: quota}
+}
+
+func (s *usageService) Report(ctx context.Context, principal CustomerPrincipal, report UsageReport) (UsageEventResponse, error) {
+	now := time.Now()
+	if err := validateUsageReport(report, now); err != nil {
+		return UsageEventResponse{}, err
+	}
+
+	params, err := usageEventParams(principal, report, now)
+	if err != nil {
+		return UsageEventResponse{}, err
+	}
+
+	decision, err := s.quota.Reserve(ctx, principal.CustomerID, report.Metric, report.Quantity)
+	if err != nil {
+		return UsageEventResponse{}, err
+	}
+	if !decision.allowed {
+		// A retry of an event recorded before the quota ran out is a duplicate
+		events, err := s.repos.Usage.FindEvents(ctx, principal.CustomerID, []string{report.EventID})
+		if err != nil {
+			return UsageEventResponse{}, E.NewInternalError("could not retrieve usage event", err)
+		}
+		if len(events) > 0 {
+			return convertUsageEvent(events[0], true)
+		}
+		return UsageEventResponse{}, E.NewQuotaExceededError(quotaExceededMessage(report.Metric))
+	}
+
+	event, created, err := s.repos.Usage.RecordEvent(ctx, params)
+	if err != nil {
+		s.quota.Release(principal.CustomerID, report.Metric, report.Quantity)
+		return UsageEventResponse{}, E.NewInternalError("could not record usage event", err)
+	}
+	if created {
+		s.quota.Confirm(principal.CustomerID, report.Metric, report.Quantity)
+	} else {
+		s.quota.Release(principal.CustomerID, report.Metric, report.Quantity)
+	}
+
+	resp, err := convertUsageEvent(event, !created)
+	if err != nil {
+		return UsageEventResponse{}, err
+	}
+	resp.OverQuota = created && decision.overQuota
+	return resp, nil
+}
+
+// BatchReport validates every event and reserves its quota before inserting
+// the valid ones with a single statement. An event ID repeated within the
+// batch counts once, and events beyond a hard limit are rejected in order.
+func (s *usageService) BatchReport(ctx context.Context, principal CustomerPrincipal, reports []UsageReport) (UsageBatchResponse, error) {
+	if len(reports) == 0 {
+		return UsageBatchResponse{}, E.NewInvalidInputError("events must not be empty", nil)
+	}
+	if len(reports) > MaxUsageBatchSize {
+		return UsageBatchResponse{}, E.NewInvalidInputError(fmt.Sprintf("a batch may carry at most %d events", MaxUsageBatchSize), nil)
+	}
+
+	limits, err := s.quota.Limits(ctx, principal.CustomerID)
+	if err != nil {
+		return UsageBatchResponse{}, err
+	}
+
+	now := time.Now()
+	results := make([]UsageBatchResult, len(reports))
+	batch := generated.CreateUsageEventsParams{
+		CustomerID: pgtype.UUID{Bytes: principal.CustomerID, Valid: true},
+		ApiKeyID:   pgtype.UUID{Bytes: principal.APIKeyID, Valid: principal.APIKeyID != uuid.Nil},
+	}
+	seen := make(map[string]bool, len(reports))
+	var exhausted []string
+	for i, report := range reports {
+		results[i] = UsageBatchResult{Index: i, EventID: report.EventID}
+
+		params, err := batchUsageEventParams(principal, report, limits, now)
+		switch {
+		case err != nil:
+			results[i].Status = UsageEventRejected
+			results[i].Error = rejectReason(err)
+			continue
+		case seen[report.EventID]:
+			results[i].Status = UsageEventDuplicate
+			continue
+		}
+
+		decision, err := s.quota.Reserve(ctx, principal.CustomerID, params.Metric, params.Quantity)
+		if err != nil {
+			s.settleBatch(principal.CustomerID, batch, nil)
+			return UsageBatchResponse{}, err
+		}
+		if !decision.allowed {
+			results[i].Status = UsageEventRejected
+			results[i].Error = quotaExceededMessage(params.Metric)
+			exhausted = append(exhausted, report.EventID)
+			continue
+		}
+		results[i].OverQuota = decision.overQuota
+		seen[report.EventID] = true
+
+		batch.Metrics = append(batch.Metrics, params.Metric)
+		batch.Quantities = append(batch.Quantities, params.Quantity)
+		batch.Metadata = append(batch.Metadata, string(params.Metadata))
+		batch.EventIds = append(batch.EventIds, params.EventID.String)
+		batch.OccurredAt = append(batch.OccurredAt, params.OccurredAt)
+	}
+
+	inserted := make(map[string]uuid.UUID)
+	if len(batch.EventIds) > 0 {
+		events, err := s.repos.Usage.RecordEvents(ctx, batch)
+		if err != nil {
+			s.settleBatch(principal.CustomerID, batch, nil)
+			return UsageBatchResponse{}, E.NewInternalError("could not record usage events", err)
+		}
+		for _, event := range events {
+			inserted[event.EventID.String] = event.ID
+		}
+		s.settleBatch(principal.CustomerID, batch, inserted)
+	}
+
+	// Retries of events recorded before the quota ran out are duplicates
+	recorded := make(map[string]bool)
+	if len(exhausted) > 0 {
+		events, err := s.repos.Usage.FindEvents(ctx, principal.CustomerID, exhausted)
+		if err != nil {
+			return UsageBatchResponse{}, E.NewInternalError("could not retrieve usage events", err)
+		}
+		for _, event := range events {
+			recorded[event.EventID.String] = true
+		}
+	}
+
+	resp := UsageBatchResponse{Results: results}
+	for i := range results {
+		switch results[i].Status {
+		case UsageEventRejected:
+			if !recorded[results[i].EventID] {
+				resp.FailedCount++
+				continue
+			}
+			results[i].Status = UsageEventDuplicate
+			results[i].Error = ""
+		case "":
+			if id, ok := inserted[results[i].EventID]; ok {
+				results[i].Status = UsageEventAccepted
+				results[i].ID = &id
+			} else {
+				// Reported by an earlier request
+				results[i].Status = UsageEventDuplicate
+				results[i].OverQuota = false
+			}
+		}
+		resp.ProcessedCount++
+	}
+
+	return resp, nil
+}
+
+func (s *usageService) CheckQuota(ctx context.Context, principal CustomerPrincipal, needed map[string]int64) (QuotaCheckResponse, error) {
+	return s.quota.Check(ctx, principal.CustomerID, needed)
+}
+
+// settleBatch confirms the quota reserved for the events of a batch that are
+// in inserted and releases the rest.
+func (s *usageService) settleBatch(customerID uuid.UUID, batch generated.CreateUsageEventsParams, inserted map[string]uuid.UUID) {
+	for i, eventID := range batch.EventIds {
+		if _, ok := inserted[eventID]; ok {
+			s.quota.Confirm(customerID, batch.Metrics[i], batch.Quantities[i])
+		} else {
+			s.quota.Release(customerID, batch.Metrics[i], batch.Quantities[i])
+		}
+	}
+}
+
+func quotaExceededMessage(metric string) string {
+	return fmt.Sprintf("%s quota of the customer's plan is exhausted for this period", metric)
+}
+
+func batchUsageEventParams(principal CustomerPrincipal, report UsageReport, limits map[string]usageQuota, now time.Time) (generated.CreateUsageEventParams, error) {
+	if err := validateUsageReport(report, now); err != nil {
+		return generated.CreateUsageEventParams{}, err
+	}
+	if err := checkUsageMetric(limits, report.Metric); err != nil {
+		return generated.CreateUsageEventParams{}, err
+	}
+	return usageEventParams(principal, report, now)
+}
+
+// rejectReason is the client-facing message of a validation error.
+func rejectReason(err error) string {
+	var appErr *E.AppError
+	if errors.As(err, &appErr) {
+		return appErr.Message
+	}
+	return err.Error()
+}
+
+// meteredSubscription reports whether usage is accepted for a subscription
+// in status.
+func meteredSubscription(status string) bool {
+	switch status {
+	case SubscriptionActive, SubscriptionTrialing, SubscriptionPastDue:
+		return true
+	default:
+		return false
+	}
+}
+
+func checkUsageMetric(limits map[string]usageQuota, metric string) error {
+	if _, ok := limits[metric]; !ok {
+		return E.NewInvalidInputError(fmt.Sprintf("metric %q is not metered by the customer's plan", metric), nil)
+	}
+	return nil
+}
+
+func validateUsageReport(report UsageReport, now time.Time) error {
+	switch {
+	case report.EventID == "":
+		return E.NewInvalidInputError("event_id is required", nil)
+	case len(report.EventID) > maxUsageEventIDLength:
+		return E.NewInvalidInputError(fmt.Sprintf("event_id must be at most %d characters", maxUsageEventIDLength), nil)
+	case report.Metric == "":
+		return E.NewInvalidInputError("metric is required", nil)
+	case report.Quantity <= 0:
+		return E.NewInvalidInputError("quantity must be positive", nil)
+	case report.Timestamp.After(now.Add(maxUsageClockSkew)):
+		return E.NewInvalidInputError("timestamp is in the future", nil)
+	}
+	return nil
+}
+
+func usageEventParams(principal CustomerPrincipal, report UsageReport, now time.Time) (generated.CreateUsageEventParams, error) {
+	var metadata []byte
+	if len(report.Metadata) > 0 {
+		var err error
+		if metadata, err = json.Marshal(report.Metadata); err != nil {
+			return generated.CreateUsageEventParams{}, E.NewInvalidInputError("invalid metadata", err)
+		}
+	}
+
+	occurredAt := report.Timestamp
+	if occurredAt.IsZero() {
+		occurredAt = now
+	}
+
+	return generated.CreateUsageEventParams{
+		CustomerID: pgtype.UUID{Bytes: principal.CustomerID, Valid: true},
+		ApiKeyID:   pgtype.UUID{Bytes: principal.APIKeyID, Valid: principal.APIKeyID != uuid.Nil},
+		Metric:     report.Metric,
+		Quantity:   report.Quantity,
+		Metadata:   metadata,
+		EventID:    pgtype.Text{String: report.EventID, Valid: true},
+		OccurredAt: timestamptz(occurredAt),
+	}, nil
+}
+
+func convertUsageEvent(event generated.UsageEvent, duplicate bool) (UsageEventResponse, error) {
+	var metadata map[string]any
+	if len(event.Metadata) > 0 {
+		if err := json.Unmarshal(event.Metadata, &metadata); err != nil {
+			return UsageEventResponse{}, E.NewInternalError("could not decode usage metadata", err)
+		}
+	}
+
+	return UsageEventResponse{
+		ID:         event.ID,
+		EventID:    event.EventID.String,
+		Metric:     event.Metric,
+		Quantity:   event.Quantity,
+		Timestamp:  event.OccurredAt.Time,
+		RecordedAt: event.ReportedAt.Time,
+		Metadata:   metadata,
+		Duplicate:  duplicate,
+	}, nil
+}
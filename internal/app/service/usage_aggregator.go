@@ -0,0 +1,350 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// UsageAggregator rolls reported usage events into usage_aggregates, the
+// per-period totals invoices are built from. Events are claimed with FOR
+// UPDATE SKIP LOCKED and marked processed in the transaction that adds them
+// to the totals, so any number of replicas can aggregate at the same time
+// without counting an event twice.
+//
+// An event is counted in the billing period it occurred in. When that period
+// has already been invoiced it is counted in the current period instead,
+// which is where a late event can still be invoiced.
+type UsageAggregator struct {
+	uow       repository.UnitOfWork
+	interval  time.Duration
+	batchSize int
+}
+
+func NewUsageAggregator(uow repository.UnitOfWork, interval time.Duration, batchSize int) *UsageAggregator {
+	return &UsageAggregator{
+		uow:       uow,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Run aggregates pending events every interval until ctx is canceled.
+func (a *UsageAggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			aggregated, err := a.AggregatePending(ctx)
+			if err != nil {
+				logger.Error("failed to aggregate usage", zap.Error(err))
+			}
+			if aggregated > 0 {
+				logger.Debug("aggregated usage events", zap.Int("count", aggregated))
+			}
+		}
+	}
+}
+
+// AggregatePending aggregates batches of events until none are left and
+// returns how many were aggregated.
+func (a *UsageAggregator) AggregatePending(ctx context.Context) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		n, err := a.AggregateBatch(ctx)
+		total += n
+		if err != nil || n < a.batchSize {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// usageAggregateKey identifies one rollup row.
+type usageAggregateKey struct {
+	customerID  uuid.UUID
+	periodStart time.Time
+	metric      string
+}
+
+type usageAggregateTotal struct {
+	periodEnd time.Time
+	quantity  int64
+	costCents int64
+}
+
+// usagePeriod is the billing period events of a customer are counted in. A
+// zero period means the customer has no subscription being billed.
+type usagePeriod struct {
+	start, end time.Time
+	events     []uuid.UUID
+}
+
+// AggregateBatch claims up to batchSize unprocessed events, adds them to the
+// rollups of the period each occurred in and marks them processed, all in
+// one transaction. Events of customers without a metered subscription are
+// marked processed without being counted.
+func (a *UsageAggregator) AggregateBatch(ctx context.Context) (int, error) {
+	claimed := 0
+	err := a.uow.Do(ctx, func(repos repository.Repositories) error {
+		events, err := repos.Usage.ClaimUnprocessed(ctx, a.batchSize)
+		if err != nil {
+			return E.NewInternalError("could not claim usage events", err)
+		}
+		claimed = len(events)
+
+		byCustomer := make(map[uuid.UUID][]generated.UsageEvent)
+		for _, event := range events {
+			customerID := uuid.UUID(event.CustomerID.Bytes)
+			byCustomer[customerID] = append(byCustomer[customerID], event)
+		}
+		// Lock subscriptions in a stable order so concurrent workers cannot
+		// deadlock
+		customers := make([]uuid.UUID, 0, len(byCustomer))
+		for id := range byCustomer {
+			customers = append(customers, id)
+		}
+		slices.SortFunc(customers, func(x, y uuid.UUID) int {
+			return strings.Compare(x.String(), y.String())
+		})
+
+		var periods []*usagePeriod
+		totals := make(map[usageAggregateKey]*usageAggregateTotal)
+		for _, customerID := range customers {
+			customerEvents := byCustomer[customerID]
+			since := usageEventTime(customerEvents[0])
+			for _, event := range customerEvents[1:] {
+				if t := usageEventTime(event); t.Before(since) {
+					since = t
+				}
+			}
+
+			history, err := loadUsagePeriods(ctx, repos, customerID, since)
+			if err != nil {
+				return err
+			}
+
+			counted := make(map[time.Time]*usagePeriod)
+			for _, event := range customerEvents {
+				start, end := history.periodAt(usageEventTime(event))
+				period, ok := counted[start]
+				if !ok {
+					period = &usagePeriod{start: start, end: end}
+					counted[start] = period
+					periods = append(periods, period)
+				}
+				period.events = append(period.events, event.ID)
+
+				if start.IsZero() {
+					logger.Info("usage event has no billing period to be counted in",
+						zap.String("customer_id", customerID.String()),
+						zap.String("usage_event_id", event.ID.String()))
+					continue
+				}
+
+				key := usageAggregateKey{customerID: customerID, periodStart: start, metric: event.Metric}
+				total, ok := totals[key]
+				if !ok {
+					total = &usageAggregateTotal{periodEnd: end}
+					totals[key] = total
+				}
+				total.quantity += event.Quantity
+				total.costCents += event.CostCents.Int64
+			}
+		}
+
+		// Upsert in a stable order so concurrent workers lock rollups alike
+		keys := make([]usageAggregateKey, 0, len(totals))
+		for key := range totals {
+			keys = append(keys, key)
+		}
+		slices.SortFunc(keys, func(x, y usageAggregateKey) int {
+			if c := strings.Compare(x.customerID.String(), y.customerID.String()); c != 0 {
+				return c
+			}
+			if c := x.periodStart.Compare(y.periodStart); c != 0 {
+				return c
+			}
+			return strings.Compare(x.metric, y.metric)
+		})
+		for _, key := range keys {
+			total := totals[key]
+			if err := repos.Usage.AddAggregate(ctx, generated.AddUsageAggregateParams{
+				CustomerID:     pgtype.UUID{Bytes: key.customerID, Valid: true},
+				PeriodStart:    pgtype.Date{Time: key.periodStart, Valid: true},
+				PeriodEnd:      pgtype.Date{Time: total.periodEnd, Valid: true},
+				Metric:         key.metric,
+				TotalQuantity:  pgtype.Int8{Int64: total.quantity, Valid: true},
+				TotalCostCents: pgtype.Int8{Int64: total.costCents, Valid: true},
+			}); err != nil {
+				return E.NewInternalError("could not update usage aggregate", err)
+			}
+		}
+
+		for _, period := range periods {
+			if err := repos.Usage.MarkProcessed(ctx, period.events, period.start, period.end); err != nil {
+				return E.NewInternalError("could not mark usage events processed", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return claimed, nil
+}
+
+// usageEventTime is when the usage happened, or when it was reported if the
+// client did not say.
+func usageEventTime(event generated.UsageEvent) time.Time {
+	if event.OccurredAt.Valid {
+		return event.OccurredAt.Time
+	}
+	return event.ReportedAt.Time
+}
+
+// customerUsagePeriods is the period history of one customer that usage can
+// still be counted in.
+type customerUsagePeriods struct {
+	current usagePeriod
+	// history holds the periods since the oldest event, oldest first, and
+	// billed marks those whose usage is already invoiced.
+	history []generated.SubscriptionPeriod
+	billed  []bool
+}
+
+// loadUsagePeriods loads the customer's periods that end after since and
+// locks their subscriptions, so renewal cannot invoice a period while usage
+// is being added to it.
+func loadUsagePeriods(ctx context.Context, repos repository.Repositories, customerID uuid.UUID, since time.Time) (*customerUsagePeriods, error) {
+	periods := &customerUsagePeriods{}
+
+	current, err := repos.Subscriptions.FindCurrentByCustomer(ctx, customerID)
+	if err != nil && !errors.Is(err, E.ErrNotFound) {
+		return nil, E.NewInternalError("could not retrieve subscription", err)
+	}
+	if err == nil {
+		if current, err = repos.Subscriptions.FindForUpdate(ctx, current.ID); err != nil {
+			return nil, E.NewInternalError("could not lock subscription", err)
+		}
+		if meteredSubscription(current.Status) && current.CurrentPeriodStart.Valid {
+			periods.current = usagePeriod{start: current.CurrentPeriodStart.Time, end: current.CurrentPeriodEnd.Time}
+		}
+	}
+
+	periods.history, err = repos.Subscriptions.ListPeriodsSince(ctx, customerID, since)
+	if err != nil {
+		return nil, E.NewInternalError("could not retrieve subscription periods", err)
+	}
+	periods.billed = make([]bool, len(periods.history))
+
+	locked := map[uuid.UUID]bool{current.ID: true}
+	for i, p := range periods.history {
+		if p.SubscriptionID == current.ID && p.PeriodStart.Time.Equal(periods.current.start) {
+			continue
+		}
+		if !locked[p.SubscriptionID] {
+			if _, err := repos.Subscriptions.FindForUpdate(ctx, p.SubscriptionID); err != nil {
+				return nil, E.NewInternalError("could not lock subscription", err)
+			}
+			locked[p.SubscriptionID] = true
+		}
+
+		billed, err := repos.Invoices.CountBilledLineItems(ctx, p.SubscriptionID, LineItemUsage, p.PeriodStart.Time)
+		if err != nil {
+			return nil, E.NewInternalError("could not check billed usage", err)
+		}
+		periods.billed[i] = billed > 0
+	}
+
+	return periods, nil
+}
+
+// periodAt returns the period usage at t is counted in: the latest period
+// that started by t and had not ended, unless its usage is already invoiced
+// or there is none, in which case the current period.
+func (p *customerUsagePeriods) periodAt(t time.Time) (time.Time, time.Time) {
+	for i := len(p.history) - 1; i >= 0; i-- {
+		period := p.history[i]
+		if period.PeriodStart.Time.After(t) {
+			continue
+		}
+		if !t.Before(period.PeriodEnd.Time) || p.billed[i] {
+			break
+		}
+		return period.PeriodStart.Time, period.PeriodEnd.Time
+	}
+	return p.current.start, p.current.end
+}
+
+// Rebuild recomputes the rollups of the period starting at periodStart from
+// the raw events counted in it, replacing whatever the rollups hold. Events
+// keep the period AggregateBatch counted them in, so a rebuild never moves
+// usage into a period that was already invoiced. A nil
+// customerID rebuilds the period of every customer. It returns the number of
+// rollups written.
+func (a *UsageAggregator) Rebuild(ctx context.Context, periodStart time.Time, customerID uuid.UUID) (int, error) {
+	written := 0
+	err := a.uow.Do(ctx, func(repos repository.Repositories) error {
+		written = 0
+
+		if err := repos.Usage.LockAggregates(ctx); err != nil {
+			return E.NewInternalError("could not lock usage aggregates", err)
+		}
+
+		customers := []uuid.UUID{customerID}
+		if customerID == uuid.Nil {
+			var err error
+			customers, err = repos.Usage.ListPeriodCustomers(ctx, periodStart)
+			if err != nil {
+				return E.NewInternalError("could not list customers with usage", err)
+			}
+		}
+
+		for _, id := range customers {
+			if err := repos.Usage.DeleteAggregatesForPeriod(ctx, id, periodStart); err != nil {
+				return E.NewInternalError("could not clear usage aggregates", err)
+			}
+
+			sums, err := repos.Usage.SumEventsForPeriod(ctx, id, periodStart)
+			if err != nil {
+				return E.NewInternalError("could not sum usage events", err)
+			}
+			for _, sum := range sums {
+				if err := repos.Usage.AddAggregate(ctx, generated.AddUsageAggregateParams{
+					CustomerID:     pgtype.UUID{Bytes: id, Valid: true},
+					PeriodStart:    pgtype.Date{Time: periodStart, Valid: true},
+					PeriodEnd:      sum.PeriodEnd,
+					Metric:         sum.Metric,
+					TotalQuantity:  pgtype.Int8{Int64: sum.TotalQuantity, Valid: true},
+					TotalCostCents: pgtype.Int8{Int64: sum.TotalCostCents, Valid: true},
+				}); err != nil {
+					return E.NewInternalError("could not write usage aggregate", err)
+				}
+				written++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+)
+
+func TestAggregateBatchCountsEventsInTheirPeriod(t *testing.T) {
+	previous := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	current := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	next := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		previousBilled bool
+		occurredAt     time.Time
+		reportedAt     time.Time
+		wantStart      time.Time
+	}{
+		{
+			name:       "current period",
+			occurredAt: current.Add(time.Hour),
+			reportedAt: current.Add(2 * time.Hour),
+			wantStart:  current,
+		},
+		{
+			name:       "late event of an open period",
+			occurredAt: previous.Add(time.Hour),
+			reportedAt: current.Add(time.Hour),
+			wantStart:  previous,
+		},
+		{
+			name:           "late event of an invoiced period",
+			previousBilled: true,
+			occurredAt:     previous.Add(time.Hour),
+			reportedAt:     current.Add(time.Hour),
+			wantStart:      current,
+		},
+		{
+			name:       "without occurred_at",
+			reportedAt: previous.Add(time.Hour),
+			wantStart:  previous,
+		},
+		{
+			name:       "before any known period",
+			occurredAt: previous.AddDate(0, -3, 0),
+			reportedAt: current.Add(time.Hour),
+			wantStart:  current,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repos := store.repos()
+			aggregator := NewUsageAggregator(memUnitOfWork{repos: repos}, time.Minute, 10)
+
+			customerID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+			sub, err := repos.Subscriptions.Create(context.Background(), generated.CreateSubscriptionParams{
+				CustomerID:         customerID,
+				Status:             SubscriptionActive,
+				CurrentPeriodStart: timestamptz(current),
+				CurrentPeriodEnd:   timestamptz(next),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.periods = append(store.periods,
+				&generated.SubscriptionPeriod{SubscriptionID: sub.ID, CustomerID: customerID, PeriodStart: timestamptz(previous), PeriodEnd: timestamptz(current)},
+				&generated.SubscriptionPeriod{SubscriptionID: sub.ID, CustomerID: customerID, PeriodStart: timestamptz(current), PeriodEnd: timestamptz(next)},
+			)
+			if tt.previousBilled {
+				store.lineItems = append(store.lineItems, &generated.InvoiceLineItem{
+					ID:             uuid.New(),
+					SubscriptionID: pgtype.UUID{Bytes: sub.ID, Valid: true},
+					Kind:           LineItemUsage,
+					PeriodStart:    timestamptz(previous),
+				})
+			}
+
+			event := &generated.UsageEvent{
+				ID:         uuid.New(),
+				CustomerID: customerID,
+				Metric:     "requests",
+				Quantity:   3,
+				ReportedAt: timestamptz(tt.reportedAt),
+				OccurredAt: pgtype.Timestamptz{Time: tt.occurredAt, Valid: !tt.occurredAt.IsZero()},
+			}
+			store.usageEvents = append(store.usageEvents, event)
+
+			if n, err := aggregator.AggregateBatch(context.Background()); err != nil || n != 1 {
+				t.Fatalf("AggregateBatch = %d, %v; want 1 event", n, err)
+			}
+
+			if !event.Processed.Bool || !event.PeriodStart.Time.Equal(tt.wantStart) {
+				t.Fatalf("event counted in period %s (processed: %t), want %s", event.PeriodStart.Time, event.Processed.Bool, tt.wantStart)
+			}
+			if len(store.aggregates) != 1 {
+				t.Fatalf("got %d aggregates, want 1", len(store.aggregates))
+			}
+			if agg := store.aggregates[0]; !agg.PeriodStart.Time.Equal(tt.wantStart) || agg.TotalQuantity.Int64 != 3 {
+				t.Fatalf("aggregate of %s = %d, want 3 in %s", agg.PeriodStart.Time, agg.TotalQuantity.Int64, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestAggregatePendingSumsEvents(t *testing.T) {
+	current := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	next := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	type rollup struct {
+		metric   string
+		quantity int64
+		cost     int64
+	}
+
+	tests := []struct {
+		name    string
+		status  string
+		events  []rollup
+		want    []rollup
+		wantAll int
+	}{
+		{
+			name:    "summed per metric across batches",
+			status:  SubscriptionActive,
+			events:  []rollup{{"requests", 3, 0}, {"tokens", 100, 5}, {"requests", 4, 0}, {"tokens", 50, 2}, {"requests", 1, 0}},
+			want:    []rollup{{"requests", 8, 0}, {"tokens", 150, 7}},
+			wantAll: 5,
+		},
+		{
+			name:    "past due subscription is still metered",
+			status:  SubscriptionPastDue,
+			events:  []rollup{{"requests", 2, 0}},
+			want:    []rollup{{"requests", 2, 0}},
+			wantAll: 1,
+		},
+		{
+			name:    "canceled subscription counts nothing",
+			status:  SubscriptionCanceled,
+			events:  []rollup{{"requests", 3, 0}, {"requests", 4, 0}, {"requests", 1, 0}},
+			wantAll: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repos := store.repos()
+			aggregator := NewUsageAggregator(memUnitOfWork{repos: repos}, time.Minute, 2)
+
+			customerID := pgtype.UUID{Bytes: uuid.New(), Valid: true}
+			if _, err := repos.Subscriptions.Create(context.Background(), generated.CreateSubscriptionParams{
+				CustomerID:         customerID,
+				Status:             tt.status,
+				CurrentPeriodStart: timestamptz(current),
+				CurrentPeriodEnd:   timestamptz(next),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range tt.events {
+				store.usageEvents = append(store.usageEvents, &generated.UsageEvent{
+					ID:         uuid.New(),
+					CustomerID: customerID,
+					Metric:     e.metric,
+					Quantity:   e.quantity,
+					CostCents:  pgtype.Int8{Int64: e.cost, Valid: true},
+					ReportedAt: timestamptz(current.Add(time.Hour)),
+				})
+			}
+
+			n, err := aggregator.AggregatePending(context.Background())
+			if err != nil || n != tt.wantAll {
+				t.Fatalf("AggregatePending = %d, %v; want %d events", n, err, tt.wantAll)
+			}
+			for _, event := range store.usageEvents {
+				if !event.Processed.Bool {
+					t.Fatalf("event %s was not processed", event.ID)
+				}
+			}
+
+			if len(store.aggregates) != len(tt.want) {
+				t.Fatalf("got %d aggregates, want %d", len(store.aggregates), len(tt.want))
+			}
+			for _, want := range tt.want {
+				var found bool
+				for _, agg := range store.aggregates {
+					if agg.Metric != want.metric {
+						continue
+					}
+					found = true
+					if !agg.PeriodStart.Time.Equal(current) || agg.TotalQuantity.Int64 != want.quantity || agg.TotalCostCents.Int64 != want.cost {
+						t.Errorf("aggregate of %s = %d, %d cents in %s; want %d, %d cents in %s", want.metric,
+							agg.TotalQuantity.Int64, agg.TotalCostCents.Int64, agg.PeriodStart.Time, want.quantity, want.cost, current)
+					}
+				}
+				if !found {
+					t.Errorf("no aggregate of %s", want.metric)
+				}
+			}
+		})
+	}
+}
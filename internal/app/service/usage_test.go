@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateUsageReport(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	valid := UsageReport{EventID: "evt_1", Metric: "requests", Quantity: 1}
+
+	tests := []struct {
+		name    string
+		edit    func(r *UsageReport)
+		wantErr bool
+	}{
+		{name: "valid", edit: func(*UsageReport) {}},
+		{name: "timestamp within clock skew", edit: func(r *UsageReport) { r.Timestamp = now.Add(maxUsageClockSkew) }},
+		{name: "timestamp in the past", edit: func(r *UsageReport) { r.Timestamp = now.AddDate(0, 0, -3) }},
+		{name: "no event id", edit: func(r *UsageReport) { r.EventID = "" }, wantErr: true},
+		{name: "event id too long", edit: func(r *UsageReport) { r.EventID = strings.Repeat("e", maxUsageEventIDLength+1) }, wantErr: true},
+		{name: "no metric", edit: func(r *UsageReport) { r.Metric = "" }, wantErr: true},
+		{name: "zero quantity", edit: func(r *UsageReport) { r.Quantity = 0 }, wantErr: true},
+		{name: "negative quantity", edit: func(r *UsageReport) { r.Quantity = -5 }, wantErr: true},
+		{name: "timestamp in the future", edit: func(r *UsageReport) { r.Timestamp = now.Add(maxUsageClockSkew + time.Second) }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := valid
+			tt.edit(&report)
+
+			err := validateUsageReport(report, now)
+			if tt.wantErr {
+				wantHTTPStatus(t, err, http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateUsageReport: %v", err)
+			}
+		})
+	}
+}
+
+func TestReportUsage(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	tracker := NewQuotaTracker(store.repos(), time.Minute, time.Hour)
+	usage := NewUsageService(store.repos(), tracker)
+	principal := CustomerPrincipal{APIKeyID: uuid.New(), CustomerID: newQuotaCustomer(t, store, `{"requests": 100}`)}
+
+	report := UsageReport{EventID: "evt_1", Metric: "requests", Quantity: 10, Metadata: map[string]any{"path": "/v1/search"}}
+	first, err := usage.Report(ctx, principal, report)
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if first.Duplicate || first.Quantity != 10 || first.Metadata["path"] != "/v1/search" {
+		t.Fatalf("first report = %+v, want a new event of 10 with its metadata", first)
+	}
+
+	// A retry returns the stored event, even with a different quantity
+	report.Quantity = 30
+	retry, err := usage.Report(ctx, principal, report)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if !retry.Duplicate || retry.ID != first.ID || retry.Quantity != 10 {
+		t.Fatalf("retry = %+v, want duplicate of %s with quantity 10", retry, first.ID)
+	}
+	if len(store.usageEvents) != 1 {
+		t.Fatalf("got %d usage events, want 1", len(store.usageEvents))
+	}
+
+	check, err := usage.CheckQuota(ctx, principal, nil)
+	if err != nil {
+		t.Fatalf("check quota: %v", err)
+	}
+	if check.Used["requests"] != 10 {
+		t.Fatalf("used = %d, want the event counted once", check.Used["requests"])
+	}
+
+	// Another customer may use the same event ID
+	other := CustomerPrincipal{CustomerID: newQuotaCustomer(t, store, `{"requests": 100}`)}
+	if resp, err := usage.Report(ctx, other, UsageReport{EventID: "evt_1", Metric: "requests", Quantity: 1}); err != nil || resp.Duplicate {
+		t.Fatalf("report of another customer = %+v, %v; want a new event", resp, err)
+	}
+}
+
+func TestReportUsageRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		quotaLimits string // empty means no subscription
+		report      UsageReport
+		wantStatus  int
+	}{
+		{
+			name:        "invalid report",
+			quotaLimits: `{"requests": 100}`,
+			report:      UsageReport{EventID: "evt_1", Metric: "requests"},
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "metric not metered by the plan",
+			quotaLimits: `{"requests": 100}`,
+			report:      UsageReport{EventID: "evt_1", Metric: "images", Quantity: 1},
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:       "no subscription",
+			report:     UsageReport{EventID: "evt_1", Metric: "requests", Quantity: 1},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			usage := NewUsageService(store.repos(), NewQuotaTracker(store.repos(), time.Minute, time.Hour))
+			principal := CustomerPrincipal{CustomerID: uuid.New()}
+			if tt.quotaLimits != "" {
+				principal.CustomerID = newQuotaCustomer(t, store, tt.quotaLimits)
+			}
+
+			_, err := usage.Report(context.Background(), principal, tt.report)
+			wantHTTPStatus(t, err, tt.wantStatus)
+			if len(store.usageEvents) != 0 {
+				t.Fatalf("got %d usage events, want none", len(store.usageEvents))
+			}
+		})
+	}
+}
+
+func TestBatchReportUsage(t *testing.T) {
+	event := func(eventID string, quantity int64) UsageReport {
+		return UsageReport{EventID: eventID, Metric: "requests", Quantity: quantity}
+	}
+
+	tests := []struct {
+		name          string
+		quotaLimits   string
+		earlier       []UsageReport
+		batch         []UsageReport
+		wantStatuses  []string
+		wantFailed    int
+		wantRecorded  int
+		wantOverQuota []bool
+	}{
+		{
+			name:        "invalid events are rejected one by one",
+			quotaLimits: `{"requests": 100}`,
+			batch: []UsageReport{
+				event("evt_1", 1),
+				event("evt_2", 0),
+				{EventID: "evt_3", Metric: "images", Quantity: 1},
+				event("evt_4", 2),
+			},
+			wantStatuses: []string{UsageEventAccepted, UsageEventRejected, UsageEventRejected, UsageEventAccepted},
+			wantFailed:   2,
+			wantRecorded: 2,
+		},
+		{
+			name:         "event repeated within the batch",
+			quotaLimits:  `{"requests": 100}`,
+			batch:        []UsageReport{event("evt_1", 1), event("evt_1", 1)},
+			wantStatuses: []string{UsageEventAccepted, UsageEventDuplicate},
+			wantRecorded: 1,
+		},
+		{
+			name:         "event reported earlier",
+			quotaLimits:  `{"requests": 100}`,
+			earlier:      []UsageReport{event("evt_1", 1)},
+			batch:        []UsageReport{event("evt_1", 1), event("evt_2", 1)},
+			wantStatuses: []string{UsageEventDuplicate, UsageEventAccepted},
+			wantRecorded: 2,
+		},
+		{
+			name:         "hard limit rejects events in order",
+			quotaLimits:  `{"requests": 10}`,
+			batch:        []UsageReport{event("evt_1", 6), event("evt_2", 6), event("evt_3", 4)},
+			wantStatuses: []string{UsageEventAccepted, UsageEventRejected, UsageEventAccepted},
+			wantFailed:   1,
+			wantRecorded: 2,
+		},
+		{
+			name:         "retry of an event recorded before the quota ran out",
+			quotaLimits:  `{"requests": 10}`,
+			earlier:      []UsageReport{event("evt_1", 10)},
+			batch:        []UsageReport{event("evt_1", 10), event("evt_2", 1)},
+			wantStatuses: []string{UsageEventDuplicate, UsageEventRejected},
+			wantFailed:   1,
+			wantRecorded: 1,
+		},
+		{
+			name:          "soft limit accepts events over quota",
+			quotaLimits:   `{"requests": {"limit": 10, "enforcement": "soft"}}`,
+			batch:         []UsageReport{event("evt_1", 6), event("evt_2", 6)},
+			wantStatuses:  []string{UsageEventAccepted, UsageEventAccepted},
+			wantRecorded:  2,
+			wantOverQuota: []bool{false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemStore()
+			usage := NewUsageService(store.repos(), NewQuotaTracker(store.repos(), time.Minute, time.Hour))
+			principal := CustomerPrincipal{CustomerID: newQuotaCustomer(t, store, tt.quotaLimits)}
+			for _, report := range tt.earlier {
+				if _, err := usage.Report(ctx, principal, report); err != nil {
+					t.Fatalf("earlier report: %v", err)
+				}
+			}
+
+			resp, err := usage.BatchReport(ctx, principal, tt.batch)
+			if err != nil {
+				t.Fatalf("batch report: %v", err)
+			}
+			if len(resp.Results) != len(tt.batch) {
+				t.Fatalf("got %d results, want %d", len(resp.Results), len(tt.batch))
+			}
+			for i, result := range resp.Results {
+				if result.Index != i || result.EventID != tt.batch[i].EventID || result.Status != tt.wantStatuses[i] {
+					t.Errorf("result %d = %+v, want %s for %s", i, result, tt.wantStatuses[i], tt.batch[i].EventID)
+				}
+				if (result.Status == UsageEventRejected) != (result.Error != "") {
+					t.Errorf("result %d has status %s with error %q", i, result.Status, result.Error)
+				}
+				if (result.Status == UsageEventAccepted) != (result.ID != nil) {
+					t.Errorf("result %d has status %s with id %v", i, result.Status, result.ID)
+				}
+				if tt.wantOverQuota != nil && result.OverQuota != tt.wantOverQuota[i] {
+					t.Errorf("result %d over quota = %t, want %t", i, result.OverQuota, tt.wantOverQuota[i])
+				}
+			}
+			if resp.FailedCount != tt.wantFailed || resp.ProcessedCount != len(tt.batch)-tt.wantFailed {
+				t.Errorf("processed %d, failed %d; want %d, %d", resp.ProcessedCount, resp.FailedCount, len(tt.batch)-tt.wantFailed, tt.wantFailed)
+			}
+			if len(store.usageEvents) != tt.wantRecorded {
+				t.Errorf("got %d usage events, want %d", len(store.usageEvents), tt.wantRecorded)
+			}
+		})
+	}
+}
+
+func TestBatchReportUsageRejectsSize(t *testing.T) {
+	store := newMemStore()
+	usage := NewUsageService(store.repos(), NewQuotaTracker(store.repos(), time.Minute, time.Hour))
+	principal := CustomerPrincipal{CustomerID: newQuotaCustomer(t, store, `{"requests": 100}`)}
+
+	for _, n := range []int{0, MaxUsageBatchSize + 1} {
+		_, err := usage.BatchReport(context.Background(), principal, make([]UsageReport, n))
+		wantHTTPStatus(t, err, http.StatusBadRequest)
+	}
+}
@@ -34,10 +34,11 @@ type UserService interface {
 type userService struct {
 	cfg  *config.Config
 	repo repository.UserRepository
+	uow  repository.UnitOfWork
 }
 
-func NewUserService(cfg *config.Config, repo repository.UserRepository) UserService {
-	return &userService{cfg: cfg, repo: repo}
+func NewUserService(cfg *config.Config, repo repository.UserRepository, uow repository.UnitOfWork) UserService {
+	return &userService{cfg: cfg, repo: repo, uow: uow}
 }
 
 func (s *userService) Login(ctx context.Context, email, password string) (string, time.Time, error) {
@@ -108,7 +109,18 @@ func (s *userService) Create(ctx context.Context, name, email, password string)
 		return UserResponse{}, err
 	}
 
-	user, err := s.repo.Create(ctx, name, email, string(hash))
+	// The user and its billing customer are created together or not at all
+	var user generated.User
+	err = s.uow.Do(ctx, func(repos repository.Repositories) error {
+		var err error
+		user, err = repos.Users.Create(ctx, name, email, string(hash))
+		if err != nil {
+			return err
+		}
+
+		_, err = repos.Customers.Create(ctx, user.ID, user.Email)
+		return err
+	})
 	if err != nil {
 		return UserResponse{}, err
 	}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+)
+
+// rollbackUnitOfWork restores the users and customers of the store when a
+// unit of work fails, like the transaction it stands in for.
+type rollbackUnitOfWork struct {
+	store *memStore
+	repos repository.Repositories
+}
+
+func (u rollbackUnitOfWork) Do(_ context.Context, fn func(repos repository.Repositories) error) error {
+	users, customers := len(u.store.users), len(u.store.customers)
+	if err := fn(u.repos); err != nil {
+		u.store.users, u.store.customers = u.store.users[:users], u.store.customers[:customers]
+		return err
+	}
+	return nil
+}
+
+func (u rollbackUnitOfWork) DoSerializable(ctx context.Context, fn func(repos repository.Repositories) error) error {
+	return u.Do(ctx, fn)
+}
+
+// failingCustomers fails every customer it is asked to create.
+type failingCustomers struct {
+	repository.CustomerRepository
+}
+
+func (failingCustomers) Create(context.Context, uuid.UUID, string) (generated.Customer, error) {
+	return generated.Customer{}, errors.New("connection reset")
+}
+
+func TestCreateUserProvisionsCustomer(t *testing.T) {
+	tests := []struct {
+		name          string
+		userName      string
+		password      string
+		failCustomer  bool
+		wantErr       bool
+		wantCustomers int
+	}{
+		{name: "created together", userName: "Ada", password: "secret1", wantCustomers: 1},
+		{name: "customer fails", userName: "Ada", password: "secret1", failCustomer: true, wantErr: true},
+		{name: "invalid user", userName: " ", password: "secret1", wantErr: true},
+		{name: "short password", userName: "Ada", password: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			repos := store.repos()
+			if tt.failCustomer {
+				repos.Customers = failingCustomers{}
+			}
+			svc := NewUserService(nil, repos.Users, rollbackUnitOfWork{store: store, repos: repos})
+
+			user, err := svc.Create(context.Background(), tt.userName, "ada@example.com", tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %t", err, tt.wantErr)
+			}
+			if len(store.customers) != tt.wantCustomers || len(store.users) != tt.wantCustomers {
+				t.Fatalf("got %d users and %d customers, want %d of each", len(store.users), len(store.customers), tt.wantCustomers)
+			}
+			if tt.wantCustomers == 0 {
+				return
+			}
+
+			customer := store.customers[0]
+			if customer.UserID.Bytes != user.ID || customer.Email.String != "ada@example.com" {
+				t.Fatalf("customer of user %s with email %q, want user %s", uuid.UUID(customer.UserID.Bytes), customer.Email.String, user.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/payment"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+// Webhook event statuses as stored in webhook_events.status.
+const (
+	WebhookReceived  = "received"
+	WebhookProcessed = "processed"
+	WebhookIgnored   = "ignored"
+	WebhookFlagged   = "flagged"
+	WebhookFailed    = "failed"
+)
+
+// webhookOutcome is how an event was handled. The note says why an event was
+// ignored or what about a flagged one needs review.
+type webhookOutcome struct {
+	status string
+	note   string
+}
+
+var applied = webhookOutcome{status: WebhookProcessed}
+
+func ignored(reason string) webhookOutcome {
+	return webhookOutcome{status: WebhookIgnored, note: reason}
+}
+
+// flagged marks an event that was applied but needs someone to look at it,
+// e.g. a payment we kept as credit.
+func flagged(note string) webhookOutcome {
+	return webhookOutcome{status: WebhookFlagged, note: note}
+}
+
+// gatewaySyncedAtKey is the subscription metadata key holding the time of the
+// last gateway event applied to it, so older events delivered late are skipped.
+const gatewaySyncedAtKey = "gateway_synced_at"
+
+type WebhookService interface {
+	// Handle verifies a webhook delivery from gateway, stores it and applies
+	// it to our invoices, transactions and subscriptions. Redelivered events
+	// that were already handled are acknowledged without applying them again.
+	Handle(ctx context.Context, gateway string, header http.Header, body []byte) error
+}
+
+type webhookService struct {
+	repos     repository.Repositories
+	uow       repository.UnitOfWork
+	builder   *InvoiceBuilder
+	verifiers map[string]payment.WebhookVerifier
+}
+
+func NewWebhookService(repos repository.Repositories, uow repository.UnitOfWork, builder *InvoiceBuilder, verifiers map[string]payment.WebhookVerifier) WebhookService {
+	return &webhookService{repos: repos, uow: uow, builder: builder, verifiers: verifiers}
+}
+
+func (s *webhookService) Handle(ctx context.Context, gateway string, header http.Header, body []byte) error {
+	verifier, ok := s.verifiers[gateway]
+	if !ok {
+		return E.NewNotFoundError("webhook", gateway+" webhooks are not configured")
+	}
+
+	event, err := verifier.VerifyWebhook(header, body)
+	if err != nil {
+		if errors.Is(err, payment.ErrInvalidSignature) {
+			return E.NewUnauthorizedError("invalid webhook signature", err)
+		}
+		return E.NewInvalidInputError("invalid webhook payload", err)
+	}
+
+	// Store the event before applying it so failed events can be replayed
+	stored, err := s.repos.Webhooks.Record(ctx, gateway, event.ID, event.Type, body)
+	if err != nil {
+		return E.NewInternalError("could not record webhook event", err)
+	}
+
+	err = s.uow.Do(ctx, func(repos repository.Repositories) error {
+		locked, err := repos.Webhooks.FindForUpdate(ctx, stored.ID)
+		if err != nil {
+			return E.NewInternalError("could not lock webhook event", err)
+		}
+		if locked.Status != WebhookReceived && locked.Status != WebhookFailed {
+			logger.Debug("webhook event already handled",
+				zap.String("gateway", gateway),
+				zap.String("event_id", event.ID))
+			return nil
+		}
+
+		outcome, err := s.apply(ctx, repos, gateway, event)
+		if err != nil {
+			return err
+		}
+		if outcome.status == WebhookFlagged {
+			logger.Error("webhook event needs review",
+				zap.String("gateway", gateway),
+				zap.String("event_id", event.ID),
+				zap.String("note", outcome.note))
+		}
+
+		if err := repos.Webhooks.SetStatus(ctx, locked.ID, outcome.status, outcome.note, time.Now()); err != nil {
+			return E.NewInternalError("could not update webhook event", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to handle webhook event",
+			zap.String("gateway", gateway),
+			zap.String("event_id", event.ID),
+			zap.String("event_type", event.Type),
+			zap.Error(err))
+
+		// The gateway retries failed deliveries; the event is applied again then
+		if markErr := s.repos.Webhooks.SetStatus(ctx, stored.ID, WebhookFailed, err.Error(), time.Time{}); markErr != nil {
+			logger.Error("failed to mark webhook event failed", zap.String("event_id", event.ID), zap.Error(markErr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// apply updates our records from event. Events that do not concern anything
+// we track are ignored.
+func (s *webhookService) apply(ctx context.Context, repos repository.Repositories, gateway string, event payment.Event) (webhookOutcome, error) {
+	switch event.Kind {
+	case payment.EventPayment:
+		return s.applyPayment(ctx, repos, gateway, event)
+	case payment.EventSubscription:
+		return s.applySubscription(ctx, repos, event)
+	default:
+		return ignored(fmt.Sprintf("%s events are not handled", event.Type)), nil
+	}
+}
+
+// applyPayment records the outcome of a payment on its invoice. A pending
+// transaction from an earlier attempt is settled in place.
+func (s *webhookService) applyPayment(ctx context.Context, repos repository.Repositories, gateway string, event payment.Event) (webhookOutcome, error) {
+	if event.Status != payment.StatusSucceeded && event.Status != payment.StatusFailed {
+		return ignored(fmt.Sprintf("%s payments do not settle invoices", event.Status)), nil
+	}
+
+	invoice, err := findEventInvoice(ctx, repos, event)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return s.applyCheckout(ctx, repos, gateway, event)
+		}
+		return webhookOutcome{}, E.NewInternalError("could not retrieve invoice", err)
+	}
+
+	var txn generated.Transaction
+	found := false
+	if event.PaymentID != "" {
+		txn, err = repos.Transactions.FindByGatewayPaymentForUpdate(ctx, gateway, event.PaymentID)
+		switch {
+		case err == nil:
+			found = true
+		case !errors.Is(err, E.ErrNotFound):
+			return webhookOutcome{}, E.NewInternalError("could not retrieve transaction", err)
+		}
+	}
+	if !found {
+		// A charge still waiting for the gateway's answer has no payment ID
+		balance, err := invoiceBalance(ctx, repos, invoice)
+		if err != nil {
+			return webhookOutcome{}, err
+		}
+		if u := balance.unsettled; u != nil && u.Gateway == gateway && u.AmountCents == event.AmountCents {
+			txn, found = *u, true
+		}
+	}
+
+	switch {
+	case found && txn.Status == event.Status:
+		// Already recorded, e.g. by the payment call itself
+	case found && txn.Status == TransactionSucceeded:
+		return ignored("payment already succeeded"), nil
+	case found:
+		if _, err := repos.Transactions.UpdateStatus(ctx, txn.ID, event.Status, event.PaymentID, event.Raw); err != nil {
+			return webhookOutcome{}, E.NewInternalError("could not update transaction", err)
+		}
+		if err := settleCreditPayments(ctx, repos, invoice, event.Status); err != nil {
+			return webhookOutcome{}, err
+		}
+	default:
+		currency := event.Currency
+		if currency == "" {
+			currency = invoice.Currency
+		}
+		if _, err := repos.Transactions.Create(ctx, generated.CreateTransactionParams{
+			InvoiceID:        pgtype.UUID{Bytes: invoice.ID, Valid: true},
+			CustomerID:       invoice.CustomerID,
+			Gateway:          gateway,
+			GatewayPaymentID: pgtype.Text{String: event.PaymentID, Valid: event.PaymentID != ""},
+			AmountCents:      event.AmountCents,
+			Currency:         currency,
+			Status:           event.Status,
+			RawResponse:      event.Raw,
+		}); err != nil {
+			return webhookOutcome{}, E.NewInternalError("could not record payment", err)
+		}
+	}
+
+	if event.Status == payment.StatusFailed {
+		if invoice.Status == InvoiceIssued {
+			if _, err := repos.Invoices.MarkFailed(ctx, invoice.ID); err != nil {
+				return webhookOutcome{}, E.NewInternalError("could not mark invoice failed", err)
+			}
+		}
+		return applied, nil
+	}
+
+	if invoice.Status != InvoiceIssued && invoice.Status != InvoiceFailed {
+		return applied, nil
+	}
+
+	balance, err := invoiceBalance(ctx, repos, invoice)
+	if err != nil {
+		return webhookOutcome{}, err
+	}
+	if balance.due > 0 {
+		return applied, nil
+	}
+
+	paidAt := event.OccurredAt
+	if paidAt.IsZero() {
+		paidAt = time.Now()
+	}
+	if _, err := repos.Invoices.MarkPaid(ctx, invoice.ID, paidAt); err != nil {
+		return webhookOutcome{}, E.NewInternalError("could not mark invoice paid", err)
+	}
+	return applied, nil
+}
+
+// applyCheckout activates the pending subscription a checkout payment refers
+// to. Failed checkout payments leave it pending; the customer may retry on
+// the hosted page. Succeeded payments that cannot activate it are kept and
+// the event is flagged.
+func (s *webhookService) applyCheckout(ctx context.Context, repos repository.Repositories, gateway string, event payment.Event) (webhookOutcome, error) {
+	id, err := uuid.Parse(event.Reference)
+	if err != nil {
+		return ignored("invoice not found"), nil
+	}
+
+	if _, err := repos.Subscriptions.FindByID(ctx, id); err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return ignored("invoice not found"), nil
+		}
+		return webhookOutcome{}, E.NewInternalError("could not retrieve subscription", err)
+	}
+	if event.Status != payment.StatusSucceeded {
+		return ignored("checkout payment failed"), nil
+	}
+
+	occurredAt := event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+	_, note, err := activateCheckout(ctx, repos, s.builder, id, checkoutPayment{
+		gateway:   gateway,
+		paymentID: event.PaymentID,
+		amount:    event.AmountCents,
+		currency:  event.Currency,
+		raw:       event.Raw,
+	}, occurredAt)
+	if err != nil {
+		return webhookOutcome{}, err
+	}
+	if note != "" {
+		return flagged(note), nil
+	}
+	return applied, nil
+}
+
+// applySubscription copies the gateway's view of a subscription onto ours.
+// Status changes our lifecycle does not allow are ignored.
+func (s *webhookService) applySubscription(ctx context.Context, repos repository.Repositories, event payment.Event) (webhookOutcome, error) {
+	update := event.Subscription
+	if update == nil {
+		return ignored("event carries no subscription"), nil
+	}
+
+	sub, err := findEventSubscription(ctx, repos, event)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return ignored("subscription not found"), nil
+		}
+		return webhookOutcome{}, E.NewInternalError("could not retrieve subscription", err)
+	}
+
+	if sub.Status == SubscriptionCanceled {
+		return ignored("subscription is already canceled"), nil
+	}
+	if syncedAt := gatewaySyncedAt(sub.Metadata); !event.OccurredAt.IsZero() && event.OccurredAt.Before(syncedAt) {
+		return ignored("a newer event was already applied"), nil
+	}
+
+	expected := sub.Status
+	if update.Status != "" && update.Status != sub.Status {
+		if !canTransition(sub.Status, update.Status) {
+			return ignored(fmt.Sprintf("subscription cannot move from %s to %s", sub.Status, update.Status)), nil
+		}
+		sub.Status = update.Status
+	}
+	if !update.CurrentPeriodStart.IsZero() {
+		sub.CurrentPeriodStart = timestamptz(update.CurrentPeriodStart)
+	}
+	if !update.CurrentPeriodEnd.IsZero() {
+		sub.CurrentPeriodEnd = timestamptz(update.CurrentPeriodEnd)
+	}
+	sub.CancelAtPeriodEnd = pgtype.Bool{Bool: update.CancelAtPeriodEnd, Valid: true}
+	if !update.CanceledAt.IsZero() {
+		sub.CanceledAt = timestamptz(update.CanceledAt)
+	}
+
+	if _, err := repos.Subscriptions.UpdateState(ctx, expected, sub); err != nil {
+		// A concurrent change fails the delivery and the gateway retries it
+		return webhookOutcome{}, E.NewInternalError("could not update subscription", err)
+	}
+
+	if update.ID != "" && sub.GatewaySubscriptionID.String != update.ID {
+		if err := repos.Subscriptions.SetGatewayID(ctx, sub.ID, update.ID); err != nil {
+			return webhookOutcome{}, E.NewInternalError("could not link gateway subscription", err)
+		}
+	}
+
+	if !event.OccurredAt.IsZero() {
+		patch, err := json.Marshal(map[string]time.Time{gatewaySyncedAtKey: event.OccurredAt})
+		if err != nil {
+			return webhookOutcome{}, E.NewInternalError("could not encode subscription metadata", err)
+		}
+		if err := repos.Subscriptions.MergeMetadata(ctx, sub.ID, patch); err != nil {
+			return webhookOutcome{}, E.NewInternalError("could not update subscription metadata", err)
+		}
+	}
+
+	return applied, nil
+}
+
+// findEventInvoice locks the invoice a payment event refers to, by our ID
+// when the gateway echoes it back and by the gateway's invoice ID otherwise.
+func findEventInvoice(ctx context.Context, repos repository.Repositories, event payment.Event) (generated.Invoice, error) {
+	if id, err := uuid.Parse(event.Reference); err == nil {
+		return repos.Invoices.FindForUpdate(ctx, id)
+	}
+	if event.GatewayInvoiceID != "" {
+		return repos.Invoices.FindByGatewayIDForUpdate(ctx, event.GatewayInvoiceID)
+	}
+	return generated.Invoice{}, E.ErrNotFound
+}
+
+func findEventSubscription(ctx context.Context, repos repository.Repositories, event payment.Event) (generated.Subscription, error) {
+	if id, err := uuid.Parse(event.Reference); err == nil {
+		return repos.Subscriptions.FindByID(ctx, id)
+	}
+	if event.Subscription.ID != "" {
+		return repos.Subscriptions.FindByGatewayID(ctx, event.Subscription.ID)
+	}
+	return generated.Subscription{}, E.ErrNotFound
+}
+
+func gatewaySyncedAt(metadata []byte) time.Time {
+	var meta struct {
+		SyncedAt time.Time `json:"gateway_synced_at"`
+	}
+	if len(metadata) > 0 {
+		_ = json.Unmarshal(metadata, &meta)
+	}
+	return meta.SyncedAt
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/payment"
+)
+
+const stripeTestSecret = "whsec_stripe_test"
+
+// stripeDelivery signs body the way Stripe signs webhook deliveries.
+func stripeDelivery(body string) http.Header {
+	signedAt := time.Now().Unix()
+	mac := hmac.New(sha256.New, []byte(stripeTestSecret))
+	fmt.Fprintf(mac, "%d.%s", signedAt, body)
+
+	header := http.Header{}
+	header.Set(payment.StripeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", signedAt, hex.EncodeToString(mac.Sum(nil))))
+	return header
+}
+
+func newStripeWebhooks(store *memStore) WebhookService {
+	repos := store.repos()
+	verifiers := map[string]payment.WebhookVerifier{
+		payment.GatewayStripe: payment.NewStripeWebhook(stripeTestSecret, 0),
+	}
+	return NewWebhookService(repos, memUnitOfWork{repos: repos}, nil, verifiers)
+}
+
+func stripeInvoiceEvent(eventID, eventType string, invoiceID uuid.UUID, amountCents int64) string {
+	return fmt.Sprintf(`{"id":%q,"type":%q,"created":%d,"data":{"object":{"id":"in_1","amount_paid":%d,"amount_due":%d,"currency":"usd","payment_intent":"pi_1","metadata":{"invoice_id":%q}}}}`,
+		eventID, eventType, time.Now().Unix(), amountCents, amountCents, invoiceID)
+}
+
+func TestStripeWebhooks(t *testing.T) {
+	type delivery struct {
+		body     func(invoiceID uuid.UUID) string
+		tamper   bool
+		wantHTTP int
+	}
+	succeeded := func(invoiceID uuid.UUID) string {
+		return stripeInvoiceEvent("evt_paid", "invoice.payment_succeeded", invoiceID, 2000)
+	}
+	failed := func(invoiceID uuid.UUID) string {
+		return stripeInvoiceEvent("evt_failed", "invoice.payment_failed", invoiceID, 2000)
+	}
+	unhandled := func(uuid.UUID) string {
+		return fmt.Sprintf(`{"id":"evt_customer","type":"customer.created","created":%d,"data":{"object":{}}}`, time.Now().Unix())
+	}
+
+	tests := []struct {
+		name              string
+		deliveries        []delivery
+		wantInvoiceStatus string
+		wantTransactions  int
+		wantEvents        map[string]string
+	}{
+		{
+			name:              "payment succeeded",
+			deliveries:        []delivery{{body: succeeded}},
+			wantInvoiceStatus: InvoicePaid,
+			wantTransactions:  1,
+			wantEvents:        map[string]string{"evt_paid": WebhookProcessed},
+		},
+		{
+			name:              "redelivery is applied once",
+			deliveries:        []delivery{{body: succeeded}, {body: succeeded}},
+			wantInvoiceStatus: InvoicePaid,
+			wantTransactions:  1,
+			wantEvents:        map[string]string{"evt_paid": WebhookProcessed},
+		},
+		{
+			name:              "payment failed",
+			deliveries:        []delivery{{body: failed}},
+			wantInvoiceStatus: InvoiceFailed,
+			wantTransactions:  1,
+			wantEvents:        map[string]string{"evt_failed": WebhookProcessed},
+		},
+		{
+			name:              "tampered body",
+			deliveries:        []delivery{{body: succeeded, tamper: true, wantHTTP: http.StatusUnauthorized}},
+			wantInvoiceStatus: InvoiceIssued,
+			wantEvents:        map[string]string{},
+		},
+		{
+			name:              "unhandled event",
+			deliveries:        []delivery{{body: unhandled}},
+			wantInvoiceStatus: InvoiceIssued,
+			wantEvents:        map[string]string{"evt_customer": WebhookIgnored},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			webhooks := newStripeWebhooks(f.store)
+			_, invoice := f.addIssuedInvoice(2000)
+
+			for i, d := range tt.deliveries {
+				body := d.body(invoice.ID)
+				header := stripeDelivery(body)
+				if d.tamper {
+					body = stripeInvoiceEvent("evt_paid", "invoice.payment_succeeded", invoice.ID, 1)
+				}
+
+				err := webhooks.Handle(context.Background(), payment.GatewayStripe, header, []byte(body))
+				if d.wantHTTP != 0 {
+					wantHTTPStatus(t, err, d.wantHTTP)
+					continue
+				}
+				if err != nil {
+					t.Fatalf("delivery %d: %v", i, err)
+				}
+			}
+
+			if got := f.invoice(t, invoice.ID).Status; got != tt.wantInvoiceStatus {
+				t.Fatalf("invoice status = %s, want %s", got, tt.wantInvoiceStatus)
+			}
+			if n := len(f.transactions(t, invoice.ID)); n != tt.wantTransactions {
+				t.Fatalf("got %d transactions, want %d", n, tt.wantTransactions)
+			}
+			if len(f.store.webhooks) != len(tt.wantEvents) {
+				t.Fatalf("stored %d events, want %d", len(f.store.webhooks), len(tt.wantEvents))
+			}
+			for _, e := range f.store.webhooks {
+				if e.Status != tt.wantEvents[e.EventID] {
+					t.Fatalf("event %s is %s, want %s", e.EventID, e.Status, tt.wantEvents[e.EventID])
+				}
+			}
+		})
+	}
+}
+
+func TestStripeSubscriptionEventsApplyInOrder(t *testing.T) {
+	store := newMemStore()
+	webhooks := newStripeWebhooks(store)
+	sub := &generated.Subscription{
+		ID:                 uuid.New(),
+		Status:             SubscriptionActive,
+		CurrentPeriodStart: timestamptz(time.Now().AddDate(0, -1, 0)),
+		CurrentPeriodEnd:   timestamptz(time.Now()),
+		Metadata:           []byte("{}"),
+	}
+	store.subscriptions = append(store.subscriptions, sub)
+
+	event := func(id, status string, created time.Time) string {
+		return fmt.Sprintf(`{"id":%q,"type":"customer.subscription.updated","created":%d,"data":{"object":{"id":"sub_1","status":%q,"metadata":{"subscription_id":%q}}}}`,
+			id, created.Unix(), status, sub.ID)
+	}
+
+	now := time.Now()
+	steps := []struct {
+		body       string
+		wantStatus string
+		wantEvent  string
+	}{
+		{event("evt_2", "past_due", now), SubscriptionPastDue, WebhookProcessed},
+		{event("evt_1", "active", now.Add(-time.Minute)), SubscriptionPastDue, WebhookIgnored},
+		{event("evt_3", "trialing", now.Add(time.Minute)), SubscriptionPastDue, WebhookIgnored},
+		{event("evt_4", "active", now.Add(2*time.Minute)), SubscriptionActive, WebhookProcessed},
+	}
+
+	for i, st := range steps {
+		if err := webhooks.Handle(context.Background(), payment.GatewayStripe, stripeDelivery(st.body), []byte(st.body)); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if sub.Status != st.wantStatus {
+			t.Fatalf("step %d: subscription is %s, want %s", i, sub.Status, st.wantStatus)
+		}
+		if got := store.webhooks[i].Status; got != st.wantEvent {
+			t.Fatalf("step %d: event is %s, want %s", i, got, st.wantEvent)
+		}
+	}
+	if sub.GatewaySubscriptionID != (pgtype.Text{String: "sub_1", Valid: true}) {
+		t.Fatalf("gateway subscription = %v, want sub_1", sub.GatewaySubscriptionID)
+	}
+}
+
+func TestGatewayNotificationsPayInvoice(t *testing.T) {
+	const midtransKey, xenditToken = "SB-Mid-server-test", "xnd_callback_token"
+
+	tests := []struct {
+		name     string
+		gateway  string
+		delivery func(invoiceID uuid.UUID) (http.Header, string)
+	}{
+		{
+			name:    "midtrans",
+			gateway: payment.GatewayMidtrans,
+			delivery: func(invoiceID uuid.UUID) (http.Header, string) {
+				sum := sha512.Sum512([]byte(invoiceID.String() + "200" + "150000.00" + midtransKey))
+				return http.Header{}, fmt.Sprintf(`{"transaction_id":"tx-1","transaction_status":"settlement","status_code":"200","order_id":%q,"gross_amount":"150000.00","currency":"IDR","signature_key":%q}`,
+					invoiceID, hex.EncodeToString(sum[:]))
+			},
+		},
+		{
+			name:    "xendit",
+			gateway: payment.GatewayXendit,
+			delivery: func(invoiceID uuid.UUID) (http.Header, string) {
+				header := http.Header{}
+				header.Set(payment.XenditCallbackTokenHeader, xenditToken)
+				return header, fmt.Sprintf(`{"id":"inv-1","external_id":%q,"status":"PAID","amount":150000,"paid_amount":150000,"currency":"IDR"}`, invoiceID)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPaymentFlow()
+			repos := f.store.repos()
+			webhooks := NewWebhookService(repos, memUnitOfWork{repos: repos}, nil, map[string]payment.WebhookVerifier{
+				payment.GatewayMidtrans: payment.NewMidtransWebhook(midtransKey),
+				payment.GatewayXendit:   payment.NewXenditWebhook(xenditToken),
+			})
+			_, invoice := f.addIssuedInvoice(150000)
+			f.store.invoices[0].Currency = "IDR"
+
+			header, body := tt.delivery(invoice.ID)
+			for i := 0; i < 2; i++ {
+				if err := webhooks.Handle(context.Background(), tt.gateway, header, []byte(body)); err != nil {
+					t.Fatalf("delivery %d: %v", i, err)
+				}
+			}
+
+			if got := f.invoice(t, invoice.ID).Status; got != InvoicePaid {
+				t.Fatalf("invoice status = %s, want %s", got, InvoicePaid)
+			}
+			txns := f.transactions(t, invoice.ID)
+			if len(txns) != 1 || txns[0].Gateway != tt.gateway || txns[0].AmountCents != 150000 {
+				t.Fatalf("transactions = %+v, want one %s payment of 150000", txns, tt.gateway)
+			}
+			if len(f.store.webhooks) != 1 {
+				t.Fatalf("stored %d events, want 1", len(f.store.webhooks))
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
@@ -14,6 +15,31 @@ type Config struct {
 	Port        string
 	DatabaseURL string
 	JWTSecret   string
+
+	// AdminUserIDs lists the users, by the subject of their JWT, who may
+	// call the /admin endpoints.
+	AdminUserIDs []string
+
+	// InvoiceNumberPrefix starts invoice numbers, e.g. "INV" in
+	// "INV-2026-000123". InvoiceNumberPrefixes overrides it per currency.
+	InvoiceNumberPrefix   string
+	InvoiceNumberPrefixes map[string]string
+
+	// StorageDir is where generated files such as invoice PDFs are kept.
+	StorageDir string
+
+	// PaymentGateway selects the payment provider. Only "fake" is built in;
+	// it answers every attempt with FakeGatewayOutcome.
+	PaymentGateway     string
+	FakeGatewaySecret  string
+	FakeGatewayOutcome string
+
+	// StripeWebhookSecret, XenditCallbackToken and MidtransServerKey verify
+	// the webhooks of each gateway. A gateway's endpoint is disabled while
+	// its secret is empty.
+	StripeWebhookSecret string
+	XenditCallbackToken string
+	MidtransServerKey   string
 }
 
 func Load() *Config {
@@ -27,5 +53,51 @@ func Load() *Config {
 		Port:        os.Getenv("PORT"),
 		DatabaseURL: os.Getenv("DATABASE_URL"),
 		JWTSecret:   os.Getenv("JWT_SECRET"),
+
+		AdminUserIDs: parseList(os.Getenv("ADMIN_USER_IDS")),
+
+		InvoiceNumberPrefix:   getEnv("INVOICE_NUMBER_PREFIX", "INV"),
+		InvoiceNumberPrefixes: parsePairs(os.Getenv("INVOICE_NUMBER_PREFIXES")),
+
+		StorageDir: getEnv("STORAGE_DIR", "./storage"),
+
+		PaymentGateway:     getEnv("PAYMENT_GATEWAY", "fake"),
+		FakeGatewaySecret:  os.Getenv("FAKE_GATEWAY_SECRET"),
+		FakeGatewayOutcome: getEnv("FAKE_GATEWAY_OUTCOME", "succeed"),
+
+		StripeWebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		XenditCallbackToken: os.Getenv("XENDIT_CALLBACK_TOKEN"),
+		MidtransServerKey:   os.Getenv("MIDTRANS_SERVER_KEY"),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseList parses "a,b" into its non-empty, trimmed parts.
+func parseList(raw string) []string {
+	var list []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
+
+// parsePairs parses "KEY=value,KEY2=value2" into a map. Keys are upper-cased.
+func parsePairs(raw string) map[string]string {
+	pairs := make(map[string]string)
+	for _, part := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" || value == "" {
+			continue
+		}
+		pairs[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(value)
 	}
+	return pairs
 }
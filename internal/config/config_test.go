@@ -0,0 +1,35 @@
+package config
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParsePairs(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]string
+	}{
+		{name: "empty", raw: "", want: map[string]string{}},
+		{name: "one", raw: "IDR=INV-ID", want: map[string]string{"IDR": "INV-ID"}},
+		{
+			name: "upper-cased keys and trimmed values",
+			raw:  " idr = INV-ID , EUR=INV-EU",
+			want: map[string]string{"IDR": "INV-ID", "EUR": "INV-EU"},
+		},
+		{
+			name: "malformed pairs are skipped",
+			raw:  "IDR,=INV,EUR=,USD=INV-US",
+			want: map[string]string{"USD": "INV-US"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePairs(tt.raw); !maps.Equal(got, tt.want) {
+				t.Fatalf("parsePairs(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/novaru/billing-service/internal/app/service"
 	"github.com/novaru/billing-service/internal/config"
 	E "github.com/novaru/billing-service/internal/shared/errors"
 	"github.com/novaru/billing-service/internal/shared/response"
@@ -13,7 +15,10 @@ import (
 
 type contextKey string
 
-const userCtxKey contextKey = "user_id"
+const (
+	userCtxKey     contextKey = "user_id"
+	customerCtxKey contextKey = "customer"
+)
 
 // AuthMiddleware validates JWT token and attaches user ID to request context.
 func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
@@ -25,66 +30,146 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				response.WriteError(w, E.NewUnauthorizedError("invalid authorization header", nil))
+			userID, err := bearerUser(cfg, authHeader)
+			if err != nil {
+				response.WriteError(w, err)
 				return
 			}
 
-			tokenStr := parts[1]
+			// Put user ID into request context
+			ctx := context.WithValue(r.Context(), userCtxKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-			// Parse and validate JWT
-			token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
-				// Ensure token uses expected signing method
-				if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
-				}
-				return []byte(cfg.JWTSecret), nil
-			})
+// OptionalAuth attaches the user ID to the request context like
+// AuthMiddleware when a JWT is sent, and lets anonymous requests through.
+func OptionalAuth(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			if err != nil || !token.Valid {
-				response.WriteError(w, E.NewUnauthorizedError("invalid or expired token", nil))
+			userID, err := bearerUser(cfg, authHeader)
+			if err != nil {
+				response.WriteError(w, err)
 				return
 			}
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				response.WriteError(w, E.NewUnauthorizedError("invalid token claims", nil))
+			ctx := context.WithValue(r.Context(), userCtxKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerUser validates the JWT of an Authorization header and returns its
+// subject.
+func bearerUser(cfg *config.Config, authHeader string) (string, error) {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", E.NewUnauthorizedError("invalid authorization header", nil)
+	}
+
+	tokenStr := parts[1]
+
+	// Parse and validate JWT
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
+		// Ensure token uses expected signing method
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(cfg.JWTSecret), nil
+	})
+
+	if err != nil || !token.Valid {
+		return "", E.NewUnauthorizedError("invalid or expired token", nil)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", E.NewUnauthorizedError("invalid token claims", nil)
+	}
+
+	userID, ok := claims["sub"].(string)
+	if !ok {
+		return "", E.NewUnauthorizedError("invalid token subject", nil)
+	}
+	return userID, nil
+}
+
+// APIKeyAuth validates the API key sent as a bearer token and attaches the
+// owning customer to the request context.
+func APIKeyAuth(apiKeys service.ApiKeyService) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				response.WriteError(w, E.NewUnauthorizedError("API key required", nil))
 				return
 			}
 
-			userID, ok := claims["sub"].(string)
-			if !ok {
-				response.WriteError(w, E.NewUnauthorizedError("invalid token subject", nil))
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				response.WriteError(w, E.NewUnauthorizedError("invalid authorization header", nil))
 				return
 			}
 
-			// Put user ID into request context
-			ctx := context.WithValue(r.Context(), userCtxKey, userID)
+			customer, err := apiKeys.Authenticate(r.Context(), parts[1])
+			if err != nil {
+				response.WriteError(w, err)
+				return
+			}
+
+			// Put customer principal into request context
+			ctx := context.WithValue(r.Context(), customerCtxKey, customer)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func APIKeyAuth() func(next http.Handler) http.Handler {
+// RequireScope rejects requests whose API key was not granted the given scope.
+// It must run after APIKeyAuth.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKey := r.Header.Get("Authorization")
-			if apiKey == "" {
-				http.Error(w, "API key required", http.StatusUnauthorized)
+			customer, ok := GetCustomer(r)
+			if !ok {
+				response.WriteError(w, E.NewUnauthorizedError("API key required", nil))
 				return
 			}
 
-			// Validate API key, get customer info
-			customer, err := validateAPIKey(apiKey)
-			if err != nil {
-				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			if !customer.HasScope(scope) {
+				response.WriteError(w, E.NewForbiddenError(fmt.Sprintf("API key is missing required scope %q", scope), nil))
 				return
 			}
 
-			// Add customer to context
-			ctx := context.WithValue(r.Context(), "customer", customer)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAdmin rejects users whose ID is not one of cfg.AdminUserIDs. It
+// must run after AuthMiddleware.
+func RequireAdmin(cfg *config.Config) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := GetUserID(r)
+			if !ok {
+				response.WriteError(w, E.NewUnauthorizedError("missing user in request context", nil))
+				return
+			}
+
+			for _, admin := range cfg.AdminUserIDs {
+				if admin == userID {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			response.WriteError(w, E.NewForbiddenError("admin access required", nil))
 		})
 	}
 }
@@ -95,7 +180,8 @@ func GetUserID(r *http.Request) (string, bool) {
 	return id, ok
 }
 
-func validateAPIKey(key string) (any, error) {
-	// TODO: implement validation
-	return key, nil
+// GetCustomer extracts the API key principal from request context
+func GetCustomer(r *http.Request) (service.CustomerPrincipal, bool) {
+	customer, ok := r.Context().Value(customerCtxKey).(service.CustomerPrincipal)
+	return customer, ok
 }
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/novaru/billing-service/internal/app/service"
+)
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		customer *service.CustomerPrincipal
+		want     int
+	}{
+		{
+			name:     "granted",
+			customer: &service.CustomerPrincipal{Scopes: []string{service.ScopeUsageRead, service.ScopeUsageWrite}},
+			want:     http.StatusNoContent,
+		},
+		{
+			name:     "missing scope",
+			customer: &service.CustomerPrincipal{Scopes: []string{service.ScopeUsageRead}},
+			want:     http.StatusForbidden,
+		},
+		{
+			name: "no API key",
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/usage", nil)
+			if tt.customer != nil {
+				r = r.WithContext(context.WithValue(r.Context(), customerCtxKey, *tt.customer))
+			}
+			w := httptest.NewRecorder()
+
+			RequireScope(service.ScopeUsageWrite)(next).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
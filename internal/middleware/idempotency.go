@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/novaru/billing-service/internal/app/service"
+	E "github.com/novaru/billing-service/internal/shared/errors"
+	"github.com/novaru/billing-service/internal/shared/response"
+	"github.com/novaru/billing-service/pkg/logger"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response replayed from storage.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBody       = 1 << 20
+)
+
+// Idempotency makes POST and PUT requests that carry an Idempotency-Key
+// header safe to retry. The first request with a key runs and its response
+// is stored; retries with the same key and body get the stored response.
+// Reusing a key for a different request, or while the first one is still
+// running, is rejected with 409. Server errors are not stored so the retry
+// runs again. It must run after the authentication middleware, since keys
+// are scoped to the caller.
+func Idempotency(store service.IdempotencyService) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPut) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				response.WriteError(w, E.NewInvalidInputError("Idempotency-Key must be at most 255 characters", nil))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+			if err != nil {
+				response.WriteError(w, E.NewInvalidInputError("could not read request body", err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scope := idempotencyScope(r)
+			stored, err := store.Begin(r.Context(), scope, key, requestFingerprint(r, body))
+			if err != nil {
+				response.WriteError(w, err)
+				return
+			}
+			if stored != nil {
+				replay(w, *stored)
+				return
+			}
+
+			// Settle the key even when the client went away mid-request
+			ctx := context.WithoutCancel(r.Context())
+			rec := &responseRecorder{ResponseWriter: w}
+			finished := false
+			defer func() {
+				if finished {
+					return
+				}
+				// The handler panicked; let the client retry
+				if err := store.Release(ctx, scope, key); err != nil {
+					logger.Error("failed to release idempotency key", zap.Error(err))
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+			finished = true
+
+			if rec.status >= http.StatusInternalServerError {
+				err = store.Release(ctx, scope, key)
+			} else {
+				err = store.Complete(ctx, scope, key, service.StoredResponse{
+					Status: rec.statusCode(),
+					Header: rec.header,
+					Body:   rec.body.Bytes(),
+				})
+			}
+			if err != nil {
+				logger.Error("failed to settle idempotency key", zap.Error(err))
+			}
+		})
+	}
+}
+
+// idempotencyScope keeps the keys of different callers apart.
+func idempotencyScope(r *http.Request) string {
+	if userID, ok := GetUserID(r); ok {
+		return "user:" + userID
+	}
+	if customer, ok := GetCustomer(r); ok {
+		return "customer:" + customer.CustomerID.String()
+	}
+	return "public"
+}
+
+// requestFingerprint identifies the request a key was first used for.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, stored service.StoredResponse) {
+	for name, values := range stored.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// responseRecorder passes the response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+		// Set by the transport for each response
+		rec.header.Del("Content-Length")
+		rec.header.Del("Content-Encoding")
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/novaru/billing-service/db/generated"
+	"github.com/novaru/billing-service/internal/app/repository"
+	"github.com/novaru/billing-service/internal/app/service"
+)
+
+// memIdempotency keeps idempotency keys in memory like the idempotency_keys
+// table, without expiry.
+type memIdempotency struct {
+	repository.IdempotencyRepository
+	keys map[string]*generated.IdempotencyKey
+}
+
+func (r *memIdempotency) Claim(_ context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (generated.IdempotencyKey, bool, error) {
+	id := scope + "\x00" + key
+	if row, ok := r.keys[id]; ok {
+		if row.Status != service.IdempotencyInFlight || !row.CreatedAt.Time.Before(staleBefore) {
+			return *row, false, nil
+		}
+	}
+
+	row := &generated.IdempotencyKey{
+		Scope:          scope,
+		IdempotencyKey: key,
+		Fingerprint:    fingerprint,
+		Status:         service.IdempotencyInFlight,
+		CreatedAt:      pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		ExpiresAt:      pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	}
+	r.keys[id] = row
+	return *row, true, nil
+}
+
+func (r *memIdempotency) Complete(_ context.Context, scope, key string, status int, header, body []byte) error {
+	row := r.keys[scope+"\x00"+key]
+	row.Status = service.IdempotencyCompleted
+	row.ResponseStatus = pgtype.Int4{Int32: int32(status), Valid: true}
+	row.ResponseHeaders = header
+	row.ResponseBody = body
+	return nil
+}
+
+func (r *memIdempotency) Release(_ context.Context, scope, key string) error {
+	delete(r.keys, scope+"\x00"+key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	type request struct {
+		method     string
+		key        string
+		body       string
+		userID     string
+		wantStatus int
+		wantRuns   int
+		replayed   bool
+	}
+	post := func(key, body string) request {
+		return request{method: http.MethodPost, key: key, body: body}
+	}
+	expect := func(r request, status, runs int, replayed bool) request {
+		r.wantStatus, r.wantRuns, r.replayed = status, runs, replayed
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		requests []request
+	}{
+		{
+			name: "retry is replayed",
+			requests: []request{
+				expect(post("k1", `{"amount":1}`), http.StatusCreated, 1, false),
+				expect(post("k1", `{"amount":1}`), http.StatusCreated, 1, true),
+			},
+		},
+		{
+			name: "key reused for another body",
+			requests: []request{
+				expect(post("k1", `{"amount":1}`), http.StatusCreated, 1, false),
+				expect(post("k1", `{"amount":2}`), http.StatusConflict, 1, false),
+			},
+		},
+		{
+			name: "keys are scoped to the caller",
+			requests: []request{
+				expect(request{method: http.MethodPost, key: "k1", body: "{}", userID: "alice"}, http.StatusCreated, 1, false),
+				expect(request{method: http.MethodPost, key: "k1", body: "{}", userID: "bob"}, http.StatusCreated, 2, false),
+			},
+		},
+		{
+			name:     "server errors run again",
+			failures: 1,
+			requests: []request{
+				expect(post("k1", "{}"), http.StatusInternalServerError, 1, false),
+				expect(post("k1", "{}"), http.StatusCreated, 2, false),
+				expect(post("k1", "{}"), http.StatusCreated, 2, true),
+			},
+		},
+		{
+			name: "without a key",
+			requests: []request{
+				expect(post("", "{}"), http.StatusCreated, 1, false),
+				expect(post("", "{}"), http.StatusCreated, 2, false),
+			},
+		},
+		{
+			name: "reads are not stored",
+			requests: []request{
+				expect(request{method: http.MethodGet, key: "k1"}, http.StatusCreated, 1, false),
+				expect(request{method: http.MethodGet, key: "k1"}, http.StatusCreated, 2, false),
+			},
+		},
+		{
+			name: "key too long",
+			requests: []request{
+				expect(post(strings.Repeat("k", 256), "{}"), http.StatusBadRequest, 0, false),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := service.NewIdempotencyStore(&memIdempotency{keys: map[string]*generated.IdempotencyKey{}}, time.Hour, time.Minute)
+
+			runs, failures := 0, tt.failures
+			handler := Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				runs++
+				if failures > 0 {
+					failures--
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"run":%d,"request":%s}`, runs, body)
+			}))
+
+			var first string
+			for i, req := range tt.requests {
+				r := httptest.NewRequest(req.method, "/api/v1/usage", strings.NewReader(req.body))
+				if req.key != "" {
+					r.Header.Set(IdempotencyKeyHeader, req.key)
+				}
+				if req.userID != "" {
+					r = r.WithContext(context.WithValue(r.Context(), userCtxKey, req.userID))
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != req.wantStatus || runs != req.wantRuns {
+					t.Fatalf("request %d: status %d after %d runs, want %d after %d", i, w.Code, runs, req.wantStatus, req.wantRuns)
+				}
+				if got := w.Header().Get(IdempotentReplayedHeader) == "true"; got != req.replayed {
+					t.Fatalf("request %d: replayed = %t, want %t", i, got, req.replayed)
+				}
+				if req.replayed {
+					if w.Body.String() != first || w.Header().Get("Content-Type") != "application/json" {
+						t.Fatalf("request %d: replayed %q (%s), want %q", i, w.Body.String(), w.Header().Get("Content-Type"), first)
+					}
+				} else if w.Code == http.StatusCreated {
+					first = w.Body.String()
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotencyRejectsConcurrentRetry(t *testing.T) {
+	store := service.NewIdempotencyStore(&memIdempotency{keys: map[string]*generated.IdempotencyKey{}}, time.Hour, time.Minute)
+
+	var retry *httptest.ResponseRecorder
+	var handler http.Handler
+	handler = Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retry == nil {
+			// The client retries while the first request is still running
+			retry = httptest.NewRecorder()
+			again := httptest.NewRequest(http.MethodPost, "/api/v1/usage", strings.NewReader("{}"))
+			again.Header.Set(IdempotencyKeyHeader, "k1")
+			handler.ServeHTTP(retry, again)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/usage", strings.NewReader("{}"))
+	r.Header.Set(IdempotencyKeyHeader, "k1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated || retry.Code != http.StatusConflict {
+		t.Fatalf("first request %d, concurrent retry %d; want %d and %d", w.Code, retry.Code, http.StatusCreated, http.StatusConflict)
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	fingerprint := func(method, target, body string) string {
+		return requestFingerprint(httptest.NewRequest(method, target, nil), []byte(body))
+	}
+	base := fingerprint(http.MethodPost, "/api/v1/usage", `{"quantity":1}`)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		wantSame bool
+	}{
+		{name: "same request", method: http.MethodPost, target: "/api/v1/usage", body: `{"quantity":1}`, wantSame: true},
+		{name: "same request on another host", method: http.MethodPost, target: "http://other.example/api/v1/usage", body: `{"quantity":1}`, wantSame: true},
+		{name: "other body", method: http.MethodPost, target: "/api/v1/usage", body: `{"quantity":2}`},
+		{name: "other path", method: http.MethodPost, target: "/api/v1/usage/batch", body: `{"quantity":1}`},
+		{name: "other query", method: http.MethodPost, target: "/api/v1/usage?dry_run=1", body: `{"quantity":1}`},
+		{name: "other method", method: http.MethodPut, target: "/api/v1/usage", body: `{"quantity":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fingerprint(tt.method, tt.target, tt.body) == base; got != tt.wantSame {
+				t.Fatalf("same fingerprint = %t, want %t", got, tt.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Outcome tells the fake gateway how to answer payment attempts.
+type Outcome string
+
+const (
+	OutcomeSucceed Outcome = "succeed"
+	OutcomeDecline Outcome = "decline"
+	OutcomeTimeout Outcome = "timeout"
+)
+
+// FakeSignatureHeader carries the HMAC-SHA256 of a fake webhook body.
+const FakeSignatureHeader = "Fake-Signature"
+
+// FakeGateway is an in-process PaymentGateway for tests and local
+// development. Charges and completed checkouts follow the queued outcomes,
+// then the default outcome. Timed-out payments stay pending until Settle is
+// called, just like a real provider that settles later.
+type FakeGateway struct {
+	secret  string
+	timeout time.Duration
+
+	mu       sync.Mutex
+	outcome  Outcome
+	queue    []Outcome
+	seq      int
+	payments map[string]*Payment
+	sessions map[string]CheckoutRequest
+	keys     map[string]string // idempotency key to payment, session or refund ID
+	refunds  map[string]Refund
+}
+
+// NewFakeGateway returns a fake that signs webhooks with secret and succeeds
+// until told otherwise. A timeout outcome blocks for timeout or until the
+// context ends.
+func NewFakeGateway(secret string, timeout time.Duration) *FakeGateway {
+	return &FakeGateway{
+		secret:   secret,
+		timeout:  timeout,
+		outcome:  OutcomeSucceed,
+		payments: make(map[string]*Payment),
+		sessions: make(map[string]CheckoutRequest),
+		keys:     make(map[string]string),
+		refunds:  make(map[string]Refund),
+	}
+}
+
+// SetOutcome sets the outcome of every attempt without a queued outcome.
+func (g *FakeGateway) SetOutcome(o Outcome) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.outcome = o
+}
+
+// QueueOutcomes sets the outcomes of the next attempts, in order.
+func (g *FakeGateway) QueueOutcomes(outcomes ...Outcome) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.queue = append(g.queue, outcomes...)
+}
+
+func (g *FakeGateway) Name() string {
+	return GatewayFake
+}
+
+func (g *FakeGateway) CreateCheckoutSession(_ context.Context, req CheckoutRequest) (CheckoutSession, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if id, ok := g.keys["checkout:"+req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return g.session(id), nil
+	}
+
+	id := g.nextID("cs")
+	g.sessions[id] = req
+	if req.IdempotencyKey != "" {
+		g.keys["checkout:"+req.IdempotencyKey] = id
+	}
+	return g.session(id), nil
+}
+
+func (g *FakeGateway) session(id string) CheckoutSession {
+	return CheckoutSession{
+		ID:        id,
+		URL:       "https://checkout.fake.local/pay/" + id,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	}
+}
+
+func (g *FakeGateway) CheckoutPayment(_ context.Context, sessionID string) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	req, ok := g.sessions[sessionID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+	if id, ok := g.keys["charge:checkout:"+sessionID]; ok {
+		return *g.payments[id], nil
+	}
+	return Payment{
+		Reference:   req.Reference,
+		Status:      StatusPending,
+		AmountCents: req.AmountCents,
+		Currency:    req.Currency,
+	}, nil
+}
+
+// CompleteCheckout simulates the customer paying on the hosted page. The
+// payment follows the next outcome and references the session's Reference.
+func (g *FakeGateway) CompleteCheckout(ctx context.Context, sessionID string) (Payment, error) {
+	g.mu.Lock()
+	req, ok := g.sessions[sessionID]
+	g.mu.Unlock()
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+
+	return g.Charge(ctx, ChargeRequest{
+		CustomerID:      req.CustomerID,
+		PaymentMethodID: "pm_fake_checkout",
+		Reference:       req.Reference,
+		AmountCents:     req.AmountCents,
+		Currency:        req.Currency,
+		IdempotencyKey:  "checkout:" + sessionID,
+	})
+}
+
+func (g *FakeGateway) Charge(ctx context.Context, req ChargeRequest) (Payment, error) {
+	g.mu.Lock()
+	if id, ok := g.keys["charge:"+req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		p := *g.payments[id]
+		g.mu.Unlock()
+		return p, statusError(p)
+	}
+
+	outcome := g.outcome
+	if len(g.queue) > 0 {
+		outcome = g.queue[0]
+		g.queue = g.queue[1:]
+	}
+
+	p := &Payment{
+		ID:          g.nextID("pay"),
+		Reference:   req.Reference,
+		AmountCents: req.AmountCents,
+		Currency:    req.Currency,
+	}
+	switch outcome {
+	case OutcomeDecline:
+		p.Status = StatusFailed
+		p.FailureReason = "card_declined"
+	case OutcomeTimeout:
+		p.Status = StatusPending
+	default:
+		p.Status = StatusSucceeded
+	}
+	p.Raw = paymentRaw(*p)
+
+	g.payments[p.ID] = p
+	if req.IdempotencyKey != "" {
+		g.keys["charge:"+req.IdempotencyKey] = p.ID
+	}
+	result := *p
+	g.mu.Unlock()
+
+	if outcome == OutcomeTimeout {
+		select {
+		case <-ctx.Done():
+		case <-time.After(g.timeout):
+		}
+	}
+
+	return result, statusError(result)
+}
+
+// Settle resolves a pending payment, e.g. one that timed out.
+func (g *FakeGateway) Settle(paymentID, status string) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+	p.Status = status
+	p.Raw = paymentRaw(*p)
+	return *p, nil
+}
+
+func (g *FakeGateway) Refund(_ context.Context, req RefundRequest) (Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if id, ok := g.keys["refund:"+req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return g.refunds[id], nil
+	}
+
+	p, ok := g.payments[req.PaymentID]
+	if !ok {
+		return Refund{}, ErrNotFound
+	}
+	if p.Status != StatusSucceeded && p.Status != StatusRefunded {
+		return Refund{}, fmt.Errorf("payment %s is %s and cannot be refunded", p.ID, p.Status)
+	}
+	if req.AmountCents <= 0 || p.RefundedCents+req.AmountCents > p.AmountCents {
+		return Refund{}, fmt.Errorf("refund of %d exceeds the refundable amount of payment %s", req.AmountCents, p.ID)
+	}
+
+	p.RefundedCents += req.AmountCents
+	if p.RefundedCents == p.AmountCents {
+		p.Status = StatusRefunded
+	}
+	p.Raw = paymentRaw(*p)
+
+	r := Refund{
+		ID:          g.nextID("re"),
+		PaymentID:   p.ID,
+		Status:      StatusSucceeded,
+		AmountCents: req.AmountCents,
+	}
+	r.Raw = fakeRaw(r)
+	g.refunds[r.ID] = r
+	if req.IdempotencyKey != "" {
+		g.keys["refund:"+req.IdempotencyKey] = r.ID
+	}
+	return r, nil
+}
+
+func (g *FakeGateway) PaymentStatus(_ context.Context, paymentID string) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrNotFound
+	}
+	return *p, nil
+}
+
+// PaymentEvent returns the webhook event announcing the status of p, as the
+// provider would send once a payment settles.
+func (g *FakeGateway) PaymentEvent(p Payment) Event {
+	g.mu.Lock()
+	id := g.nextID("evt")
+	g.mu.Unlock()
+
+	return Event{
+		ID:          id,
+		Type:        "payment." + p.Status,
+		Kind:        EventPayment,
+		PaymentID:   p.ID,
+		Reference:   p.Reference,
+		Status:      p.Status,
+		AmountCents: p.AmountCents,
+		Currency:    p.Currency,
+		OccurredAt:  time.Now(),
+	}
+}
+
+// VerifyWebhook accepts bodies produced by SignEvent. Without a secret every
+// delivery is rejected.
+func (g *FakeGateway) VerifyWebhook(header http.Header, body []byte) (Event, error) {
+	sig, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if g.secret == "" || err != nil || !hmac.Equal(sig, g.sign(body)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return Event{}, fmt.Errorf("decode fake event: %w", err)
+	}
+	e.Raw = body
+	return e, nil
+}
+
+// SignEvent encodes e as a webhook body with a matching signature header.
+func (g *FakeGateway) SignEvent(e Event) (http.Header, []byte, error) {
+	e.Raw = nil
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, hex.EncodeToString(g.sign(body)))
+	return header, body, nil
+}
+
+func (g *FakeGateway) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(g.secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (g *FakeGateway) nextID(prefix string) string {
+	g.seq++
+	return fmt.Sprintf("%s_fake_%06d", prefix, g.seq)
+}
+
+// statusError maps a payment status to the error Charge returns with it.
+func statusError(p Payment) error {
+	switch p.Status {
+	case StatusFailed:
+		return ErrDeclined
+	case StatusPending:
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
+
+func paymentRaw(p Payment) []byte {
+	p.Raw = nil
+	return fakeRaw(p)
+}
+
+func fakeRaw(v any) []byte {
+	raw, _ := json.Marshal(v)
+	return raw
+}
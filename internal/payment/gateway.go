@@ -0,0 +1,169 @@
+// Package payment talks to payment providers such as Stripe, Xendit and
+// Midtrans behind a single interface.
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Gateway names as stored in transactions.gateway.
+const (
+	GatewayFake     = "fake"
+	GatewayStripe   = "stripe"
+	GatewayXendit   = "xendit"
+	GatewayMidtrans = "midtrans"
+)
+
+// Payment statuses normalized across gateways.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRefunded  = "refunded"
+)
+
+var (
+	// ErrDeclined means the provider refused the payment; retrying with the
+	// same payment method will not help.
+	ErrDeclined = errors.New("payment declined")
+	// ErrTimeout means the provider did not answer in time. The outcome is
+	// unknown until PaymentStatus or a webhook settles it.
+	ErrTimeout = errors.New("payment gateway timed out")
+	// ErrInvalidSignature means a webhook could not be authenticated.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrNotFound means the provider does not know the payment or session.
+	ErrNotFound = errors.New("payment not found")
+)
+
+// CheckoutRequest asks for a hosted payment page.
+type CheckoutRequest struct {
+	CustomerID  uuid.UUID
+	Reference   string // our ID the payment settles, echoed back in webhooks
+	AmountCents int64
+	Currency    string
+	Description string
+	SuccessURL  string
+	CancelURL   string
+	// IdempotencyKey makes retries return the first session.
+	IdempotencyKey string
+}
+
+type CheckoutSession struct {
+	ID        string
+	URL       string
+	ExpiresAt time.Time
+}
+
+// ChargeRequest charges a payment method saved with the provider.
+type ChargeRequest struct {
+	CustomerID      uuid.UUID
+	PaymentMethodID string
+	Reference       string
+	AmountCents     int64
+	Currency        string
+	// IdempotencyKey makes retries return the first payment instead of
+	// charging again.
+	IdempotencyKey string
+}
+
+type Payment struct {
+	ID            string
+	Reference     string
+	Status        string
+	AmountCents   int64
+	RefundedCents int64
+	Currency      string
+	FailureReason string
+	// Raw is the provider response, kept for audits.
+	Raw []byte
+}
+
+type RefundRequest struct {
+	PaymentID      string
+	AmountCents    int64
+	Reason         string
+	IdempotencyKey string
+}
+
+type Refund struct {
+	ID          string
+	PaymentID   string
+	Status      string
+	AmountCents int64
+	Raw         []byte
+}
+
+// Event kinds tell which of our records an event updates.
+const (
+	EventPayment      = "payment"
+	EventSubscription = "subscription"
+)
+
+// Event is a verified webhook notification.
+type Event struct {
+	ID string
+	// Type is the provider's event type, e.g. "invoice.payment_succeeded".
+	Type string
+	// Kind is EventPayment, EventSubscription or empty for events we do not
+	// act on.
+	Kind string
+	// PaymentID and GatewayInvoiceID are the provider's IDs of the payment
+	// and of the invoice it settles, when there is one.
+	PaymentID        string
+	GatewayInvoiceID string
+	// Reference is our ID of the invoice or subscription the event is about.
+	Reference   string
+	Status      string
+	AmountCents int64
+	Currency    string
+	// Subscription is set for EventSubscription events.
+	Subscription *SubscriptionEvent
+	OccurredAt   time.Time
+	Raw          []byte
+}
+
+// SubscriptionEvent is the state of a subscription kept by the provider.
+type SubscriptionEvent struct {
+	ID string
+	// Status is one of our subscription statuses (trialing, active,
+	// past_due, canceled), or empty when the provider's has no equivalent.
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	CancelAtPeriodEnd  bool
+	CanceledAt         time.Time
+}
+
+// WebhookVerifier authenticates webhook deliveries of one provider.
+type WebhookVerifier interface {
+	// VerifyWebhook authenticates a webhook request and parses its event. It
+	// returns ErrInvalidSignature when the request cannot be trusted.
+	VerifyWebhook(header http.Header, body []byte) (Event, error)
+}
+
+// PaymentGateway is a payment provider. Amounts are in the minor unit of the
+// currency, or whole units for currencies without one.
+type PaymentGateway interface {
+	// Name returns the gateway name stored with transactions.
+	Name() string
+	// CreateCheckoutSession creates a hosted page where the customer pays.
+	CreateCheckoutSession(ctx context.Context, req CheckoutRequest) (CheckoutSession, error)
+	// CheckoutPayment returns the payment made through a checkout session.
+	// Its Reference is the session's. A session nobody paid yet yields a
+	// pending payment without an ID; an unknown session yields ErrNotFound.
+	CheckoutPayment(ctx context.Context, sessionID string) (Payment, error)
+	// Charge charges a saved payment method. It returns ErrDeclined when the
+	// provider refuses and ErrTimeout when the outcome is unknown; the
+	// returned Payment carries the provider's ID whenever one is known.
+	Charge(ctx context.Context, req ChargeRequest) (Payment, error)
+	// Refund returns all or part of a succeeded payment.
+	Refund(ctx context.Context, req RefundRequest) (Refund, error)
+	// PaymentStatus fetches the current state of a payment.
+	PaymentStatus(ctx context.Context, paymentID string) (Payment, error)
+	WebhookVerifier
+}
@@ -0,0 +1,182 @@
+package payment
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const midtransTestKey = "SB-Mid-server-test"
+
+func midtransSignature(n midtransNotification, key string) string {
+	sum := sha512.Sum512([]byte(n.OrderID + n.StatusCode + n.GrossAmount + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// midtransBody builds a notification signed with key.
+func midtransBody(t *testing.T, key string, n midtransNotification) []byte {
+	t.Helper()
+
+	if n.SignatureKey == "" {
+		n.SignatureKey = midtransSignature(n, key)
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestMidtransVerifyWebhook(t *testing.T) {
+	settlement := midtransNotification{
+		TransactionID:     "tx-1",
+		TransactionStatus: "settlement",
+		TransactionTime:   "2026-10-18 19:00:00",
+		StatusCode:        "200",
+		OrderID:           "order-1",
+		GrossAmount:       "150000.00",
+	}
+	with := func(change func(n *midtransNotification)) midtransNotification {
+		n := settlement
+		change(&n)
+		return n
+	}
+
+	tests := []struct {
+		name         string
+		noServerKey  bool
+		wrongKey     bool
+		notification midtransNotification
+		wantErr      error
+		wantAnyErr   bool
+		wantID       string
+		wantStatus   string
+		wantAmount   int64
+	}{
+		{
+			name:         "settlement",
+			notification: settlement,
+			wantID:       "tx-1:settlement",
+			wantStatus:   StatusSucceeded,
+			wantAmount:   150000,
+		},
+		{
+			name: "upper-case signature",
+			notification: with(func(n *midtransNotification) {
+				n.SignatureKey = strings.ToUpper(midtransSignature(*n, midtransTestKey))
+			}),
+			wantID:     "tx-1:settlement",
+			wantStatus: StatusSucceeded,
+			wantAmount: 150000,
+		},
+		{
+			name: "capture under review",
+			notification: with(func(n *midtransNotification) {
+				n.TransactionStatus, n.FraudStatus = "capture", "challenge"
+			}),
+			wantID:     "tx-1:capture:challenge",
+			wantStatus: StatusPending,
+			wantAmount: 150000,
+		},
+		{
+			name:         "expired",
+			notification: with(func(n *midtransNotification) { n.TransactionStatus, n.StatusCode = "expire", "407" }),
+			wantID:       "tx-1:expire",
+			wantStatus:   StatusFailed,
+			wantAmount:   150000,
+		},
+		{
+			name:         "wrong key",
+			wrongKey:     true,
+			notification: settlement,
+			wantErr:      ErrInvalidSignature,
+		},
+		{
+			name: "amount changed after signing",
+			notification: with(func(n *midtransNotification) {
+				n.SignatureKey = midtransSignature(*n, midtransTestKey)
+				n.GrossAmount = "1.00"
+			}),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:         "no server key configured",
+			noServerKey:  true,
+			notification: settlement,
+			wantErr:      ErrInvalidSignature,
+		},
+		{
+			name:         "fractional rupiah",
+			notification: with(func(n *midtransNotification) { n.GrossAmount = "150000.50" }),
+			wantAnyErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverKey := midtransTestKey
+			if tt.noServerKey {
+				serverKey = ""
+			}
+			signKey := serverKey
+			if tt.wrongKey {
+				signKey = "SB-Mid-server-other"
+			}
+
+			event, err := NewMidtransWebhook(serverKey).VerifyWebhook(nil, midtransBody(t, signKey, tt.notification))
+			if tt.wantAnyErr {
+				if err == nil || errors.Is(err, ErrInvalidSignature) {
+					t.Fatalf("error = %v, want a payload error", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if event.ID != tt.wantID || event.Status != tt.wantStatus || event.AmountCents != tt.wantAmount {
+				t.Fatalf("event %s %s of %d, want %s %s of %d", event.ID, event.Status, event.AmountCents, tt.wantID, tt.wantStatus, tt.wantAmount)
+			}
+			if event.Currency != "IDR" || event.Reference != "order-1" || event.PaymentID != "tx-1" {
+				t.Fatalf("event in %s for %s paid by %s", event.Currency, event.Reference, event.PaymentID)
+			}
+			if want := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC); !event.OccurredAt.Equal(want) {
+				t.Fatalf("occurred at %s, want %s", event.OccurredAt, want)
+			}
+		})
+	}
+}
+
+func TestMidtransStatus(t *testing.T) {
+	tests := []struct {
+		status, fraudStatus string
+		want                string
+	}{
+		{"settlement", "", StatusSucceeded},
+		{"capture", "", StatusSucceeded},
+		{"capture", "accept", StatusSucceeded},
+		{"capture", "challenge", StatusPending},
+		{"capture", "deny", StatusFailed},
+		{"pending", "", StatusPending},
+		{"deny", "", StatusFailed},
+		{"cancel", "", StatusFailed},
+		{"expire", "", StatusFailed},
+		{"failure", "", StatusFailed},
+		{"refund", "", StatusRefunded},
+		{"partial_refund", "", StatusRefunded},
+		{"authorize", "", StatusPending},
+	}
+
+	for _, tt := range tests {
+		if got := midtransStatus(tt.status, tt.fraudStatus); got != tt.want {
+			t.Errorf("midtransStatus(%q, %q) = %s, want %s", tt.status, tt.fraudStatus, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package payment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CurrencyExponent returns the number of minor units of a currency. Amounts
+// of zero-decimal currencies such as IDR are stored in whole units.
+func CurrencyExponent(currency string) int {
+	switch strings.ToUpper(currency) {
+	case "IDR", "JPY", "KRW", "VND":
+		return 0
+	default:
+		return 2
+	}
+}
+
+// ParseAmount converts a decimal amount in major units, as sent by gateways
+// that do not use minor units ("150000.00" IDR, "12.5" USD), into our
+// stored amount. It is exact and rejects fractions the currency cannot hold.
+func ParseAmount(amount, currency string) (int64, error) {
+	amount = strings.TrimSpace(amount)
+	whole, frac, _ := strings.Cut(amount, ".")
+
+	exp := CurrencyExponent(currency)
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return 0, fmt.Errorf("amount %q has more decimals than %s allows", amount, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	value, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || whole == "" || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	return value, nil
+}
@@ -0,0 +1,56 @@
+package payment
+
+import "testing"
+
+func TestCurrencyExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+	}{
+		{"USD", 2},
+		{"eur", 2},
+		{"IDR", 0},
+		{"jpy", 0},
+		{"KRW", 0},
+		{"VND", 0},
+	}
+
+	for _, tt := range tests {
+		if got := CurrencyExponent(tt.currency); got != tt.want {
+			t.Errorf("CurrencyExponent(%s) = %d, want %d", tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{amount: "150000.00", currency: "IDR", want: 150000},
+		{amount: "150000", currency: "IDR", want: 150000},
+		{amount: "150000.50", currency: "IDR", wantErr: true},
+		{amount: "12.5", currency: "USD", want: 1250},
+		{amount: "12.50", currency: "USD", want: 1250},
+		{amount: "12", currency: "USD", want: 1200},
+		{amount: "0.01", currency: "USD", want: 1},
+		{amount: " 7.00 ", currency: "USD", want: 700},
+		{amount: "12.345", currency: "USD", wantErr: true},
+		{amount: "12.3450", currency: "USD", wantErr: true},
+		{amount: "-5.00", currency: "USD", want: -500},
+		{amount: "", currency: "USD", wantErr: true},
+		{amount: ".50", currency: "USD", wantErr: true},
+		{amount: "+5", currency: "USD", wantErr: true},
+		{amount: "1e3", currency: "USD", wantErr: true},
+		{amount: "12.3a", currency: "USD", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.amount, tt.currency)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAmount(%q, %s) = %d, %v; want %d, error: %t", tt.amount, tt.currency, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
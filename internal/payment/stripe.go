@@ -0,0 +1,267 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeSignatureHeader carries the timestamp and signatures of a Stripe
+// webhook, e.g. "t=1700000000,v1=5257a869...".
+const StripeSignatureHeader = "Stripe-Signature"
+
+// DefaultStripeTolerance is how old a signed Stripe webhook may be, the same
+// default Stripe's own libraries use.
+const DefaultStripeTolerance = 5 * time.Minute
+
+// StripeWebhook verifies Stripe webhooks signed with an endpoint secret.
+type StripeWebhook struct {
+	secret    string
+	tolerance time.Duration
+	now       func() time.Time
+}
+
+// NewStripeWebhook returns a verifier for the endpoint secret ("whsec_...").
+// Deliveries signed more than tolerance away from now are rejected so a
+// captured request cannot be replayed later.
+func NewStripeWebhook(secret string, tolerance time.Duration) *StripeWebhook {
+	if tolerance <= 0 {
+		tolerance = DefaultStripeTolerance
+	}
+	return &StripeWebhook{secret: secret, tolerance: tolerance, now: time.Now}
+}
+
+// VerifyWebhook checks the HMAC-SHA256 of "<timestamp>.<body>" against every
+// v1 signature in the header, then parses the event.
+func (w *StripeWebhook) VerifyWebhook(header http.Header, body []byte) (Event, error) {
+	timestamp, signatures := parseStripeSignature(header.Get(StripeSignatureHeader))
+	if timestamp == "" || len(signatures) == 0 {
+		return Event{}, ErrInvalidSignature
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Event{}, ErrInvalidSignature
+	}
+	if age := w.now().Sub(time.Unix(signedAt, 0)); age > w.tolerance || age < -w.tolerance {
+		return Event{}, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(w.secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	valid := false
+	for _, sig := range signatures {
+		decoded, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(decoded, expected) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return Event{}, ErrInvalidSignature
+	}
+
+	return parseStripeEvent(body)
+}
+
+// parseStripeSignature splits the header into its timestamp and v1
+// signatures. Other schemes, such as the v0 test signatures, are ignored.
+func parseStripeSignature(header string) (string, []string) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	return timestamp, signatures
+}
+
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeInvoice struct {
+	ID            string            `json:"id"`
+	AmountDue     int64             `json:"amount_due"`
+	AmountPaid    int64             `json:"amount_paid"`
+	Currency      string            `json:"currency"`
+	PaymentIntent stripeRef         `json:"payment_intent"`
+	Charge        stripeRef         `json:"charge"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+type stripeSubscription struct {
+	ID                 string            `json:"id"`
+	Status             string            `json:"status"`
+	CurrentPeriodStart int64             `json:"current_period_start"`
+	CurrentPeriodEnd   int64             `json:"current_period_end"`
+	CancelAtPeriodEnd  bool              `json:"cancel_at_period_end"`
+	CanceledAt         int64             `json:"canceled_at"`
+	Metadata           map[string]string `json:"metadata"`
+}
+
+// stripeRef is a reference to another Stripe object, sent either as its ID
+// or, when expanded, as the object itself.
+type stripeRef string
+
+func (r *stripeRef) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*r = stripeRef(id)
+		return nil
+	}
+
+	var object struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*r = stripeRef(object.ID)
+	return nil
+}
+
+func parseStripeEvent(body []byte) (Event, error) {
+	var raw stripeEvent
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return Event{}, fmt.Errorf("decode stripe event: %w", err)
+	}
+	if raw.ID == "" {
+		return Event{}, fmt.Errorf("decode stripe event: missing id")
+	}
+
+	e := Event{
+		ID:         raw.ID,
+		Type:       raw.Type,
+		OccurredAt: time.Unix(raw.Created, 0),
+		Raw:        body,
+	}
+
+	switch {
+	case raw.Type == "invoice.payment_succeeded" || raw.Type == "invoice.payment_failed":
+		var invoice stripeInvoice
+		if err := json.Unmarshal(raw.Data.Object, &invoice); err != nil {
+			return Event{}, fmt.Errorf("decode stripe invoice: %w", err)
+		}
+
+		e.Kind = EventPayment
+		e.GatewayInvoiceID = invoice.ID
+		e.PaymentID = string(invoice.PaymentIntent)
+		if e.PaymentID == "" {
+			e.PaymentID = string(invoice.Charge)
+		}
+		e.Reference = invoice.Metadata["invoice_id"]
+		e.Currency = strings.ToUpper(invoice.Currency)
+		amount := invoice.AmountPaid
+		e.Status = StatusSucceeded
+		if raw.Type == "invoice.payment_failed" {
+			amount = invoice.AmountDue
+			e.Status = StatusFailed
+		}
+		var err error
+		if e.AmountCents, err = stripeAmount(amount, e.Currency); err != nil {
+			return Event{}, fmt.Errorf("decode stripe invoice: %w", err)
+		}
+
+	case strings.HasPrefix(raw.Type, "customer.subscription."):
+		var sub stripeSubscription
+		if err := json.Unmarshal(raw.Data.Object, &sub); err != nil {
+			return Event{}, fmt.Errorf("decode stripe subscription: %w", err)
+		}
+
+		status := stripeSubscriptionStatus(sub.Status)
+		if raw.Type == "customer.subscription.deleted" {
+			status = "canceled"
+		}
+
+		e.Kind = EventSubscription
+		e.Reference = sub.Metadata["subscription_id"]
+		e.Subscription = &SubscriptionEvent{
+			ID:                 sub.ID,
+			Status:             status,
+			CurrentPeriodStart: unixTime(sub.CurrentPeriodStart),
+			CurrentPeriodEnd:   unixTime(sub.CurrentPeriodEnd),
+			CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+			CanceledAt:         unixTime(sub.CanceledAt),
+		}
+	}
+
+	return e, nil
+}
+
+// stripeExponent returns the number of decimals Stripe uses for a currency,
+// which differs from ours for some, e.g. IDR is sent in hundredths.
+func stripeExponent(currency string) int {
+	switch strings.ToUpper(currency) {
+	case "BIF", "CLP", "DJF", "GNF", "JPY", "KMF", "KRW", "MGA", "PYG", "RWF", "UGX", "VND", "VUV", "XAF", "XOF", "XPF":
+		return 0
+	case "BHD", "JOD", "KWD", "OMR", "TND":
+		return 3
+	default:
+		return 2
+	}
+}
+
+// stripeAmount converts an amount in Stripe's minor units into our stored
+// amount. It rejects amounts our unit cannot hold exactly.
+func stripeAmount(amount int64, currency string) (int64, error) {
+	converted := amount
+	for diff := stripeExponent(currency) - CurrencyExponent(currency); diff != 0; {
+		switch {
+		case diff > 0 && converted%10 != 0:
+			return 0, fmt.Errorf("stripe amount %d has more decimals than %s allows", amount, currency)
+		case diff > 0:
+			converted /= 10
+			diff--
+		default:
+			converted *= 10
+			diff++
+		}
+	}
+	return converted, nil
+}
+
+// stripeSubscriptionStatus maps Stripe's subscription statuses onto ours.
+// Incomplete and paused subscriptions have no equivalent and keep our status.
+func stripeSubscriptionStatus(status string) string {
+	switch status {
+	case "trialing", "active", "past_due", "canceled":
+		return status
+	case "unpaid":
+		return "past_due"
+	case "incomplete_expired":
+		return "canceled"
+	default:
+		return ""
+	}
+}
+
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
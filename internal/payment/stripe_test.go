@@ -0,0 +1,200 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func signStripe(secret string, signedAt time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", signedAt.Unix(), body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseStripeSignature(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		wantTimestamp string
+		wantSigs      []string
+	}{
+		{name: "one signature", header: "t=1700000000,v1=abc", wantTimestamp: "1700000000", wantSigs: []string{"abc"}},
+		{name: "rolled secret", header: "t=1700000000,v1=abc,v1=def", wantTimestamp: "1700000000", wantSigs: []string{"abc", "def"}},
+		{name: "test signatures ignored", header: "t=1700000000, v0=old, v1=abc", wantTimestamp: "1700000000", wantSigs: []string{"abc"}},
+		{name: "no timestamp", header: "v1=abc", wantSigs: []string{"abc"}},
+		{name: "malformed", header: "garbage,,t", wantTimestamp: ""},
+		{name: "empty", header: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp, sigs := parseStripeSignature(tt.header)
+			if timestamp != tt.wantTimestamp || !slices.Equal(sigs, tt.wantSigs) {
+				t.Fatalf("parseStripeSignature(%q) = %q, %q; want %q, %q", tt.header, timestamp, sigs, tt.wantTimestamp, tt.wantSigs)
+			}
+		})
+	}
+}
+
+func TestStripeVerifyWebhook(t *testing.T) {
+	const secret = "whsec_test"
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1","type":"invoice.payment_succeeded","created":1760788800,"data":{"object":{"id":"in_1","amount_paid":2000,"currency":"usd","payment_intent":"pi_1","metadata":{}}}}`)
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			header: fmt.Sprintf("t=%d,v1=%s", now.Unix(), signStripe(secret, now, body)),
+		},
+		{
+			name:   "valid among others",
+			header: fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), signStripe("whsec_old", now, body), signStripe(secret, now, body)),
+		},
+		{
+			name:   "within tolerance",
+			header: fmt.Sprintf("t=%d,v1=%s", now.Add(-4*time.Minute).Unix(), signStripe(secret, now.Add(-4*time.Minute), body)),
+		},
+		{
+			name:    "too old",
+			header:  fmt.Sprintf("t=%d,v1=%s", now.Add(-6*time.Minute).Unix(), signStripe(secret, now.Add(-6*time.Minute), body)),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "too far ahead",
+			header:  fmt.Sprintf("t=%d,v1=%s", now.Add(6*time.Minute).Unix(), signStripe(secret, now.Add(6*time.Minute), body)),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "wrong secret",
+			header:  fmt.Sprintf("t=%d,v1=%s", now.Unix(), signStripe("whsec_other", now, body)),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "timestamp not signed",
+			header:  fmt.Sprintf("t=%d,v1=%s", now.Unix()+1, signStripe(secret, now, body)),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "bad timestamp",
+			header:  "t=soon,v1=" + signStripe(secret, now, body),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "missing",
+			wantErr: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewStripeWebhook(secret, 0)
+			w.now = func() time.Time { return now }
+
+			header := http.Header{}
+			header.Set(StripeSignatureHeader, tt.header)
+			event, err := w.VerifyWebhook(header, body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && event.ID != "evt_1" {
+				t.Fatalf("event ID = %q, want evt_1", event.ID)
+			}
+		})
+	}
+}
+
+func TestParseStripeEvent(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantKind      string
+		wantStatus    string
+		wantAmount    int64
+		wantPaymentID string
+	}{
+		{
+			name:          "payment succeeded",
+			body:          `{"id":"evt_1","type":"invoice.payment_succeeded","created":1,"data":{"object":{"id":"in_1","amount_paid":2000,"amount_due":2000,"currency":"usd","payment_intent":"pi_1"}}}`,
+			wantKind:      EventPayment,
+			wantStatus:    StatusSucceeded,
+			wantAmount:    2000,
+			wantPaymentID: "pi_1",
+		},
+		{
+			name:          "payment failed with an expanded charge",
+			body:          `{"id":"evt_2","type":"invoice.payment_failed","created":1,"data":{"object":{"id":"in_1","amount_paid":0,"amount_due":2000,"currency":"usd","charge":{"id":"ch_1"}}}}`,
+			wantKind:      EventPayment,
+			wantStatus:    StatusFailed,
+			wantAmount:    2000,
+			wantPaymentID: "ch_1",
+		},
+		{
+			name:          "IDR is sent in hundredths",
+			body:          `{"id":"evt_3","type":"invoice.payment_succeeded","created":1,"data":{"object":{"id":"in_1","amount_paid":15000000,"currency":"idr","payment_intent":"pi_1"}}}`,
+			wantKind:      EventPayment,
+			wantStatus:    StatusSucceeded,
+			wantAmount:    150000,
+			wantPaymentID: "pi_1",
+		},
+		{
+			name:       "subscription deleted",
+			body:       `{"id":"evt_4","type":"customer.subscription.deleted","created":1,"data":{"object":{"id":"sub_1","status":"active"}}}`,
+			wantKind:   EventSubscription,
+			wantStatus: "canceled",
+		},
+		{
+			name: "other events",
+			body: `{"id":"evt_5","type":"customer.created","created":1,"data":{"object":{}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseStripeEvent([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := e.Status
+			if e.Subscription != nil {
+				status = e.Subscription.Status
+			}
+			if e.Kind != tt.wantKind || status != tt.wantStatus || e.AmountCents != tt.wantAmount || e.PaymentID != tt.wantPaymentID {
+				t.Fatalf("event %q %q of %d paid by %q; want %q %q of %d paid by %q", e.Kind, status, e.AmountCents, e.PaymentID, tt.wantKind, tt.wantStatus, tt.wantAmount, tt.wantPaymentID)
+			}
+		})
+	}
+}
+
+func TestStripeAmount(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{amount: 2999, currency: "USD", want: 2999},
+		{amount: 500, currency: "JPY", want: 500},
+		{amount: 15000000, currency: "IDR", want: 150000},
+		{amount: 15000050, currency: "IDR", wantErr: true},
+		{amount: 1230, currency: "KWD", want: 123},
+		{amount: 1234, currency: "KWD", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := stripeAmount(tt.amount, tt.currency)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("stripeAmount(%d, %s) = %d, %v; want %d, error: %t", tt.amount, tt.currency, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
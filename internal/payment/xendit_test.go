@@ -0,0 +1,142 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestXenditVerifyWebhook(t *testing.T) {
+	const token = "xnd_callback_token"
+	paid := `{"id":"inv-1","external_id":"order-1","status":"PAID","amount":150000,"paid_amount":150000,"currency":"IDR","paid_at":"2026-10-18T12:00:00.000Z"}`
+
+	tests := []struct {
+		name          string
+		configured    string
+		token         string
+		webhookID     string
+		body          string
+		wantErr       error
+		wantAnyErr    bool
+		wantID        string
+		wantStatus    string
+		wantAmount    int64
+		wantReference string
+	}{
+		{
+			name:          "paid",
+			configured:    token,
+			token:         token,
+			body:          paid,
+			wantID:        "inv-1:PAID",
+			wantStatus:    StatusSucceeded,
+			wantAmount:    150000,
+			wantReference: "order-1",
+		},
+		{
+			name:          "delivery ID",
+			configured:    token,
+			token:         token,
+			webhookID:     "whk-1",
+			body:          paid,
+			wantID:        "whk-1",
+			wantStatus:    StatusSucceeded,
+			wantAmount:    150000,
+			wantReference: "order-1",
+		},
+		{
+			name:          "expired in USD without paid amount",
+			configured:    token,
+			token:         token,
+			body:          `{"id":"inv-2","external_id":"order-2","status":"expired","amount":12.5,"currency":"usd","updated":"2026-10-18T12:00:00Z"}`,
+			wantID:        "inv-2:EXPIRED",
+			wantStatus:    StatusFailed,
+			wantAmount:    1250,
+			wantReference: "order-2",
+		},
+		{
+			name:       "wrong token",
+			configured: token,
+			token:      "xnd_other",
+			body:       paid,
+			wantErr:    ErrInvalidSignature,
+		},
+		{
+			name:       "missing token",
+			configured: token,
+			body:       paid,
+			wantErr:    ErrInvalidSignature,
+		},
+		{
+			name:    "no token configured",
+			body:    paid,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:       "missing status",
+			configured: token,
+			token:      token,
+			body:       `{"id":"inv-1","amount":150000}`,
+			wantAnyErr: true,
+		},
+		{
+			name:       "fractional rupiah",
+			configured: token,
+			token:      token,
+			body:       `{"id":"inv-1","status":"PAID","amount":150000.5,"currency":"IDR"}`,
+			wantAnyErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set(XenditCallbackTokenHeader, tt.token)
+			}
+			if tt.webhookID != "" {
+				header.Set(XenditWebhookIDHeader, tt.webhookID)
+			}
+
+			event, err := NewXenditWebhook(tt.configured).VerifyWebhook(header, []byte(tt.body))
+			if tt.wantAnyErr {
+				if err == nil || errors.Is(err, ErrInvalidSignature) {
+					t.Fatalf("error = %v, want a payload error", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if event.ID != tt.wantID || event.Status != tt.wantStatus || event.AmountCents != tt.wantAmount || event.Reference != tt.wantReference {
+				t.Fatalf("event %s %s of %d for %s, want %s %s of %d for %s", event.ID, event.Status, event.AmountCents, event.Reference, tt.wantID, tt.wantStatus, tt.wantAmount, tt.wantReference)
+			}
+			if event.OccurredAt.IsZero() {
+				t.Fatal("event has no time")
+			}
+		})
+	}
+}
+
+func TestXenditStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"PAID", StatusSucceeded},
+		{"SETTLED", StatusSucceeded},
+		{"EXPIRED", StatusFailed},
+		{"FAILED", StatusFailed},
+		{"PENDING", StatusPending},
+	}
+
+	for _, tt := range tests {
+		if got := xenditStatus(tt.status); got != tt.want {
+			t.Errorf("xenditStatus(%s) = %s, want %s", tt.status, got, tt.want)
+		}
+	}
+}
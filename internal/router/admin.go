@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"github.com/novaru/billing-service/internal/middleware"
+)
+
+// adminRoutes are limited to the users listed in ADMIN_USER_IDS.
+func (rt *Router) adminRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(middleware.AuthMiddleware(rt.config))
+	r.Use(middleware.RequireAdmin(rt.config))
+	r.Use(middleware.Idempotency(rt.idempotency))
+
+	r.Post("/transactions/{id}/refund", rt.handlers.Admin.RefundTransaction)
+
+	return r
+}
@@ -2,32 +2,86 @@ package router
 
 import (
 	"github.com/go-chi/chi/v5"
+
+	"github.com/novaru/billing-service/internal/app/service"
 	"github.com/novaru/billing-service/internal/middleware"
 )
 
 func (rt *Router) apiRoutes() chi.Router {
 	r := chi.NewRouter()
 
+	// Auth responses carry tokens, which must not be stored for replay
 	r.Route("/auth", func(r chi.Router) {
 		r.Post("/login", rt.handlers.User.Login)
 		r.Post("/register", rt.handlers.User.Create)
 	})
 
+	// Idempotency is applied per route here, after each route's own
+	// authentication, so keys are scoped to the signed-in caller
 	r.Route("/plans", func(r chi.Router) {
 		r.Get("/", rt.handlers.Plan.FindAll)
 		r.Get("/{slug}", rt.handlers.Plan.FindBySlug)
-		r.Post("/", rt.handlers.Plan.Create)
+		// Plans set billing terms, so only admins create them
+		r.With(
+			middleware.AuthMiddleware(rt.config),
+			middleware.RequireAdmin(rt.config),
+			middleware.Idempotency(rt.idempotency),
+		).Post("/", rt.handlers.Plan.Create)
+	})
+
+	r.Route("/checkout", func(r chi.Router) {
+		r.With(
+			middleware.OptionalAuth(rt.config),
+			middleware.Idempotency(rt.idempotency),
+		).Post("/create", rt.handlers.Checkout.Create)
+		r.Get("/success", rt.handlers.Checkout.Success)
 	})
 
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.AuthMiddleware(rt.config))
-		r.Use(middleware.APIKeyAuth())
+		r.Use(middleware.Idempotency(rt.idempotency))
 
 		r.Route("/users", func(r chi.Router) {
 			r.Get("/", rt.handlers.User.FindAll)
 			r.Get("/{id}", rt.handlers.User.FindByID)
 			r.Post("/", rt.handlers.User.Create)
 		})
+
+		r.Route("/subscriptions", func(r chi.Router) {
+			r.Get("/", rt.handlers.Subscription.FindCurrent)
+			r.Post("/cancel", rt.handlers.Subscription.Cancel)
+			r.Post("/reactivate", rt.handlers.Subscription.Reactivate)
+			r.Put("/upgrade", rt.handlers.Subscription.ChangePlan)
+			r.Get("/upgrade/preview", rt.handlers.Subscription.PreviewChangePlan)
+			r.Put("/payment-method", rt.handlers.Subscription.UpdatePaymentMethod)
+		})
+
+		r.Route("/invoices", func(r chi.Router) {
+			r.Get("/", rt.handlers.Invoice.FindAll)
+			r.Get("/{id}", rt.handlers.Invoice.FindByID)
+			r.Get("/{id}/pdf", rt.handlers.Invoice.PDF)
+			r.Post("/{id}/pay", rt.handlers.Invoice.Pay)
+		})
+
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Get("/", rt.handlers.ApiKey.FindAll)
+			r.Post("/", rt.handlers.ApiKey.Create)
+			r.Put("/{id}", rt.handlers.ApiKey.Update)
+			r.Delete("/{id}", rt.handlers.ApiKey.Revoke)
+			r.Post("/{id}/rotate", rt.handlers.ApiKey.Rotate)
+		})
+	})
+
+	// Service-to-service routes, authenticated with a customer API key
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.APIKeyAuth(rt.apiKeys))
+		r.Use(middleware.Idempotency(rt.idempotency))
+
+		r.Route("/usage", func(r chi.Router) {
+			r.With(middleware.RequireScope(service.ScopeUsageWrite)).Post("/report", rt.handlers.Usage.Report)
+			r.With(middleware.RequireScope(service.ScopeUsageWrite)).Post("/batch-report", rt.handlers.Usage.BatchReport)
+			r.With(middleware.RequireScope(service.ScopeQuotaRead)).Get("/quota-check", rt.handlers.Usage.QuotaCheck)
+		})
 	})
 
 	return r
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"github.com/go-chi/chi/v5"
+)
+
+// fakeGatewayRoutes stand in for the fake gateway's hosted pages and
+// dashboard. They carry no credentials and are never mounted in production.
+func (rt *Router) fakeGatewayRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/checkouts/{id}/complete", rt.handlers.FakeGateway.CompleteCheckout)
+	r.Post("/payments/{id}/settle", rt.handlers.FakeGateway.SettlePayment)
+
+	return r
+}
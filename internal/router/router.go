@@ -8,16 +8,19 @@ import (
 	"github.com/go-chi/cors"
 
 	"github.com/novaru/billing-service/internal/app/handler"
+	"github.com/novaru/billing-service/internal/app/service"
 	"github.com/novaru/billing-service/internal/config"
 )
 
 type Router struct {
-	config   *config.Config
-	handlers *handler.Handlers
+	config      *config.Config
+	handlers    *handler.Handlers
+	apiKeys     service.ApiKeyService
+	idempotency service.IdempotencyService
 }
 
-func New(cfg *config.Config, handlers *handler.Handlers) *Router {
-	return &Router{config: cfg, handlers: handlers}
+func New(cfg *config.Config, handlers *handler.Handlers, apiKeys service.ApiKeyService, idempotency service.IdempotencyService) *Router {
+	return &Router{config: cfg, handlers: handlers, apiKeys: apiKeys, idempotency: idempotency}
 }
 
 func (rt *Router) Setup() chi.Router {
@@ -32,6 +35,11 @@ func (rt *Router) Setup() chi.Router {
 	}))
 
 	r.Mount("/api/v1", rt.apiRoutes())
+	r.Mount("/webhooks", rt.webhookRoutes())
+	r.Mount("/admin", rt.adminRoutes())
+	if rt.handlers.FakeGateway != nil {
+		r.Mount("/dev/fake-gateway", rt.fakeGatewayRoutes())
+	}
 
 	return r
 }
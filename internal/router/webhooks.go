@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookRoutes receives payment gateway notifications. They carry no user
+// credentials; each gateway's signature is verified by the webhook service.
+func (rt *Router) webhookRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/stripe", rt.handlers.Webhook.Stripe)
+	r.Post("/xendit", rt.handlers.Webhook.Xendit)
+	r.Post("/midtrans", rt.handlers.Webhook.Midtrans)
+	if rt.handlers.FakeGateway != nil {
+		r.Post("/fake", rt.handlers.Webhook.Fake)
+	}
+
+	return r
+}
@@ -14,6 +14,9 @@ var (
 	ErrUnauthorized  = errors.New("unauthorized")
 	ErrForbidden     = errors.New("forbidden")
 	ErrInternal      = errors.New("internal server error")
+
+	ErrInvalidTransition = errors.New("invalid state transition")
+	ErrQuotaExceeded     = errors.New("quota exceeded")
 )
 
 // AppError represents a structured application error
@@ -48,6 +51,16 @@ func (e *AppError) HTTPStatus() int {
 		return http.StatusUnauthorized
 	case "FORBIDDEN":
 		return http.StatusForbidden
+	case "INVALID_STATE_TRANSITION":
+		return http.StatusConflict
+	case "CONFLICT":
+		return http.StatusConflict
+	case "PAYMENT_FAILED":
+		return http.StatusPaymentRequired
+	case "GATEWAY_TIMEOUT":
+		return http.StatusGatewayTimeout
+	case "QUOTA_EXCEEDED":
+		return http.StatusTooManyRequests
 	default:
 		return http.StatusInternalServerError
 	}
@@ -84,6 +97,14 @@ func NewUnauthorizedError(msg string, err error) *AppError {
 	}
 }
 
+func NewForbiddenError(msg string, err error) *AppError {
+	return &AppError{
+		Code:    "FORBIDDEN",
+		Message: msg,
+		Err:     err,
+	}
+}
+
 func NewInternalError(msg string, err error) *AppError {
 	return &AppError{
 		Code:    "INTERNAL_ERROR",
@@ -100,3 +121,43 @@ func NewAlreadyExistsError(resource string, details string) *AppError {
 		Err:     ErrAlreadyExists,
 	}
 }
+
+func NewInvalidTransitionError(resource, from, to string) *AppError {
+	return &AppError{
+		Code:    "INVALID_STATE_TRANSITION",
+		Message: fmt.Sprintf("%s cannot move from %s to %s", resource, from, to),
+		Err:     ErrInvalidTransition,
+	}
+}
+
+func NewPaymentFailedError(msg string, err error) *AppError {
+	return &AppError{
+		Code:    "PAYMENT_FAILED",
+		Message: msg,
+		Err:     err,
+	}
+}
+
+func NewGatewayTimeoutError(msg string, err error) *AppError {
+	return &AppError{
+		Code:    "GATEWAY_TIMEOUT",
+		Message: msg,
+		Err:     err,
+	}
+}
+
+func NewConflictError(msg string, err error) *AppError {
+	return &AppError{
+		Code:    "CONFLICT",
+		Message: msg,
+		Err:     err,
+	}
+}
+
+func NewQuotaExceededError(msg string) *AppError {
+	return &AppError{
+		Code:    "QUOTA_EXCEEDED",
+		Message: msg,
+		Err:     ErrQuotaExceeded,
+	}
+}
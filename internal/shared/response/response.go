@@ -65,6 +65,12 @@ func WriteError(w http.ResponseWriter, e error) {
 	})
 }
 
+// WriteJSON writes data as is, without the success envelope. Use it for
+// callers such as payment gateways that expect their own response format.
+func WriteJSON(w http.ResponseWriter, status int, data any) {
+	writeJSON(w, status, data)
+}
+
 func writeJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
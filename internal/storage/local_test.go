@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(ctx, "invoices/a.pdf", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "invoices/a.pdf", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := s.Open(ctx, "invoices/a.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second" {
+		t.Fatalf("read %q, want the replaced file", data)
+	}
+
+	if _, err := s.Open(ctx, "invoices/missing.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("open missing file: error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestLocalStorageRejectsKeys(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../outside.pdf", "invoices/../../outside.pdf", "/etc/passwd", ".tmp-123", ""} {
+		if err := s.Put(context.Background(), key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded, want the key rejected", key)
+		}
+		if _, err := s.Open(context.Background(), key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%q) error = %v, want the key rejected", key, err)
+		}
+	}
+}
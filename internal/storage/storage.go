@@ -0,0 +1,19 @@
+// Package storage keeps generated files such as invoice PDFs.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Open when no file is stored under the key.
+var ErrNotFound = errors.New("file not found")
+
+// Storage stores files under slash-separated keys, e.g. "invoices/<id>.pdf".
+type Storage interface {
+	// Put stores data under key, replacing any previous file.
+	Put(ctx context.Context, key string, data []byte) error
+	// Open returns the file stored under key. Callers must close it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
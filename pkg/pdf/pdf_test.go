@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"Invoice INV-2026-000001", "Invoice INV-2026-000001"},
+		{"Pro (yearly)", `Pro \(yearly\)`},
+		{`C:\billing`, `C:\\billing`},
+		{"Café €5", "Caf? ?5"},
+	}
+
+	for _, tt := range tests {
+		if got := escape(tt.s); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		size float64
+		want float64
+	}{
+		{"", 12, 0},
+		{"0", 10, 5.56},
+		{"00", 10, 11.12},
+		{"i", 1000, 222},
+		{"é", 1000, 556},
+	}
+
+	for _, tt := range tests {
+		if got := TextWidth(tt.s, tt.size); got != tt.want {
+			t.Errorf("TextWidth(%q, %g) = %g, want %g", tt.s, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentBytes(t *testing.T) {
+	doc := New("Invoice (draft)")
+	page := doc.AddPage()
+	page.Text(50, 700, 12, true, "INVOICE")
+	page.TextRight(550, 700, 12, false, "USD 29.99")
+	page.Line(50, 690, 550, 690, 1)
+
+	out := doc.Bytes()
+	if !bytes.HasPrefix(out, []byte("%PDF-")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("output is not a PDF document:\n%s", out)
+	}
+	for _, want := range []string{`(Invoice \(draft\))`, "(INVOICE)", "(USD 29.99)", "xref", "/Count 1"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("document does not contain %q", want)
+		}
+	}
+}